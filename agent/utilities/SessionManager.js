@@ -56,6 +56,16 @@ const isSafeConversationStart = (message) => {
   return true;
 };
 
+/**
+ * A unit warning as the engine reported it: either a bare message or an
+ * { element, message } object naming the variable it is about.
+ */
+const formatUnitWarning = (warning) => {
+  if (typeof warning === 'string') return warning;
+  if (warning?.element && warning?.message) return `${warning.element}: ${warning.message}`;
+  return warning?.message ?? JSON.stringify(warning);
+};
+
 /**
  * SessionManager
  * Manages in-memory WebSocket sessions with session-specific temp folders
@@ -438,7 +448,7 @@ export class SessionManager {
       // absent field means the client did not report a unit check, so stay silent.
       if (Array.isArray(model.unitWarnings)) {
         if (model.unitWarnings.length) {
-          parts.push(`Unit warnings (reported by the simulation engine's unit checker — authoritative): ${model.unitWarnings.map(w => typeof w === 'string' ? w : JSON.stringify(w)).join('; ')}`);
+          parts.push(`Unit warnings (reported by the simulation engine's unit checker — authoritative): ${model.unitWarnings.map(formatUnitWarning).join('; ')}`);
         } else {
           parts.push(`Unit check: the simulation engine's unit checker reported NO unit warnings for this model — its units are consistent. Do not report any unit or dimensional-consistency problems.`);
         }
@@ -111,6 +111,19 @@ describe('SessionManager', () => {
       expect(issues).toContain("simulation engine's unit checker");
     });
 
+    it('reports engine unit warnings that name their element', () => {
+      const sessionId = sessionManager.createSession(null);
+      sessionManager.initializeSession(sessionId, 'sfd', {}, [], {}, '');
+
+      const model = {
+        variables: [{ name: 'Stock1', type: 'stock' }],
+        unitWarnings: [{ element: 'Births', message: '"Births" does not have units.' }]
+      };
+      const { issues } = sessionManager.updateClientModel(sessionId, model);
+
+      expect(issues).toContain('Births: "Births" does not have units.');
+    });
+
     it('reports a positive "no unit warnings" signal when the engine array is present but empty', () => {
       const sessionId = sessionManager.createSession(null);
       sessionManager.initializeSession(sessionId, 'sfd', {}, [], {}, '');
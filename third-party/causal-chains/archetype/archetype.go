@@ -0,0 +1,546 @@
+// Package archetype finds the classic system archetypes (Senge, "The
+// Fifth Discipline", 1990; Kim and Anderson, "Systems Archetype
+// Basics", 1998) in causal loop diagrams.
+//
+// Archetypes are recognized by how their feedback loops fit together:
+// which loops reinforce and which balance, which variables they share,
+// and the polarities of the links into and out of the shared variables.
+// That's all a diagram says for certain, so a match means the diagram
+// has an archetype's structure; whether the story fits is for people to
+// judge.
+package archetype
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+)
+
+// Archetype names a system archetype.
+type Archetype string
+
+const (
+	// FixesThatFail is a balancing loop, in which a fix relieves a
+	// problem, and a reinforcing loop through the same fix in which its
+	// unintended consequences make the problem worse.
+	FixesThatFail Archetype = "Fixes that Fail"
+	// ShiftingTheBurden is two balancing loops that relieve the same
+	// problem, one with a symptomatic solution and one with a
+	// fundamental solution, and a reinforcing loop in which a side
+	// effect of the symptomatic solution undermines the fundamental one.
+	ShiftingTheBurden Archetype = "Shifting the Burden"
+	// LimitsToGrowth is a reinforcing loop of growth and a balancing
+	// loop that slows it, through a shared variable.
+	LimitsToGrowth Archetype = "Limits to Growth"
+	// TragedyOfTheCommons is two reinforcing loops of activity, each
+	// limited by a balancing loop through a resource they share.
+	TragedyOfTheCommons Archetype = "Tragedy of the Commons"
+	// Escalation is two balancing loops, one for each side, through
+	// their relative position: each side acts when it falls behind,
+	// which puts the other behind.
+	Escalation Archetype = "Escalation"
+	// SuccessToTheSuccessful is two reinforcing loops through an
+	// allocation that favors one side at the expense of the other, so
+	// that success breeds success.
+	SuccessToTheSuccessful Archetype = "Success to the Successful"
+	// ErodingGoals is two balancing loops through a gap between a goal
+	// and the actual state: one closes the gap by improving the state,
+	// and the other by lowering the goal.
+	ErodingGoals Archetype = "Eroding Goals"
+	// GrowthAndUnderinvestment is Limits to Growth, in which the limit
+	// is a capacity that a second balancing loop would invest in.
+	GrowthAndUnderinvestment Archetype = "Growth and Underinvestment"
+)
+
+// Archetypes are all the archetypes Find looks for, in the order it
+// reports them.
+var Archetypes = []Archetype{
+	FixesThatFail,
+	ShiftingTheBurden,
+	LimitsToGrowth,
+	TragedyOfTheCommons,
+	Escalation,
+	SuccessToTheSuccessful,
+	ErodingGoals,
+	GrowthAndUnderinvestment,
+}
+
+// Role is a part of an archetype, and the variables that play it.
+type Role struct {
+	Role      string   `json:"role"`
+	Variables []string `json:"variables"`
+}
+
+// Match is an archetype found in a diagram.
+type Match struct {
+	Archetype Archetype `json:"archetype"`
+	// Loops are the identifiers of the loops that make up the match.
+	Loops []string `json:"loops"`
+	// Roles are the archetype's parts, in the order it's usually told.
+	// A role no variable plays is left out.
+	Roles []Role `json:"roles"`
+}
+
+// Role returns the variables that play a role in the match.
+func (m *Match) Role(role string) []string {
+	for _, r := range m.Roles {
+		if r.Role == role {
+			return r.Variables
+		}
+	}
+	return nil
+}
+
+// maxLoops is the number of loops Find compares, shortest first.
+// Archetypes are made of short loops, and the combinations of loops to
+// compare grow fast.
+const maxLoops = 200
+
+// Find returns the archetypes in a map, ordered as Archetypes lists them
+// and then by their loops.  Its loops are found within the limits opts
+// sets; if the map has more loops than opts allows, Find looks among
+// the ones found before the search stopped.
+func Find(ctx context.Context, m *causal.Map, opts causal.LoopOptions) ([]Match, error) {
+	found, err := m.LoopsContext(ctx, opts)
+	if err != nil && !errors.Is(err, causal.ErrTooManyLoops) {
+		return nil, fmt.Errorf("LoopsContext: %w", err)
+	}
+	found = found[:min(len(found), maxLoops)]
+
+	f := &finder{ctx: ctx, seen: make(map[string]bool)}
+	for _, l := range found {
+		if len(l.Links) < 2 {
+			// a variable's link to itself plays no part in an archetype
+			continue
+		}
+		switch {
+		case l.Reinforcing():
+			f.reinforcing = append(f.reinforcing, newLoop(l))
+		case l.Balancing():
+			f.balancing = append(f.balancing, newLoop(l))
+		}
+	}
+
+	for _, find := range []func() error{f.pairs, f.shiftingTheBurden, f.tragedyOfTheCommons, f.growthAndUnderinvestment} {
+		if err := find(); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortStableFunc(f.matches, func(a, b Match) int {
+		return cmp.Or(
+			cmp.Compare(slices.Index(Archetypes, a.Archetype), slices.Index(Archetypes, b.Archetype)),
+			slices.Compare(a.Loops, b.Loops),
+		)
+	})
+	if f.matches == nil {
+		f.matches = []Match{}
+	}
+	return f.matches, nil
+}
+
+// loop is a feedback loop, with its variables by canonical name.
+type loop struct {
+	causal.Loop
+	vars     []string // canonical, in order
+	display  []string
+	polarity []string // of the link out of each variable
+	index    map[string]int
+}
+
+func newLoop(l causal.Loop) *loop {
+	lp := &loop{Loop: l, index: make(map[string]int, len(l.Links))}
+	for i, link := range l.Links {
+		c := causal.Canonicalize(link.From)
+		lp.vars = append(lp.vars, c)
+		lp.display = append(lp.display, link.From)
+		lp.polarity = append(lp.polarity, link.Polarity)
+		lp.index[c] = i
+	}
+	return lp
+}
+
+func (l *loop) has(v string) bool {
+	_, ok := l.index[v]
+	return ok
+}
+
+// out and in return the polarity of the links out of and into one of
+// the loop's variables.
+func (l *loop) out(v string) string { return l.polarity[l.index[v]] }
+func (l *loop) in(v string) string  { return l.polarity[l.index[l.prev(v)]] }
+
+// next and prev return the variables after and before v in the loop.
+func (l *loop) next(v string) string { return l.vars[(l.index[v]+1)%len(l.vars)] }
+func (l *loop) prev(v string) string {
+	return l.vars[(l.index[v]+len(l.vars)-1)%len(l.vars)]
+}
+
+// after orders some of the loop's variables as the loop goes, starting
+// after v.
+func (l *loop) after(v string, vars []string) []string {
+	n := len(l.vars)
+	return slices.SortedFunc(slices.Values(vars), func(a, b string) int {
+		return (l.index[a]-l.index[v]+n)%n - (l.index[b]-l.index[v]+n)%n
+	})
+}
+
+// shared returns l's variables that are in every other loop, in l's
+// order.
+func (l *loop) shared(others ...*loop) []string {
+	return l.filter(func(v string) bool {
+		for _, o := range others {
+			if !o.has(v) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// only returns l's variables that are in none of the other loops, in
+// l's order.
+func (l *loop) only(others ...*loop) []string {
+	return l.filter(func(v string) bool {
+		for _, o := range others {
+			if o.has(v) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (l *loop) filter(keep func(string) bool) []string {
+	var vars []string
+	for _, v := range l.vars {
+		if keep(v) {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+// names returns the display names of some of l's variables.
+func (l *loop) names(vars []string) []string {
+	names := make([]string, 0, len(vars))
+	for _, v := range vars {
+		names = append(names, l.display[l.index[v]])
+	}
+	return names
+}
+
+type finder struct {
+	ctx                    context.Context
+	reinforcing, balancing []*loop
+	matches                []Match
+	seen                   map[string]bool
+}
+
+// add records a match, unless the same loops already make up one of the
+// same archetype.
+func (f *finder) add(archetype Archetype, loops []*loop, roles ...Role) {
+	ids := make([]string, len(loops))
+	for i, l := range loops {
+		ids[i] = l.Identifier
+	}
+	sorted := slices.Sorted(slices.Values(ids))
+	key := string(archetype) + ":" + strings.Join(sorted, ",")
+	if f.seen[key] {
+		return
+	}
+	f.seen[key] = true
+
+	m := Match{Archetype: archetype, Loops: ids}
+	for _, r := range roles {
+		if len(r.Variables) > 0 {
+			m.Roles = append(m.Roles, r)
+		}
+	}
+	f.matches = append(f.matches, m)
+}
+
+// pairs finds the archetypes made of two loops.
+func (f *finder) pairs() error {
+	for _, r := range f.reinforcing {
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+		for _, b := range f.balancing {
+			if !f.fixesThatFail(b, r) {
+				f.limitsToGrowth(r, b)
+			}
+		}
+	}
+	for i, a := range f.balancing {
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+		for _, b := range f.balancing[i+1:] {
+			f.twoBalancing(a, b)
+		}
+	}
+	for i, a := range f.reinforcing {
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+		for _, b := range f.reinforcing[i+1:] {
+			f.successToTheSuccessful(a, b)
+		}
+	}
+	return nil
+}
+
+// fixesThatFail matches a balancing loop and a reinforcing loop that
+// share a single run of links: from the problem, where the loops come
+// together, through the fix, after which the reinforcing loop turns off
+// into the fix's unintended consequences.
+func (f *finder) fixesThatFail(b, r *loop) bool {
+	consequences := r.only(b)
+	if len(consequences) == 0 {
+		return false
+	}
+	var starts []string
+	for _, v := range b.vars {
+		if sharesLink(b, r, v) && !sharesLink(b, r, b.prev(v)) {
+			starts = append(starts, v)
+		}
+	}
+	if len(starts) != 1 {
+		return false
+	}
+	run := []string{starts[0]}
+	for at := starts[0]; sharesLink(b, r, at); at = b.next(at) {
+		run = append(run, b.next(at))
+	}
+	if len(run) != len(b.shared(r)) {
+		// the loops meet again away from the run
+		return false
+	}
+	end := run[len(run)-1]
+	f.add(FixesThatFail, []*loop{b, r},
+		Role{"problem symptom", b.names(run[:1])},
+		Role{"fix", b.names(append(run[1:], b.after(end, b.only(r))...))},
+		Role{"unintended consequences", r.names(r.after(end, consequences))})
+	return true
+}
+
+// sharesLink reports whether the link out of v in a is in b too.
+func sharesLink(a, b *loop, v string) bool {
+	return b.has(v) && b.next(v) == a.next(v)
+}
+
+// limitsToGrowth matches a reinforcing loop and a balancing loop that
+// share some variables, each with variables of its own.
+func (f *finder) limitsToGrowth(r, b *loop) {
+	shared := r.shared(b)
+	growing, slowing := r.only(b), b.only(r)
+	if len(shared) == 0 || len(growing) == 0 || len(slowing) == 0 {
+		return
+	}
+	f.add(LimitsToGrowth, []*loop{r, b},
+		Role{"growing action", r.names(growing)},
+		Role{"state", r.names(shared)},
+		Role{"slowing action", b.names(slowing)})
+}
+
+// twoBalancing matches Escalation and Eroding Goals, in which two
+// balancing loops share a single variable.  The links into it have
+// opposite polarities: it's a difference between the loops.  In
+// Eroding Goals, the gap drives both loops the same way; in
+// Escalation, the relative position drives the sides in opposite ways.
+func (f *finder) twoBalancing(a, b *loop) {
+	shared := a.shared(b)
+	if len(shared) != 1 {
+		return
+	}
+	x := shared[0]
+	if !opposite(a.in(x), b.in(x)) {
+		return
+	}
+	// a is the loop whose link into x is positive
+	if a.in(x) == "-" {
+		a, b = b, a
+	}
+
+	switch {
+	case opposite(a.out(x), b.out(x)):
+		f.add(Escalation, []*loop{a, b},
+			Role{"relative position", a.names(shared)},
+			Role{"side A", a.names(a.after(x, a.only(b)))},
+			Role{"side B", b.names(b.after(x, b.only(a)))})
+	case a.out(x) == b.out(x):
+		// the state makes the gap smaller, and the goal larger
+		goal, state := a.after(x, a.only(b)), b.after(x, b.only(a))
+		if len(goal) == 0 || len(state) == 0 {
+			return
+		}
+		f.add(ErodingGoals, []*loop{b, a},
+			Role{"gap", a.names(shared)},
+			Role{"corrective action", b.names(state[:len(state)-1])},
+			Role{"state", b.names(state[len(state)-1:])},
+			Role{"pressure to lower goal", a.names(goal[:len(goal)-1])},
+			Role{"goal", a.names(goal[len(goal)-1:])})
+	}
+}
+
+func opposite(p, q string) bool {
+	return p == "+" && q == "-" || p == "-" && q == "+"
+}
+
+// successToTheSuccessful matches two reinforcing loops that share a
+// single variable, an allocation that favors one side, through links
+// of opposite polarity.
+func (f *finder) successToTheSuccessful(a, b *loop) {
+	shared := a.shared(b)
+	if len(shared) != 1 {
+		return
+	}
+	x := shared[0]
+	if !opposite(a.out(x), b.out(x)) {
+		return
+	}
+	if a.out(x) == "-" {
+		a, b = b, a
+	}
+	f.add(SuccessToTheSuccessful, []*loop{a, b},
+		Role{"allocation to A instead of B", a.names(shared)},
+		Role{"success of A", a.names(a.after(x, a.only(b)))},
+		Role{"success of B", b.names(b.after(x, b.only(a)))})
+}
+
+// shiftingTheBurden matches two balancing loops through a problem
+// symptom, and a reinforcing loop through both loops' solutions, in
+// which the side effect runs from the symptomatic solution to the
+// fundamental one.
+func (f *finder) shiftingTheBurden() error {
+	for i, b1 := range f.balancing {
+		for _, b2 := range f.balancing[i+1:] {
+			if err := f.ctx.Err(); err != nil {
+				return err
+			}
+			if len(b1.shared(b2)) == 0 || len(b1.only(b2)) == 0 || len(b2.only(b1)) == 0 {
+				continue
+			}
+			for _, r := range f.reinforcing {
+				symptomatic, fundamental, ok := sideEffect(r, b1, b2)
+				if !ok {
+					continue
+				}
+				f.add(ShiftingTheBurden, []*loop{symptomatic, fundamental, r},
+					Role{"problem symptom", symptomatic.names(symptomatic.shared(fundamental))},
+					Role{"symptomatic solution", symptomatic.names(symptomatic.only(fundamental))},
+					Role{"fundamental solution", fundamental.names(fundamental.only(symptomatic))},
+					Role{"side effect", r.names(r.only(b1, b2))})
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// sideEffect returns the balancing loops in order, the symptomatic one
+// first, if r goes from a variable only in one of them to one only in
+// the other, through variables in neither.
+func sideEffect(r, b1, b2 *loop) (*loop, *loop, bool) {
+	for _, v := range r.vars {
+		from := 0
+		switch {
+		case b1.has(v) && !b2.has(v):
+			from = 1
+		case b2.has(v) && !b1.has(v):
+			from = 2
+		default:
+			continue
+		}
+		to := r.next(v)
+		for !b1.has(to) && !b2.has(to) && to != v {
+			to = r.next(to)
+		}
+		switch {
+		case from == 1 && b2.has(to) && !b1.has(to):
+			return b1, b2, true
+		case from == 2 && b1.has(to) && !b2.has(to):
+			return b2, b1, true
+		}
+	}
+	return nil, nil, false
+}
+
+// tragedyOfTheCommons matches two balancing loops through a shared
+// resource that neither side's reinforcing loop goes through, each of
+// them limiting one side's activity.
+func (f *finder) tragedyOfTheCommons() error {
+	for i, b1 := range f.balancing {
+		for _, b2 := range f.balancing[i+1:] {
+			if err := f.ctx.Err(); err != nil {
+				return err
+			}
+			commons := b1.shared(b2)
+			if len(commons) == 0 {
+				continue
+			}
+			r1, r2 := f.sides(b1, b2, commons)
+			if r1 == nil {
+				continue
+			}
+			f.add(TragedyOfTheCommons, []*loop{r1, r2, b1, b2},
+				Role{"activity of A", r1.names(r1.vars)},
+				Role{"activity of B", r2.names(r2.vars)},
+				Role{"shared resource", b1.names(commons)})
+		}
+	}
+	return nil
+}
+
+// sides returns two separate reinforcing loops, one through each of b1
+// and b2 but not the commons, or nil if there are none.
+func (f *finder) sides(b1, b2 *loop, commons []string) (*loop, *loop) {
+	avoids := func(r *loop) bool {
+		return !slices.ContainsFunc(commons, r.has)
+	}
+	for _, r1 := range f.reinforcing {
+		if !avoids(r1) || len(r1.shared(b1)) == 0 || len(r1.shared(b2)) > 0 {
+			continue
+		}
+		for _, r2 := range f.reinforcing {
+			if r2 != r1 && avoids(r2) && len(r2.shared(b2)) > 0 && len(r2.shared(b1)) == 0 && len(r1.shared(r2)) == 0 {
+				return r1, r2
+			}
+		}
+	}
+	return nil, nil
+}
+
+// growthAndUnderinvestment matches Limits to Growth, in which a second
+// balancing loop goes through the limiting loop's own variables, the
+// capacity, but not the growth loop: investment in the capacity.
+func (f *finder) growthAndUnderinvestment() error {
+	for _, r := range f.reinforcing {
+		for _, b1 := range f.balancing {
+			if err := f.ctx.Err(); err != nil {
+				return err
+			}
+			if len(r.shared(b1)) == 0 || len(r.only(b1)) == 0 || len(b1.only(r)) == 0 {
+				continue
+			}
+			for _, b2 := range f.balancing {
+				if b2 == b1 || len(b2.shared(r)) > 0 || len(b2.shared(b1)) == 0 || len(b2.only(b1)) == 0 {
+					continue
+				}
+				performance := b1.shared(b2) // not empty, as checked above
+				f.add(GrowthAndUnderinvestment, []*loop{r, b1, b2},
+					Role{"growing action", r.names(r.only(b1))},
+					Role{"demand", r.names(r.shared(b1))},
+					Role{"performance", b1.names(performance)},
+					Role{"investment in capacity", b2.names(b2.after(performance[len(performance)-1], b2.only(b1)))})
+			}
+		}
+	}
+	return nil
+}
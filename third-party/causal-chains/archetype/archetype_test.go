@@ -0,0 +1,357 @@
+package archetype
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// diagram makes a map of links, each written as from, polarity, to.
+func diagram(links ...[3]string) *causal.Map {
+	var relationships []sdjson.Relationship
+	for _, l := range links {
+		relationships = append(relationships, sdjson.Relationship{From: l[0], Polarity: l[1], To: l[2]})
+	}
+	return causal.NewMap(relationships)
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name      string
+		links     [][3]string
+		archetype Archetype
+		roles     []Role
+	}{
+		{
+			name: "fixes that fail",
+			links: [][3]string{
+				{"Problem", "+", "Fix"},
+				{"Fix", "-", "Problem"},
+				{"Fix", "+", "Unintended Consequence"},
+				{"Unintended Consequence", "+", "Problem"},
+			},
+			archetype: FixesThatFail,
+			roles: []Role{
+				{"problem symptom", []string{"Problem"}},
+				{"fix", []string{"Fix"}},
+				{"unintended consequences", []string{"Unintended Consequence"}},
+			},
+		},
+		{
+			name: "shifting the burden",
+			links: [][3]string{
+				{"Problem Symptom", "+", "Symptomatic Solution"},
+				{"Symptomatic Solution", "-", "Problem Symptom"},
+				{"Problem Symptom", "+", "Fundamental Solution"},
+				{"Fundamental Solution", "-", "Problem Symptom"},
+				{"Symptomatic Solution", "+", "Side Effect"},
+				{"Side Effect", "-", "Fundamental Solution"},
+			},
+			archetype: ShiftingTheBurden,
+			roles: []Role{
+				{"problem symptom", []string{"Problem Symptom"}},
+				{"symptomatic solution", []string{"Symptomatic Solution"}},
+				{"fundamental solution", []string{"Fundamental Solution"}},
+				{"side effect", []string{"Side Effect"}},
+			},
+		},
+		{
+			name: "limits to growth",
+			links: [][3]string{
+				{"Efforts", "+", "Performance"},
+				{"Performance", "+", "Efforts"},
+				{"Performance", "+", "Slowing Action"},
+				{"Slowing Action", "-", "Performance"},
+				{"Limiting Condition", "+", "Slowing Action"},
+			},
+			archetype: LimitsToGrowth,
+			roles: []Role{
+				{"growing action", []string{"Efforts"}},
+				{"state", []string{"Performance"}},
+				{"slowing action", []string{"Slowing Action"}},
+			},
+		},
+		{
+			name: "tragedy of the commons",
+			links: [][3]string{
+				{"A's Activity", "+", "Net Gains for A"},
+				{"Net Gains for A", "+", "A's Activity"},
+				{"B's Activity", "+", "Net Gains for B"},
+				{"Net Gains for B", "+", "B's Activity"},
+				{"A's Activity", "+", "Total Activity"},
+				{"B's Activity", "+", "Total Activity"},
+				{"Total Activity", "-", "Gain per Individual Activity"},
+				{"Gain per Individual Activity", "+", "Net Gains for A"},
+				{"Gain per Individual Activity", "+", "Net Gains for B"},
+			},
+			archetype: TragedyOfTheCommons,
+			roles: []Role{
+				{"activity of A", []string{"A's Activity", "Net Gains for A"}},
+				{"activity of B", []string{"B's Activity", "Net Gains for B"}},
+				{"shared resource", []string{"Total Activity", "Gain per Individual Activity"}},
+			},
+		},
+		{
+			name: "escalation",
+			links: [][3]string{
+				{"A's Position Relative to B", "-", "Activity by A"},
+				{"Activity by A", "+", "A's Position Relative to B"},
+				{"A's Position Relative to B", "+", "Activity by B"},
+				{"Activity by B", "-", "A's Position Relative to B"},
+			},
+			archetype: Escalation,
+			roles: []Role{
+				{"relative position", []string{"A's Position Relative to B"}},
+				{"side A", []string{"Activity by A"}},
+				{"side B", []string{"Activity by B"}},
+			},
+		},
+		{
+			name: "success to the successful",
+			links: [][3]string{
+				{"Allocation to A Instead of B", "+", "Resources to A"},
+				{"Resources to A", "+", "Success of A"},
+				{"Success of A", "+", "Allocation to A Instead of B"},
+				{"Allocation to A Instead of B", "-", "Resources to B"},
+				{"Resources to B", "+", "Success of B"},
+				{"Success of B", "-", "Allocation to A Instead of B"},
+			},
+			archetype: SuccessToTheSuccessful,
+			roles: []Role{
+				{"allocation to A instead of B", []string{"Allocation to A Instead of B"}},
+				{"success of A", []string{"Resources to A", "Success of A"}},
+				{"success of B", []string{"Resources to B", "Success of B"}},
+			},
+		},
+		{
+			name: "eroding goals",
+			links: [][3]string{
+				{"Gap", "+", "Corrective Action"},
+				{"Corrective Action", "+", "Condition"},
+				{"Condition", "-", "Gap"},
+				{"Gap", "+", "Pressure to Lower Goal"},
+				{"Pressure to Lower Goal", "-", "Goal"},
+				{"Goal", "+", "Gap"},
+			},
+			archetype: ErodingGoals,
+			roles: []Role{
+				{"gap", []string{"Gap"}},
+				{"corrective action", []string{"Corrective Action"}},
+				{"state", []string{"Condition"}},
+				{"pressure to lower goal", []string{"Pressure to Lower Goal"}},
+				{"goal", []string{"Goal"}},
+			},
+		},
+		{
+			name: "growth and underinvestment",
+			links: [][3]string{
+				{"Growing Action", "+", "Demand"},
+				{"Demand", "+", "Growing Action"},
+				{"Demand", "+", "Delivery Delay"},
+				{"Delivery Delay", "-", "Demand"},
+				{"Delivery Delay", "+", "Perceived Need to Invest"},
+				{"Perceived Need to Invest", "+", "Investment in Capacity"},
+				{"Investment in Capacity", "+", "Capacity"},
+				{"Capacity", "-", "Delivery Delay"},
+			},
+			archetype: GrowthAndUnderinvestment,
+			roles: []Role{
+				{"growing action", []string{"Growing Action"}},
+				{"demand", []string{"Demand"}},
+				{"performance", []string{"Delivery Delay"}},
+				{"investment in capacity", []string{"Perceived Need to Invest", "Investment in Capacity", "Capacity"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := Find(context.Background(), diagram(tt.links...), causal.DefaultLoopOptions)
+			require.NoError(t, err)
+			var found []Match
+			for _, m := range matches {
+				if m.Archetype == tt.archetype {
+					found = append(found, m)
+				}
+			}
+			require.Len(t, found, 1, "matches: %v", matches)
+			for _, r := range tt.roles {
+				assert.Equal(t, r.Variables, found[0].Role(r.Role), r.Role)
+			}
+			assert.Len(t, found[0].Roles, len(tt.roles))
+		})
+	}
+}
+
+func TestFindPolarity(t *testing.T) {
+	tests := []struct {
+		name  string
+		links [][3]string
+		want  []Archetype
+	}{
+		{
+			name: "sides driven apart",
+			links: [][3]string{
+				{"Position", "-", "A"},
+				{"A", "+", "Position"},
+				{"Position", "+", "B"},
+				{"B", "-", "Position"},
+			},
+			want: []Archetype{Escalation},
+		},
+		{
+			name: "sides driven together",
+			links: [][3]string{
+				{"Position", "+", "A"},
+				{"A", "+", "Position"},
+				{"Position", "+", "B"},
+				{"B", "-", "Position"},
+			},
+			want: []Archetype{LimitsToGrowth},
+		},
+		{
+			name: "loops driven together",
+			links: [][3]string{
+				{"Position", "-", "A"},
+				{"A", "+", "Position"},
+				{"Position", "-", "B"},
+				{"B", "-", "B2"},
+				{"B2", "-", "Position"},
+			},
+			want: []Archetype{ErodingGoals},
+		},
+		{
+			// the shared variable is no difference between the loops
+			name: "same links in",
+			links: [][3]string{
+				{"Position", "-", "A"},
+				{"A", "+", "Position"},
+				{"Position", "+", "B"},
+				{"B", "-", "B2"},
+				{"B2", "+", "Position"},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := Find(context.Background(), diagram(tt.links...), causal.DefaultLoopOptions)
+			require.NoError(t, err)
+			var got []Archetype
+			for _, m := range matches {
+				got = append(got, m.Archetype)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestFindSelfLink checks that a variable's link to itself, which
+// shares its only variable with every loop through it, matches nothing.
+func TestFindSelfLink(t *testing.T) {
+	matches, err := Find(context.Background(), diagram(
+		[3]string{"Anxiety", "-", "Anxiety"},
+		[3]string{"Anxiety", "-", "Coping"},
+		[3]string{"Coping", "+", "Anxiety"},
+	), causal.DefaultLoopOptions)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+// TestFindTooManyLoops checks that archetypes are found among the loops
+// found before the search for loops stops.
+func TestFindTooManyLoops(t *testing.T) {
+	links := [][3]string{
+		{"Efforts", "+", "Performance"},
+		{"Performance", "+", "Efforts"},
+		{"Performance", "+", "Slowing Action"},
+		{"Slowing Action", "-", "Performance"},
+	}
+	// a clique of 8 variables has 8018 loops, searched for after the
+	// others since its variables' names come last
+	for i := range 8 {
+		for j := range 8 {
+			if i != j {
+				links = append(links, [3]string{fmt.Sprint("Word of Mouth ", i), "+", fmt.Sprint("Word of Mouth ", j)})
+			}
+		}
+	}
+	matches, err := Find(context.Background(), diagram(links...), causal.LoopOptions{MaxLoops: 1000})
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, LimitsToGrowth, matches[0].Archetype)
+}
+
+func TestFindNone(t *testing.T) {
+	matches, err := Find(context.Background(), diagram(
+		[3]string{"a", "+", "b"},
+		[3]string{"b", "+", "a"},
+		[3]string{"c", "+", "b"},
+	), causal.DefaultLoopOptions)
+	require.NoError(t, err)
+	assert.NotNil(t, matches)
+	assert.Empty(t, matches)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Find(ctx, diagram([3]string{"a", "+", "b"}, [3]string{"b", "-", "a"}), causal.DefaultLoopOptions)
+	assert.Error(t, err)
+}
+
+// TestFindFixtures checks that every match in the evaluation fixtures
+// names loops and variables that are in the model.
+func TestFindFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("no evaluation fixtures")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var fixture struct {
+				Model *sdjson.Model `json:"model"`
+			}
+			if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+				t.Skip("no model")
+			}
+			m := causal.NewMap(fixture.Model.Relationships)
+			loops, err := m.LoopsContext(context.Background(), causal.DefaultLoopOptions)
+			if err != nil {
+				t.Skip(err)
+			}
+			ids := make(map[string]bool)
+			for _, l := range loops {
+				ids[l.Identifier] = true
+			}
+			names := make(map[string]bool)
+			for _, r := range fixture.Model.Relationships {
+				names[causal.Canonicalize(r.From)] = true
+				names[causal.Canonicalize(r.To)] = true
+			}
+
+			matches, err := Find(context.Background(), m, causal.DefaultLoopOptions)
+			require.NoError(t, err)
+			for _, match := range matches {
+				for _, id := range match.Loops {
+					assert.True(t, ids[id], "%s: loop %s", match.Archetype, id)
+				}
+				require.NotEmpty(t, match.Roles)
+				for _, r := range match.Roles {
+					for _, v := range r.Variables {
+						assert.True(t, names[causal.Canonicalize(v)], "%s: %s: %s", match.Archetype, r.Role, v)
+					}
+				}
+			}
+		})
+	}
+}
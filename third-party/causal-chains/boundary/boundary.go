@@ -0,0 +1,231 @@
+// Package boundary draws model boundary charts: it sorts a model's
+// variables by whether the model explains them, and suggests which of
+// the variables it takes as given are most worth explaining.
+//
+// A variable on a feedback loop is endogenous.  One with no inputs is
+// exogenous: the model takes it as given.  The rest are downstream:
+// the model determines them, but they don't feed back, so they can't
+// shape the model's behavior beyond passing on what drives them.
+package boundary
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// Class is where a variable sits relative to the model's boundary.
+type Class string
+
+const (
+	// Endogenous variables are on at least one feedback loop.
+	Endogenous Class = "endogenous"
+	// Downstream variables have inputs but aren't on a loop.
+	Downstream Class = "downstream"
+	// Exogenous variables have no inputs.
+	Exogenous Class = "exogenous"
+)
+
+// Classes are the classes in the order a chart shows them.
+var Classes = []Class{Endogenous, Downstream, Exogenous}
+
+// Variable is a variable's place in a boundary chart.
+type Variable struct {
+	Name  string `json:"name"`
+	Class Class  `json:"class"`
+}
+
+// Driver is an exogenous variable that affects the model's feedback
+// loops, and so might be worth explaining with loops of its own.
+type Driver struct {
+	Name string `json:"name"`
+	// Affects are the endogenous variables the driver reaches first,
+	// directly or through downstream variables.
+	Affects []string `json:"affects"`
+	// Reach is the number of endogenous variables the driver affects,
+	// directly or not.
+	Reach int `json:"reach"`
+}
+
+// Chart is a model boundary chart.
+type Chart struct {
+	// Variables are every variable of the model, in its order.
+	Variables []Variable `json:"variables"`
+	// Drivers are the exogenous variables that affect a feedback loop,
+	// those that reach the most of the model first.
+	Drivers []Driver `json:"drivers"`
+}
+
+// Class returns the names of the chart's variables of a class.
+func (c *Chart) Class(class Class) []string {
+	var names []string
+	for _, v := range c.Variables {
+		if v.Class == class {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+// FromMap charts the boundary of a causal map.
+func FromMap(m *causal.Map) *Chart {
+	mdl := m.Compat()
+	return FromModel(&mdl)
+}
+
+// FromModel charts the boundary of a model, from its relationships and
+// those its equations imply.
+func FromModel(m *sdjson.Model) *Chart {
+	g := &graph{index: make(map[string]int)}
+	for _, v := range m.Variables {
+		g.variable(v.Name)
+	}
+	derived, _ := equation.DeriveRelationships(m)
+	for _, r := range append(slices.Clip(m.Relationships), derived...) {
+		g.link(g.variable(r.From), g.variable(r.To))
+	}
+	return g.chart()
+}
+
+// graph is a model's variables, numbered in order, and the links
+// between them.
+type graph struct {
+	names []string
+	index map[string]int // by canonical name
+	out   [][]int
+	in    []int // the number of links into each variable
+	self  []bool
+}
+
+func (g *graph) variable(name string) int {
+	c := causal.Canonicalize(name)
+	if v, ok := g.index[c]; ok {
+		return v
+	}
+	g.index[c] = len(g.names)
+	g.names = append(g.names, name)
+	g.out = append(g.out, nil)
+	g.in = append(g.in, 0)
+	g.self = append(g.self, false)
+	return len(g.names) - 1
+}
+
+func (g *graph) link(from, to int) {
+	if from == to {
+		g.self[from] = true
+		return
+	}
+	if slices.Contains(g.out[from], to) {
+		return
+	}
+	g.out[from] = append(g.out[from], to)
+	g.in[to]++
+}
+
+func (g *graph) chart() *Chart {
+	onLoop := g.onLoop()
+	c := &Chart{Variables: make([]Variable, len(g.names)), Drivers: []Driver{}}
+	for v, name := range g.names {
+		class := Downstream
+		switch {
+		case onLoop[v]:
+			class = Endogenous
+		case g.in[v] == 0:
+			class = Exogenous
+		}
+		c.Variables[v] = Variable{Name: name, Class: class}
+	}
+
+	for v, name := range g.names {
+		if c.Variables[v].Class != Exogenous {
+			continue
+		}
+		d := Driver{Name: name, Affects: []string{}}
+		// search through downstream variables, stopping at loops
+		seen := make([]bool, len(g.names))
+		queue := []int{v}
+		seen[v] = true
+		for len(queue) > 0 {
+			at := queue[0]
+			queue = queue[1:]
+			for _, next := range g.out[at] {
+				if seen[next] {
+					continue
+				}
+				seen[next] = true
+				if onLoop[next] && !onLoop[at] {
+					d.Affects = append(d.Affects, g.names[next])
+				}
+				if onLoop[next] {
+					d.Reach++
+				}
+				queue = append(queue, next)
+			}
+		}
+		if d.Reach > 0 {
+			c.Drivers = append(c.Drivers, d)
+		}
+	}
+	slices.SortStableFunc(c.Drivers, func(a, b Driver) int {
+		return cmp.Or(b.Reach-a.Reach, len(b.Affects)-len(a.Affects))
+	})
+	return c
+}
+
+// onLoop reports which variables are on a feedback loop: those linked
+// to themselves, and those in a strongly connected component of more
+// than one variable, found with Tarjan's algorithm.
+func (g *graph) onLoop() []bool {
+	n := len(g.names)
+	onLoop := slices.Clone(g.self)
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range index {
+		index[i] = -1
+	}
+	var stack []int
+	next := 0
+
+	var connect func(int)
+	connect = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range g.out[v] {
+			switch {
+			case index[w] < 0:
+				connect(w)
+				low[v] = min(low[v], low[w])
+			case onStack[w]:
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		// v is the root of a component: pop it
+		i := len(stack) - 1
+		for stack[i] != v {
+			i--
+		}
+		component := stack[i:]
+		stack = stack[:i]
+		for _, w := range component {
+			onStack[w] = false
+			if len(component) > 1 {
+				onLoop[w] = true
+			}
+		}
+	}
+	for v := range n {
+		if index[v] < 0 {
+			connect(v)
+		}
+	}
+	return onLoop
+}
@@ -0,0 +1,113 @@
+package boundary
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// population is a map with a reinforcing and a balancing loop, drivers
+// from outside them, and variables they drive.
+func population() *causal.Map {
+	link := func(from, to string) sdjson.Relationship {
+		return sdjson.Relationship{From: from, To: to, Polarity: "+"}
+	}
+	return causal.NewMap([]sdjson.Relationship{
+		link("Births", "Population"),
+		link("Population", "Births"),
+		link("Population", "Deaths"),
+		link("Deaths", "Population"),
+		link("Subsidy", "Fertility"),
+		link("Fertility", "Births"),
+		link("Lifetime", "Deaths"),
+		link("Population", "Density"),
+		link("Density", "Crowding"),
+		link("Weather", "Crowding"),
+	})
+}
+
+func TestFromMap(t *testing.T) {
+	c := FromMap(population())
+	assert.ElementsMatch(t, []string{"Births", "Population", "Deaths"}, c.Class(Endogenous))
+	assert.ElementsMatch(t, []string{"Fertility", "Density", "Crowding"}, c.Class(Downstream))
+	assert.ElementsMatch(t, []string{"Subsidy", "Lifetime", "Weather"}, c.Class(Exogenous))
+	assert.Len(t, c.Variables, 9)
+
+	// the weather only affects a downstream variable, so it's no driver;
+	// drivers that reach as far come in the order of the variables
+	assert.Equal(t, []Driver{
+		{Name: "Lifetime", Affects: []string{"Deaths"}, Reach: 3},
+		{Name: "Subsidy", Affects: []string{"Births"}, Reach: 3},
+	}, c.Drivers)
+
+	empty := FromMap(causal.NewMap(nil))
+	assert.Empty(t, empty.Variables)
+	assert.NotNil(t, empty.Drivers)
+}
+
+func TestFromModel(t *testing.T) {
+	m := &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "Population", Type: sdjson.VariableTypeStock, Equation: "100", Inflows: []string{"births"}},
+			{Name: "births", Type: sdjson.VariableTypeFlow, Equation: "Population * birth_rate"},
+			{Name: "birth rate", Type: sdjson.VariableTypeAux, Equation: "0.03"},
+			{Name: "headlines", Type: sdjson.VariableTypeAux},
+			{Name: "unused", Type: sdjson.VariableTypeAux, Equation: "1"},
+		},
+		// a declared relationship adds to those the equations imply
+		Relationships: []sdjson.Relationship{{From: "births", To: "Headlines", Polarity: "+"}},
+	}
+	c := FromModel(m)
+	assert.Equal(t, []Variable{
+		{Name: "Population", Class: Endogenous},
+		{Name: "births", Class: Endogenous},
+		{Name: "birth rate", Class: Exogenous},
+		{Name: "headlines", Class: Downstream},
+		{Name: "unused", Class: Exogenous},
+	}, c.Variables)
+	assert.Equal(t, []Driver{{Name: "birth rate", Affects: []string{"births"}, Reach: 2}}, c.Drivers)
+}
+
+func TestSelfLoop(t *testing.T) {
+	c := FromMap(causal.NewMap([]sdjson.Relationship{
+		{From: "Habit", To: "Habit", Polarity: "+"},
+		{From: "Cue", To: "Habit", Polarity: "+"},
+	}))
+	assert.Equal(t, []string{"Habit"}, c.Class(Endogenous))
+	assert.Equal(t, []string{"Cue"}, c.Class(Exogenous))
+}
+
+func TestFromModelFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *sdjson.Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			c := FromModel(fixture.Model)
+			require.GreaterOrEqual(t, len(c.Variables), len(fixture.Model.Variables))
+			for _, d := range c.Drivers {
+				assert.NotEmpty(t, d.Affects, d.Name)
+			}
+		})
+	}
+}
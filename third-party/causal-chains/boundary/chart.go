@@ -0,0 +1,116 @@
+package boundary
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// titles are the headings of each class's column.
+var titles = map[Class]string{
+	Endogenous: "Endogenous",
+	Downstream: "Downstream",
+	Exogenous:  "Exogenous",
+}
+
+// columns returns the names in each class's column, in Classes order.
+func (c *Chart) columns() [][]string {
+	columns := make([][]string, len(Classes))
+	for i, class := range Classes {
+		columns[i] = c.Class(class)
+	}
+	return columns
+}
+
+// Table returns the chart as a Markdown table, with a column for each
+// class, followed by the candidate drivers.
+func (c *Chart) Table() string {
+	columns := c.columns()
+	rows := 0
+	for _, column := range columns {
+		rows = max(rows, len(column))
+	}
+
+	var b strings.Builder
+	b.WriteString("|")
+	for _, class := range Classes {
+		fmt.Fprintf(&b, " %s |", titles[class])
+	}
+	b.WriteString("\n|")
+	for range Classes {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for row := range rows {
+		b.WriteString("|")
+		for _, column := range columns {
+			var name string
+			if row < len(column) {
+				name = strings.ReplaceAll(column[row], "|", `\|`)
+			}
+			fmt.Fprintf(&b, " %s |", name)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(c.Drivers) > 0 {
+		b.WriteString("\nCandidate drivers to endogenize:\n\n")
+		for _, d := range c.Drivers {
+			fmt.Fprintf(&b, "- %s, which affects %s and %d endogenous variables in all\n", d.Name, strings.Join(d.Affects, ", "), d.Reach)
+		}
+	}
+	return b.String()
+}
+
+const (
+	fontSize  = 14
+	charWidth = 0.55 * fontSize // an average character, in the sans-serif font used
+	rowHeight = 24
+	padding   = 12
+	minColumn = 120
+)
+
+// SVG draws the chart as a table, with a column for each class.
+func (c *Chart) SVG() []byte {
+	columns := c.columns()
+	widths := make([]float64, len(columns))
+	rows := 0
+	for i, column := range columns {
+		widths[i] = minColumn
+		for _, name := range append([]string{titles[Classes[i]]}, column...) {
+			widths[i] = max(widths[i], float64(len([]rune(name)))*charWidth+2*padding)
+		}
+		rows = max(rows, len(column))
+	}
+	var width float64
+	for _, w := range widths {
+		width += w
+	}
+	height := float64(rows+1) * rowHeight
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.1f" height="%.1f" viewBox="0 0 %.1f %.1f" font-family="Helvetica, Arial, sans-serif" font-size="%d">`+"\n",
+		width+1, height+1, width+1, height+1, fontSize)
+	fmt.Fprintf(&b, `<rect x="0.5" y="0.5" width="%.1f" height="%.1f" fill="white" stroke="#333"/>`+"\n", width, height)
+	fmt.Fprintf(&b, `<rect x="0.5" y="0.5" width="%.1f" height="%d" fill="#eee" stroke="#333"/>`+"\n", width, rowHeight)
+
+	x := 0.5
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprintf(&b, `<line x1="%.1f" y1="0.5" x2="%.1f" y2="%.1f" stroke="#333"/>`+"\n", x, x, height+0.5)
+		}
+		fmt.Fprintf(&b, `<g class="%s" dominant-baseline="central">`+"\n", Classes[i])
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-weight="bold">%s</text>`+"\n", x+padding, 0.5+rowHeight/2.0, titles[Classes[i]])
+		for row, name := range column {
+			fmt.Fprintf(&b, `<text class="variable" x="%.1f" y="%.1f">`, x+padding, 0.5+float64(row+1)*rowHeight+rowHeight/2.0)
+			// writes to a bytes.Buffer don't fail
+			_ = xml.EscapeText(&b, []byte(name))
+			b.WriteString("</text>\n")
+		}
+		b.WriteString("</g>\n")
+		x += widths[i]
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
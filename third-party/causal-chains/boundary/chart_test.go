@@ -0,0 +1,63 @@
+package boundary
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	c := &Chart{
+		Variables: []Variable{
+			{Name: "Population", Class: Endogenous},
+			{Name: "Births", Class: Endogenous},
+			{Name: "Rate | per year", Class: Exogenous},
+		},
+		Drivers: []Driver{{Name: "Rate | per year", Affects: []string{"Births"}, Reach: 2}},
+	}
+	assert.Equal(t, `| Endogenous | Downstream | Exogenous |
+| --- | --- | --- |
+| Population |  | Rate \| per year |
+| Births |  |  |
+
+Candidate drivers to endogenize:
+
+- Rate | per year, which affects Births and 2 endogenous variables in all
+`, c.Table())
+}
+
+type svgDoc struct {
+	Groups []struct {
+		Class string `xml:"class,attr"`
+		Texts []struct {
+			Class string `xml:"class,attr"`
+			Text  string `xml:",chardata"`
+		} `xml:"text"`
+	} `xml:"g"`
+}
+
+func TestSVG(t *testing.T) {
+	c := FromMap(population())
+	var doc svgDoc
+	require.NoError(t, xml.Unmarshal(c.SVG(), &doc))
+	require.Len(t, doc.Groups, 3)
+	for i, class := range Classes {
+		group := doc.Groups[i]
+		assert.Equal(t, string(class), group.Class)
+		assert.Equal(t, titles[class], group.Texts[0].Text)
+		var names []string
+		for _, text := range group.Texts[1:] {
+			assert.Equal(t, "variable", text.Class)
+			names = append(names, text.Text)
+		}
+		assert.Equal(t, c.Class(class), names)
+	}
+
+	// names are escaped
+	c = &Chart{Variables: []Variable{{Name: "Profits & <Losses>", Class: Downstream}}}
+	doc = svgDoc{}
+	require.NoError(t, xml.Unmarshal(c.SVG(), &doc))
+	assert.Equal(t, "Profits & <Losses>", doc.Groups[1].Texts[1].Text)
+}
@@ -0,0 +1,279 @@
+package causal
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Comparison is how closely a map matches a reference map, such as an
+// expert's diagram of the same problem.
+type Comparison struct {
+	// Alignment pairs the reference's variables with the map's, in the
+	// reference's alphabetical order.  Variables either map has that the
+	// other lacks are left out.
+	Alignment []Alignment `json:"alignment"`
+	// EdgePrecision is the share of the map's links that are in the
+	// reference, and EdgeRecall the share of the reference's links that
+	// are in the map.
+	EdgePrecision float64 `json:"edgePrecision"`
+	EdgeRecall    float64 `json:"edgeRecall"`
+	// PolarityAgreement is the share of the links in both maps that have
+	// the same polarity in each.
+	PolarityAgreement float64 `json:"polarityAgreement"`
+	// LoopOverlap is the Jaccard index of the two maps' feedback loops:
+	// the loops through the same variables in the same order, over the
+	// loops of either.
+	LoopOverlap float64 `json:"loopOverlap"`
+	// Score sums the comparison up, from 0 for maps with nothing in
+	// common to 1 for the same map: the mean of the F1 score of the
+	// links, the same counting only links of the same polarity, and, if
+	// either map has loops, the loop overlap.
+	Score float64 `json:"score"`
+}
+
+// Alignment is a variable of the reference map, and the map's variable
+// that names the same thing.
+type Alignment struct {
+	Reference string `json:"reference"`
+	Variable  string `json:"variable"`
+	// Similarity is how alike the names are, by NameSimilarity, and
+	// raised by the neighbors the variables share.
+	Similarity float64 `json:"similarity"`
+}
+
+// AlignThreshold is the Similarity at which Compare aligns two
+// variables.  Names that share at least half their words reach it by
+// themselves; names that share none reach it only if every neighbor of
+// both variables is aligned with a neighbor of the other.
+const AlignThreshold = 0.5
+
+const (
+	// structureWeight is how much of the gap between a pair's name
+	// similarity and 1 the neighbors it shares make up.
+	structureWeight = 0.5
+	// alignRounds bounds the rounds of aligning variables by their
+	// neighbors' alignment.
+	alignRounds = 5
+)
+
+// Compare compares the map with a reference map.  Variables are aligned
+// one to one, the most similar pairs first, by their names and by how
+// many of their neighbors are aligned with each other.
+func (m *Map) Compare(reference *Map) *Comparison {
+	ref, got := newCompareGraph(reference), newCompareGraph(m)
+
+	names := make([][]float64, len(ref.names))
+	for i, a := range ref.names {
+		names[i] = make([]float64, len(got.names))
+		for j, b := range got.names {
+			names[i][j] = NameSimilarity(a, b)
+		}
+	}
+	align, similarity := alignVariables(ref, got, names, nil)
+	for range alignRounds {
+		next, nextSimilarity := alignVariables(ref, got, names, align)
+		settled := slices.Equal(next, align)
+		align, similarity = next, nextSimilarity
+		if settled {
+			break
+		}
+	}
+
+	c := &Comparison{Alignment: []Alignment{}}
+	for i, j := range align {
+		if j >= 0 {
+			c.Alignment = append(c.Alignment, Alignment{
+				Reference:  ref.names[i],
+				Variable:   got.names[j],
+				Similarity: similarity[i],
+			})
+		}
+	}
+
+	var matched, agreed int
+	for e, polarity := range ref.edges {
+		if align[e[0]] < 0 || align[e[1]] < 0 {
+			continue
+		}
+		if p, ok := got.edges[[2]int{align[e[0]], align[e[1]]}]; ok {
+			matched++
+			if p == polarity {
+				agreed++
+			}
+		}
+	}
+	c.EdgePrecision = ratio(matched, len(got.edges), len(ref.edges) == 0)
+	c.EdgeRecall = ratio(matched, len(ref.edges), len(got.edges) == 0)
+	c.PolarityAgreement = ratio(agreed, matched, false)
+
+	// a map's loops in terms of the reference's variables
+	mapLoops := m.Loops()
+	refLoops := make(Set[string])
+	for _, l := range reference.Loops() {
+		if key, ok := ref.loopKey(l, nil); ok {
+			refLoops.Add(key)
+		}
+	}
+	aligned := make(map[int]int)
+	for i, j := range align {
+		if j >= 0 {
+			aligned[j] = i
+		}
+	}
+	gotLoops := make(Set[string])
+	for _, l := range mapLoops {
+		if key, ok := got.loopKey(l, aligned); ok {
+			gotLoops.Add(key)
+		}
+	}
+	var common int
+	for key := range gotLoops {
+		if refLoops.Contains(key) {
+			common++
+		}
+	}
+	// loops through variables with nothing aligned are only in the map
+	c.LoopOverlap = ratio(common, len(refLoops)+len(mapLoops)-common, true)
+
+	edges := len(ref.edges) + len(got.edges)
+	f1 := ratio(2*matched, edges, true)
+	signed := ratio(2*agreed, edges, true)
+	c.Score = (f1 + signed) / 2
+	if len(refLoops) > 0 || len(mapLoops) > 0 {
+		c.Score = (f1 + signed + c.LoopOverlap) / 3
+	}
+	return c
+}
+
+// ratio returns n/d, or, if d is 0, 1 if nothing is as good as it gets
+// and 0 otherwise.
+func ratio(n, d int, emptyIsPerfect bool) float64 {
+	if d == 0 {
+		if emptyIsPerfect {
+			return 1
+		}
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// compareGraph is a map's variables, numbered in order, and the
+// polarity of the links between them.
+type compareGraph struct {
+	names   []string
+	index   map[string]int // by canonical name
+	edges   map[[2]int]string
+	in, out []Set[int]
+}
+
+func newCompareGraph(m *Map) *compareGraph {
+	g := &compareGraph{index: make(map[string]int), edges: make(map[[2]int]string)}
+	variable := func(name string) int {
+		c := Canonicalize(name)
+		if v, ok := g.index[c]; ok {
+			return v
+		}
+		g.index[c] = len(g.names)
+		g.names = append(g.names, name)
+		g.in = append(g.in, make(Set[int]))
+		g.out = append(g.out, make(Set[int]))
+		return len(g.names) - 1
+	}
+	for _, name := range m.Variables().Slice() {
+		variable(name)
+	}
+	provs, _ := m.Relationships()
+	for _, p := range provs {
+		from, to := variable(p.Relationship.From), variable(p.Relationship.To)
+		e := [2]int{from, to}
+		if _, ok := g.edges[e]; ok {
+			continue
+		}
+		g.edges[e] = p.Relationship.Polarity
+		g.out[from].Add(to)
+		g.in[to].Add(from)
+	}
+	return g
+}
+
+// loopKey names a loop by its variables' numbers, in the order the loop
+// goes, starting from the lowest.  Numbers are translated with through
+// if it isn't nil; a loop through a variable it lacks has no key.
+func (g *compareGraph) loopKey(l Loop, through map[int]int) (string, bool) {
+	vars := make([]int, 0, len(l.Links))
+	for _, link := range l.Links {
+		v := g.index[Canonicalize(link.From)]
+		if through != nil {
+			var ok bool
+			if v, ok = through[v]; !ok {
+				return "", false
+			}
+		}
+		vars = append(vars, v)
+	}
+	start := slices.Index(vars, slices.Min(vars))
+	return fmt.Sprint(slices.Concat(vars[start:], vars[:start])), true
+}
+
+// alignVariables pairs the reference's variables with the map's, most
+// similar first, and returns the map's variable for each of the
+// reference's, or -1, and how similar they are.  Similarity is raised by
+// the neighbors two variables share under a previous alignment, if any.
+func alignVariables(ref, got *compareGraph, names [][]float64, previous []int) ([]int, []float64) {
+	type pair struct {
+		i, j       int
+		similarity float64
+	}
+	var pairs []pair
+	for i := range ref.names {
+		for j := range got.names {
+			s := names[i][j]
+			if previous != nil {
+				s += (1 - s) * structureWeight * sharedNeighbors(ref, got, previous, i, j)
+			}
+			if s >= AlignThreshold {
+				pairs = append(pairs, pair{i, j, s})
+			}
+		}
+	}
+	slices.SortStableFunc(pairs, func(a, b pair) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+
+	align := make([]int, len(ref.names))
+	similarity := make([]float64, len(ref.names))
+	for i := range align {
+		align[i] = -1
+	}
+	used := make([]bool, len(got.names))
+	for _, p := range pairs {
+		if align[p.i] < 0 && !used[p.j] {
+			align[p.i], similarity[p.i] = p.j, p.similarity
+			used[p.j] = true
+		}
+	}
+	return align, similarity
+}
+
+// sharedNeighbors is the Dice coefficient of two variables' links: the
+// links into and out of reference variable i whose other ends are
+// aligned with the other ends of map variable j's links the same way.
+func sharedNeighbors(ref, got *compareGraph, align []int, i, j int) float64 {
+	links := len(ref.in[i]) + len(ref.out[i]) + len(got.in[j]) + len(got.out[j])
+	if links == 0 {
+		return 0
+	}
+	var shared int
+	for v := range ref.in[i] {
+		if align[v] >= 0 && got.in[j].Contains(align[v]) {
+			shared++
+		}
+	}
+	for v := range ref.out[i] {
+		if align[v] >= 0 && got.out[j].Contains(align[v]) {
+			shared++
+		}
+	}
+	return float64(2*shared) / float64(links)
+}
@@ -0,0 +1,149 @@
+package causal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestCompare(t *testing.T) {
+	reference := &Map{CausalChains: []Chain{
+		chain("++", "Population", "Births", "Population"),
+		chain("+", "Birth Rate", "Births"),
+	}}
+	tests := []struct {
+		name      string
+		m         *Map
+		aligned   map[string]string
+		precision float64
+		recall    float64
+		polarity  float64
+		loops     float64
+		score     float64
+	}{
+		{
+			name:      "same map",
+			m:         reference,
+			aligned:   map[string]string{"Population": "Population", "Births": "Births", "Birth Rate": "Birth Rate"},
+			precision: 1, recall: 1, polarity: 1, loops: 1, score: 1,
+		},
+		{
+			name: "similar names",
+			m: &Map{CausalChains: []Chain{
+				chain("++", "population", "Number of Births", "population"),
+				chain("+", "Birth Rates", "Number of Births"),
+			}},
+			aligned:   map[string]string{"Population": "population", "Births": "Number of Births", "Birth Rate": "Birth Rates"},
+			precision: 1, recall: 1, polarity: 1, loops: 1, score: 1,
+		},
+		{
+			// nothing in the names says Pop is Population, but its links
+			// do once Births is aligned
+			name: "aligned by structure",
+			m: &Map{CausalChains: []Chain{
+				chain("++", "Pop", "Births", "Pop"),
+				chain("+", "Birth Rate", "Births"),
+			}},
+			aligned:   map[string]string{"Population": "Pop", "Births": "Births", "Birth Rate": "Birth Rate"},
+			precision: 1, recall: 1, polarity: 1, loops: 1, score: 1,
+		},
+		{
+			name: "opposite polarity",
+			m: &Map{CausalChains: []Chain{
+				chain("+-", "Population", "Births", "Population"),
+				chain("+", "Birth Rate", "Births"),
+			}},
+			aligned:   map[string]string{"Population": "Population", "Births": "Births", "Birth Rate": "Birth Rate"},
+			precision: 1, recall: 1, polarity: 2.0 / 3, loops: 1, score: (1 + 2.0/3 + 1) / 3,
+		},
+		{
+			name: "extra loop",
+			m: &Map{CausalChains: []Chain{
+				chain("++", "Population", "Births", "Population"),
+				chain("+", "Birth Rate", "Births"),
+				chain("+-", "Population", "Deaths", "Population"),
+			}},
+			aligned:   map[string]string{"Population": "Population", "Births": "Births", "Birth Rate": "Birth Rate"},
+			precision: 3.0 / 5, recall: 1, polarity: 1, loops: 1.0 / 2, score: (6.0/8 + 6.0/8 + 1.0/2) / 3,
+		},
+		{
+			name: "missing link",
+			m: &Map{CausalChains: []Chain{
+				chain("+", "Population", "Births"),
+				chain("+", "Birth Rate", "Births"),
+			}},
+			aligned:   map[string]string{"Population": "Population", "Births": "Births", "Birth Rate": "Birth Rate"},
+			precision: 1, recall: 2.0 / 3, polarity: 1, loops: 0, score: (4.0/5 + 4.0/5 + 0) / 3,
+		},
+		{
+			name: "nothing in common",
+			m: &Map{CausalChains: []Chain{
+				chain("+", "Interest", "Savings"),
+			}},
+			aligned:   map[string]string{},
+			precision: 0, recall: 0, polarity: 0, loops: 0, score: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.m.Compare(reference)
+			aligned := make(map[string]string)
+			for _, a := range c.Alignment {
+				aligned[a.Reference] = a.Variable
+				assert.GreaterOrEqual(t, a.Similarity, AlignThreshold)
+			}
+			assert.Equal(t, tt.aligned, aligned)
+			assert.InDelta(t, tt.precision, c.EdgePrecision, 1e-9, "precision")
+			assert.InDelta(t, tt.recall, c.EdgeRecall, 1e-9, "recall")
+			assert.InDelta(t, tt.polarity, c.PolarityAgreement, 1e-9, "polarity")
+			assert.InDelta(t, tt.loops, c.LoopOverlap, 1e-9, "loops")
+			assert.InDelta(t, tt.score, c.Score, 1e-9, "score")
+		})
+	}
+}
+
+func TestCompareEmpty(t *testing.T) {
+	empty := NewMap(nil)
+	c := empty.Compare(empty)
+	assert.Equal(t, 1.0, c.Score)
+	assert.NotNil(t, c.Alignment)
+
+	m := &Map{CausalChains: []Chain{chain("+-", "a", "b", "a")}}
+	assert.Equal(t, 0.0, empty.Compare(m).Score)
+	assert.Equal(t, 0.0, m.Compare(empty).Score)
+}
+
+// TestCompareFixtures checks that every evaluation fixture's diagram
+// matches itself perfectly.
+func TestCompareFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *sdjson.Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil || len(fixture.Model.Relationships) == 0 {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			m := NewMap(fixture.Model.Relationships)
+			c := m.Compare(m)
+			assert.Len(t, c.Alignment, len(m.Variables()))
+			assert.Equal(t, 1.0, c.Score)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package causal
+
+import (
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// linkGraph is a list of relationships as a graph, with variables
+// numbered in the order they're first mentioned and links in their
+// original order.  Relationships that repeat are separate links.
+type linkGraph struct {
+	spellings []string // each variable's name, as first spelled
+	links     []sdjson.Relationship
+	from, to  []int   // each link's ends
+	out       [][]int // each variable's links, in order
+	used      []bool  // links already in a chain
+}
+
+func newLinkGraph(relationships []sdjson.Relationship) *linkGraph {
+	g := &linkGraph{links: relationships, used: make([]bool, len(relationships))}
+	index := make(map[string]int)
+	variable := func(name string) int {
+		c := Canonicalize(name)
+		v, ok := index[c]
+		if !ok {
+			v = len(g.spellings)
+			index[c] = v
+			g.spellings = append(g.spellings, name)
+			g.out = append(g.out, nil)
+		}
+		return v
+	}
+	for i, r := range relationships {
+		from, to := variable(r.From), variable(r.To)
+		g.from = append(g.from, from)
+		g.to = append(g.to, to)
+		g.out[from] = append(g.out[from], i)
+	}
+	return g
+}
+
+// chains decomposes the graph into as few chains as it reasonably can:
+// first feedback loops, each the shortest through the first link not in
+// one yet, until no loops are left among the remaining links; then, as
+// those links can't form a loop, the fewest paths that cover them.
+func (g *linkGraph) chains() []Chain {
+	var chains []Chain
+	for link := range g.links {
+		if g.used[link] {
+			continue
+		}
+		if path := g.shortestPath(g.to[link], g.from[link]); path != nil {
+			chains = append(chains, g.chain(append([]int{link}, path...)))
+		}
+	}
+
+	// a path can start only where more links leave a variable than
+	// enter it, and every such surplus needs its own path
+	surplus := make([]int, len(g.spellings))
+	for link := range g.links {
+		if !g.used[link] {
+			surplus[g.from[link]]++
+			surplus[g.to[link]]--
+		}
+	}
+	for v := range g.spellings {
+		for ; surplus[v] > 0; surplus[v]-- {
+			var path []int
+			for at := v; ; {
+				next := g.unused(at)
+				if next < 0 {
+					break
+				}
+				g.used[next] = true
+				path = append(path, next)
+				at = g.to[next]
+			}
+			chains = append(chains, g.chain(path))
+		}
+	}
+	return chains
+}
+
+// unused returns the first of a variable's links not in a chain yet, or
+// -1.
+func (g *linkGraph) unused(v int) int {
+	for _, link := range g.out[v] {
+		if !g.used[link] {
+			return link
+		}
+	}
+	return -1
+}
+
+// shortestPath returns the fewest links not in a chain yet that lead
+// from one variable to another, or nil if none do.  A variable leads to
+// itself by no links at all.
+func (g *linkGraph) shortestPath(from, to int) []int {
+	if from == to {
+		return []int{}
+	}
+	via := make([]int, len(g.spellings)) // the link each variable is reached by
+	for i := range via {
+		via[i] = -1
+	}
+	queue := []int{from}
+	for len(queue) > 0 {
+		at := queue[0]
+		queue = queue[1:]
+		for _, link := range g.out[at] {
+			next := g.to[link]
+			if g.used[link] || next == from || via[next] >= 0 {
+				continue
+			}
+			via[next] = link
+			if next == to {
+				var path []int
+				for v := to; v != from; v = g.from[via[v]] {
+					path = append(path, via[v])
+				}
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// chain makes a chain of consecutive links, and marks them used.  The
+// chain's reasoning is its links' if they all have the same.
+func (g *linkGraph) chain(links []int) Chain {
+	c := Chain{
+		InitialVariable: g.spellings[g.from[links[0]]],
+		Relationships:   make([]RelationshipEntry, 0, len(links)),
+		Reasoning:       g.links[links[0]].Reasoning,
+	}
+	for _, link := range links {
+		g.used[link] = true
+		r := g.links[link]
+		c.Relationships = append(c.Relationships, RelationshipEntry{
+			Variable:          g.spellings[g.to[link]],
+			Polarity:          r.Polarity,
+			PolarityReasoning: r.PolarityReasoning,
+		})
+		if r.Reasoning != c.Reasoning {
+			c.Reasoning = ""
+		}
+	}
+	return c
+}
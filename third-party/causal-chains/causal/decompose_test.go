@@ -0,0 +1,136 @@
+package causal
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestNewMapChains(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges [][2]string
+		want  []Chain
+	}{
+		{
+			name:  "path",
+			edges: [][2]string{{"b", "c"}, {"a", "b"}, {"c", "d"}},
+			want:  []Chain{chain("", "a", "b", "c", "d")},
+		},
+		{
+			name:  "loop with a tail",
+			edges: [][2]string{{"a", "b"}, {"b", "c"}, {"c", "a"}, {"c", "d"}, {"x", "a"}},
+			want:  []Chain{chain("", "a", "b", "c", "a"), chain("", "c", "d"), chain("", "x", "a")},
+		},
+		{
+			name:  "shortest loop first",
+			edges: [][2]string{{"a", "b"}, {"b", "c"}, {"c", "a"}, {"b", "a"}},
+			want:  []Chain{chain("", "a", "b", "a"), chain("", "b", "c", "a")},
+		},
+		{
+			name:  "branches",
+			edges: [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}},
+			want:  []Chain{chain("", "a", "b", "d"), chain("", "a", "c", "d")},
+		},
+		{
+			name:  "self link",
+			edges: [][2]string{{"a", "a"}, {"a", "b"}},
+			want:  []Chain{chain("", "a", "a"), chain("", "a", "b")},
+		},
+		{
+			name:  "spellings",
+			edges: [][2]string{{"Birth Rate", "births"}, {"Births", "Population"}},
+			want:  []Chain{chain("", "Birth Rate", "births", "Population")},
+		},
+		{
+			name:  "repeated relationship",
+			edges: [][2]string{{"a", "b"}, {"a", "b"}},
+			want:  []Chain{chain("", "a", "b"), chain("", "a", "b")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := edgeMap(tt.edges...)
+			for i := range tt.want {
+				tt.want[i].Reasoning = ""
+			}
+			assert.Equal(t, tt.want, m.CausalChains)
+		})
+	}
+
+	assert.Empty(t, NewMap(nil).CausalChains)
+}
+
+func TestNewMapReasoning(t *testing.T) {
+	m := NewMap([]sdjson.Relationship{
+		{From: "a", To: "b", Polarity: "+", Reasoning: "same", PolarityReasoning: "ab"},
+		{From: "b", To: "a", Polarity: "-", Reasoning: "same"},
+		{From: "a", To: "c", Polarity: "+", Reasoning: "first"},
+		{From: "c", To: "d", Polarity: "+", Reasoning: "second"},
+	})
+	require.Len(t, m.CausalChains, 2)
+	assert.Equal(t, "same", m.CausalChains[0].Reasoning)
+	assert.Equal(t, RelationshipEntry{Variable: "b", Polarity: "+", PolarityReasoning: "ab"}, m.CausalChains[0].Relationships[0])
+	assert.Empty(t, m.CausalChains[1].Reasoning, "the links of a chain have different reasoning")
+}
+
+// TestNewMapRandom checks that the chains of random graphs keep every
+// link, follow the rules for chains, and are as few as possible.
+func TestNewMapRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for n := range 50 {
+		var relationships []sdjson.Relationship
+		vars := 2 + r.IntN(10)
+		for range r.IntN(3 * vars) {
+			from, to := r.IntN(vars), r.IntN(vars)
+			if from != to {
+				relationships = append(relationships, sdjson.Relationship{
+					From:     fmt.Sprintf("v%d", from),
+					To:       fmt.Sprintf("v%d", to),
+					Polarity: []string{"+", "-"}[r.IntN(2)],
+				})
+			}
+		}
+
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			m := NewMap(relationships)
+			assert.Empty(t, m.Validate())
+
+			var links []sdjson.Relationship
+			var paths bool
+			surplus := make(map[string]int)
+			for _, c := range m.CausalChains {
+				closed := c.InitialVariable == c.Relationships[len(c.Relationships)-1].Variable
+				assert.False(t, closed && paths, "loops come first")
+				paths = paths || !closed
+				from := c.InitialVariable
+				for _, e := range c.Relationships {
+					links = append(links, sdjson.Relationship{From: from, To: e.Variable, Polarity: e.Polarity})
+					if !closed {
+						surplus[from]++
+						surplus[e.Variable]--
+					}
+					from = e.Variable
+				}
+			}
+			assert.ElementsMatch(t, relationships, links)
+
+			// the paths are a minimum cover of the links not in loops
+			var count, needed int
+			for _, c := range m.CausalChains {
+				if c.InitialVariable != c.Relationships[len(c.Relationships)-1].Variable {
+					count++
+				}
+			}
+			for _, s := range surplus {
+				needed += max(s, 0)
+			}
+			assert.Equal(t, needed, count)
+		})
+	}
+}
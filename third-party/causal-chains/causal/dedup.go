@@ -0,0 +1,479 @@
+package causal
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/bpowers/go-agent/chat"
+	"github.com/bpowers/go-agent/schema"
+)
+
+// Merge is a group of variables that name the same thing, such as
+// "Colonist Anger" and "Anger of Colonists", merged into one.
+type Merge struct {
+	// Into is the name the merged variables take.
+	Into string `json:"into"`
+	// Merged are the names that were replaced, as first spelled.
+	Merged []string `json:"merged"`
+	// Similarity is the lowest NameSimilarity of a merged name to Into.
+	Similarity float64 `json:"similarity"`
+}
+
+// DefaultMergeThreshold is the NameSimilarity at which ProposeMerges
+// proposes merging two variables: in practice, names with the same
+// words in a different order or number.
+const DefaultMergeThreshold = 0.8
+
+// DefaultConfirmedMergeThreshold is the NameSimilarity at which
+// ProposeMerges proposes merging two variables when the LLM confirms
+// each merge: low enough to include names with a typo.
+const DefaultConfirmedMergeThreshold = typoSimilarity
+
+// typoSimilarity is the most NameSimilarity that names matching only
+// with a typo score.  A typo is a guess, "Hiring" or "Firing" apart, so
+// it stays below DefaultMergeThreshold for the LLM to confirm.
+const typoSimilarity = 0.7
+
+type dedupOptions struct {
+	threshold       float64
+	preferred       []string
+	client          chat.Client
+	reasoningEffort string
+}
+
+// DedupOption customizes ProposeMerges and Dedup.
+type DedupOption func(*dedupOptions)
+
+// WithMergeThreshold proposes merging variables whose NameSimilarity
+// is at least threshold, in place of DefaultMergeThreshold or
+// DefaultConfirmedMergeThreshold.
+func WithMergeThreshold(threshold float64) DedupOption {
+	return func(o *dedupOptions) {
+		o.threshold = threshold
+	}
+}
+
+// WithPreferredNames names the variables to keep, such as those of a
+// model being revised: a merge takes a preferred name if it has one,
+// and two preferred names are never merged with each other.
+func WithPreferredNames(names ...string) DedupOption {
+	return func(o *dedupOptions) {
+		o.preferred = append(o.preferred, names...)
+	}
+}
+
+// WithConfirmation asks the LLM whether each proposed merge really
+// names one thing, and makes only those it confirms.  Unless
+// WithMergeThreshold says otherwise, it proposes merges from
+// DefaultConfirmedMergeThreshold.
+func WithConfirmation(client chat.Client, reasoningEffort string) DedupOption {
+	return func(o *dedupOptions) {
+		o.client = client
+		o.reasoningEffort = reasoningEffort
+	}
+}
+
+func newDedupOptions(opts []DedupOption) dedupOptions {
+	o := dedupOptions{threshold: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.threshold < 0 {
+		o.threshold = DefaultMergeThreshold
+		if o.client != nil {
+			o.threshold = DefaultConfirmedMergeThreshold
+		}
+	}
+	return o
+}
+
+// stopWords are left out when comparing names, so that "Anger of
+// Colonists" matches "Colonist Anger".
+var stopWords = NewSet("a", "an", "and", "at", "by", "for", "from", "in", "of", "on", "s", "the", "to", "with")
+
+// nameTokens returns the words of a name that matter for comparing it
+// to others, lowercased and in the singular.
+func nameTokens(name string) []string {
+	words := strings.FieldsFunc(Canonicalize(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if !stopWords.Contains(w) {
+			tokens = append(tokens, singular(w))
+		}
+	}
+	return tokens
+}
+
+// singular folds common English plurals, well enough to match names.
+func singular(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "ches"), strings.HasSuffix(w, "shes"), strings.HasSuffix(w, "xes"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"), strings.HasSuffix(w, "is"):
+		return w
+	case len(w) > 3 && strings.HasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// minTypoLength is the length of the shortest words sameToken allows a
+// typo in: shorter words one letter apart, like "Lending" and
+// "Landing", are too often different words.
+const minTypoLength = 8
+
+// sameToken reports whether two words are the same, and whether only
+// because it allows a typo in long words.  A different first letter is
+// a different word, not a typo.
+func sameToken(a, b string) (same, typo bool) {
+	if a == b {
+		return true, false
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < minTypoLength || len(rb) < minTypoLength || ra[0] != rb[0] {
+		return false, false
+	}
+	typo = editDistance(ra, rb) <= 1
+	return typo, typo
+}
+
+// editDistance is the Levenshtein distance between two words.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range a {
+		cur[0] = i + 1
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			cur[j+1] = min(prev[j+1]+1, cur[j]+1, prev[j]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// NameSimilarity scores how likely two variable names are to name the
+// same thing, from 0 to 1, by the words they share: word order, case,
+// plurals and small words like "of" don't matter.  A single typo in a
+// long word is allowed, but names that need one score no more than
+// DefaultConfirmedMergeThreshold.  Names that Canonicalize the same are
+// 1.
+func NameSimilarity(a, b string) float64 {
+	if Canonicalize(a) == Canonicalize(b) {
+		return 1
+	}
+	return tokenSimilarity(nameTokens(a), nameTokens(b))
+}
+
+// tokenSimilarity is the Dice coefficient of two names' words, capped
+// at typoSimilarity if a pair of words only matches with a typo.
+func tokenSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	used := make([]bool, len(b))
+	var shared int
+	var typos bool
+	for _, t := range a {
+		// prefer the same word to one with a typo
+		match, matchTypo := -1, false
+		for j, u := range b {
+			if used[j] {
+				continue
+			}
+			if same, typo := sameToken(t, u); same && (match < 0 || matchTypo && !typo) {
+				match, matchTypo = j, typo
+			}
+		}
+		if match >= 0 {
+			used[match] = true
+			shared++
+			typos = typos || matchTypo
+		}
+	}
+	similarity := 2 * float64(shared) / float64(len(a)+len(b))
+	if typos {
+		similarity = min(similarity, typoSimilarity)
+	}
+	return similarity
+}
+
+// mapName is a variable of a map, however it's spelled.
+type mapName struct {
+	canonical string
+	spelling  string // as first spelled
+	mentions  int
+	tokens    []string
+	preferred bool
+}
+
+// names returns the map's variables in the order they're first
+// mentioned.
+func (m *Map) names() []*mapName {
+	var names []*mapName
+	index := make(map[string]*mapName)
+	mention := func(name string) {
+		c := Canonicalize(name)
+		n, ok := index[c]
+		if !ok {
+			n = &mapName{canonical: c, spelling: name, tokens: nameTokens(name)}
+			index[c] = n
+			names = append(names, n)
+		}
+		n.mentions++
+	}
+	for _, chain := range m.CausalChains {
+		mention(chain.InitialVariable)
+		for _, r := range chain.Relationships {
+			mention(r.Variable)
+		}
+	}
+	return names
+}
+
+// ProposeMerges finds groups of the map's variables whose names are
+// similar enough to name the same thing.  Each group is merged into
+// its preferred name if it has one, or else the name mentioned most.
+// The map is left as it is; ApplyMerges makes the merges.
+func (m *Map) ProposeMerges(opts ...DedupOption) []Merge {
+	o := newDedupOptions(opts)
+	preferred := make(Set[string])
+	for _, name := range o.preferred {
+		preferred.Add(Canonicalize(name))
+	}
+	names := m.names()
+	for _, n := range names {
+		n.preferred = preferred.Contains(n.canonical)
+	}
+
+	// union the similar names, never joining two preferred ones
+	parent := make([]int, len(names))
+	hasPreferred := make([]bool, len(names))
+	for i, n := range names {
+		parent[i], hasPreferred[i] = i, n.preferred
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			ri, rj := find(i), find(j)
+			if ri == rj || hasPreferred[ri] && hasPreferred[rj] {
+				continue
+			}
+			if tokenSimilarity(names[i].tokens, names[j].tokens) >= o.threshold {
+				parent[rj] = ri
+				hasPreferred[ri] = hasPreferred[ri] || hasPreferred[rj]
+			}
+		}
+	}
+
+	groups := make(map[int][]*mapName)
+	var roots []int
+	for i, n := range names {
+		r := find(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], n)
+	}
+
+	var merges []Merge
+	for _, r := range roots {
+		group := groups[r]
+		if len(group) < 2 {
+			continue
+		}
+		into := slices.MaxFunc(group, func(a, b *mapName) int {
+			if a.preferred != b.preferred {
+				if a.preferred {
+					return 1
+				}
+				return -1
+			}
+			// MaxFunc returns the first of equals, the earliest mentioned
+			return a.mentions - b.mentions
+		})
+		merge := Merge{Into: into.spelling, Similarity: 1}
+		for _, n := range group {
+			if n != into {
+				merge.Merged = append(merge.Merged, n.spelling)
+				merge.Similarity = min(merge.Similarity, tokenSimilarity(into.tokens, n.tokens))
+			}
+		}
+		merges = append(merges, merge)
+	}
+	return merges
+}
+
+// ApplyMerges renames the merged variables throughout the map's chains.
+// A link between two variables that were merged into one is dropped,
+// along with any chain left empty.
+func (m *Map) ApplyMerges(merges []Merge) {
+	rename := make(map[string]string)
+	for _, merge := range merges {
+		for _, name := range merge.Merged {
+			rename[Canonicalize(name)] = merge.Into
+		}
+	}
+	if len(rename) == 0 {
+		return
+	}
+	renamed := func(name string) (string, string) {
+		c := Canonicalize(name)
+		if into, ok := rename[c]; ok {
+			return into, Canonicalize(into)
+		}
+		return name, c
+	}
+
+	chains := m.CausalChains[:0]
+	for _, chain := range m.CausalChains {
+		prevOld := Canonicalize(chain.InitialVariable)
+		var prevNew string
+		chain.InitialVariable, prevNew = renamed(chain.InitialVariable)
+
+		relationships := make([]RelationshipEntry, 0, len(chain.Relationships))
+		for _, r := range chain.Relationships {
+			old := Canonicalize(r.Variable)
+			var c string
+			r.Variable, c = renamed(r.Variable)
+			if c == prevNew && old != prevOld {
+				// the link's ends were merged: the next link starts here
+				prevOld = old
+				continue
+			}
+			relationships = append(relationships, r)
+			prevOld, prevNew = old, c
+		}
+		if len(relationships) == 0 {
+			continue
+		}
+		chain.Relationships = relationships
+		chains = append(chains, chain)
+	}
+	m.CausalChains = chains
+}
+
+// Dedup merges the map's variables that name the same thing, asking the
+// LLM to confirm each merge first if WithConfirmation is given, and
+// returns the merges it made.  If confirming fails, the map is left as
+// it is.
+func (m *Map) Dedup(ctx context.Context, opts ...DedupOption) ([]Merge, error) {
+	o := newDedupOptions(opts)
+	merges := m.ProposeMerges(opts...)
+	if len(merges) > 0 && o.client != nil {
+		var err error
+		merges, err = confirmMerges(ctx, o.client, o.reasoningEffort, merges)
+		if err != nil {
+			return nil, fmt.Errorf("confirmMerges: %w", err)
+		}
+	}
+	m.ApplyMerges(merges)
+	return merges, nil
+}
+
+//go:embed merge_prompt.txt
+var mergePrompt string
+
+// mergeDecisionsSchema is the structured output confirmMerges asks for.
+var mergeDecisionsSchema = &schema.JSON{
+	Type: "object",
+	Properties: map[string]*schema.JSON{
+		"decisions": {
+			Type: "array",
+			Items: &schema.JSON{
+				Type: "object",
+				Properties: map[string]*schema.JSON{
+					"index":     {Type: "integer", Description: "The number of the proposed merge."},
+					"reasoning": {Type: "string", Description: "Why the names do or don't mean the same thing in this diagram."},
+					"same":      {Type: "boolean", Description: "Whether every name in the merge means the same thing."},
+				},
+				Required:             []string{"index", "reasoning", "same"},
+				AdditionalProperties: new(bool),
+			},
+		},
+	},
+	Required:             []string{"decisions"},
+	AdditionalProperties: new(bool),
+}
+
+// confirmMerges asks the LLM which of the proposed merges name one
+// thing, and returns those.
+func confirmMerges(ctx context.Context, client chat.Client, reasoningEffort string, merges []Merge) ([]Merge, error) {
+	schemaJSON, err := json.MarshalIndent(mergeDecisionsSchema, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	c := client.NewChat(strings.ReplaceAll(mergePrompt, "{schema}", string(schemaJSON)))
+
+	opts := []chat.Option{chat.WithResponseFormat("merge_decisions", true, mergeDecisionsSchema)}
+	if reasoningEffort != "" {
+		opts = append(opts, chat.WithReasoningEffort(reasoningEffort))
+	}
+	resp, err := c.Message(ctx, chat.UserMessage(mergeMessage(merges)), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("c.Message: %w", err)
+	}
+	return parseMergeDecisions(resp.GetText(), merges)
+}
+
+// mergeMessage lists the proposed merges, numbered, for the LLM.
+func mergeMessage(merges []Merge) string {
+	var b strings.Builder
+	b.WriteString("Proposed merges:\n")
+	for i, merge := range merges {
+		quoted := make([]string, 0, len(merge.Merged)+1)
+		for _, name := range append([]string{merge.Into}, merge.Merged...) {
+			quoted = append(quoted, fmt.Sprintf("%q", name))
+		}
+		fmt.Fprintf(&b, "%d. %s\n", i, strings.Join(quoted, ", "))
+	}
+	return b.String()
+}
+
+// parseMergeDecisions returns the merges the LLM confirmed.  A merge it
+// didn't decide on isn't made.
+func parseMergeDecisions(content string, merges []Merge) ([]Merge, error) {
+	var resp struct {
+		Decisions []struct {
+			Index int  `json:"index"`
+			Same  bool `json:"same"`
+		} `json:"decisions"`
+	}
+	if err := json.Unmarshal([]byte(stripCodeFence(content)), &resp); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	same := make([]bool, len(merges))
+	for _, d := range resp.Decisions {
+		if d.Index >= 0 && d.Index < len(merges) {
+			same[d.Index] = d.Same
+		}
+	}
+	var confirmed []Merge
+	for i, merge := range merges {
+		if same[i] {
+			confirmed = append(confirmed, merge)
+		}
+	}
+	return confirmed, nil
+}
@@ -0,0 +1,143 @@
+package causal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"Colonist Anger", "Anger of Colonists", 1},
+		{"Customer Satisfaction", "satisfaction_of_the_customers", 1},
+		{"Pollution", "Polution", typoSimilarity},
+		{"Customer Satisfaction", "Customer Satisfation", typoSimilarity},
+		{"Births", "births", 1},
+		{"Colonies", "Colony", 1},
+		{"Crown Taxes", "Crown Tax", 1},
+		{"Churches", "church", 1},
+		{"Birth Rate", "Death Rate", 0.5},
+		{"Population", "Population Growth", 2.0 / 3},
+		{"Employment", "Unemployment", 0},
+		{"Cost", "Costs of Goods", 2.0 / 3},
+		{"The", "Of", 0},
+		// one letter apart, but different words
+		{"Hiring Rate", "Firing Rate", 0.5},
+		{"Lending", "Landing", 0},
+		{"Imports", "Exports", 0},
+		{"Import", "Export", 0},
+		{"Investing", "Divesting", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"~"+tt.b, func(t *testing.T) {
+			assert.InDelta(t, tt.want, NameSimilarity(tt.a, tt.b), 1e-9)
+			assert.InDelta(t, tt.want, NameSimilarity(tt.b, tt.a), 1e-9)
+		})
+	}
+}
+
+func dedupMap(t *testing.T) *Map {
+	t.Helper()
+	m := new(Map)
+	require.NoError(t, json.Unmarshal([]byte(`{"causal_chains": [
+		{"initial_variable": "Colonist Anger", "relationships": [{"variable": "Protests", "polarity": "+"}, {"variable": "Crown Taxes", "polarity": "-"}, {"variable": "Colonist Anger", "polarity": "+"}]},
+		{"initial_variable": "Anger of Colonists", "relationships": [{"variable": "Boycotts", "polarity": "+"}]},
+		{"initial_variable": "Crown Tax", "relationships": [{"variable": "Anger of Colonists", "polarity": "+"}, {"variable": "Colonist Anger", "polarity": "+"}, {"variable": "protest", "polarity": "+"}]},
+		{"initial_variable": "Boycotts", "relationships": [{"variable": "Trade", "polarity": "-"}]}
+	]}`), m))
+	return m
+}
+
+func TestProposeMerges(t *testing.T) {
+	m := dedupMap(t)
+	want := []Merge{
+		{Into: "Colonist Anger", Merged: []string{"Anger of Colonists"}, Similarity: 1},
+		{Into: "Protests", Merged: []string{"protest"}, Similarity: 1},
+		{Into: "Crown Taxes", Merged: []string{"Crown Tax"}, Similarity: 1},
+	}
+	assert.Equal(t, want, m.ProposeMerges())
+	assert.Len(t, m.Variables(), 8, "proposing leaves the map as it is")
+
+	// a preferred name wins, even if it's mentioned less
+	merges := m.ProposeMerges(WithPreferredNames("anger of colonists"))
+	assert.Equal(t, Merge{Into: "Anger of Colonists", Merged: []string{"Colonist Anger"}, Similarity: 1}, merges[0])
+
+	// two preferred names are never merged
+	merges = m.ProposeMerges(WithPreferredNames("Colonist Anger", "Anger of Colonists"))
+	assert.Len(t, merges, 2)
+	assert.Equal(t, "Protests", merges[0].Into)
+
+	assert.Empty(t, m.ProposeMerges(WithMergeThreshold(1.1)))
+}
+
+func TestProposeMergesTypos(t *testing.T) {
+	m := &Map{CausalChains: []Chain{
+		chain("+++", "Hiring Rate", "Firing Rate", "Pollution", "Polution"),
+	}}
+	// a typo alone isn't enough to merge without the LLM's confirmation
+	assert.Empty(t, m.ProposeMerges())
+
+	// at the threshold for confirmed merges, names with a typo are
+	// proposed, but different words aren't
+	merges := m.ProposeMerges(WithMergeThreshold(DefaultConfirmedMergeThreshold))
+	assert.Equal(t, []Merge{{Into: "Pollution", Merged: []string{"Polution"}, Similarity: typoSimilarity}}, merges)
+}
+
+func TestApplyMerges(t *testing.T) {
+	m := dedupMap(t)
+	merges, err := m.Dedup(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, merges, 3)
+
+	assert.ElementsMatch(t, []string{"Colonist Anger", "Protests", "Crown Taxes", "Boycotts", "Trade"}, m.Variables().Slice())
+	require.Len(t, m.CausalChains, 4)
+	assert.Equal(t, "Colonist Anger", m.CausalChains[1].InitialVariable)
+
+	// Crown Tax -> Anger of Colonists -> Colonist Anger -> protest loses
+	// the link between the two angers, and keeps the polarity of the link
+	// out of the merged one
+	chain := m.CausalChains[2]
+	assert.Equal(t, "Crown Taxes", chain.InitialVariable)
+	require.Len(t, chain.Relationships, 2)
+	assert.Equal(t, RelationshipEntry{Variable: "Colonist Anger", Polarity: "+"}, chain.Relationships[0])
+	assert.Equal(t, "Protests", chain.Relationships[1].Variable)
+
+	// a chain that only linked merged variables is dropped
+	m = new(Map)
+	require.NoError(t, json.Unmarshal([]byte(`{"causal_chains": [
+		{"initial_variable": "Colonist Anger", "relationships": [{"variable": "Anger of Colonists", "polarity": "+"}]},
+		{"initial_variable": "Trade", "relationships": [{"variable": "Trade", "polarity": "+"}]}
+	]}`), m))
+	m.ApplyMerges(m.ProposeMerges())
+	require.Len(t, m.CausalChains, 1, "links to themselves that weren't made by merging are kept")
+	assert.Equal(t, "Trade", m.CausalChains[0].InitialVariable)
+}
+
+func TestParseMergeDecisions(t *testing.T) {
+	merges := []Merge{
+		{Into: "Colonist Anger", Merged: []string{"Anger of Colonists"}},
+		{Into: "Population", Merged: []string{"Population Growth"}},
+		{Into: "Protests", Merged: []string{"protest"}},
+	}
+
+	msg := mergeMessage(merges)
+	assert.Contains(t, msg, `0. "Colonist Anger", "Anger of Colonists"`)
+	assert.Contains(t, msg, `2. "Protests", "protest"`)
+
+	confirmed, err := parseMergeDecisions("```json\n"+`{"decisions": [
+		{"index": 0, "reasoning": "the same feeling", "same": true},
+		{"index": 1, "reasoning": "a stock and its flow", "same": false},
+		{"index": 7, "reasoning": "no such merge", "same": true}
+	]}`+"\n```", merges)
+	require.NoError(t, err)
+	assert.Equal(t, merges[:1], confirmed, "an undecided merge isn't made")
+
+	_, err = parseMergeDecisions("not json", merges)
+	assert.Error(t, err)
+}
@@ -8,13 +8,42 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
 	"github.com/bpowers/go-agent/chat"
 )
 
 var codeFenceStartRe = regexp.MustCompile("^```.*\n")
 
 type Diagrammer interface {
-	Generate(ctx context.Context, prompt, backgroundKnowledge string) (*Map, error)
+	Generate(ctx context.Context, prompt, backgroundKnowledge string, opts ...GenerateOption) (*Map, error)
+}
+
+type generateOptions struct {
+	currentModel     *sdjson.Model
+	problemStatement string
+}
+
+// GenerateOption customizes a single call to Generate.
+type GenerateOption func(*generateOptions)
+
+// WithCurrentModel asks Generate to revise an existing diagram rather
+// than start from scratch.  The model's relationships are given to the
+// LLM as causal chains to build on; use Map.UpdateModel to apply the
+// result to the model.  A nil or empty model is ignored.
+func WithCurrentModel(m *sdjson.Model) GenerateOption {
+	return func(o *generateOptions) {
+		o.currentModel = m
+	}
+}
+
+// WithProblemStatement steers Generate toward the feedback structure
+// behind a problem, described as an undesirable behavior over time, and
+// asks for an explanation of the loops that produce it.  An empty
+// statement is ignored.
+func WithProblemStatement(statement string) GenerateOption {
+	return func(o *generateOptions) {
+		o.problemStatement = statement
+	}
 }
 
 type diagrammer struct {
@@ -37,9 +66,20 @@ var (
 
 	//go:embed background_prompt.txt
 	backgroundPrompt string
+
+	//go:embed current_model_prompt.txt
+	currentModelPrompt string
+
+	//go:embed problem_statement_prompt.txt
+	problemStatementPrompt string
 )
 
-func (d diagrammer) Generate(ctx context.Context, prompt, backgroundKnowledge string) (*Map, error) {
+func (d diagrammer) Generate(ctx context.Context, prompt, backgroundKnowledge string, genOpts ...GenerateOption) (*Map, error) {
+	var o generateOptions
+	for _, opt := range genOpts {
+		opt(&o)
+	}
+
 	schema, err := json.MarshalIndent(RelationshipsResponseSchema, "", "    ")
 	if err != nil {
 		return nil, fmt.Errorf("json.MarshalIndent: %w", err)
@@ -47,10 +87,11 @@ func (d diagrammer) Generate(ctx context.Context, prompt, backgroundKnowledge st
 
 	systemPrompt := strings.ReplaceAll(baseSystemPrompt, "{schema}", string(schema))
 
-	msg := chat.UserMessage(fmt.Sprintf("%s\n\n%s",
-		strings.ReplaceAll(backgroundPrompt, "{backgroundKnowledge}", backgroundKnowledge),
-		prompt,
-	))
+	content, err := userMessage(prompt, backgroundKnowledge, o)
+	if err != nil {
+		return nil, fmt.Errorf("userMessage: %w", err)
+	}
+	msg := chat.UserMessage(content)
 
 	c := d.client.NewChat(systemPrompt)
 
@@ -92,6 +133,29 @@ func (d diagrammer) Generate(ctx context.Context, prompt, backgroundKnowledge st
 	return result, nil
 }
 
+// userMessage builds the request to the LLM: the user's prompt, preceded
+// by the background knowledge, the problem statement and the current
+// model, if there are any.
+func userMessage(prompt, backgroundKnowledge string, o generateOptions) (string, error) {
+	preamble := strings.ReplaceAll(backgroundPrompt, "{backgroundKnowledge}", backgroundKnowledge)
+
+	if statement := strings.TrimSpace(o.problemStatement); statement != "" {
+		preamble += "\n\n" + strings.ReplaceAll(problemStatementPrompt, "{problemStatement}", statement)
+	}
+
+	current, err := formatCurrentModel(o.currentModel)
+	if err != nil {
+		return "", fmt.Errorf("formatCurrentModel: %w", err)
+	}
+	if current != "" {
+		preamble += "\n\n" + current
+	}
+
+	return fmt.Sprintf("%s\n\n%s", preamble, prompt), nil
+}
+
+// parseRelationshipsResponse parses the LLM's response, repairing its
+// chains.  Its errors are meant to be sent back to the LLM.
 func parseRelationshipsResponse(content string) (*Map, error) {
 	cleaned := stripCodeFence(content)
 	if cleaned == "" {
@@ -103,6 +167,18 @@ func parseRelationshipsResponse(content string) (*Map, error) {
 		return nil, fmt.Errorf("json.Unmarshal: %w", err)
 	}
 
+	// fix what we can of chains that break the rules, and send the rest
+	// back to the LLM
+	var unrepaired []string
+	for _, p := range rr.Repair() {
+		if !p.Repairable {
+			unrepaired = append(unrepaired, p.String())
+		}
+	}
+	if len(unrepaired) > 0 {
+		return nil, fmt.Errorf("invalid causal chains (chains are numbered from 0):\n%s", strings.Join(unrepaired, "\n"))
+	}
+
 	return &rr, nil
 }
 
@@ -2,7 +2,7 @@ package causal
 
 import (
 	"encoding/json"
-	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -289,20 +289,19 @@ func TestExtractingResults(t *testing.T) {
 	actualVars := causalMap.Variables()
 	assert.Equal(t, expectedVars, actualVars)
 
-	loops := causalMap.Loops()
+	var loops [][]string
+	for _, loop := range causalMap.Loops() {
+		loops = append(loops, loop.Variables())
+	}
 
-	// canonicalized
-	assert.Contains(t, loops, []string{"anti-british_sentiment", "collective_action", "colonial_identity", "anti-british_sentiment"})
-	assert.Contains(t, loops, []string{"anti-british_sentiment", "political_mobilization", "unified_assemblies", "resolute_demands", "anti-british_sentiment"})
-	assert.Contains(t, loops, []string{"british_repressive_policies", "tax_burden", "colonist_anger", "collective_action", "british_repressive_policies"})
+	// starting from the lowest canonicalized name
+	assert.Contains(t, loops, []string{"Anti-British Sentiment", "Collective Action", "Colonial Identity"})
+	assert.Contains(t, loops, []string{"Anti-British Sentiment", "Political Mobilization", "Unified Assemblies", "Resolute Demands"})
+	assert.Contains(t, loops, []string{"British Repressive Policies", "Tax Burden", "Colonist Anger", "Collective Action"})
 	assert.Equal(t, 3, len(loops))
 }
 
 func TestDiagrammerSVG(t *testing.T) {
-	if _, err := exec.LookPath("dot"); err != nil {
-		t.Skip("Skipping test because graphviz is not installed.")
-	}
-
 	var causalMap Map
 	err := json.Unmarshal([]byte(roadRage1), &causalMap)
 	require.NoError(t, err)
@@ -313,19 +312,63 @@ func TestDiagrammerSVG(t *testing.T) {
 	svg, err := causalMap.VisualSVG()
 	require.NoError(t, err)
 
-	// assert we got something
-	assert.Greater(t, len(svg), 0)
-
-	// f, err := os.CreateTemp("", "cld-*.svg")
-	// require.NoError(t, err)
+	classes := svgClasses(t, svg)
+	assert.Equal(t, len(causalMap.Compat().Relationships), classes["link"])
+	assert.Equal(t, len(causalMap.Compat().Relationships), classes["polarity"])
+	assert.Equal(t, len(causalMap.Variables()), classes["variable"])
+	assert.Equal(t, len(loops), classes["loop-label"])
 
-	// n, err := f.Write(svg)
-	// require.NoError(t, err)
-	// require.Equal(t, len(svg), n)
+	// the layout is the same every time for the same seed
+	again, err := causalMap.VisualSVG()
+	require.NoError(t, err)
+	assert.Equal(t, svg, again)
 
-	// path := f.Name()
-	// require.NoError(t, f.Close())
+	reseeded, err := causalMap.VisualSVG(WithSeed(2))
+	require.NoError(t, err)
+	assert.NotEqual(t, svg, reseeded)
+}
 
-	// err = exec.Command("open", path).Run()
-	// require.NoError(t, err)
+func TestUserMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        generateOptions
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:        "prompt only",
+			contains:    []string{"background knowledge", "Draw a diagram"},
+			notContains: []string{"undesirable behavior", "causal_chains"},
+		},
+		{
+			name:        "problem statement",
+			opts:        generateOptions{problemStatement: "  Congestion keeps getting worse despite new roads.\n"},
+			contains:    []string{"undesirable behavior over time:\n\nCongestion keeps getting worse despite new roads.\n\n", "name the loops that produce the behavior"},
+			notContains: []string{"{problemStatement}"},
+		},
+		{
+			name:        "blank problem statement",
+			opts:        generateOptions{problemStatement: " \n"},
+			notContains: []string{"undesirable behavior"},
+		},
+		{
+			name:     "current model",
+			opts:     generateOptions{currentModel: currentModel(), problemStatement: "Population overshoots."},
+			contains: []string{"Population overshoots.", `"initial_variable": "Births"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := userMessage("Draw a diagram", "Roads are expensive.", tt.opts)
+			require.NoError(t, err)
+			assert.True(t, strings.HasSuffix(msg, "\n\nDraw a diagram"))
+			assert.Contains(t, msg, "Roads are expensive.")
+			for _, s := range tt.contains {
+				assert.Contains(t, msg, s)
+			}
+			for _, s := range tt.notContains {
+				assert.NotContains(t, msg, s)
+			}
+		})
+	}
 }
@@ -0,0 +1,452 @@
+package causal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// ErrTooManyLoops is returned when a map has more loops than
+// LoopOptions.MaxLoops allows.
+var ErrTooManyLoops = errors.New("too many feedback loops")
+
+// LoopOptions bounds the search for feedback loops, which can take
+// exponential time on dense maps.  Zero values mean no limit.
+type LoopOptions struct {
+	// MaxLoops is the number of loops to find before giving up with
+	// ErrTooManyLoops.
+	MaxLoops int
+	// MaxLength skips loops with more variables than this.
+	MaxLength int
+}
+
+// DefaultLoopOptions are the limits Loops uses.
+var DefaultLoopOptions = LoopOptions{MaxLoops: 10000}
+
+// outgoing returns the edges of the map's graph, by canonical name.
+func (m *Map) outgoing() map[string][]string {
+	outgoing := make(map[string][]string)
+	for _, chain := range m.CausalChains {
+		for i, r := range chain.Relationships {
+			var from string
+			if i == 0 {
+				from = chain.InitialVariable
+			} else {
+				from = chain.Relationships[i-1].Variable
+			}
+			from = Canonicalize(from)
+			to := Canonicalize(r.Variable)
+			if !slices.Contains(outgoing[from], to) {
+				outgoing[from] = append(outgoing[from], to)
+			}
+		}
+	}
+	return outgoing
+}
+
+// Link is a causal relationship in a feedback loop.
+type Link struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Polarity string `json:"polarity"` // "+", "-", or "?" if unknown
+}
+
+// Loop is a feedback loop, in the form the discussion engines take as
+// feedbackLoops.
+type Loop struct {
+	// Identifier is R for reinforcing loops, B for balancing ones, or U
+	// if the polarity is unknown, followed by the loop's number among
+	// those of its kind, as in "R1" or "B2".
+	Identifier string `json:"identifier"`
+	Name       string `json:"name"`
+	Links      []Link `json:"links"`
+	// Polarity is "+" for reinforcing loops, "-" for balancing ones,
+	// and "?" if any of its links' polarities are unknown.
+	Polarity string `json:"polarity"`
+}
+
+// Reinforcing reports whether the loop amplifies change.
+func (l Loop) Reinforcing() bool {
+	return l.Polarity == "+"
+}
+
+// Balancing reports whether the loop counteracts change.
+func (l Loop) Balancing() bool {
+	return l.Polarity == "-"
+}
+
+// Variables returns the names of the variables in the loop, in order.
+func (l Loop) Variables() []string {
+	names := make([]string, len(l.Links))
+	for i, link := range l.Links {
+		names[i] = link.From
+	}
+	return names
+}
+
+// Loops returns the map's feedback loops.  Each loop starts with its
+// lowest-named variable, shorter loops come first, and identifiers are
+// numbered in that order.  Variables are named as the map first spells
+// them.  Loops stops early, returning the loops it has found so far, if
+// there are more than DefaultLoopOptions allows.
+func (m *Map) Loops() []Loop {
+	loops, _ := m.LoopsContext(context.Background(), DefaultLoopOptions)
+	return loops
+}
+
+// LoopsContext is like Loops, but with explicit limits.  If the context
+// is done or there are too many loops, it returns the loops it has found
+// so far along with the error.
+func (m *Map) LoopsContext(ctx context.Context, opts LoopOptions) ([]Loop, error) {
+	var cycles [][]string
+	var err error
+	for cycle, loopErr := range m.AllLoops(ctx, opts) {
+		if loopErr != nil {
+			err = loopErr
+			break
+		}
+		cycles = append(cycles, cycle)
+	}
+
+	slices.SortStableFunc(cycles, func(a, b []string) int {
+		if len(a) < len(b) {
+			return -1
+		} else if len(a) > len(b) {
+			return 1
+		}
+
+		return slices.Compare(a, b)
+	})
+
+	return m.classify(cycles), err
+}
+
+// classify turns cycles of canonicalized names into loops.  A link's
+// polarity is the one Relationships settles on, taken over every
+// spelling of its variables' names.
+func (m *Map) classify(cycles [][]string) []Loop {
+	type edge struct{ from, to string }
+	names := make(map[string]string)
+	links := make(map[edge]*Provenance)
+	provs, _ := m.Relationships()
+	for _, p := range provs {
+		for _, name := range []string{p.Relationship.From, p.Relationship.To} {
+			if _, ok := names[Canonicalize(name)]; !ok {
+				names[Canonicalize(name)] = name
+			}
+		}
+		e := edge{Canonicalize(p.Relationship.From), Canonicalize(p.Relationship.To)}
+		if link, ok := links[e]; ok {
+			link.Assertions = append(link.Assertions, p.Assertions...)
+			slices.SortStableFunc(link.Assertions, func(a, b Assertion) int { return a.Chain - b.Chain })
+			continue
+		}
+		links[e] = &Provenance{Assertions: slices.Clone(p.Assertions)}
+	}
+	polarities := make(map[edge]string, len(links))
+	for e, link := range links {
+		polarity := link.polarity()
+		if polarity != "+" && polarity != "-" {
+			polarity = "?"
+		}
+		polarities[e] = polarity
+	}
+
+	counts := make(map[string]int)
+	loops := make([]Loop, 0, len(cycles))
+	for _, cycle := range cycles {
+		var loop Loop
+		negative, unknown := 0, false
+		display := make([]string, len(cycle))
+		for i, name := range cycle {
+			display[i] = names[name]
+		}
+		for i := 0; i+1 < len(cycle); i++ {
+			polarity := polarities[edge{cycle[i], cycle[i+1]}]
+			switch polarity {
+			case "-":
+				negative++
+			case "?":
+				unknown = true
+			}
+			loop.Links = append(loop.Links, Link{From: display[i], To: display[i+1], Polarity: polarity})
+		}
+
+		kind := "R"
+		switch {
+		case unknown:
+			loop.Polarity, kind = "?", "U"
+		case negative%2 == 1:
+			loop.Polarity, kind = "-", "B"
+		default:
+			loop.Polarity = "+"
+		}
+		counts[kind]++
+		loop.Identifier = fmt.Sprintf("%s%d", kind, counts[kind])
+		loop.Name = strings.Join(display, " -> ")
+		loops = append(loops, loop)
+	}
+	return loops
+}
+
+// AllLoops iterates over the map's feedback loops as lists of
+// canonicalized variable names, in no particular order.  Each list
+// starts with the loop's lowest-named variable and repeats it at the
+// end.  If the context is done or there are more than opts.MaxLoops
+// loops, the final iteration yields the error instead of a loop.
+func (m *Map) AllLoops(ctx context.Context, opts LoopOptions) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		outgoing := m.outgoing()
+
+		// number the variables in name order, so each loop is found
+		// starting from its lowest-named variable
+		names := make(Set[string], len(outgoing))
+		for from, tos := range outgoing {
+			names.Add(from)
+			for _, to := range tos {
+				names.Add(to)
+			}
+		}
+		vertices := names.Slice()
+		index := make(map[string]int, len(vertices))
+		for i, name := range vertices {
+			index[name] = i
+		}
+		adj := make([][]int, len(vertices))
+		for from, tos := range outgoing {
+			for _, to := range tos {
+				adj[index[from]] = append(adj[index[from]], index[to])
+			}
+		}
+		for _, ws := range adj {
+			slices.Sort(ws)
+		}
+
+		err := findCircuits(ctx, adj, opts, func(circuit []int) bool {
+			loop := make([]string, 0, len(circuit)+1)
+			for _, v := range circuit {
+				loop = append(loop, vertices[v])
+			}
+			return yield(append(loop, loop[0]), nil)
+		})
+		if err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// findCircuits calls yield with each elementary circuit of a graph,
+// given as adjacency lists, until yield returns false.  Each circuit
+// starts with its lowest-numbered vertex.
+//
+// It uses Johnson's algorithm ("Finding all the elementary circuits of
+// a directed graph", 1975), which takes time linear in the number of
+// circuits.  Johnson's blocking assumes every path is explored to the
+// end, so with a length limit it falls back to a plain backtracking
+// search, which the limit keeps bounded.
+func findCircuits(ctx context.Context, adj [][]int, opts LoopOptions, yield func([]int) bool) error {
+	n := len(adj)
+	c := &circuitSearch{
+		ctx:     ctx,
+		adj:     adj,
+		opts:    opts,
+		yield:   yield,
+		inComp:  make([]bool, n),
+		blocked: make([]bool, n),
+		b:       make([][]int, n),
+	}
+
+	for s := 0; s < n && !c.done; s++ {
+		if !c.component(s) {
+			continue
+		}
+		c.s = s
+		for v := range n {
+			if c.inComp[v] {
+				c.blocked[v] = false
+				c.b[v] = c.b[v][:0]
+			}
+		}
+		if opts.MaxLength > 0 {
+			c.backtrack(s)
+		} else {
+			c.circuit(s)
+		}
+	}
+	return c.err
+}
+
+type circuitSearch struct {
+	ctx   context.Context
+	adj   [][]int
+	opts  LoopOptions
+	yield func([]int) bool
+
+	// s is the least vertex of the current search, and inComp marks
+	// the strongly connected component it's in, among vertices >= s
+	s      int
+	inComp []bool
+
+	blocked []bool
+	b       [][]int
+	stack   []int
+
+	found int
+	steps int
+	done  bool
+	err   error
+}
+
+// component finds the strongly connected component containing s in
+// the subgraph of vertices >= s, and reports whether it has any
+// circuits.
+func (c *circuitSearch) component(s int) bool {
+	n := len(c.adj)
+	forward := c.reach(s, func(v int) []int { return c.adj[v] })
+
+	// the reverse graph, restricted to vertices reachable from s
+	reverse := make([][]int, n)
+	for v := s; v < n; v++ {
+		if !forward[v] {
+			continue
+		}
+		for _, w := range c.adj[v] {
+			if w >= s && forward[w] {
+				reverse[w] = append(reverse[w], v)
+			}
+		}
+	}
+	backward := c.reach(s, func(v int) []int { return reverse[v] })
+
+	size := 0
+	for v := range n {
+		c.inComp[v] = v >= s && forward[v] && backward[v]
+		if c.inComp[v] {
+			size++
+		}
+	}
+	return size > 1 || slices.Contains(c.adj[s], s)
+}
+
+// reach marks the vertices >= s reachable from s.
+func (c *circuitSearch) reach(s int, next func(int) []int) []bool {
+	seen := make([]bool, len(c.adj))
+	seen[s] = true
+	queue := []int{s}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range next(v) {
+			if w >= s && !seen[w] {
+				seen[w] = true
+				queue = append(queue, w)
+			}
+		}
+	}
+	return seen
+}
+
+// step checks the context every so often.
+func (c *circuitSearch) step() bool {
+	c.steps++
+	if c.steps%1024 == 0 {
+		if err := c.ctx.Err(); err != nil {
+			c.err = err
+			c.done = true
+		}
+	}
+	return !c.done
+}
+
+func (c *circuitSearch) emit() {
+	if c.opts.MaxLoops > 0 && c.found >= c.opts.MaxLoops {
+		c.err = ErrTooManyLoops
+		c.done = true
+		return
+	}
+	c.found++
+	if !c.yield(slices.Clone(c.stack)) {
+		c.done = true
+	}
+}
+
+// circuit is Johnson's CIRCUIT procedure.
+func (c *circuitSearch) circuit(v int) bool {
+	if !c.step() {
+		return false
+	}
+
+	found := false
+	c.stack = append(c.stack, v)
+	c.blocked[v] = true
+
+	for _, w := range c.adj[v] {
+		if c.done {
+			break
+		}
+		if !c.inComp[w] {
+			continue
+		}
+		if w == c.s {
+			c.emit()
+			found = true
+		} else if !c.blocked[w] && c.circuit(w) {
+			found = true
+		}
+	}
+
+	if found {
+		c.unblock(v)
+	} else {
+		for _, w := range c.adj[v] {
+			if c.inComp[w] && !slices.Contains(c.b[w], v) {
+				c.b[w] = append(c.b[w], v)
+			}
+		}
+	}
+
+	c.stack = c.stack[:len(c.stack)-1]
+	return found
+}
+
+func (c *circuitSearch) unblock(u int) {
+	c.blocked[u] = false
+	for len(c.b[u]) > 0 {
+		w := c.b[u][len(c.b[u])-1]
+		c.b[u] = c.b[u][:len(c.b[u])-1]
+		if c.blocked[w] {
+			c.unblock(w)
+		}
+	}
+}
+
+// backtrack finds the circuits through s of at most opts.MaxLength
+// vertices, using blocked to mark the vertices on the current path.
+func (c *circuitSearch) backtrack(v int) {
+	if !c.step() {
+		return
+	}
+
+	c.stack = append(c.stack, v)
+	c.blocked[v] = true
+
+	for _, w := range c.adj[v] {
+		if c.done {
+			break
+		}
+		if !c.inComp[w] {
+			continue
+		}
+		if w == c.s {
+			c.emit()
+		} else if !c.blocked[w] && len(c.stack) < c.opts.MaxLength {
+			c.backtrack(w)
+		}
+	}
+
+	c.blocked[v] = false
+	c.stack = c.stack[:len(c.stack)-1]
+}
@@ -0,0 +1,283 @@
+package causal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func edgeMap(edges ...[2]string) *Map {
+	relationships := make([]sdjson.Relationship, 0, len(edges))
+	for _, e := range edges {
+		relationships = append(relationships, sdjson.Relationship{From: e[0], To: e[1], Polarity: "+"})
+	}
+	return NewMap(relationships)
+}
+
+// completeMap links every pair of n variables in both directions.
+func completeMap(n int) *Map {
+	var edges [][2]string
+	for i := range n {
+		for j := range n {
+			if i != j {
+				edges = append(edges, [2]string{fmt.Sprintf("v%02d", i), fmt.Sprintf("v%02d", j)})
+			}
+		}
+	}
+	return edgeMap(edges...)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        *Map
+		expected [][]string
+	}{
+		{
+			name: "no loops",
+			m:    edgeMap([2]string{"a", "b"}, [2]string{"b", "c"}, [2]string{"a", "c"}),
+		},
+		{
+			name:     "self loop",
+			m:        edgeMap([2]string{"a", "a"}, [2]string{"a", "b"}),
+			expected: [][]string{{"a"}},
+		},
+		{
+			name: "loops sharing a prefix",
+			// a DFS that never unmarks visited variables finds only one
+			// of the loops through b
+			m: edgeMap(
+				[2]string{"a", "b"}, [2]string{"b", "c"}, [2]string{"c", "a"},
+				[2]string{"b", "d"}, [2]string{"d", "c"},
+			),
+			expected: [][]string{{"a", "b", "c"}, {"a", "b", "d", "c"}},
+		},
+		{
+			name: "rotated to the lowest name",
+			m: edgeMap(
+				[2]string{"Zebra Count", "Lion Count"}, [2]string{"Lion Count", "Zebra Count"},
+				[2]string{"Lion Count", "Grass"}, [2]string{"Grass", "Zebra Count"},
+			),
+			expected: [][]string{
+				{"Lion Count", "Zebra Count"},
+				{"Grass", "Zebra Count", "Lion Count"},
+			},
+		},
+		{
+			name: "duplicate links",
+			m:    edgeMap([2]string{"a", "b"}, [2]string{"b", "a"}, [2]string{"A", "b"}),
+			expected: [][]string{
+				{"a", "b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actual [][]string
+			for _, loop := range tt.m.Loops() {
+				actual = append(actual, loop.Variables())
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestLoopsCount(t *testing.T) {
+	// a complete graph on n vertices has sum over k of C(n, k) (k-1)!
+	// elementary circuits
+	assert.Len(t, completeMap(4).Loops(), 20)
+	assert.Len(t, completeMap(5).Loops(), 84)
+	assert.Len(t, completeMap(6).Loops(), 409)
+}
+
+func TestLoopsContextLimits(t *testing.T) {
+	ctx := context.Background()
+
+	loops, err := completeMap(4).LoopsContext(ctx, LoopOptions{MaxLength: 2})
+	require.NoError(t, err)
+	assert.Len(t, loops, 6)
+
+	loops, err = completeMap(4).LoopsContext(ctx, LoopOptions{MaxLength: 3})
+	require.NoError(t, err)
+	assert.Len(t, loops, 14)
+	for _, loop := range loops {
+		assert.LessOrEqual(t, len(loop.Links), 3)
+	}
+
+	loops, err = completeMap(5).LoopsContext(ctx, LoopOptions{MaxLoops: 10})
+	assert.ErrorIs(t, err, ErrTooManyLoops)
+	assert.Len(t, loops, 10)
+
+	loops, err = completeMap(5).LoopsContext(ctx, LoopOptions{MaxLoops: 84})
+	require.NoError(t, err)
+	assert.Len(t, loops, 84)
+}
+
+func TestLoopsContextCanceled(t *testing.T) {
+	// K12 has hundreds of millions of loops
+	m := completeMap(12)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := m.LoopsContext(ctx, LoopOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = m.LoopsContext(ctx, LoopOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAllLoops(t *testing.T) {
+	m := completeMap(5)
+
+	var loops [][]string
+	for loop, err := range m.AllLoops(context.Background(), LoopOptions{}) {
+		require.NoError(t, err)
+		loops = append(loops, loop)
+		if len(loops) == 3 {
+			break
+		}
+	}
+	assert.Len(t, loops, 3)
+	for _, loop := range loops {
+		assert.Equal(t, loop[0], loop[len(loop)-1])
+		assert.Equal(t, slices.Min(loop), loop[0])
+	}
+}
+
+func TestLoopsBacktrackingAgrees(t *testing.T) {
+	// with a length limit that can't be reached, the backtracking
+	// search must find the same loops as Johnson's algorithm
+	rng := rand.New(rand.NewPCG(1, 2))
+	for trial := range 20 {
+		n := 4 + rng.IntN(6)
+		var edges [][2]string
+		for i := range n {
+			for j := range n {
+				if rng.Float64() < 0.3 {
+					edges = append(edges, [2]string{fmt.Sprintf("v%d", i), fmt.Sprintf("v%d", j)})
+				}
+			}
+		}
+		m := edgeMap(edges...)
+
+		johnson, err := m.LoopsContext(context.Background(), LoopOptions{})
+		require.NoError(t, err)
+		backtracking, err := m.LoopsContext(context.Background(), LoopOptions{MaxLength: n})
+		require.NoError(t, err)
+		assert.Equal(t, johnson, backtracking, "trial %d", trial)
+	}
+}
+
+func TestLoopPolarity(t *testing.T) {
+	m := NewMap([]sdjson.Relationship{
+		{From: "Births", To: "Population", Polarity: "+"},
+		{From: "Population", To: "Births", Polarity: "+"},
+		{From: "Deaths", To: "Population", Polarity: "-"},
+		{From: "Population", To: "Deaths", Polarity: "+"},
+		{From: "Crowding", To: "Deaths", Polarity: "+"},
+		{From: "Deaths", To: "Crowding", Polarity: "-"},
+		{From: "Deaths", To: "Grief", Polarity: "+"},
+		{From: "Grief", To: "Deaths", Polarity: ""},
+		{From: "Births", To: "Joy", Polarity: "+"},
+		{From: "Joy", To: "Births", Polarity: "+"},
+		{From: "Joy", To: "Births", Polarity: "-"},
+	})
+
+	assert.Equal(t, []Loop{
+		{
+			// the chains are evenly split, so the first one's polarity wins,
+			// as in Relationships
+			Identifier: "R1",
+			Name:       "Births -> Joy -> Births",
+			Links:      []Link{{From: "Births", To: "Joy", Polarity: "+"}, {From: "Joy", To: "Births", Polarity: "+"}},
+			Polarity:   "+",
+		},
+		{
+			Identifier: "R2",
+			Name:       "Births -> Population -> Births",
+			Links:      []Link{{From: "Births", To: "Population", Polarity: "+"}, {From: "Population", To: "Births", Polarity: "+"}},
+			Polarity:   "+",
+		},
+		{
+			Identifier: "B1",
+			Name:       "Crowding -> Deaths -> Crowding",
+			Links:      []Link{{From: "Crowding", To: "Deaths", Polarity: "+"}, {From: "Deaths", To: "Crowding", Polarity: "-"}},
+			Polarity:   "-",
+		},
+		{
+			Identifier: "U1",
+			Name:       "Deaths -> Grief -> Deaths",
+			Links:      []Link{{From: "Deaths", To: "Grief", Polarity: "+"}, {From: "Grief", To: "Deaths", Polarity: "?"}},
+			Polarity:   "?",
+		},
+		{
+			Identifier: "B2",
+			Name:       "Deaths -> Population -> Deaths",
+			Links:      []Link{{From: "Deaths", To: "Population", Polarity: "-"}, {From: "Population", To: "Deaths", Polarity: "+"}},
+			Polarity:   "-",
+		},
+	}, m.Loops())
+}
+
+// TestLoopPolarityVotes checks that a loop's links take the polarity
+// most chains give them, however they spell the variables, and agree
+// with the relationships the map emits.
+func TestLoopPolarityVotes(t *testing.T) {
+	m := &Map{CausalChains: []Chain{
+		chain("++", "Anger", "Protests", "Anger"),
+		chain("-", "protests", "anger"),
+		chain("-", "Protests", "Anger"),
+	}}
+	loops := m.Loops()
+	require.Len(t, loops, 1)
+	assert.Equal(t, "B1", loops[0].Identifier)
+	assert.Equal(t, []Link{{From: "Anger", To: "Protests", Polarity: "+"}, {From: "Protests", To: "Anger", Polarity: "-"}}, loops[0].Links)
+
+	// evenly split: the first chain wins, as in Relationships
+	m.CausalChains = m.CausalChains[:2]
+	m.CausalChains[1] = chain("-", "Protests", "Anger")
+	provs, diags := m.Relationships()
+	require.Len(t, diags, 1)
+	assert.Contains(t, diags[0].Message, "kept +")
+	assert.Equal(t, "+", provs[1].Relationship.Polarity)
+	assert.Equal(t, "R1", m.Loops()[0].Identifier)
+}
+
+func TestLoopPolarityRoadRage(t *testing.T) {
+	var m Map
+	require.NoError(t, json.Unmarshal([]byte(roadRage1), &m))
+
+	loops := m.Loops()
+	require.Len(t, loops, 11)
+
+	counts := make(map[string]int)
+	for _, loop := range loops {
+		negative := 0
+		for _, link := range loop.Links {
+			if link.Polarity == "-" {
+				negative++
+			}
+		}
+		kind := "R"
+		if negative%2 == 1 {
+			kind = "B"
+		}
+		assert.Equal(t, negative%2 == 1, loop.Balancing(), loop.Name)
+		assert.Equal(t, negative%2 == 0, loop.Reinforcing(), loop.Name)
+
+		counts[kind]++
+		assert.Equal(t, fmt.Sprintf("%s%d", kind, counts[kind]), loop.Identifier)
+	}
+	assert.Equal(t, map[string]int{"R": 4, "B": 7}, counts)
+}
@@ -0,0 +1,120 @@
+package causal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// Assertion is one chain's claim that a relationship exists.
+type Assertion struct {
+	// Chain is the index of the chain in the map's CausalChains.
+	Chain             int    `json:"chain"`
+	Polarity          string `json:"polarity"`
+	Reasoning         string `json:"reasoning,omitzero"`
+	PolarityReasoning string `json:"polarityReasoning,omitzero"`
+}
+
+// Provenance is a relationship of the map, as Compat emits it, with
+// every chain that asserted it, in order.
+type Provenance struct {
+	Relationship sdjson.Relationship `json:"relationship"`
+	Assertions   []Assertion         `json:"assertions"`
+}
+
+// Relationships returns each distinct relationship the map's chains
+// assert, in the order they first assert them, and a diagnostic for
+// each one that chains give opposite polarities.  A relationship takes
+// the polarity most of its chains give it, or the first chain's if
+// they're evenly split, and the reasoning of the chains that agree.
+func (m *Map) Relationships() ([]Provenance, []sdjson.Diagnostic) {
+	var provs []Provenance
+	index := make(map[string]int)
+	for c, chain := range m.CausalChains {
+		from := chain.InitialVariable
+		for _, r := range chain.Relationships {
+			rel := sdjson.Relationship{From: from, To: r.Variable}
+			from = r.Variable
+
+			i, ok := index[rel.Key()]
+			if !ok {
+				i = len(provs)
+				index[rel.Key()] = i
+				provs = append(provs, Provenance{Relationship: rel})
+			}
+			provs[i].Assertions = append(provs[i].Assertions, Assertion{
+				Chain:             c,
+				Polarity:          r.Polarity,
+				Reasoning:         chain.Reasoning,
+				PolarityReasoning: r.PolarityReasoning,
+			})
+		}
+	}
+
+	var diags []sdjson.Diagnostic
+	for i := range provs {
+		p := &provs[i]
+		p.Relationship.Polarity = p.polarity()
+
+		var reasons []string
+		var positive, negative []string
+		for _, a := range p.Assertions {
+			switch a.Polarity {
+			case "+":
+				positive = append(positive, fmt.Sprint(a.Chain))
+			case "-":
+				negative = append(negative, fmt.Sprint(a.Chain))
+			}
+			if a.Polarity != p.Relationship.Polarity {
+				continue
+			}
+			if a.Reasoning != "" && !slices.Contains(reasons, a.Reasoning) {
+				reasons = append(reasons, a.Reasoning)
+			}
+			if p.Relationship.PolarityReasoning == "" {
+				p.Relationship.PolarityReasoning = a.PolarityReasoning
+			}
+		}
+		p.Relationship.Reasoning = strings.Join(reasons, "\n\n")
+
+		if len(positive) > 0 && len(negative) > 0 {
+			rel := p.Relationship
+			diags = append(diags, sdjson.Diagnostic{
+				Code:     sdjson.CodeConflictingPolarity,
+				Severity: sdjson.SeverityWarning,
+				Message: fmt.Sprintf("%q -> %q is positive in chains %s but negative in chains %s; kept %s",
+					rel.From, rel.To, strings.Join(positive, ", "), strings.Join(negative, ", "), rel.Polarity),
+				Relationship: &rel,
+			})
+		}
+	}
+	return provs, diags
+}
+
+// polarity is the polarity most of the assertions give, ignoring any
+// that give none, or the first one's on a tie.
+func (p *Provenance) polarity() string {
+	var votes int
+	for _, a := range p.Assertions {
+		switch a.Polarity {
+		case "+":
+			votes++
+		case "-":
+			votes--
+		}
+	}
+	switch {
+	case votes > 0:
+		return "+"
+	case votes < 0:
+		return "-"
+	}
+	for _, a := range p.Assertions {
+		if a.Polarity == "+" || a.Polarity == "-" {
+			return a.Polarity
+		}
+	}
+	return p.Assertions[0].Polarity
+}
@@ -0,0 +1,86 @@
+package causal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestRelationships(t *testing.T) {
+	var m Map
+	require.NoError(t, json.Unmarshal([]byte(`{"causal_chains": [
+		{"initial_variable": "Taxes", "relationships": [{"variable": "Anger", "polarity": "+", "polarity_reasoning": "taxes are resented"}, {"variable": "Protests", "polarity": "+"}], "reasoning": "resentment"},
+		{"initial_variable": "Taxes", "relationships": [{"variable": "Anger", "polarity": "-", "polarity_reasoning": "taxes pay for order"}], "reasoning": "order"},
+		{"initial_variable": "Taxes", "relationships": [{"variable": "Anger", "polarity": "+"}], "reasoning": "grievance"},
+		{"initial_variable": "Anger", "relationships": [{"variable": "Protests", "polarity": "+"}], "reasoning": "resentment"},
+		{"initial_variable": "Protests", "relationships": [{"variable": "Repression", "polarity": "+"}, {"variable": "Protests", "polarity": "-"}]},
+		{"initial_variable": "Protests", "relationships": [{"variable": "Repression", "polarity": "-"}]}
+	]}`), &m))
+
+	provs, diags := m.Relationships()
+	require.Len(t, provs, 4)
+
+	// the majority wins, with the reasoning of the chains that agree
+	assert.Equal(t, Provenance{
+		Relationship: sdjson.Relationship{
+			From:              "Taxes",
+			To:                "Anger",
+			Polarity:          "+",
+			Reasoning:         "resentment\n\ngrievance",
+			PolarityReasoning: "taxes are resented",
+		},
+		Assertions: []Assertion{
+			{Chain: 0, Polarity: "+", Reasoning: "resentment", PolarityReasoning: "taxes are resented"},
+			{Chain: 1, Polarity: "-", Reasoning: "order", PolarityReasoning: "taxes pay for order"},
+			{Chain: 2, Polarity: "+", Reasoning: "grievance"},
+		},
+	}, provs[0])
+
+	// chains that agree give the reasoning once
+	assert.Equal(t, "resentment", provs[1].Relationship.Reasoning)
+	assert.Len(t, provs[1].Assertions, 2)
+
+	// a tie goes to the first chain
+	assert.Equal(t, "+", provs[2].Relationship.Polarity)
+
+	require.Len(t, diags, 2)
+	assert.Equal(t, sdjson.CodeConflictingPolarity, diags[0].Code)
+	assert.Equal(t, sdjson.SeverityWarning, diags[0].Severity)
+	assert.Equal(t, `"Taxes" -> "Anger" is positive in chains 0, 2 but negative in chains 1; kept +`, diags[0].Message)
+	assert.Equal(t, &provs[0].Relationship, diags[0].Relationship)
+	assert.Equal(t, "Repression", diags[1].Relationship.To)
+
+	// Compat emits the same relationships
+	mdl := m.Compat()
+	require.Len(t, mdl.Relationships, len(provs))
+	for i, p := range provs {
+		assert.Equal(t, p.Relationship, mdl.Relationships[i])
+	}
+}
+
+func TestProvenancePolarity(t *testing.T) {
+	tests := []struct {
+		name       string
+		polarities []string
+		want       string
+	}{
+		{"single", []string{"-"}, "-"},
+		{"majority", []string{"+", "-", "-"}, "-"},
+		{"tie", []string{"-", "+"}, "-"},
+		{"unknown ignored", []string{"", "+"}, "+"},
+		{"all unknown", []string{"", ""}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Provenance
+			for i, polarity := range tt.polarities {
+				p.Assertions = append(p.Assertions, Assertion{Chain: i, Polarity: polarity})
+			}
+			assert.Equal(t, tt.want, p.polarity())
+		})
+	}
+}
@@ -5,9 +5,6 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
 	"regexp"
 	"slices"
 	"strings"
@@ -136,6 +133,9 @@ type Map struct {
 	CausalChains []Chain `json:"causal_chains"`
 }
 
+// Compat returns the map as a model of auxiliaries linked by the
+// relationships its chains assert.  See Relationships for how chains
+// that assert the same relationship are reconciled.
 func (m *Map) Compat() sdjson.Model {
 	vars := m.Variables()
 	mdl := sdjson.Model{
@@ -151,33 +151,9 @@ func (m *Map) Compat() sdjson.Model {
 		)
 	}
 
-	seenRelationships := NewSet[string]()
-
-	for _, chain := range m.CausalChains {
-		for i, r := range chain.Relationships {
-			var from string
-			if i == 0 {
-				from = chain.InitialVariable
-			} else {
-				from = chain.Relationships[i-1].Variable
-			}
-			to := r.Variable
-			relationship := sdjson.Relationship{
-				From:              from,
-				To:                to,
-				Polarity:          r.Polarity,
-				PolarityReasoning: r.PolarityReasoning,
-				// use the overall reasoning for the chain for this relationship
-				Reasoning: chain.Reasoning,
-			}
-			rk := relationship.Key()
-			if seenRelationships.Contains(rk) {
-				continue
-			}
-
-			seenRelationships.Add(rk)
-			mdl.Relationships = append(mdl.Relationships, relationship)
-		}
+	provs, _ := m.Relationships()
+	for _, p := range provs {
+		mdl.Relationships = append(mdl.Relationships, p.Relationship)
 	}
 
 	return mdl
@@ -194,150 +170,9 @@ func (m *Map) Variables() (vars Set[string]) {
 	return vars
 }
 
-type searchState struct {
-	edges   map[string][]string
-	visited Set[string]
-	found   [][]string
-}
-
-func (s *searchState) addCycle(path []string) {
-	cycle := make([]string, 0, len(path))
-
-	// rotate the path so that the lowest-named variable is first
-	i := slices.Index(path, slices.Min(path))
-	cycle = append(cycle, path[i:]...)
-	cycle = append(cycle, path[:i]...)
-
-	for _, foundCycle := range s.found {
-		// already recorded it, nothing to do
-		if slices.Equal(foundCycle, cycle) {
-			return
-		}
-	}
-
-	s.found = append(s.found, cycle)
-}
-
-func (s *searchState) search(path []string, v string) {
-	s.visited.Add(v)
-	path = append(path, v)
-
-	for _, neighbor := range s.edges[v] {
-		if !s.visited.Contains(neighbor) {
-			s.search(path, neighbor)
-		}
-		// found a cycle
-		if i := slices.Index(path, neighbor); i >= 0 {
-			s.addCycle(path[i:])
-		}
-	}
-}
-
-func findCycles(outgoing map[string][]string) (found [][]string) {
-	s := searchState{
-		edges:   outgoing,
-		visited: make(Set[string], len(outgoing)),
-	}
-
-	for v := range outgoing {
-		clear(s.visited)
-
-		path := make([]string, 0, 32)
-		s.search(path, v)
-	}
-
-	return s.found
-}
-
-func (m *Map) Loops() [][]string {
-	// build a map of all outgoing edges in our diagram/graph.
-	outgoing := make(map[string][]string)
-	for _, chain := range m.CausalChains {
-		for i, r := range chain.Relationships {
-			var from string
-			if i == 0 {
-				from = chain.InitialVariable
-			} else {
-				from = chain.Relationships[i-1].Variable
-			}
-			from = Canonicalize(from)
-			to := Canonicalize(r.Variable)
-			outgoing[from] = append(outgoing[from], to)
-		}
-	}
-
-	allLoops := findCycles(outgoing)
-
-	// make the loops clearer by ensuring that we repeat as the last
-	// element the initial one.
-	for i, loop := range allLoops {
-		allLoops[i] = append(loop, loop[0])
-	}
-
-	slices.SortStableFunc(allLoops, func(a, b []string) int {
-		if len(a) < len(b) {
-			return -1
-		} else if len(a) > len(b) {
-			return 1
-		}
-
-		return slices.Compare(a, b)
-	})
-
-	return allLoops
-}
-
-func (m *Map) VisualSVG() ([]byte, error) {
-	var b strings.Builder
-
-	b.WriteString("digraph {\n\tsplines=curved\n\toverlap=false\n\tmode=KK\n")
-
-	for _, r := range m.Compat().Relationships {
-		b.WriteString(fmt.Sprintf("\t%q -> %q\n", r.From, r.To))
-	}
-
-	b.WriteString("}\n")
-
-	cmd := exec.Command("dot", "-Tsvg", "-Kneato")
-	cmd.Stdin = strings.NewReader(b.String())
-	cmd.Stderr = os.Stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("cmd.StdoutPipe: %w", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("cmd.Start: %w", err)
-	}
-
-	svg, err := io.ReadAll(stdout)
-	if err != nil {
-		return nil, fmt.Errorf("io.ReadAll: %w", err)
-	}
-
-	if err = cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("cmd.Wait: %w ()", err)
-	}
-
-	return svg, nil
-}
-
-// NewMap builds a causal map from a list of relationships.
+// NewMap builds a causal map from a list of relationships, as the
+// fewest chains it reasonably can: each feedback loop among them as a
+// chain that ends where it starts, then paths through the rest.
 func NewMap(relationships []sdjson.Relationship) *Map {
-	m := &Map{}
-
-	for _, r := range relationships {
-		m.CausalChains = append(m.CausalChains, Chain{
-			InitialVariable: r.From,
-			Relationships: []RelationshipEntry{
-				{
-					Variable:          r.To,
-					Polarity:          r.Polarity,
-					PolarityReasoning: r.PolarityReasoning,
-				},
-			},
-		})
-	}
-
-	return m
+	return &Map{CausalChains: newLinkGraph(relationships).chains()}
 }
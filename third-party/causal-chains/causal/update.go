@@ -0,0 +1,160 @@
+package causal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// formatCurrentModel renders an existing model for the LLM as the causal
+// chains it should build on.  It returns "" if there's no model.
+func formatCurrentModel(current *sdjson.Model) (string, error) {
+	if current == nil || len(current.Variables) == 0 && len(current.Relationships) == 0 {
+		return "", nil
+	}
+
+	chains := struct {
+		CausalChains []Chain `json:"causal_chains"`
+	}{
+		CausalChains: NewMap(current.Relationships).CausalChains,
+	}
+	if chains.CausalChains == nil {
+		chains.CausalChains = []Chain{}
+	}
+	data, err := json.MarshalIndent(chains, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+
+	prompt := strings.ReplaceAll(currentModelPrompt, "{causalChains}", string(data))
+
+	connected := make(Set[string])
+	for _, r := range current.Relationships {
+		connected.Add(Canonicalize(r.From))
+		connected.Add(Canonicalize(r.To))
+	}
+	var unconnected []string
+	for _, v := range current.Variables {
+		if !connected.Contains(Canonicalize(v.Name)) {
+			unconnected = append(unconnected, fmt.Sprintf("%q", v.Name))
+		}
+	}
+	if len(unconnected) > 0 {
+		prompt += "\n\nThe diagram also has these variables, which aren't connected to anything yet: " + strings.Join(unconnected, ", ")
+	}
+
+	return prompt, nil
+}
+
+// UpdateModel applies the map to an existing model, as Compat would
+// convert it, but keeping whatever the map leaves unchanged verbatim:
+// variables that are still in the map keep their name, type, equation,
+// units and so on from the current model, and relationships whose
+// polarity is unchanged keep their reasoning.  The current model's
+// variables and relationships come first, in their original order,
+// followed by the map's additions.  Relationships that aren't in the map
+// are dropped, and so are the variables they linked that aren't in it
+// any more; a variable with no links can't be in the map's chains, so
+// it is kept.  Stocks lose the inflows and outflows that were dropped.
+//
+// The current model's specs and modules are kept, but its errors and
+// unit warnings are not, since they describe the old model.  A nil
+// current model gives the same result as Compat.
+func (m *Map) UpdateModel(current *sdjson.Model) sdjson.Model {
+	updated := m.Compat()
+	if current == nil {
+		return updated
+	}
+
+	// spell variables the way the current model does
+	spelling := make(map[string]string, len(current.Variables))
+	for _, v := range current.Variables {
+		if _, ok := spelling[Canonicalize(v.Name)]; !ok {
+			spelling[Canonicalize(v.Name)] = v.Name
+		}
+	}
+	respell := func(name string) string {
+		if existing, ok := spelling[Canonicalize(name)]; ok {
+			return existing
+		}
+		return name
+	}
+
+	mdl := sdjson.Model{
+		Modules: current.Modules,
+		Specs:   current.Specs,
+	}
+
+	linked := make(Set[string])
+	for _, r := range current.Relationships {
+		linked.Add(Canonicalize(r.From))
+		linked.Add(Canonicalize(r.To))
+	}
+	inMap := make(Set[string], len(updated.Variables))
+	for _, n := range updated.Variables {
+		inMap.Add(Canonicalize(n.Name))
+	}
+
+	kept := make(Set[string])
+	for _, v := range current.Variables {
+		id := Canonicalize(v.Name)
+		if kept.Contains(id) || linked.Contains(id) && !inMap.Contains(id) {
+			continue
+		}
+		mdl.Variables = append(mdl.Variables, v)
+		kept.Add(id)
+	}
+	for _, n := range updated.Variables {
+		if id := Canonicalize(n.Name); !kept.Contains(id) {
+			mdl.Variables = append(mdl.Variables, n)
+			kept.Add(id)
+		}
+	}
+	dropped := func(name string) bool {
+		return !kept.Contains(Canonicalize(name))
+	}
+	for i, v := range mdl.Variables {
+		if slices.ContainsFunc(v.Inflows, dropped) {
+			mdl.Variables[i].Inflows = slices.DeleteFunc(slices.Clone(v.Inflows), dropped)
+		}
+		if slices.ContainsFunc(v.Outflows, dropped) {
+			mdl.Variables[i].Outflows = slices.DeleteFunc(slices.Clone(v.Outflows), dropped)
+		}
+	}
+
+	relationshipKey := func(r sdjson.Relationship) string {
+		return Canonicalize(r.From) + "->" + Canonicalize(r.To)
+	}
+	newRelationships := make(map[string]sdjson.Relationship, len(updated.Relationships))
+	for _, r := range updated.Relationships {
+		newRelationships[relationshipKey(r)] = r
+	}
+
+	keptRelationships := make(Set[string])
+	for _, r := range current.Relationships {
+		key := relationshipKey(r)
+		n, ok := newRelationships[key]
+		if !ok || keptRelationships.Contains(key) {
+			continue
+		}
+		if n.Polarity == r.Polarity {
+			mdl.Relationships = append(mdl.Relationships, r)
+		} else {
+			n.From, n.To = r.From, r.To
+			mdl.Relationships = append(mdl.Relationships, n)
+		}
+		keptRelationships.Add(key)
+	}
+	for _, r := range updated.Relationships {
+		if key := relationshipKey(r); !keptRelationships.Contains(key) {
+			r.From, r.To = respell(r.From), respell(r.To)
+			mdl.Relationships = append(mdl.Relationships, r)
+			keptRelationships.Add(key)
+		}
+	}
+
+	return mdl
+}
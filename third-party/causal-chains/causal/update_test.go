@@ -0,0 +1,122 @@
+package causal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func currentModel() *sdjson.Model {
+	return &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "Population", Type: sdjson.VariableTypeStock, Equation: "100", Units: "people"},
+			{Name: "Births", Type: sdjson.VariableTypeFlow, Equation: "Population * 0.03"},
+			{Name: "Food Supply", Type: sdjson.VariableTypeAux},
+			{Name: "Pollution", Type: sdjson.VariableTypeAux},
+		},
+		Relationships: []sdjson.Relationship{
+			{From: "Births", To: "Population", Polarity: "+", Reasoning: "births add people"},
+			{From: "Population", To: "Births", Polarity: "+", Reasoning: "more people, more births"},
+			{From: "Food Supply", To: "Births", Polarity: "+", Reasoning: "well-fed people have more children"},
+		},
+		Specs: sdjson.Specs{StartTime: 0, StopTime: 100, TimeUnits: "years"},
+	}
+}
+
+func TestUpdateModel(t *testing.T) {
+	m := &Map{
+		CausalChains: []Chain{
+			{
+				InitialVariable: "births",
+				Relationships: []RelationshipEntry{
+					{Variable: "population", Polarity: "+"},
+					{Variable: "Births", Polarity: "+"},
+				},
+				Reasoning: "population growth feedback loop",
+			},
+			{
+				InitialVariable: "Food_Supply",
+				Relationships:   []RelationshipEntry{{Variable: "Births", Polarity: "-"}},
+				Reasoning:       "food supply is limited",
+			},
+			{
+				InitialVariable: "Population",
+				Relationships:   []RelationshipEntry{{Variable: "Deaths", Polarity: "+"}, {Variable: "Population", Polarity: "-"}},
+				Reasoning:       "mortality feedback loop",
+			},
+		},
+	}
+
+	current := currentModel()
+	updated := m.UpdateModel(current)
+
+	assert.Equal(t, []sdjson.Variable{
+		current.Variables[0],
+		current.Variables[1],
+		current.Variables[2],
+		current.Variables[3],
+		{Name: "Deaths", Type: sdjson.VariableTypeAux},
+	}, updated.Variables)
+
+	assert.Equal(t, []sdjson.Relationship{
+		current.Relationships[0],
+		current.Relationships[1],
+		{From: "Food Supply", To: "Births", Polarity: "-", Reasoning: "food supply is limited"},
+		{From: "Population", To: "Deaths", Polarity: "+", Reasoning: "mortality feedback loop"},
+		{From: "Deaths", To: "Population", Polarity: "-", Reasoning: "mortality feedback loop"},
+	}, updated.Relationships)
+
+	assert.Equal(t, current.Specs, updated.Specs)
+
+	// nothing has changed in the current model
+	assert.Equal(t, currentModel(), current)
+
+	assert.Equal(t, m.Compat(), m.UpdateModel(nil))
+}
+
+// TestUpdateModelRemoved checks that variables whose links the map
+// drops are removed, along with the flows of stocks that name them.
+func TestUpdateModelRemoved(t *testing.T) {
+	current := &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "Population", Type: sdjson.VariableTypeStock, Inflows: []string{"Births"}, Outflows: []string{"Deaths"}},
+			{Name: "Births", Type: sdjson.VariableTypeFlow},
+			{Name: "Deaths", Type: sdjson.VariableTypeFlow},
+			{Name: "Capital", Type: sdjson.VariableTypeStock, Equation: "10"},
+		},
+		Relationships: []sdjson.Relationship{
+			{From: "Births", To: "Population", Polarity: "+"},
+			{From: "Deaths", To: "Population", Polarity: "-"},
+		},
+	}
+	m := &Map{CausalChains: []Chain{chain("+", "Births", "Population")}}
+
+	updated := m.UpdateModel(current)
+	assert.Equal(t, []sdjson.Variable{
+		{Name: "Population", Type: sdjson.VariableTypeStock, Inflows: []string{"Births"}, Outflows: []string{}},
+		{Name: "Births", Type: sdjson.VariableTypeFlow},
+		{Name: "Capital", Type: sdjson.VariableTypeStock, Equation: "10"},
+	}, updated.Variables)
+	assert.Equal(t, []string{"Deaths"}, current.Variables[0].Outflows)
+}
+
+func TestFormatCurrentModel(t *testing.T) {
+	prompt, err := formatCurrentModel(nil)
+	require.NoError(t, err)
+	assert.Empty(t, prompt)
+
+	prompt, err = formatCurrentModel(&sdjson.Model{})
+	require.NoError(t, err)
+	assert.Empty(t, prompt)
+
+	prompt, err = formatCurrentModel(currentModel())
+	require.NoError(t, err)
+	assert.Contains(t, prompt, "keep every existing variable name spelled exactly as it is")
+	assert.Contains(t, prompt, `"initial_variable": "Food Supply"`)
+	assert.Contains(t, prompt, `"variable": "Births"`)
+	assert.NotContains(t, prompt, "{causalChains}")
+	assert.Contains(t, prompt, `which aren't connected to anything yet: "Pollution"`)
+}
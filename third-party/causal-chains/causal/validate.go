@@ -0,0 +1,176 @@
+package causal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChainProblemKind identifies a way a chain breaks the rules the system
+// prompt sets for them.
+type ChainProblemKind string
+
+const (
+	// ChainEmpty chains have no relationships.
+	ChainEmpty ChainProblemKind = "empty-chain"
+	// ChainSelfLink chains name a variable twice in a row, linking it
+	// to itself.
+	ChainSelfLink ChainProblemKind = "self-link"
+	// ChainRepeatedVariable chains go through a variable other than
+	// the initial one twice.
+	ChainRepeatedVariable ChainProblemKind = "repeated-variable"
+	// ChainEarlyClosure chains come back to the initial variable
+	// before their last relationship.
+	ChainEarlyClosure ChainProblemKind = "mid-chain-loop-closure"
+	// ChainEmptyName chains have a variable without a name.
+	ChainEmptyName ChainProblemKind = "empty-variable-name"
+	// ChainInvalidPolarity chains have a relationship whose polarity is
+	// neither "+" nor "-".
+	ChainInvalidPolarity ChainProblemKind = "invalid-polarity"
+)
+
+// ChainProblem is a single problem with a chain of a map.
+type ChainProblem struct {
+	Kind ChainProblemKind `json:"kind"`
+	// Chain is the index of the chain in the map's CausalChains, as
+	// it was before any repair.
+	Chain    int    `json:"chain"`
+	Variable string `json:"variable,omitzero"`
+	Message  string `json:"message"`
+	// Repairable problems are fixed by Repair without losing any of
+	// the chain's relationships.
+	Repairable bool `json:"repairable"`
+}
+
+func (p ChainProblem) String() string {
+	return fmt.Sprintf("chain %d: %s: %s", p.Chain, p.Kind, p.Message)
+}
+
+// Validate returns the chain's problems.  Their Chain is 0.
+func (c Chain) Validate() []ChainProblem {
+	_, problems := c.repair()
+	return problems
+}
+
+// Validate returns the problems of each of the map's chains.
+func (m *Map) Validate() []ChainProblem {
+	var problems []ChainProblem
+	for i, chain := range m.CausalChains {
+		for _, p := range chain.Validate() {
+			p.Chain = i
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
+
+// Repair fixes the problems of the map's chains that it can, and
+// returns every problem it found.  Empty chains are dropped, links from
+// a variable to itself are trimmed, and a chain that goes through a
+// variable twice is split in two: the feedback loop between the visits,
+// and the chain with that loop cut out.  Chains with a problem Repair
+// can't fix are left as they are.
+func (m *Map) Repair() []ChainProblem {
+	var problems []ChainProblem
+	chains := make([]Chain, 0, len(m.CausalChains))
+	for i, chain := range m.CausalChains {
+		repaired, found := chain.repair()
+		for _, p := range found {
+			p.Chain = i
+			problems = append(problems, p)
+		}
+		chains = append(chains, repaired...)
+	}
+	m.CausalChains = chains
+	return problems
+}
+
+// repair returns the chains that replace c, and its problems.
+func (c Chain) repair() ([]Chain, []ChainProblem) {
+	var problems []ChainProblem
+	report := func(kind ChainProblemKind, variable string, repairable bool, format string, args ...any) {
+		problems = append(problems, ChainProblem{
+			Kind:       kind,
+			Variable:   variable,
+			Message:    fmt.Sprintf(format, args...),
+			Repairable: repairable,
+		})
+	}
+
+	// problems that can't be fixed without guessing what was meant
+	if strings.TrimSpace(c.InitialVariable) == "" {
+		report(ChainEmptyName, "", false, "initial_variable is empty")
+	}
+	for i, r := range c.Relationships {
+		if strings.TrimSpace(r.Variable) == "" {
+			report(ChainEmptyName, "", false, "relationship %d has an empty variable", i)
+		}
+		if r.Polarity != "+" && r.Polarity != "-" {
+			report(ChainInvalidPolarity, r.Variable, false, "relationship %d to %q has polarity %q, not \"+\" or \"-\"", i, r.Variable, r.Polarity)
+		}
+	}
+	if len(problems) > 0 {
+		return []Chain{c}, problems
+	}
+
+	// trim links to themselves
+	names := []string{Canonicalize(c.InitialVariable)}
+	relationships := make([]RelationshipEntry, 0, len(c.Relationships))
+	for _, r := range c.Relationships {
+		name := Canonicalize(r.Variable)
+		if name == names[len(names)-1] {
+			report(ChainSelfLink, r.Variable, true, "%q is linked to itself", r.Variable)
+			continue
+		}
+		names = append(names, name)
+		relationships = append(relationships, r)
+	}
+	if len(relationships) == 0 {
+		report(ChainEmpty, c.InitialVariable, true, "the chain from %q has no relationships", c.InitialVariable)
+		return nil, problems
+	}
+	c.Relationships = relationships
+
+	// split off the loops in the middle of the chain; the relationship
+	// at index k links names[k] to names[k+1]
+	var loops []Chain
+	variable := func(k int) string {
+		if k == 0 {
+			return c.InitialVariable
+		}
+		return c.Relationships[k-1].Variable
+	}
+	for {
+		i, j := firstRepeat(names)
+		if j < 0 || i == 0 && j == len(names)-1 {
+			// a chain that ends where it starts is a feedback loop
+			break
+		}
+		if i == 0 {
+			report(ChainEarlyClosure, c.InitialVariable, true, "the chain comes back to %q before its end", c.InitialVariable)
+		} else {
+			report(ChainRepeatedVariable, variable(j), true, "%q appears more than once", variable(j))
+		}
+
+		loops = append(loops, Chain{
+			InitialVariable: variable(i),
+			Relationships:   append([]RelationshipEntry(nil), c.Relationships[i:j]...),
+			Reasoning:       c.Reasoning,
+		})
+		c.Relationships = append(c.Relationships[:i:i], c.Relationships[j:]...)
+		names = append(names[:i+1:i+1], names[j+1:]...)
+	}
+	return append([]Chain{c}, loops...), problems
+}
+
+// firstRepeat returns the earliest j such that names[j] is the same as
+// an earlier names[i], and i, or -1, -1.
+func firstRepeat(names []string) (int, int) {
+	seen := make(map[string]int, len(names))
+	for j, name := range names {
+		if i, ok := seen[name]; ok {
+			return i, j
+		}
+		seen[name] = j
+	}
+	return -1, -1
+}
@@ -0,0 +1,146 @@
+package causal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chain builds a chain through the variables, with links of the given
+// polarities, or "+" if there are none.
+func chain(polarities string, variables ...string) Chain {
+	c := Chain{InitialVariable: variables[0], Reasoning: "why"}
+	for i, v := range variables[1:] {
+		polarity := "+"
+		if i < len(polarities) {
+			polarity = string(polarities[i])
+		}
+		c.Relationships = append(c.Relationships, RelationshipEntry{Variable: v, Polarity: polarity})
+	}
+	return c
+}
+
+func TestChainRepair(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain Chain
+		want  []Chain
+		kinds []ChainProblemKind
+	}{
+		{
+			name:  "valid path",
+			chain: chain("", "a", "b", "c"),
+			want:  []Chain{chain("", "a", "b", "c")},
+		},
+		{
+			name:  "valid loop",
+			chain: chain("+-", "a", "b", "A"),
+			want:  []Chain{chain("+-", "a", "b", "A")},
+		},
+		{
+			name:  "empty",
+			chain: chain("", "a"),
+			kinds: []ChainProblemKind{ChainEmpty},
+		},
+		{
+			name:  "self link",
+			chain: chain("+-+", "a", "b", "b", "c"),
+			want:  []Chain{chain("++", "a", "b", "c")},
+			kinds: []ChainProblemKind{ChainSelfLink},
+		},
+		{
+			name:  "only a self link",
+			chain: chain("", "a", "a"),
+			kinds: []ChainProblemKind{ChainSelfLink, ChainEmpty},
+		},
+		{
+			name:  "early closure",
+			chain: chain("+-++", "a", "b", "a", "c", "d"),
+			want:  []Chain{chain("++", "a", "c", "d"), chain("+-", "a", "b", "a")},
+			kinds: []ChainProblemKind{ChainEarlyClosure},
+		},
+		{
+			name:  "repeated variable",
+			chain: chain("+--+", "a", "b", "c", "b", "d"),
+			want:  []Chain{chain("++", "a", "b", "d"), chain("--", "b", "c", "b")},
+			kinds: []ChainProblemKind{ChainRepeatedVariable},
+		},
+		{
+			name:  "repeated at the end",
+			chain: chain("+--", "a", "b", "c", "b"),
+			want:  []Chain{chain("+", "a", "b"), chain("--", "b", "c", "b")},
+			kinds: []ChainProblemKind{ChainRepeatedVariable},
+		},
+		{
+			name:  "loops in a loop",
+			chain: chain("+-+-+", "a", "b", "c", "b", "d", "a"),
+			want:  []Chain{chain("+-+", "a", "b", "d", "a"), chain("-+", "b", "c", "b")},
+			kinds: []ChainProblemKind{ChainRepeatedVariable},
+		},
+		{
+			name:  "empty name",
+			chain: chain("", "a", " "),
+			want:  []Chain{chain("", "a", " ")},
+			kinds: []ChainProblemKind{ChainEmptyName},
+		},
+		{
+			name:  "invalid polarity",
+			chain: chain("?", "a", "b", "b"),
+			want:  []Chain{chain("?", "a", "b", "b")},
+			kinds: []ChainProblemKind{ChainInvalidPolarity},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, problems := tt.chain.repair()
+			assert.Equal(t, tt.want, got)
+
+			var kinds []ChainProblemKind
+			for _, p := range problems {
+				kinds = append(kinds, p.Kind)
+				assert.Equal(t, p.Kind != ChainEmptyName && p.Kind != ChainInvalidPolarity, p.Repairable, p.Message)
+			}
+			assert.Equal(t, tt.kinds, kinds)
+			assert.Equal(t, problems, tt.chain.Validate())
+		})
+	}
+}
+
+func TestMapRepair(t *testing.T) {
+	m := &Map{CausalChains: []Chain{
+		chain("", "a", "b"),
+		chain("", "c"),
+		chain("", "d", "e", "d", "f"),
+		chain("?", "g", "h"),
+	}}
+	require.Len(t, m.Validate(), 3)
+
+	problems := m.Repair()
+	require.Len(t, problems, 3)
+	assert.Equal(t, ChainProblem{Kind: ChainEmpty, Chain: 1, Variable: "c", Message: `the chain from "c" has no relationships`, Repairable: true}, problems[0])
+	assert.Equal(t, 2, problems[1].Chain)
+	assert.Equal(t, `chain 3: invalid-polarity: relationship 0 to "h" has polarity "?", not "+" or "-"`, problems[2].String())
+
+	assert.Equal(t, []Chain{
+		chain("", "a", "b"),
+		chain("", "d", "f"),
+		chain("", "d", "e", "d"),
+		chain("?", "g", "h"),
+	}, m.CausalChains)
+	assert.Len(t, m.Validate(), 1, "only the problem that can't be repaired is left")
+}
+
+func TestParseRelationshipsResponseRepairs(t *testing.T) {
+	m, err := parseRelationshipsResponse(`{"title": "t", "explanation": "e", "causal_chains": [
+		{"initial_variable": "a", "relationships": [{"variable": "b", "polarity": "+", "polarity_reasoning": ""}, {"variable": "a", "polarity": "-", "polarity_reasoning": ""}, {"variable": "c", "polarity": "+", "polarity_reasoning": ""}], "reasoning": ""}
+	]}`)
+	require.NoError(t, err)
+	assert.Len(t, m.CausalChains, 2)
+
+	_, err = parseRelationshipsResponse(`{"title": "t", "explanation": "e", "causal_chains": [
+		{"initial_variable": "", "relationships": [{"variable": "b", "polarity": "+", "polarity_reasoning": ""}], "reasoning": ""}
+	]}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "chain 0: empty-variable-name: initial_variable is empty")
+}
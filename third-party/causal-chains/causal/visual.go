@@ -0,0 +1,201 @@
+package causal
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/diagram"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+type svgOptions struct {
+	layout diagram.Options
+	style  diagram.Style
+	delays []Link
+	view   *sdjson.View
+}
+
+// SVGOption configures how VisualSVG lays out and draws a diagram.
+type SVGOption func(*svgOptions)
+
+// WithSeed picks a different layout.  The same map and seed always give
+// the same diagram.
+func WithSeed(seed uint64) SVGOption {
+	return func(o *svgOptions) {
+		o.layout.Seed = seed
+	}
+}
+
+// WithStyle sets what's drawn besides the variables and links, in place
+// of diagram.DefaultStyle.
+func WithStyle(style diagram.Style) SVGOption {
+	return func(o *svgOptions) {
+		o.style = style
+	}
+}
+
+// WithDelays marks links as delayed.  Polarities are ignored.
+func WithDelays(links ...Link) SVGOption {
+	return func(o *svgOptions) {
+		o.delays = append(o.delays, links...)
+	}
+}
+
+// WithView keeps the variables, link curvatures and loop labels that a
+// view places where it put them, and lays out only the rest.  A nil
+// view lays out everything.
+func WithView(view *sdjson.View) SVGOption {
+	return func(o *svgOptions) {
+		o.view = view
+	}
+}
+
+func newSVGOptions(opts []SVGOption) svgOptions {
+	o := svgOptions{layout: diagram.DefaultOptions, style: diagram.DefaultStyle}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// graph returns the map's variables and relationships, as Compat names
+// them, and its feedback loops, for drawing.
+func (m *Map) graph(o svgOptions, loops []Loop) *diagram.Graph {
+	mdl := m.Compat()
+	g := &diagram.Graph{Nodes: make([]string, 0, len(mdl.Variables))}
+	index := make(map[string]int, len(mdl.Variables))
+	for _, v := range mdl.Variables {
+		if _, ok := index[Canonicalize(v.Name)]; !ok {
+			index[Canonicalize(v.Name)] = len(g.Nodes)
+		}
+		g.Nodes = append(g.Nodes, v.Name)
+	}
+
+	delayed := make(Set[string])
+	for _, link := range o.delays {
+		delayed.Add(Canonicalize(link.From) + "->" + Canonicalize(link.To))
+	}
+	for _, r := range mdl.Relationships {
+		from, to := Canonicalize(r.From), Canonicalize(r.To)
+		g.Edges = append(g.Edges, diagram.Edge{
+			From:     index[from],
+			To:       index[to],
+			Polarity: r.Polarity,
+			Delay:    delayed.Contains(from + "->" + to),
+		})
+	}
+
+	for _, loop := range loops {
+		nodes := make([]int, 0, len(loop.Links))
+		for _, name := range loop.Variables() {
+			nodes = append(nodes, index[Canonicalize(name)])
+		}
+		g.Loops = append(g.Loops, diagram.Loop{Label: loop.Identifier, Polarity: loop.Polarity, Nodes: nodes})
+	}
+	return g
+}
+
+// layout lays out the map's diagram, keeping what the view places.
+func (m *Map) layout(o svgOptions, loops []Loop) *diagram.Layout {
+	g := m.graph(o, loops)
+	opts := o.layout
+	if o.view == nil {
+		return diagram.NewLayout(g, opts)
+	}
+
+	opts.Pinned = make(map[int]diagram.Point)
+	for i, name := range g.Nodes {
+		if v := o.view.Variable(name); v != nil {
+			opts.Pinned[i] = diagram.Point{X: v.X, Y: v.Y}
+		}
+	}
+	opts.Curvatures = make(map[int]float64)
+	for i, e := range g.Edges {
+		if r := o.view.Relationship(g.Nodes[e.From], g.Nodes[e.To]); r != nil {
+			opts.Curvatures[i] = r.Curvature
+		}
+	}
+	names := make(map[string]diagram.Point, len(o.view.Loops))
+	for _, loop := range o.view.Loops {
+		names[Canonicalize(loop.Name)] = diagram.Point{X: loop.X, Y: loop.Y}
+	}
+	opts.LoopLabels = make(map[int]diagram.Point)
+	for i, loop := range loops {
+		if p, ok := names[Canonicalize(loop.Name)]; ok {
+			opts.LoopLabels[i] = p
+		}
+	}
+	return diagram.NewLayout(g, opts)
+}
+
+// VisualSVG draws the map as a causal loop diagram in SVG.
+func (m *Map) VisualSVG(opts ...SVGOption) ([]byte, error) {
+	o := newSVGOptions(opts)
+	return writeSVG(m.layout(o, m.Loops()), o.style)
+}
+
+// View returns where VisualSVG, given the same options, draws the map's
+// variables and loop labels, and how it bends the links.  Passing it
+// back WithView after the map changes draws the new map with everything
+// that was already there left in place.
+func (m *Map) View(opts ...SVGOption) *sdjson.View {
+	o := newSVGOptions(opts)
+	loops := m.Loops()
+	l := m.layout(o, loops)
+	g := l.Graph
+
+	// to a tenth of a pixel, as in the SVG
+	round := func(x float64) float64 { return math.Round(x*10) / 10 }
+	view := &sdjson.View{
+		Variables:     make([]sdjson.VariableView, 0, len(g.Nodes)),
+		Relationships: make([]sdjson.RelationshipView, 0, len(g.Edges)),
+		Loops:         make([]sdjson.LoopView, 0, len(loops)),
+	}
+	for i, name := range g.Nodes {
+		view.Variables = append(view.Variables, sdjson.VariableView{Name: name, X: round(l.Nodes[i].X), Y: round(l.Nodes[i].Y)})
+	}
+	for i, e := range g.Edges {
+		view.Relationships = append(view.Relationships, sdjson.RelationshipView{From: g.Nodes[e.From], To: g.Nodes[e.To], Curvature: l.Curvatures[i]})
+	}
+	for i, loop := range loops {
+		view.Loops = append(view.Loops, sdjson.LoopView{Identifier: loop.Identifier, Name: loop.Name, X: round(l.LoopLabels[i].X), Y: round(l.LoopLabels[i].Y)})
+	}
+	return view
+}
+
+func writeSVG(l *diagram.Layout, style diagram.Style) ([]byte, error) {
+	var b bytes.Buffer
+	if err := l.WriteSVG(&b, style); err != nil {
+		return nil, fmt.Errorf("WriteSVG: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// LoopSVG is a diagram highlighting one feedback loop.
+type LoopSVG struct {
+	Loop Loop
+	SVG  []byte
+}
+
+// LoopSVGs draws the map once for each of its feedback loops, in the
+// order Loops returns them, with that loop highlighted and the rest of
+// the diagram faded.  Every diagram has the same layout, so they can be
+// shown one after another.
+func (m *Map) LoopSVGs(opts ...SVGOption) ([]LoopSVG, error) {
+	o := newSVGOptions(opts)
+	loops := m.Loops()
+	l := m.layout(o, loops)
+
+	svgs := make([]LoopSVG, 0, len(loops))
+	for _, loop := range loops {
+		style := o.style
+		style.Highlight = loop.Identifier
+		svg, err := writeSVG(l, style)
+		if err != nil {
+			return nil, err
+		}
+		svgs = append(svgs, LoopSVG{Loop: loop, SVG: svg})
+	}
+	return svgs, nil
+}
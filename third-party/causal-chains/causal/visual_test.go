@@ -0,0 +1,137 @@
+package causal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/diagram"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// svgClasses counts the elements of an SVG document by class.
+func svgClasses(t *testing.T, svg []byte) map[string]int {
+	t.Helper()
+	classes := make(map[string]int)
+	d := xml.NewDecoder(bytes.NewReader(svg))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if start, ok := tok.(xml.StartElement); ok {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "class" {
+					classes[attr.Value]++
+				}
+			}
+		}
+	}
+	return classes
+}
+
+func TestVisualSVGStyle(t *testing.T) {
+	m := NewMap(nil)
+	require.NoError(t, json.Unmarshal([]byte(`{"causal_chains": [
+		{"initial_variable": "Births", "relationships": [{"variable": "Population", "polarity": "+"}, {"variable": "Births", "polarity": "+"}]},
+		{"initial_variable": "Population", "relationships": [{"variable": "Deaths", "polarity": "+"}, {"variable": "Population", "polarity": "-"}]},
+		{"initial_variable": "Food", "relationships": [{"variable": "Births", "polarity": ""}]}
+	]}`), m))
+
+	svg, err := m.VisualSVG(WithDelays(Link{From: "population", To: "Deaths", Polarity: "-"}))
+	require.NoError(t, err)
+	classes := svgClasses(t, svg)
+	assert.Equal(t, 5, classes["link"])
+	assert.Equal(t, 4, classes["polarity"], "the link with no polarity has no sign")
+	assert.Equal(t, 2, classes["delay"])
+	assert.Equal(t, 2, classes["loop"])
+	assert.Contains(t, string(svg), ">R1</text>")
+	assert.Contains(t, string(svg), ">B1</text>")
+	assert.Contains(t, string(svg), ">−</text>")
+
+	plain, err := m.VisualSVG(WithStyle(diagram.Style{}))
+	require.NoError(t, err)
+	classes = svgClasses(t, plain)
+	assert.Equal(t, 5, classes["link"])
+	assert.Zero(t, classes["polarity"]+classes["delay"]+classes["loop"])
+	assert.NotContains(t, string(plain), "#1f77b4")
+
+	colored, err := m.VisualSVG(WithStyle(diagram.Style{LoopColors: true}))
+	require.NoError(t, err)
+	assert.Contains(t, string(colored), `stroke="#1f77b4"`)
+	assert.Contains(t, string(colored), `stroke="#d62728"`)
+}
+
+func TestLoopSVGs(t *testing.T) {
+	var m Map
+	require.NoError(t, json.Unmarshal([]byte(roadRage1), &m))
+
+	svgs, err := m.LoopSVGs()
+	require.NoError(t, err)
+	loops := m.Loops()
+	require.Len(t, svgs, len(loops))
+
+	for i, s := range svgs {
+		assert.Equal(t, loops[i], s.Loop)
+		classes := svgClasses(t, s.SVG)
+		// only the highlighted loop is labeled
+		assert.Equal(t, 1, classes["loop-label"])
+		assert.Contains(t, string(s.SVG), ">"+s.Loop.Identifier+"</text>")
+		assert.Equal(t, len(m.Variables()), classes["variable"])
+	}
+	assert.NotEqual(t, svgs[0].SVG, svgs[1].SVG)
+}
+
+func TestView(t *testing.T) {
+	m := NewMap(nil)
+	require.NoError(t, json.Unmarshal([]byte(`{"causal_chains": [
+		{"initial_variable": "Births", "relationships": [{"variable": "Population", "polarity": "+"}, {"variable": "Births", "polarity": "+"}]},
+		{"initial_variable": "Population", "relationships": [{"variable": "Deaths", "polarity": "+"}, {"variable": "Population", "polarity": "-"}]}
+	]}`), m))
+
+	view := m.View()
+	require.Len(t, view.Variables, 3)
+	require.Len(t, view.Relationships, 4)
+	require.Len(t, view.Loops, 2)
+	assert.Equal(t, diagram.DefaultOptions.Curvature, view.Relationships[0].Curvature)
+
+	// the view survives a trip through the model
+	data, err := json.Marshal(sdjson.Model{View: view})
+	require.NoError(t, err)
+	var mdl sdjson.Model
+	require.NoError(t, json.Unmarshal(data, &mdl))
+	assert.Equal(t, view, mdl.View)
+
+	// a user moves a variable and a loop label, and straightens a link
+	mdl.View.Variables[0].X = 600
+	mdl.View.Loops[0].Y = 30
+	mdl.View.Relationships[0].Curvature = 0
+
+	// then the map grows
+	require.NoError(t, json.Unmarshal([]byte(`{"causal_chains": [
+		{"initial_variable": "Births", "relationships": [{"variable": "Population", "polarity": "+"}, {"variable": "Births", "polarity": "+"}]},
+		{"initial_variable": "Population", "relationships": [{"variable": "Deaths", "polarity": "+"}, {"variable": "Population", "polarity": "-"}]},
+		{"initial_variable": "Food", "relationships": [{"variable": "Births", "polarity": "+"}]}
+	]}`), m))
+	grown := m.View(WithView(mdl.View))
+	require.Len(t, grown.Variables, 4)
+	for _, v := range mdl.View.Variables {
+		assert.Equal(t, &v, grown.Variable(v.Name))
+	}
+	assert.Equal(t, mdl.View.Loops, grown.Loops)
+	assert.Zero(t, grown.Relationship(mdl.View.Relationships[0].From, mdl.View.Relationships[0].To).Curvature)
+	assert.NotNil(t, grown.Variable("food"))
+
+	// the drawing is the same with or without the view it was laid out from
+	svg, err := m.VisualSVG(WithView(mdl.View))
+	require.NoError(t, err)
+	again, err := m.VisualSVG(WithView(grown))
+	require.NoError(t, err)
+	assert.Equal(t, string(svg), string(again))
+}
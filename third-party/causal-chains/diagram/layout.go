@@ -0,0 +1,473 @@
+// Package diagram lays out causal loop diagrams and draws them as SVG,
+// without any external tools.
+//
+// Layouts are force-directed: linked variables pull together, every
+// variable pushes the others away, and labels that still overlap are
+// then pushed apart.  Links are drawn as curves, so a pair of opposing
+// links shows as two arcs.  The same graph and seed always give the
+// same layout.  Pinning nodes that were laid out before keeps them where
+// they were, so that a diagram grows by placing only its new variables.
+//
+// WriteSVG can also mark links' polarities and delays, label feedback
+// loops, and color or highlight them, as a Style sets.
+package diagram
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Graph is a diagram's variables, the links between them, and the
+// feedback loops they form.
+type Graph struct {
+	Nodes []string
+	Edges []Edge
+	Loops []Loop
+}
+
+// Edge is a link between two nodes, by their index in Graph.Nodes.
+type Edge struct {
+	From, To int
+	// Polarity is "+", "-", or anything else if it's unknown.
+	Polarity string
+	// Delay marks links whose effect takes significant time.
+	Delay bool
+}
+
+// Loop is a feedback loop, through nodes in order.
+type Loop struct {
+	// Label names the loop in the diagram, as in "R1".
+	Label string
+	// Polarity is "+" for reinforcing loops, "-" for balancing ones, or
+	// anything else if it's unknown.
+	Polarity string
+	Nodes    []int
+}
+
+// edge returns the index of the first edge between two nodes, or -1.
+func (g *Graph) edge(from, to int) int {
+	return slices.IndexFunc(g.Edges, func(e Edge) bool { return e.From == from && e.To == to })
+}
+
+// Point is a position in the diagram, with y increasing downward as in
+// SVG.
+type Point struct {
+	X, Y float64
+}
+
+func (p Point) add(q Point) Point             { return Point{p.X + q.X, p.Y + q.Y} }
+func (p Point) sub(q Point) Point             { return Point{p.X - q.X, p.Y - q.Y} }
+func (p Point) scale(f float64) Point         { return Point{p.X * f, p.Y * f} }
+func (p Point) length() float64               { return math.Hypot(p.X, p.Y) }
+func (p Point) lerp(q Point, t float64) Point { return p.add(q.sub(p).scale(t)) }
+
+// Curve is a quadratic Bézier curve from Start to End, bending toward
+// Control.
+type Curve struct {
+	Start, Control, End Point
+}
+
+// Options control a layout.
+type Options struct {
+	// Seed picks the starting positions.
+	Seed uint64
+	// Iterations is the number of steps of the force simulation.
+	Iterations int
+	// Curvature is how far the middle of a link bends to its left, as
+	// a fraction of its length.
+	Curvature float64
+
+	// Pinned places some nodes, by index, where the layout leaves them,
+	// so that only the other nodes are laid out.  A layout with pinned
+	// nodes keeps their coordinates rather than moving to the origin.
+	Pinned map[int]Point
+	// Curvatures sets the curvature of some edges, by index, in place
+	// of Curvature.
+	Curvatures map[int]float64
+	// LoopLabels places some loops' labels, by index, in place of the
+	// loops' centers.
+	LoopLabels map[int]Point
+}
+
+// DefaultOptions are the options VisualSVG uses.
+var DefaultOptions = Options{Seed: 1, Iterations: 500, Curvature: 0.2}
+
+const (
+	fontSize   = 14
+	charWidth  = 0.55 * fontSize // an average character, in the sans-serif font used
+	labelPadX  = 8
+	labelPadY  = 6
+	margin     = 20
+	linkGap    = 4  // between a link's ends and the labels
+	selfLoop   = 50 // the height of a link from a variable to itself
+	minSpacing = 12 // between labels, once overlaps are removed
+)
+
+// Layout is where a graph's nodes, edges and loop labels are drawn.
+type Layout struct {
+	Graph *Graph
+	// Origin is the top left corner of the drawing, which is Width by
+	// Height.
+	Origin        Point
+	Width, Height float64
+	// Nodes are the centers of the nodes' labels, and Sizes the labels'
+	// widths and heights.
+	Nodes []Point
+	Sizes []Point
+	// Edges are the curves of the graph's edges, in the same order, and
+	// Curvatures how much each bends.
+	Edges      []Curve
+	Curvatures []float64
+	// LoopLabels are the centers of the loops' labels.
+	LoopLabels []Point
+
+	pinned []bool
+}
+
+// NewLayout lays out a graph.  Iterations of 0 or less mean the default.
+func NewLayout(g *Graph, opts Options) *Layout {
+	if opts.Iterations <= 0 {
+		opts.Iterations = DefaultOptions.Iterations
+	}
+
+	n := len(g.Nodes)
+	l := &Layout{
+		Graph:  g,
+		Nodes:  make([]Point, n),
+		Sizes:  make([]Point, n),
+		pinned: make([]bool, n),
+	}
+	for v, p := range opts.Pinned {
+		if v >= 0 && v < n {
+			l.Nodes[v], l.pinned[v] = p, true
+		}
+	}
+	var meanWidth float64
+	for i, name := range g.Nodes {
+		l.Sizes[i] = labelSize(name)
+		meanWidth += l.Sizes[i].X
+	}
+	if n > 0 {
+		meanWidth /= float64(n)
+	}
+
+	// the ideal distance between linked variables
+	k := 60 + 0.6*meanWidth
+
+	l.start(k, opts.Seed)
+	l.relax(k, opts.Iterations)
+	l.separate()
+	l.route(opts.Curvature, opts.Curvatures)
+	l.labelLoops(opts.LoopLabels)
+	l.fit(slices.Contains(l.pinned, true))
+	return l
+}
+
+// labelSize estimates the size of a variable's label.
+func labelSize(name string) Point {
+	runes := len([]rune(name))
+	return Point{float64(runes)*charWidth + 2*labelPadX, fontSize + 2*labelPadY}
+}
+
+// neighbors returns each node's neighbors, ignoring direction, sorted.
+func (l *Layout) neighbors() [][]int {
+	neighbors := make([][]int, len(l.Nodes))
+	for _, e := range l.Graph.Edges {
+		if e.From == e.To {
+			continue
+		}
+		if !slices.Contains(neighbors[e.From], e.To) {
+			neighbors[e.From] = append(neighbors[e.From], e.To)
+			neighbors[e.To] = append(neighbors[e.To], e.From)
+		}
+	}
+	for _, ns := range neighbors {
+		slices.Sort(ns)
+	}
+	return neighbors
+}
+
+// start places the nodes around a circle, in depth-first order so that
+// linked nodes start out near each other, with a little jitter.  If some
+// nodes are pinned, the others start next to their placed neighbors
+// instead, or beside the pinned nodes if they have none.
+func (l *Layout) start(k float64, seed uint64) {
+	n := len(l.Nodes)
+	neighbors := l.neighbors()
+	order := make([]int, 0, n)
+	seen := make([]bool, n)
+	var visit func(int)
+	visit = func(v int) {
+		seen[v] = true
+		order = append(order, v)
+		for _, w := range neighbors[v] {
+			if !seen[w] {
+				visit(w)
+			}
+		}
+	}
+	for v := range n {
+		if !seen[v] {
+			visit(v)
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(seed, seed))
+	jitter := func() Point {
+		return Point{rng.Float64() - 0.5, rng.Float64() - 0.5}.scale(k / 4)
+	}
+
+	if !slices.Contains(l.pinned, true) {
+		radius := k * float64(n) / (2 * math.Pi)
+		for i, v := range order {
+			angle := 2 * math.Pi * float64(i) / float64(n)
+			l.Nodes[v] = Point{radius * math.Cos(angle), radius * math.Sin(angle)}.add(jitter())
+		}
+		return
+	}
+
+	placed := slices.Clone(l.pinned)
+	right, top := math.Inf(-1), math.Inf(1)
+	for v, p := range l.Nodes {
+		if placed[v] {
+			right, top = max(right, p.X+l.Sizes[v].X/2), min(top, p.Y)
+		}
+	}
+	for _, v := range order {
+		if placed[v] {
+			continue
+		}
+		var sum Point
+		count := 0
+		for _, w := range neighbors[v] {
+			if placed[w] {
+				sum = sum.add(l.Nodes[w])
+				count++
+			}
+		}
+		if count > 0 {
+			// off to one side, so it isn't right on top of a neighbor
+			l.Nodes[v] = sum.scale(1 / float64(count)).add(Point{0, k / 2}).add(jitter())
+		} else {
+			l.Nodes[v] = Point{right + k + l.Sizes[v].X/2, top}.add(jitter())
+			top += k / 2
+		}
+		placed[v] = true
+	}
+}
+
+// relax runs a Fruchterman-Reingold force simulation, with a little
+// gravity so that unconnected parts of the graph stay close.
+func (l *Layout) relax(k float64, iterations int) {
+	n := len(l.Nodes)
+	if n < 2 {
+		return
+	}
+	neighbors := l.neighbors()
+	disp := make([]Point, n)
+	temperature := k * math.Sqrt(float64(n)) / 2
+	if slices.Contains(l.pinned, true) {
+		// new nodes only need to settle in among the others
+		temperature = k
+	}
+
+	for iter := range iterations {
+		clear(disp)
+		var center Point
+		for _, p := range l.Nodes {
+			center = center.add(p)
+		}
+		center = center.scale(1 / float64(n))
+
+		for v := range n {
+			for u := v + 1; u < n; u++ {
+				d := l.Nodes[v].sub(l.Nodes[u])
+				dist := max(d.length(), 1)
+				// wide labels need more room
+				reach := k + (l.Sizes[v].X+l.Sizes[u].X)/4
+				f := d.scale(reach * reach / (dist * dist))
+				disp[v] = disp[v].add(f)
+				disp[u] = disp[u].sub(f)
+			}
+			for _, u := range neighbors[v] {
+				d := l.Nodes[u].sub(l.Nodes[v])
+				disp[v] = disp[v].add(d.scale(d.length() / k))
+			}
+			disp[v] = disp[v].add(center.sub(l.Nodes[v]).scale(0.02))
+		}
+
+		cooling := temperature * (1 - float64(iter)/float64(iterations))
+		for v := range n {
+			if l.pinned[v] {
+				continue
+			}
+			if step := disp[v].length(); step > cooling {
+				disp[v] = disp[v].scale(cooling / step)
+			}
+			l.Nodes[v] = l.Nodes[v].add(disp[v])
+		}
+	}
+}
+
+// separate pushes overlapping labels apart along the axis where they
+// overlap least.
+func (l *Layout) separate() {
+	n := len(l.Nodes)
+	for range 100 {
+		moved := false
+		for v := range n {
+			for u := v + 1; u < n; u++ {
+				d := l.Nodes[u].sub(l.Nodes[v])
+				overlapX := (l.Sizes[u].X+l.Sizes[v].X)/2 + minSpacing - math.Abs(d.X)
+				overlapY := (l.Sizes[u].Y+l.Sizes[v].Y)/2 + minSpacing - math.Abs(d.Y)
+				if overlapX <= 0 || overlapY <= 0 || l.pinned[u] && l.pinned[v] {
+					continue
+				}
+				moved = true
+				var push Point
+				if overlapX < overlapY {
+					push.X = math.Copysign(overlapX/2, d.X)
+					if d.X == 0 {
+						push.X = overlapX / 2
+					}
+				} else {
+					push.Y = math.Copysign(overlapY/2, d.Y)
+					if d.Y == 0 {
+						push.Y = overlapY / 2
+					}
+				}
+				// a pinned node stays put, and the other moves twice as far
+				switch {
+				case l.pinned[v]:
+					l.Nodes[u] = l.Nodes[u].add(push.scale(2))
+				case l.pinned[u]:
+					l.Nodes[v] = l.Nodes[v].sub(push.scale(2))
+				default:
+					l.Nodes[u] = l.Nodes[u].add(push)
+					l.Nodes[v] = l.Nodes[v].sub(push)
+				}
+			}
+		}
+		if !moved {
+			return
+		}
+	}
+}
+
+// fit sets the drawing's origin and size to take in everything in it,
+// plus a margin.  Unless it keeps the coordinates, it first moves
+// everything so that the origin is at 0, 0.
+func (l *Layout) fit(keep bool) {
+	if len(l.Nodes) == 0 {
+		l.Width, l.Height = 2*margin, 2*margin
+		return
+	}
+	lo := Point{math.Inf(1), math.Inf(1)}
+	hi := Point{math.Inf(-1), math.Inf(-1)}
+	extend := func(p Point) {
+		lo = Point{min(lo.X, p.X), min(lo.Y, p.Y)}
+		hi = Point{max(hi.X, p.X), max(hi.Y, p.Y)}
+	}
+	for i, p := range l.Nodes {
+		half := l.Sizes[i].scale(0.5)
+		extend(p.sub(half))
+		extend(p.add(half))
+	}
+	for _, c := range l.Edges {
+		extend(c.Start)
+		extend(c.End)
+		// the point of the curve furthest toward its control point
+		extend(c.Start.lerp(c.End, 0.5).lerp(c.Control, 0.5))
+	}
+	for _, p := range l.LoopLabels {
+		extend(p.sub(Point{glyphR, glyphR}))
+		extend(p.add(Point{glyphR, glyphR}))
+	}
+
+	l.Origin = lo.sub(Point{margin, margin})
+	l.Width, l.Height = hi.X-lo.X+2*margin, hi.Y-lo.Y+2*margin
+	if keep {
+		return
+	}
+
+	offset := l.Origin
+	l.Origin = Point{}
+	for i := range l.Nodes {
+		l.Nodes[i] = l.Nodes[i].sub(offset)
+	}
+	for i, c := range l.Edges {
+		l.Edges[i] = Curve{c.Start.sub(offset), c.Control.sub(offset), c.End.sub(offset)}
+	}
+	for i := range l.LoopLabels {
+		l.LoopLabels[i] = l.LoopLabels[i].sub(offset)
+	}
+}
+
+// labelLoops places each loop's label at its center, unless it's
+// given.
+func (l *Layout) labelLoops(given map[int]Point) {
+	l.LoopLabels = make([]Point, len(l.Graph.Loops))
+	for i, loop := range l.Graph.Loops {
+		if p, ok := given[i]; ok {
+			l.LoopLabels[i] = p
+		} else {
+			l.LoopLabels[i], _ = l.loopCenter(loop)
+		}
+	}
+}
+
+// route draws the edges as curves between the labels' borders, bending
+// them by the given curvature unless an edge has its own.
+func (l *Layout) route(curvature float64, curvatures map[int]float64) {
+	l.Edges = make([]Curve, len(l.Graph.Edges))
+	l.Curvatures = make([]float64, len(l.Graph.Edges))
+	for i, e := range l.Graph.Edges {
+		from, to := l.Nodes[e.From], l.Nodes[e.To]
+		l.Curvatures[i] = curvature
+		if c, ok := curvatures[i]; ok {
+			l.Curvatures[i] = c
+		}
+		if e.From == e.To {
+			top := from.Y - l.Sizes[e.From].Y/2 - linkGap
+			l.Edges[i] = Curve{
+				Start:   Point{from.X - 10, top},
+				Control: Point{from.X, top - 2*selfLoop},
+				End:     Point{from.X + 10, top},
+			}
+			continue
+		}
+
+		chord := to.sub(from)
+		length := chord.length()
+		if length == 0 {
+			l.Edges[i] = Curve{from, from, to}
+			continue
+		}
+		// the left of the direction of travel, in SVG coordinates
+		normal := Point{chord.Y, -chord.X}.scale(1 / length)
+		control := from.lerp(to, 0.5).add(normal.scale(l.Curvatures[i] * length))
+		l.Edges[i] = Curve{
+			Start:   l.border(e.From, control.sub(from)),
+			Control: control,
+			End:     l.border(e.To, control.sub(to)),
+		}
+	}
+}
+
+// border returns where a ray from the center of a node's label, in the
+// given direction, leaves the label, plus a gap.
+func (l *Layout) border(v int, dir Point) Point {
+	half := l.Sizes[v].scale(0.5).add(Point{linkGap, linkGap})
+	t := math.Inf(1)
+	if dir.X != 0 {
+		t = half.X / math.Abs(dir.X)
+	}
+	if dir.Y != 0 {
+		t = min(t, half.Y/math.Abs(dir.Y))
+	}
+	if math.IsInf(t, 1) {
+		return l.Nodes[v]
+	}
+	return l.Nodes[v].add(dir.scale(t))
+}
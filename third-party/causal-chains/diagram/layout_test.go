@@ -0,0 +1,159 @@
+package diagram
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ring links n variables in a loop, with a few chords across it.
+func ring(n int) *Graph {
+	g := &Graph{}
+	for i := range n {
+		g.Nodes = append(g.Nodes, fmt.Sprintf("Variable number %d", i))
+		g.Edges = append(g.Edges, Edge{From: i, To: (i + 1) % n})
+		if i%3 == 0 {
+			g.Edges = append(g.Edges, Edge{From: i, To: (i + n/2) % n})
+		}
+	}
+	return g
+}
+
+func complete(n int) *Graph {
+	g := &Graph{}
+	for i := range n {
+		g.Nodes = append(g.Nodes, fmt.Sprintf("v%d", i))
+		for j := range n {
+			if i != j {
+				g.Edges = append(g.Edges, Edge{From: i, To: j})
+			}
+		}
+	}
+	return g
+}
+
+func TestLayoutDeterministic(t *testing.T) {
+	a := NewLayout(ring(10), DefaultOptions)
+	b := NewLayout(ring(10), DefaultOptions)
+	assert.Equal(t, a, b)
+
+	opts := DefaultOptions
+	opts.Seed = 7
+	c := NewLayout(ring(10), opts)
+	assert.NotEqual(t, a.Nodes, c.Nodes)
+}
+
+func TestLayoutFits(t *testing.T) {
+	graphs := map[string]*Graph{
+		"ring":        ring(12),
+		"complete":    complete(6),
+		"single":      {Nodes: []string{"alone"}},
+		"self loop":   {Nodes: []string{"a", "b"}, Edges: []Edge{{From: 0, To: 0}, {From: 0, To: 1}}},
+		"unconnected": {Nodes: []string{"a", "b", "c", "d"}, Edges: []Edge{{From: 0, To: 1}}},
+	}
+	for name, g := range graphs {
+		t.Run(name, func(t *testing.T) {
+			l := NewLayout(g, DefaultOptions)
+			require.Len(t, l.Nodes, len(g.Nodes))
+			require.Len(t, l.Edges, len(g.Edges))
+
+			inside := func(p Point) {
+				assert.True(t, p.X >= 0 && p.X <= l.Width && p.Y >= 0 && p.Y <= l.Height, "%v is outside %gx%g", p, l.Width, l.Height)
+			}
+			for i, p := range l.Nodes {
+				inside(p.sub(l.Sizes[i].scale(0.5)))
+				inside(p.add(l.Sizes[i].scale(0.5)))
+
+				for j := i + 1; j < len(l.Nodes); j++ {
+					d := l.Nodes[j].sub(p)
+					overlaps := math.Abs(d.X) < (l.Sizes[i].X+l.Sizes[j].X)/2 && math.Abs(d.Y) < (l.Sizes[i].Y+l.Sizes[j].Y)/2
+					assert.False(t, overlaps, "%q overlaps %q", g.Nodes[i], g.Nodes[j])
+				}
+			}
+			for _, c := range l.Edges {
+				inside(c.Start)
+				inside(c.End)
+			}
+		})
+	}
+
+	empty := NewLayout(&Graph{}, DefaultOptions)
+	assert.Equal(t, [2]float64{2 * margin, 2 * margin}, [2]float64{empty.Width, empty.Height})
+}
+
+func TestLayoutEdges(t *testing.T) {
+	g := &Graph{Nodes: []string{"a", "b"}, Edges: []Edge{{From: 0, To: 1}, {From: 1, To: 0}, {From: 1, To: 1}}}
+	l := NewLayout(g, DefaultOptions)
+
+	// opposing links bend to opposite sides, so they don't overlap
+	mid := l.Nodes[0].lerp(l.Nodes[1], 0.5)
+	side := func(c Curve) float64 {
+		chord := l.Nodes[1].sub(l.Nodes[0])
+		off := c.Control.sub(mid)
+		return chord.X*off.Y - chord.Y*off.X
+	}
+	assert.Negative(t, side(l.Edges[0])*side(l.Edges[1]))
+
+	// links end at the labels' borders, not their centers
+	for i, c := range l.Edges[:2] {
+		e := g.Edges[i]
+		assert.Greater(t, c.Start.sub(l.Nodes[e.From]).length(), l.Sizes[e.From].Y/2)
+		assert.Greater(t, c.End.sub(l.Nodes[e.To]).length(), l.Sizes[e.To].Y/2)
+	}
+
+	// a link to itself loops over the top of the label
+	self := l.Edges[2]
+	assert.Less(t, self.Control.Y, l.Nodes[1].Y-l.Sizes[1].Y/2)
+	assert.Less(t, self.Start.X, self.End.X)
+
+	straight := DefaultOptions
+	straight.Curvature = 0
+	l = NewLayout(g, straight)
+	assert.InDelta(t, 0, side(l.Edges[0]), 1e-9)
+}
+
+func TestLayoutPinned(t *testing.T) {
+	before := NewLayout(ring(8), DefaultOptions)
+
+	// two more variables, linked into the ring
+	g := ring(8)
+	g.Nodes = append(g.Nodes, "New variable", "Another new one")
+	g.Edges = append(g.Edges, Edge{From: 2, To: 8}, Edge{From: 8, To: 9}, Edge{From: 9, To: 5})
+	g.Loops = []Loop{{Label: "R1", Nodes: []int{0, 1, 2, 3, 4, 5, 6, 7}}}
+
+	opts := DefaultOptions
+	opts.Pinned = make(map[int]Point)
+	for i, p := range before.Nodes {
+		opts.Pinned[i] = p
+	}
+	opts.Curvatures = map[int]float64{0: -0.5}
+	opts.LoopLabels = map[int]Point{0: {100, 120}}
+	l := NewLayout(g, opts)
+
+	assert.Equal(t, before.Nodes, l.Nodes[:8])
+	assert.Equal(t, -0.5, l.Curvatures[0])
+	assert.Equal(t, DefaultOptions.Curvature, l.Curvatures[1])
+	assert.Equal(t, Point{100, 120}, l.LoopLabels[0])
+
+	for i := 8; i < len(l.Nodes); i++ {
+		p := l.Nodes[i]
+		for j, q := range l.Nodes {
+			if i == j {
+				continue
+			}
+			d := q.sub(p)
+			overlaps := math.Abs(d.X) < (l.Sizes[i].X+l.Sizes[j].X)/2 && math.Abs(d.Y) < (l.Sizes[i].Y+l.Sizes[j].Y)/2
+			assert.False(t, overlaps, "%q overlaps %q", g.Nodes[i], g.Nodes[j])
+		}
+	}
+
+	// the drawing takes in every label, wherever the pinned nodes are
+	for i, p := range l.Nodes {
+		lo, hi := p.sub(l.Sizes[i].scale(0.5)), p.add(l.Sizes[i].scale(0.5))
+		assert.True(t, lo.X >= l.Origin.X && lo.Y >= l.Origin.Y, "%q is outside", g.Nodes[i])
+		assert.True(t, hi.X <= l.Origin.X+l.Width && hi.Y <= l.Origin.Y+l.Height, "%q is outside", g.Nodes[i])
+	}
+}
@@ -0,0 +1,285 @@
+package diagram
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Style controls what WriteSVG draws besides the variables and links.
+type Style struct {
+	// Polarities marks each link + or − by its arrowhead.
+	Polarities bool
+	// Delays draws two hash marks across delayed links.
+	Delays bool
+	// LoopLabels draws each loop's label inside a circular arrow at the
+	// loop's center, turning the way the loop does.
+	LoopLabels bool
+	// LoopColors draws each loop's links in its own color.  A link in
+	// several loops takes the color of the first.
+	LoopColors bool
+	// Highlight, if not empty, is the label of a loop to draw in color,
+	// with the rest of the diagram faded.
+	Highlight string
+}
+
+// DefaultStyle is the style VisualSVG uses.
+var DefaultStyle = Style{Polarities: true, Delays: true, LoopLabels: true}
+
+const (
+	ink      = "#333"
+	faded    = "#ccc"
+	fadedInk = "#999"
+	glyphR   = 16 // the radius of a loop's circular arrow
+)
+
+// loopColors are distinct colors for loops, used in turn.
+var loopColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#17becf"}
+
+// loopColor returns the color of the i'th loop.
+func loopColor(i int) string {
+	return loopColors[i%len(loopColors)]
+}
+
+// loopEdges returns the indexes of a loop's edges, in order.
+func (g *Graph) loopEdges(loop Loop) []int {
+	edges := make([]int, 0, len(loop.Nodes))
+	for i, from := range loop.Nodes {
+		to := loop.Nodes[(i+1)%len(loop.Nodes)]
+		if e := g.edge(from, to); e >= 0 {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// WriteSVG draws the layout as a standalone SVG document.
+func (l *Layout) WriteSVG(w io.Writer, style Style) error {
+	g := l.Graph
+	b := bufio.NewWriter(w)
+
+	highlight := slices.IndexFunc(g.Loops, func(loop Loop) bool { return style.Highlight != "" && loop.Label == style.Highlight })
+
+	// the color and width of each edge
+	colors := make([]string, len(g.Edges))
+	widths := make([]string, len(g.Edges))
+	for i := range g.Edges {
+		colors[i], widths[i] = ink, "1.5"
+		if highlight >= 0 {
+			colors[i] = faded
+		}
+	}
+	inLoop := make([]bool, len(g.Nodes))
+	switch {
+	case highlight >= 0:
+		for _, e := range g.loopEdges(g.Loops[highlight]) {
+			colors[e], widths[e] = loopColor(highlight), "2.5"
+		}
+		for _, v := range g.Loops[highlight].Nodes {
+			inLoop[v] = true
+		}
+	case style.LoopColors:
+		colored := make([]bool, len(g.Edges))
+		for i, loop := range g.Loops {
+			for _, e := range g.loopEdges(loop) {
+				if !colored[e] {
+					colors[e], colored[e] = loopColor(i), true
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="%s %s %s %s" font-family="Helvetica, Arial, sans-serif" font-size="%d">`+"\n",
+		num(l.Width), num(l.Height), num(l.Origin.X), num(l.Origin.Y), num(l.Width), num(l.Height), fontSize)
+
+	// an arrowhead for each color in use
+	markerColors := []string{ink}
+	for _, c := range colors {
+		if !slices.Contains(markerColors, c) {
+			markerColors = append(markerColors, c)
+		}
+	}
+	if style.LoopLabels {
+		for i := range g.Loops {
+			if c := l.glyphColor(style, highlight, i); !slices.Contains(markerColors, c) {
+				markerColors = append(markerColors, c)
+			}
+		}
+	}
+	b.WriteString("<defs>")
+	for _, c := range markerColors {
+		fmt.Fprintf(b, `<marker id="%s" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse"><path d="M0,0 L10,5 L0,10 z" fill="%s"/></marker>`, markerID(c), c)
+	}
+	b.WriteString("</defs>\n")
+	fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="%s" fill="white"/>`+"\n", num(l.Origin.X), num(l.Origin.Y), num(l.Width), num(l.Height))
+
+	b.WriteString(`<g class="links" fill="none" stroke-width="1.5">` + "\n")
+	for i, c := range l.Edges {
+		e := g.Edges[i]
+		fmt.Fprintf(b, `<path class="link" d="M%s,%s Q%s,%s %s,%s" stroke="%s"`,
+			num(c.Start.X), num(c.Start.Y), num(c.Control.X), num(c.Control.Y), num(c.End.X), num(c.End.Y), colors[i])
+		if widths[i] != "1.5" {
+			fmt.Fprintf(b, ` stroke-width="%s"`, widths[i])
+		}
+		fmt.Fprintf(b, ` marker-end="url(#%s)"><title>`, markerID(colors[i]))
+		escape(b, g.Nodes[e.From]+" → "+g.Nodes[e.To])
+		b.WriteString("</title></path>\n")
+
+		if style.Delays && e.Delay {
+			writeDelay(b, c, colors[i])
+		}
+	}
+	b.WriteString("</g>\n")
+
+	if style.Polarities {
+		b.WriteString(`<g class="polarities" text-anchor="middle" dominant-baseline="central" font-size="16" font-weight="bold">` + "\n")
+		for i, c := range l.Edges {
+			var sign string
+			switch g.Edges[i].Polarity {
+			case "+":
+				sign = "+"
+			case "-":
+				sign = "−"
+			default:
+				continue
+			}
+			p := c.at(0.8).add(c.normal(0.8).scale(10))
+			fmt.Fprintf(b, `<text class="polarity" x="%s" y="%s" fill="%s">%s</text>`+"\n", num(p.X), num(p.Y), colors[i], sign)
+		}
+		b.WriteString("</g>\n")
+	}
+
+	if style.LoopLabels && len(g.Loops) > 0 {
+		b.WriteString(`<g class="loops" fill="none" text-anchor="middle" dominant-baseline="central" font-weight="bold">` + "\n")
+		for i, loop := range g.Loops {
+			if highlight >= 0 && i != highlight {
+				continue
+			}
+			l.writeGlyph(b, loop, l.LoopLabels[i], l.glyphColor(style, highlight, i))
+		}
+		b.WriteString("</g>\n")
+	}
+
+	b.WriteString(`<g class="variables" text-anchor="middle" dominant-baseline="central" fill="#000">` + "\n")
+	for i, p := range l.Nodes {
+		b.WriteString(`<text class="variable"`)
+		if highlight >= 0 && !inLoop[i] {
+			fmt.Fprintf(b, ` fill="%s"`, fadedInk)
+		}
+		fmt.Fprintf(b, ` x="%s" y="%s">`, num(p.X), num(p.Y))
+		escape(b, g.Nodes[i])
+		b.WriteString("</text>\n")
+	}
+	b.WriteString("</g>\n</svg>\n")
+
+	return b.Flush()
+}
+
+func (l *Layout) glyphColor(style Style, highlight, i int) string {
+	if highlight >= 0 || style.LoopColors {
+		return loopColor(i)
+	}
+	return ink
+}
+
+// markerID names the arrowhead of a color.
+func markerID(color string) string {
+	if color == ink {
+		return "arrow"
+	}
+	return "arrow-" + strings.TrimPrefix(color, "#")
+}
+
+// writeDelay draws two short lines across the middle of a link.
+func writeDelay(b *bufio.Writer, c Curve, color string) {
+	mid, tangent, normal := c.at(0.5), c.tangent(0.5), c.normal(0.5)
+	for _, offset := range []float64{-3, 3} {
+		center := mid.add(tangent.scale(offset))
+		p, q := center.sub(normal.scale(7)), center.add(normal.scale(7))
+		fmt.Fprintf(b, `<path class="delay" d="M%s,%s L%s,%s" stroke="%s"/>`+"\n", num(p.X), num(p.Y), num(q.X), num(q.Y), color)
+	}
+}
+
+// writeGlyph draws a loop's label inside a circular arrow, turning the
+// way the loop goes around.
+func (l *Layout) writeGlyph(b *bufio.Writer, loop Loop, center Point, color string) {
+	_, clockwise := l.loopCenter(loop)
+
+	// leave a gap at the top, with the arrowhead at one side of it
+	start, end := -math.Pi/3, -2*math.Pi/3
+	sweep := 1
+	if !clockwise {
+		start, end = end, start
+		sweep = 0
+	}
+	p := center.add(Point{math.Cos(start), math.Sin(start)}.scale(glyphR))
+	q := center.add(Point{math.Cos(end), math.Sin(end)}.scale(glyphR))
+	fmt.Fprintf(b, `<path class="loop" d="M%s,%s A%d,%d 0 1,%d %s,%s" stroke="%s" stroke-width="1.5" marker-end="url(#%s)"/>`+"\n",
+		num(p.X), num(p.Y), glyphR, glyphR, sweep, num(q.X), num(q.Y), color, markerID(color))
+	fmt.Fprintf(b, `<text class="loop-label" x="%s" y="%s" fill="%s">`, num(center.X), num(center.Y), color)
+	escape(b, loop.Label)
+	b.WriteString("</text>\n")
+}
+
+// loopCenter returns the middle of a loop, and whether it goes around
+// clockwise on the page.  The links' curves count, so that a loop of two
+// variables still has a direction.
+func (l *Layout) loopCenter(loop Loop) (Point, bool) {
+	var points []Point
+	for i, v := range loop.Nodes {
+		points = append(points, l.Nodes[v])
+		if e := l.Graph.edge(v, loop.Nodes[(i+1)%len(loop.Nodes)]); e >= 0 {
+			c := l.Edges[e]
+			points = append(points, c.at(0.5))
+		}
+	}
+
+	var center Point
+	var area float64
+	for i, p := range points {
+		center = center.add(p)
+		q := points[(i+1)%len(points)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	center = center.scale(1 / float64(len(points)))
+	// y grows downward, so a positive area goes clockwise on the page
+	return center, area >= 0
+}
+
+// at returns the point of the curve at t, from 0 at Start to 1 at End.
+func (c Curve) at(t float64) Point {
+	return c.Start.lerp(c.Control, t).lerp(c.Control.lerp(c.End, t), t)
+}
+
+// tangent returns the curve's direction at t, as a unit vector.
+func (c Curve) tangent(t float64) Point {
+	d := c.Control.sub(c.Start).lerp(c.End.sub(c.Control), t)
+	if length := d.length(); length > 0 {
+		return d.scale(1 / length)
+	}
+	return Point{1, 0}
+}
+
+// normal returns the unit vector to the left of the curve at t.
+func (c Curve) normal(t float64) Point {
+	d := c.tangent(t)
+	return Point{d.Y, -d.X}
+}
+
+// num formats a coordinate compactly, to a tenth of a pixel.
+func num(x float64) string {
+	s := fmt.Sprintf("%.1f", x)
+	if s == "-0.0" {
+		s = "0.0"
+	}
+	return s
+}
+
+func escape(w *bufio.Writer, s string) {
+	// writes to a bufio.Writer only fail at Flush
+	_ = xml.EscapeText(w, []byte(s))
+}
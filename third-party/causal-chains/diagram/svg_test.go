@@ -0,0 +1,117 @@
+package diagram
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type svgDoc struct {
+	XMLName xml.Name
+	Width   string `xml:"width,attr"`
+	Height  string `xml:"height,attr"`
+	Links   []struct {
+		D     string `xml:"d,attr"`
+		Title string `xml:"title"`
+	} `xml:"g>path"`
+	Texts []struct {
+		X    string `xml:"x,attr"`
+		Y    string `xml:"y,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"g>text"`
+}
+
+func TestWriteSVG(t *testing.T) {
+	g := &Graph{
+		Nodes: []string{"Profits & <Losses>", `"Quoted"`, "plain"},
+		Edges: []Edge{{From: 0, To: 1}, {From: 1, To: 2}, {From: 2, To: 0}},
+	}
+	l := NewLayout(g, DefaultOptions)
+
+	var b bytes.Buffer
+	require.NoError(t, l.WriteSVG(&b, Style{}))
+
+	var doc svgDoc
+	require.NoError(t, xml.Unmarshal(b.Bytes(), &doc))
+	assert.Equal(t, "svg", doc.XMLName.Local)
+	assert.Equal(t, num(l.Width), doc.Width)
+	assert.Equal(t, num(l.Height), doc.Height)
+
+	require.Len(t, doc.Texts, 3)
+	for i, text := range doc.Texts {
+		assert.Equal(t, g.Nodes[i], text.Text)
+		assert.Equal(t, num(l.Nodes[i].X), text.X)
+		assert.Equal(t, num(l.Nodes[i].Y), text.Y)
+	}
+
+	require.Len(t, doc.Links, 3)
+	assert.Equal(t, "Profits & <Losses> → \"Quoted\"", doc.Links[0].Title)
+	c := l.Edges[0]
+	assert.Equal(t, "M"+num(c.Start.X)+","+num(c.Start.Y)+" Q"+num(c.Control.X)+","+num(c.Control.Y)+" "+num(c.End.X)+","+num(c.End.Y), doc.Links[0].D)
+}
+
+func TestNum(t *testing.T) {
+	assert.Equal(t, "12.3", num(12.345))
+	assert.Equal(t, "0.0", num(-0.01))
+	assert.Equal(t, "-1.5", num(-1.5))
+}
+
+func TestWriteSVGHighlight(t *testing.T) {
+	g := &Graph{
+		Nodes: []string{"a", "b", "c"},
+		Edges: []Edge{{From: 0, To: 1, Polarity: "+"}, {From: 1, To: 0, Polarity: "-"}, {From: 1, To: 2, Polarity: "+", Delay: true}},
+		Loops: []Loop{{Label: "B1", Polarity: "-", Nodes: []int{0, 1}}},
+	}
+	l := NewLayout(g, DefaultOptions)
+
+	var b bytes.Buffer
+	require.NoError(t, l.WriteSVG(&b, Style{Polarities: true, Delays: true, LoopLabels: true, Highlight: "B1"}))
+	svg := b.String()
+	assert.Contains(t, svg, `stroke="`+loopColor(0)+`" stroke-width="2.5"`)
+	assert.Contains(t, svg, `stroke="`+faded+`"`)
+	assert.Contains(t, svg, `<text class="variable" fill="`+fadedInk+`"`)
+	assert.Contains(t, svg, `class="delay"`)
+	assert.Contains(t, svg, `>B1</text>`)
+
+	// an unknown loop highlights nothing
+	b.Reset()
+	require.NoError(t, l.WriteSVG(&b, Style{Highlight: "R9"}))
+	assert.NotContains(t, b.String(), faded)
+}
+
+func TestLoopCenter(t *testing.T) {
+	g := &Graph{
+		Nodes: []string{"a", "b", "c"},
+		Edges: []Edge{{From: 0, To: 1}, {From: 1, To: 2}, {From: 2, To: 0}, {From: 1, To: 0}, {From: 2, To: 1}, {From: 0, To: 2}},
+	}
+	l := &Layout{
+		Graph: g,
+		Nodes: []Point{{0, 0}, {100, 0}, {50, 100}},
+		Sizes: []Point{{10, 10}, {10, 10}, {10, 10}},
+	}
+	l.route(0.2, nil)
+
+	// right, then down and back: clockwise on the page
+	center, clockwise := l.loopCenter(Loop{Nodes: []int{0, 1, 2}})
+	assert.True(t, clockwise)
+	assert.InDelta(t, 50, center.X, 5)
+	assert.InDelta(t, 33, center.Y, 5)
+
+	_, clockwise = l.loopCenter(Loop{Nodes: []int{0, 2, 1}})
+	assert.False(t, clockwise)
+
+	// two variables go around the way their links bend
+	_, clockwise = l.loopCenter(Loop{Nodes: []int{0, 1}})
+	_, reversed := l.loopCenter(Loop{Nodes: []int{1, 0}})
+	assert.Equal(t, clockwise, reversed)
+}
+
+func TestCurve(t *testing.T) {
+	c := Curve{Start: Point{0, 0}, Control: Point{50, -50}, End: Point{100, 0}}
+	assert.Equal(t, Point{50, -25}, c.at(0.5))
+	assert.Equal(t, Point{1, 0}, c.tangent(0.5))
+	assert.Equal(t, Point{0, -1}, c.normal(0.5))
+}
@@ -0,0 +1,341 @@
+// Package equation parses the XMILE/Stella expression language used in
+// sdjson.Variable equations into an abstract syntax tree.
+//
+// The grammar covers the arithmetic, comparison and logical operators,
+// IF THEN ELSE, builtin and lookup calls, array subscripts, and
+// module-qualified names like "Module.variable".  Vensim's spelling of
+// a few constructs (IF THEN ELSE(c, a, b), :AND:, and names containing
+// spaces) is accepted too, so equations imported from .mdl files parse.
+package equation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pos is a position in an equation.  Offset is a 0-based byte offset;
+// Line and Column are 1-based, with Column counted in bytes.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Node is an expression in an equation's syntax tree.
+type Node interface {
+	// Pos returns the position of the first character of the node.
+	Pos() Pos
+	// String formats the node as an equation that parses back to the
+	// same tree.
+	String() string
+	node()
+}
+
+// Number is a numeric literal.
+type Number struct {
+	Value    float64
+	Text     string // as written
+	Position Pos
+}
+
+// Ident is a reference to a variable or, when it is the target of an
+// Index, an array.  Module-qualified names keep their dots:
+// "Module.variable".
+type Ident struct {
+	Name     string // as written, without surrounding quotes
+	Position Pos
+}
+
+// Unary is a prefix operator: "-", "+" or "NOT".
+type Unary struct {
+	Op       string
+	X        Node
+	Position Pos
+}
+
+// Binary is an infix operator: "+", "-", "*", "/", "//", "^", "MOD",
+// "=", "<>", "<", "<=", ">", ">=", "AND" or "OR".  "//" is Stella's safe
+// division, which yields 0 when dividing by 0.
+type Binary struct {
+	Op   string
+	X, Y Node
+}
+
+// Call is a call to a builtin function or a graphical function.  Func
+// is as written; builtins should be matched case-insensitively.
+type Call struct {
+	Func     string
+	Args     []Node
+	Position Pos
+}
+
+// If is an IF THEN ELSE expression.
+type If struct {
+	Cond, Then, Else Node
+	Position         Pos
+}
+
+// Index is a subscripted array reference: "x[a, 1, *]".
+type Index struct {
+	X          *Ident
+	Subscripts []Node
+}
+
+// Wildcard is the "*" subscript, selecting every element of a
+// dimension.
+type Wildcard struct {
+	Position Pos
+}
+
+// Range is a subscript range such as "1:3".
+type Range struct {
+	Lo, Hi Node
+}
+
+func (n *Number) Pos() Pos   { return n.Position }
+func (n *Ident) Pos() Pos    { return n.Position }
+func (n *Unary) Pos() Pos    { return n.Position }
+func (n *Binary) Pos() Pos   { return n.X.Pos() }
+func (n *Call) Pos() Pos     { return n.Position }
+func (n *If) Pos() Pos       { return n.Position }
+func (n *Index) Pos() Pos    { return n.X.Pos() }
+func (n *Wildcard) Pos() Pos { return n.Position }
+func (n *Range) Pos() Pos    { return n.Lo.Pos() }
+
+func (*Number) node()   {}
+func (*Ident) node()    {}
+func (*Unary) node()    {}
+func (*Binary) node()   {}
+func (*Call) node()     {}
+func (*If) node()       {}
+func (*Index) node()    {}
+func (*Wildcard) node() {}
+func (*Range) node()    {}
+
+// Module splits a module-qualified name into its module path and local
+// name; module is empty for unqualified names.
+func (n *Ident) Module() (module, local string) {
+	if i := strings.LastIndexByte(n.Name, '.'); i >= 0 {
+		return n.Name[:i], n.Name[i+1:]
+	}
+	return "", n.Name
+}
+
+var builtinReplacer = strings.NewReplacer(" ", "", "_", "")
+
+// Builtin returns the function name in the form used to match builtins:
+// upper case, without spaces or underscores, so that Vensim's "DELAY
+// FIXED" and XMILE's "DELAY_FIXED" are both "DELAYFIXED".
+func (n *Call) Builtin() string {
+	return strings.ToUpper(builtinReplacer.Replace(n.Func))
+}
+
+// precedence returns the binding strength of a binary operator; higher
+// binds tighter.
+func precedence(op string) int {
+	switch op {
+	case "OR":
+		return 1
+	case "AND":
+		return 2
+	case "=", "<>":
+		return 3
+	case "<", "<=", ">", ">=":
+		return 4
+	case "+", "-":
+		return 5
+	case "*", "/", "//", "MOD":
+		return 6
+	case "^":
+		return 8
+	}
+	return 0
+}
+
+// unaryPrecedence sits between multiplication and exponentiation, so
+// that -2^2 is -(2^2).
+const unaryPrecedence = 7
+
+func (n *Number) String() string {
+	if n.Text != "" {
+		return n.Text
+	}
+	return strconv.FormatFloat(n.Value, 'g', -1, 64)
+}
+
+func (n *Ident) String() string {
+	if identRequiresQuotes(n.Name) {
+		return strconv.Quote(n.Name)
+	}
+	return n.Name
+}
+
+func identRequiresQuotes(name string) bool {
+	if name == "" {
+		return true
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part == "" || (i == 0 && !isIdentStart(rune(part[0]))) {
+			return true
+		}
+		for _, c := range part {
+			if !isIdentChar(c) && c != ' ' {
+				return true
+			}
+		}
+		words := strings.Fields(part)
+		for _, word := range words {
+			// a keyword on its own is a name only as part of a
+			// qualified one, as in "Mod.x"
+			if isKeyword(word) && (len(words) > 1 || len(parts) == 1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *Unary) String() string {
+	x := n.X.String()
+	switch c := n.X.(type) {
+	case *Binary:
+		if precedence(c.Op) < unaryPrecedence {
+			x = "(" + x + ")"
+		}
+	case *If:
+		// the ELSE would take in whatever follows
+		x = "(" + x + ")"
+	}
+	if n.Op == "NOT" {
+		return "NOT " + x
+	}
+	return n.Op + x
+}
+
+func (n *Binary) String() string {
+	prec := precedence(n.Op)
+	x, y := n.X.String(), n.Y.String()
+
+	// "^" is right-associative; everything else is left-associative
+	if needsParens(n.X, prec, n.Op == "^") {
+		x = "(" + x + ")"
+	}
+	if needsParens(n.Y, prec, n.Op != "^") {
+		y = "(" + y + ")"
+	}
+
+	switch n.Op {
+	case "^":
+		return x + "^" + y
+	case "*", "/", "//":
+		return x + n.Op + y
+	}
+	return x + " " + n.Op + " " + y
+}
+
+func needsParens(child Node, parentPrec int, sameLevel bool) bool {
+	switch c := child.(type) {
+	case *Binary:
+		prec := precedence(c.Op)
+		return prec < parentPrec || (prec == parentPrec && sameLevel)
+	case *Unary:
+		if _, ok := c.X.(*If); ok {
+			return true
+		}
+		return unaryPrecedence < parentPrec
+	case *If:
+		return true
+	}
+	return false
+}
+
+func joinNodes(nodes []Node) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = n.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (n *Call) String() string {
+	return n.Func + "(" + joinNodes(n.Args) + ")"
+}
+
+func (n *If) String() string {
+	return "IF " + n.Cond.String() + " THEN " + n.Then.String() + " ELSE " + n.Else.String()
+}
+
+func (n *Index) String() string {
+	return n.X.String() + "[" + joinNodes(n.Subscripts) + "]"
+}
+
+func (n *Wildcard) String() string {
+	return "*"
+}
+
+func (n *Range) String() string {
+	return n.Lo.String() + ":" + n.Hi.String()
+}
+
+// Walk traverses a syntax tree in depth-first order, calling fn for
+// each node before its children.  If fn returns false, the node's
+// children are skipped.
+func Walk(n Node, fn func(Node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	switch n := n.(type) {
+	case *Unary:
+		Walk(n.X, fn)
+	case *Binary:
+		Walk(n.X, fn)
+		Walk(n.Y, fn)
+	case *Call:
+		for _, arg := range n.Args {
+			Walk(arg, fn)
+		}
+	case *If:
+		Walk(n.Cond, fn)
+		Walk(n.Then, fn)
+		Walk(n.Else, fn)
+	case *Index:
+		Walk(n.X, fn)
+		for _, s := range n.Subscripts {
+			Walk(s, fn)
+		}
+	case *Range:
+		Walk(n.Lo, fn)
+		Walk(n.Hi, fn)
+	}
+}
+
+// Idents returns the variables an expression refers to, in the order
+// they first appear.  Array subscripts name dimensions and elements
+// rather than variables, so they aren't included.
+func Idents(n Node) []*Ident {
+	var idents []*Ident
+	seen := make(map[string]bool)
+	Walk(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *Index:
+			if !seen[n.X.Name] {
+				seen[n.X.Name] = true
+				idents = append(idents, n.X)
+			}
+			return false
+		case *Ident:
+			if !seen[n.Name] {
+				seen[n.Name] = true
+				idents = append(idents, n)
+			}
+		}
+		return true
+	})
+	return idents
+}
@@ -0,0 +1,288 @@
+package equation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// DeriveRelationships rebuilds a model's relationships from its
+// equations and its stocks' inflows and outflows.  Each variable an
+// equation refers to becomes a relationship into the variable, and each
+// inflow and outflow a "+" or "-" relationship into its stock; a stock's
+// own equation is only its initial value, so it contributes nothing.
+//
+// Polarities come from the sign of the equation's partial derivative,
+// worked out symbolically where possible and estimated numerically
+// otherwise.  A relationship whose polarity can't be determined, such as
+// one that only affects an IF condition, has an empty polarity.
+//
+// The diagnostics report equations that don't parse, declared
+// relationships whose polarity disagrees with the equation, and declared
+// relationships into a variable whose equation doesn't use them.
+func DeriveRelationships(m *sdjson.Model) ([]sdjson.Relationship, []sdjson.Diagnostic) {
+	d := &deriver{m: m}
+
+	for i := range m.Variables {
+		v := &m.Variables[i]
+		switch {
+		case v.CrossLevelGhostOf != "":
+			// a ghost's value comes from its source, not its equation
+		case v.Type == sdjson.VariableTypeStock:
+			d.deriveFlows(v)
+		default:
+			d.deriveEquation(v)
+		}
+	}
+
+	d.compare()
+
+	return d.rels, d.diags
+}
+
+type deriver struct {
+	m     *sdjson.Model
+	rels  []sdjson.Relationship
+	diags []sdjson.Diagnostic
+	// derived indexes rels by their endpoints
+	derived map[[2]*sdjson.Variable]int
+	// parsed records the variables whose equations parsed
+	parsed map[*sdjson.Variable]bool
+}
+
+func (d *deriver) add(from, to *sdjson.Variable, polarity string) {
+	key := [2]*sdjson.Variable{from, to}
+	if d.derived == nil {
+		d.derived = make(map[[2]*sdjson.Variable]int)
+	}
+	if _, ok := d.derived[key]; ok || from == to {
+		return
+	}
+	d.derived[key] = len(d.rels)
+	d.rels = append(d.rels, sdjson.Relationship{From: from.Name, To: to.Name, Polarity: polarity})
+}
+
+func (d *deriver) deriveFlows(stock *sdjson.Variable) {
+	for _, name := range stock.Inflows {
+		if flow := d.m.Variable(name); flow != nil {
+			d.add(flow, stock, "+")
+		}
+	}
+	for _, name := range stock.Outflows {
+		if flow := d.m.Variable(name); flow != nil {
+			d.add(flow, stock, "-")
+		}
+	}
+	if d.parsed == nil {
+		d.parsed = make(map[*sdjson.Variable]bool)
+	}
+	d.parsed[stock] = true
+}
+
+// resolve finds the variable a name in v's equation refers to.
+func (d *deriver) resolve(v *sdjson.Variable, name string) *sdjson.Variable {
+	return d.m.Resolve(v.Name, name)
+}
+
+// constant returns the value of the variable a name in v's equation
+// refers to, if its equation is a number.  A stock's equation is only its
+// initial value, so stocks are never constant.
+func (d *deriver) constant(v *sdjson.Variable, name string) (float64, bool) {
+	found := d.resolve(v, name)
+	if found == nil || found.Type == sdjson.VariableTypeStock || found.GraphicalFunction != nil || len(found.ArrayEquations) > 0 {
+		return 0, false
+	}
+	n, err := Parse(found.Equation)
+	if err != nil {
+		return 0, false
+	}
+	switch n := n.(type) {
+	case *Number:
+		return n.Value, true
+	case *Unary:
+		if x, ok := n.X.(*Number); ok && n.Op == "-" {
+			return -x.Value, true
+		}
+	}
+	return 0, false
+}
+
+func (d *deriver) deriveEquation(v *sdjson.Variable) {
+	equations := []string{v.Equation}
+	for _, ae := range v.ArrayEquations {
+		equations = append(equations, ae.Equation)
+	}
+
+	var trees []Node
+	for _, eqn := range equations {
+		if strings.TrimSpace(eqn) == "" {
+			continue
+		}
+		n, err := Parse(eqn)
+		if err != nil {
+			d.diags = append(d.diags, sdjson.Diagnostic{
+				Code:     sdjson.CodeInvalidEquation,
+				Severity: sdjson.SeverityError,
+				Message:  fmt.Sprintf("equation for %q doesn't parse: %s", v.Name, err),
+				Variable: v.Name,
+			})
+			return
+		}
+		trees = append(trees, n)
+	}
+	if len(trees) == 0 {
+		return
+	}
+	if d.parsed == nil {
+		d.parsed = make(map[*sdjson.Variable]bool)
+	}
+	d.parsed[v] = true
+
+	lookup := func(call *Call) *sdjson.GraphicalFunction {
+		if found := d.resolve(v, call.Func); found != nil && found.GraphicalFunction != nil {
+			return found.GraphicalFunction
+		}
+		return nil
+	}
+
+	for _, input := range d.inputs(v, trees) {
+		isInput := func(ident *Ident) bool {
+			return d.resolve(v, ident.Name) == input
+		}
+
+		a := &analyzer{
+			isInput: isInput,
+			constant: func(ident *Ident) (float64, bool) {
+				return d.constant(v, ident.Name)
+			},
+			lookup: lookup,
+		}
+
+		var e effect
+		for _, n := range trees {
+			e |= a.effect(n)
+		}
+		polarity := e.polarity()
+		if e == ambiguous {
+			polarity = numericPolarity(a, trees)
+		}
+
+		// a variable with a graphical function applies it to its
+		// equation's result
+		if gf := v.GraphicalFunction; gf != nil && polarity != "" {
+			switch shape(gf) {
+			case rises:
+			case falls:
+				polarity = effectOf(polarity).flip().polarity()
+			default:
+				polarity = ""
+			}
+		}
+
+		d.add(input, v, polarity)
+	}
+
+	// lookups called by name are inputs too, though without a
+	// polarity of their own
+	for _, n := range trees {
+		Walk(n, func(n Node) bool {
+			if call, ok := n.(*Call); ok && lookup(call) != nil {
+				d.add(d.resolve(v, call.Func), v, "")
+			}
+			return true
+		})
+	}
+}
+
+// inputs returns the variables v's equations refer to, in the order they
+// first appear.
+func (d *deriver) inputs(v *sdjson.Variable, trees []Node) []*sdjson.Variable {
+	var inputs []*sdjson.Variable
+	seen := make(map[*sdjson.Variable]bool)
+	for _, n := range trees {
+		for _, ident := range Idents(n) {
+			input := d.resolve(v, ident.Name)
+			if input == nil || seen[input] {
+				continue
+			}
+			seen[input] = true
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// numericPolarity combines the numeric estimates for each of a
+// variable's equations.
+func numericPolarity(a *analyzer, trees []Node) string {
+	var e effect
+	for _, n := range trees {
+		p := a.numericPolarity(n)
+		if p == "" {
+			return ""
+		}
+		e |= effectOf(p)
+	}
+	return e.polarity()
+}
+
+func effectOf(polarity string) effect {
+	switch polarity {
+	case "+":
+		return rises
+	case "-":
+		return falls
+	}
+	return ambiguous
+}
+
+// compare checks the model's declared relationships against the derived
+// ones.
+func (d *deriver) compare() {
+	for i := range d.m.Relationships {
+		declared := &d.m.Relationships[i]
+		from, to := d.m.Variable(declared.From), d.m.Variable(declared.To)
+		// unknown variables and self-relationships are reported by
+		// sdjson.Validate
+		if from == nil || to == nil || from == to || !d.parsed[to] {
+			continue
+		}
+
+		j, ok := d.derived[[2]*sdjson.Variable{from, to}]
+		if !ok {
+			what := "equation for"
+			if to.Type == sdjson.VariableTypeStock {
+				what = "inflows and outflows of"
+			}
+			d.report(sdjson.CodeUnusedRelationship, to.Name, declared,
+				"relationship %s isn't used by the %s %q", declared.Key(), what, to.Name)
+			continue
+		}
+
+		derived := &d.rels[j]
+		if derived.Reasoning == "" {
+			derived.Reasoning = declared.Reasoning
+		}
+		switch {
+		case declared.Polarity == "" || derived.Polarity == "":
+		case declared.Polarity != derived.Polarity:
+			d.report(sdjson.CodePolarityMismatch, to.Name, declared,
+				"relationship %s is declared %q, but the equation for %q makes it %q",
+				declared.Key(), declared.Polarity, to.Name, derived.Polarity)
+		default:
+			derived.PolarityReasoning = declared.PolarityReasoning
+		}
+	}
+}
+
+func (d *deriver) report(code sdjson.Code, variable string, rel *sdjson.Relationship, format string, args ...any) {
+	r := *rel
+	d.diags = append(d.diags, sdjson.Diagnostic{
+		Code:         code,
+		Severity:     sdjson.SeverityWarning,
+		Message:      fmt.Sprintf(format, args...),
+		Variable:     variable,
+		Relationship: &r,
+	})
+}
@@ -0,0 +1,171 @@
+package equation
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestDeriveRelationshipsPolarity(t *testing.T) {
+	tests := []struct {
+		equation string
+		// expected polarity of the relationship from each input to y
+		expected map[string]string
+	}{
+		{"a + b", map[string]string{"a": "+", "b": "+"}},
+		{"a - b", map[string]string{"a": "+", "b": "-"}},
+		{"a * b / c", map[string]string{"a": "+", "b": "+", "c": "-"}},
+		{"-a * b", map[string]string{"a": "-", "b": "-"}},
+		{"a * negative", map[string]string{"a": "-", "negative": "+"}},
+		{"(1 - fraction) * a", map[string]string{"fraction": "-", "a": "+"}},
+		{"(a - b) / c", map[string]string{"a": "+", "b": "-", "c": ""}},
+		{"a^2 / b^0.5", map[string]string{"a": "+", "b": "-"}},
+		{"0.5^a", map[string]string{"a": "-"}},
+		// the symbolic rules can't tell for a, but the numeric estimate
+		// is consistent
+		{"a / (a + b)", map[string]string{"a": "+", "b": "-"}},
+		{"IF a > b THEN c ELSE 0", map[string]string{"a": "+", "b": "-", "c": "+"}},
+		{"IF a > 1 THEN c ELSE 2*c", map[string]string{"a": "-", "c": "+"}},
+		{"(a - fraction) * (a - fraction)", map[string]string{"a": "", "fraction": ""}},
+		{"MIN(a, b) + MAX(c, 0)", map[string]string{"a": "+", "b": "+", "c": "+"}},
+		// the numeric estimate for a evaluates MAX of one argument
+		{"a / (a + MAX(b))", map[string]string{"a": "+", "b": "-"}},
+		{"SMTH1(a, b)", map[string]string{"a": "+", "b": ""}},
+		{"DELAY FIXED(a, b, c)", map[string]string{"a": "+", "b": "", "c": ""}},
+		{"a * STEP(b, c)", map[string]string{"a": "+", "b": "+", "c": ""}},
+		{"a / INIT(a)", map[string]string{"a": "+"}},
+		{"rising(a) * falling(b)", map[string]string{"a": "+", "b": "-", "rising": "", "falling": ""}},
+		{"EXP(-a) + LN(b)", map[string]string{"a": "-", "b": "+"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.equation, func(t *testing.T) {
+			m := &sdjson.Model{Variables: []sdjson.Variable{
+				{Name: "y", Equation: tt.equation},
+				{Name: "a", Type: sdjson.VariableTypeStock, Equation: "0"},
+				{Name: "b", Type: sdjson.VariableTypeStock, Equation: "0"},
+				{Name: "c", Type: sdjson.VariableTypeStock, Equation: "0"},
+				{Name: "fraction", Equation: "0.25"},
+				{Name: "negative", Equation: "-3"},
+				{Name: "rising", GraphicalFunction: &sdjson.GraphicalFunction{Points: []sdjson.Point{{X: 0, Y: 0}, {X: 1, Y: 2}}}},
+				{Name: "falling", GraphicalFunction: &sdjson.GraphicalFunction{Points: []sdjson.Point{{X: 0, Y: 2}, {X: 1, Y: 0}}}},
+			}}
+
+			rels, diags := DeriveRelationships(m)
+			assert.Empty(t, diags)
+
+			actual := make(map[string]string, len(rels))
+			for _, rel := range rels {
+				require.Equal(t, "y", rel.To)
+				actual[rel.From] = rel.Polarity
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestDeriveRelationships(t *testing.T) {
+	m := &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "Population", Type: sdjson.VariableTypeStock, Equation: "initial_population", Inflows: []string{"births"}, Outflows: []string{"deaths"}},
+			{Name: "initial population", Equation: "100"},
+			{Name: "births", Type: sdjson.VariableTypeFlow, Equation: "Population * birth_rate * crowding(Population / capacity)"},
+			{Name: "deaths", Type: sdjson.VariableTypeFlow, Equation: "Population / lifetime"},
+			{Name: "birth rate", Equation: "0.03"},
+			{Name: "lifetime", Equation: "(70"},
+			{Name: "capacity", Equation: "1000"},
+			{Name: "crowding", GraphicalFunction: &sdjson.GraphicalFunction{Points: []sdjson.Point{{X: 0, Y: 1}, {X: 2, Y: 0}}}},
+			{Name: "Health.risk", Equation: "exposure * Population"},
+			{Name: "Health.exposure", Equation: "2"},
+		},
+		Relationships: []sdjson.Relationship{
+			{From: "births", To: "Population", Polarity: "+"},
+			{From: "deaths", To: "Population", Polarity: "-"},
+			{From: "initial population", To: "Population", Polarity: "+"},
+			{From: "Population", To: "births", Polarity: "+", Reasoning: "more people, more babies", PolarityReasoning: "proportional"},
+			{From: "birth rate", To: "births", Polarity: "-", Reasoning: "fertility", PolarityReasoning: "wrong"},
+			{From: "lifetime", To: "deaths", Polarity: "+"},
+			{From: "Population", To: "Health.risk"},
+		},
+	}
+
+	rels, diags := DeriveRelationships(m)
+
+	assert.Equal(t, []sdjson.Relationship{
+		{From: "births", To: "Population", Polarity: "+"},
+		{From: "deaths", To: "Population", Polarity: "-"},
+		{From: "Population", To: "births", Polarity: "+", Reasoning: "more people, more babies", PolarityReasoning: "proportional"},
+		{From: "birth rate", To: "births", Polarity: "+", Reasoning: "fertility"},
+		{From: "capacity", To: "births", Polarity: "+"},
+		{From: "crowding", To: "births"},
+		{From: "Population", To: "deaths", Polarity: "+"},
+		{From: "lifetime", To: "deaths", Polarity: "-"},
+		{From: "Health.exposure", To: "Health.risk", Polarity: "+"},
+		{From: "Population", To: "Health.risk", Polarity: "+"},
+	}, rels)
+
+	assert.Equal(t, []sdjson.Diagnostic{
+		{
+			Code:     sdjson.CodeInvalidEquation,
+			Severity: sdjson.SeverityError,
+			Variable: "lifetime",
+			Message:  `equation for "lifetime" doesn't parse: 1:4: expected ")", found end of equation`,
+		},
+		{
+			Code:         sdjson.CodeUnusedRelationship,
+			Severity:     sdjson.SeverityWarning,
+			Variable:     "Population",
+			Relationship: &sdjson.Relationship{From: "initial population", To: "Population", Polarity: "+"},
+			Message:      `relationship "initial population"->"Population" isn't used by the inflows and outflows of "Population"`,
+		},
+		{
+			Code:         sdjson.CodePolarityMismatch,
+			Severity:     sdjson.SeverityWarning,
+			Variable:     "births",
+			Relationship: &sdjson.Relationship{From: "birth rate", To: "births", Polarity: "-", Reasoning: "fertility", PolarityReasoning: "wrong"},
+			Message:      `relationship "birth rate"->"births" is declared "-", but the equation for "births" makes it "+"`,
+		},
+		{
+			Code:         sdjson.CodePolarityMismatch,
+			Severity:     sdjson.SeverityWarning,
+			Variable:     "deaths",
+			Relationship: &sdjson.Relationship{From: "lifetime", To: "deaths", Polarity: "+"},
+			Message:      `relationship "lifetime"->"deaths" is declared "+", but the equation for "deaths" makes it "-"`,
+		},
+	}, diags)
+}
+
+// TestDeriveRelationshipsFixtures checks that the relationships derived
+// from the evals fixtures agree with the ones they declare.
+func TestDeriveRelationshipsFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *sdjson.Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			_, diags := DeriveRelationships(fixture.Model)
+			for _, d := range diags {
+				assert.Fail(t, "unexpected diagnostic", d.String())
+			}
+		})
+	}
+}
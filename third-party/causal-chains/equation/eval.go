@@ -0,0 +1,256 @@
+package equation
+
+import (
+	"fmt"
+	"math"
+)
+
+// Env supplies the values of the variables an expression refers to, and
+// evaluates the functions Eval doesn't know itself, such as
+// time-dependent builtins and graphical function lookups.
+type Env interface {
+	// Value returns the value of a variable.  subscripts is empty unless
+	// the variable is indexed.
+	Value(ident *Ident, subscripts []Node) (float64, error)
+	// Call evaluates a call to a function Eval doesn't know.
+	Call(call *Call, args []float64) (float64, error)
+}
+
+// EvalError is a problem evaluating an equation that parsed, such as a
+// function called with the wrong number of arguments.
+type EvalError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Eval evaluates an expression.  Eval implements the operators, IF THEN
+// ELSE and the pure math builtins; everything else is delegated to env.
+// Comparisons and logical operators yield 1 for true and 0 for false.
+func Eval(n Node, env Env) (float64, error) {
+	switch n := n.(type) {
+	case *Number:
+		return n.Value, nil
+
+	case *Ident:
+		return env.Value(n, nil)
+
+	case *Index:
+		return env.Value(n.X, n.Subscripts)
+
+	case *Unary:
+		x, err := Eval(n.X, env)
+		if err != nil {
+			return 0, err
+		}
+		switch n.Op {
+		case "-":
+			return -x, nil
+		case "NOT":
+			return truth(x == 0), nil
+		}
+		return x, nil
+
+	case *Binary:
+		return evalBinary(n, env)
+
+	case *If:
+		cond, err := Eval(n.Cond, env)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return Eval(n.Then, env)
+		}
+		return Eval(n.Else, env)
+
+	case *Call:
+		args := make([]float64, len(n.Args))
+		for i, arg := range n.Args {
+			v, err := Eval(arg, env)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = v
+		}
+		if v, ok, err := evalMath(n, args); ok || err != nil {
+			return v, err
+		}
+		return env.Call(n, args)
+	}
+
+	return 0, &EvalError{Pos: n.Pos(), Msg: fmt.Sprintf("can't evaluate %s", n)}
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func evalBinary(n *Binary, env Env) (float64, error) {
+	x, err := Eval(n.X, env)
+	if err != nil {
+		return 0, err
+	}
+
+	// AND and OR short-circuit, so the right-hand side can guard
+	// against errors the way an IF would
+	switch n.Op {
+	case "AND":
+		if x == 0 {
+			return 0, nil
+		}
+	case "OR":
+		if x != 0 {
+			return 1, nil
+		}
+	}
+
+	y, err := Eval(n.Y, env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.Op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		return x / y, nil
+	case "//":
+		if y == 0 {
+			return 0, nil
+		}
+		return x / y, nil
+	case "^":
+		return math.Pow(x, y), nil
+	case "MOD":
+		return modulo(x, y), nil
+	case "=":
+		return truth(x == y), nil
+	case "<>":
+		return truth(x != y), nil
+	case "<":
+		return truth(x < y), nil
+	case "<=":
+		return truth(x <= y), nil
+	case ">":
+		return truth(x > y), nil
+	case ">=":
+		return truth(x >= y), nil
+	case "AND", "OR":
+		return truth(y != 0), nil
+	}
+	return 0, &EvalError{Pos: n.Pos(), Msg: fmt.Sprintf("unknown operator %q", n.Op)}
+}
+
+// modulo follows XMILE and Vensim: the result has the sign of the
+// divisor.
+func modulo(x, y float64) float64 {
+	m := math.Mod(x, y)
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m
+}
+
+var mathFuncs = map[string]func(float64) float64{
+	"ABS":     math.Abs,
+	"EXP":     math.Exp,
+	"LN":      math.Log,
+	"LOG10":   math.Log10,
+	"SQRT":    math.Sqrt,
+	"SIN":     math.Sin,
+	"COS":     math.Cos,
+	"TAN":     math.Tan,
+	"ARCSIN":  math.Asin,
+	"ARCCOS":  math.Acos,
+	"ARCTAN":  math.Atan,
+	"INT":     math.Floor,
+	"INTEGER": math.Trunc,
+}
+
+// evalMath evaluates the pure math builtins.  ok is false if the
+// function isn't one of them.
+func evalMath(call *Call, args []float64) (v float64, ok bool, err error) {
+	name := call.Builtin()
+	arity := func(n int) error {
+		if len(args) != n {
+			return &EvalError{Pos: call.Position, Msg: fmt.Sprintf("%s takes %d arguments, found %d", call.Func, n, len(args))}
+		}
+		return nil
+	}
+
+	if fn, found := mathFuncs[name]; found {
+		if err := arity(1); err != nil {
+			return 0, true, err
+		}
+		return fn(args[0]), true, nil
+	}
+
+	switch name {
+	case "PI":
+		if err := arity(0); err != nil {
+			return 0, true, err
+		}
+		return math.Pi, true, nil
+	case "MIN", "MAX":
+		// a single argument is an array reduction, which only the
+		// environment knows how to do
+		if len(args) < 2 {
+			return 0, false, nil
+		}
+		v = args[0]
+		for _, arg := range args[1:] {
+			if name == "MIN" {
+				v = math.Min(v, arg)
+			} else {
+				v = math.Max(v, arg)
+			}
+		}
+		return v, true, nil
+	case "MODULO":
+		if err := arity(2); err != nil {
+			return 0, true, err
+		}
+		return modulo(args[0], args[1]), true, nil
+	case "SAFEDIV":
+		// SAFEDIV(a, b[, onzero])
+		if len(args) != 2 && len(args) != 3 {
+			return 0, true, &EvalError{Pos: call.Position, Msg: fmt.Sprintf("%s takes 2 or 3 arguments, found %d", call.Func, len(args))}
+		}
+		if args[1] == 0 {
+			if len(args) == 3 {
+				return args[2], true, nil
+			}
+			return 0, true, nil
+		}
+		return args[0] / args[1], true, nil
+	case "ZIDZ":
+		if err := arity(2); err != nil {
+			return 0, true, err
+		}
+		if args[1] == 0 {
+			return 0, true, nil
+		}
+		return args[0] / args[1], true, nil
+	case "XIDZ":
+		if err := arity(3); err != nil {
+			return 0, true, err
+		}
+		if args[1] == 0 {
+			return args[2], true, nil
+		}
+		return args[0] / args[1], true, nil
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,111 @@
+package equation
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapEnv evaluates variables from a map, and TIME as a function.
+type mapEnv map[string]float64
+
+func (env mapEnv) Value(ident *Ident, subscripts []Node) (float64, error) {
+	v, ok := env[ident.Name]
+	if !ok {
+		return 0, errors.New("unknown variable " + ident.Name)
+	}
+	return v, nil
+}
+
+func (env mapEnv) Call(call *Call, args []float64) (float64, error) {
+	if call.Builtin() == "TIME" {
+		return env["time"], nil
+	}
+	return 0, errors.New("unknown function " + call.Func)
+}
+
+func TestEval(t *testing.T) {
+	env := mapEnv{"a": 2, "b": 3, "zero": 0, "time": 5}
+
+	tests := []struct {
+		src      string
+		expected float64
+	}{
+		{"1 + 2*3", 7},
+		{"-2^2", -4},
+		{"2^3^2", 512},
+		{"a/b*b", 2},
+		{"a // zero", 0},
+		{"a // b", 2.0 / 3},
+		{"-7 MOD 3", 2},
+		{"7 MOD -3", -2},
+		{"a < b", 1},
+		{"a >= b", 0},
+		{"a = 2 AND NOT (b = 2)", 1},
+		{"zero OR a", 1},
+		{"IF a > b THEN a ELSE b", 3},
+		{"IF THEN ELSE(zero, 1/zero, 4)", 4},
+		{"MIN(a, b, 1)", 1},
+		{"MAX(a, b)", 3},
+		{"ABS(-a) + INT(2.7) + SQRT(16)", 8},
+		{"EXP(LN(a))", 2},
+		{"ZIDZ(a, zero) + XIDZ(a, zero, 9)", 9},
+		{"SAFEDIV(a, zero, 5)", 5},
+		{"TIME() * 2", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			n, err := Parse(tt.src)
+			require.NoError(t, err)
+			v, err := Eval(n, env)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, v, 1e-12)
+		})
+	}
+
+	t.Run("division by zero", func(t *testing.T) {
+		n, err := Parse("a / zero")
+		require.NoError(t, err)
+		v, err := Eval(n, env)
+		require.NoError(t, err)
+		assert.True(t, math.IsInf(v, 1))
+	})
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := mapEnv{"a": 2}
+
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"a + c", "unknown variable c"},
+		{"SMTH1(a, 3)", "unknown function SMTH1"},
+		{"EXP(a, a)", "1:1: EXP takes 1 arguments, found 2"},
+		{"a + ZIDZ(a)", "1:5: ZIDZ takes 2 arguments, found 1"},
+		// AND short-circuits, so the error on the right isn't reached
+		{"0 AND c OR c", "unknown variable c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			n, err := Parse(tt.src)
+			require.NoError(t, err)
+			_, err = Eval(n, env)
+			assert.EqualError(t, err, tt.expected)
+			var syntaxErr *SyntaxError
+			assert.False(t, errors.As(err, &syntaxErr), "not a syntax error")
+		})
+	}
+
+	n, err := Parse("a + ZIDZ(a)")
+	require.NoError(t, err)
+	_, err = Eval(n, env)
+	var evalErr *EvalError
+	require.True(t, errors.As(err, &evalErr))
+	assert.Equal(t, Pos{Offset: 4, Line: 1, Column: 5}, evalErr.Pos)
+}
@@ -0,0 +1,327 @@
+package equation
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenKeyword // IF, THEN, ELSE, AND, OR, NOT, MOD
+	tokenOp      // operators and punctuation
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written, except for keywords, which are
+	// upper-cased, and quoted names, which are unquoted.
+	text string
+	pos  Pos
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of equation"
+	case tokenIdent:
+		return "name " + t.text
+	}
+	return `"` + t.text + `"`
+}
+
+var keywords = map[string]bool{
+	"IF":   true,
+	"THEN": true,
+	"ELSE": true,
+	"AND":  true,
+	"OR":   true,
+	"NOT":  true,
+	"MOD":  true,
+}
+
+func isKeyword(word string) bool {
+	return keywords[strings.ToUpper(word)]
+}
+
+func isIdentStart(c rune) bool {
+	return unicode.IsLetter(c) || c == '_' || c == '$'
+}
+
+func isIdentChar(c rune) bool {
+	return isIdentStart(c) || unicode.IsDigit(c) || c == '\''
+}
+
+type lexer struct {
+	src    string
+	offset int
+	line   int
+	col    int
+	tokens []token
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Offset: l.offset, Line: l.line, Column: l.col}
+}
+
+func (l *lexer) peek() rune {
+	if l.offset >= len(l.src) {
+		return -1
+	}
+	c, _ := utf8.DecodeRuneInString(l.src[l.offset:])
+	return c
+}
+
+func (l *lexer) peekAt(offset int) rune {
+	if offset >= len(l.src) {
+		return -1
+	}
+	c, _ := utf8.DecodeRuneInString(l.src[offset:])
+	return c
+}
+
+func (l *lexer) next() rune {
+	c, size := utf8.DecodeRuneInString(l.src[l.offset:])
+	l.offset += size
+	if c == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col += size
+	}
+	return c
+}
+
+// skipSpace skips whitespace and {comments}.
+func (l *lexer) skipSpace() *SyntaxError {
+	for {
+		switch c := l.peek(); {
+		case c == '{':
+			start := l.pos()
+			for l.peek() != '}' {
+				if l.peek() < 0 {
+					return &SyntaxError{Pos: start, Msg: "unterminated comment"}
+				}
+				l.next()
+			}
+			l.next()
+		case c >= 0 && unicode.IsSpace(c):
+			l.next()
+		default:
+			return nil
+		}
+	}
+}
+
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1, col: 1}
+
+	for {
+		if err := l.skipSpace(); err != nil {
+			return nil, err
+		}
+		start := l.pos()
+		c := l.peek()
+
+		switch {
+		case c < 0:
+			l.tokens = append(l.tokens, token{kind: tokenEOF, pos: start})
+			return l.tokens, nil
+		case unicode.IsDigit(c) || (c == '.' && unicode.IsDigit(l.peekAt(l.offset+1))):
+			l.number(start)
+		case isIdentStart(c) || c == '"':
+			if err := l.name(start); err != nil {
+				return nil, err
+			}
+		case c == ':':
+			// Vensim spells logical operators :AND:, :OR: and :NOT:
+			if op := l.vensimLogical(); op != "" {
+				l.tokens = append(l.tokens, token{kind: tokenKeyword, text: op, pos: start})
+				continue
+			}
+			l.next()
+			l.tokens = append(l.tokens, token{kind: tokenOp, text: ":", pos: start})
+		default:
+			if err := l.operator(start); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (l *lexer) number(start Pos) {
+	for unicode.IsDigit(l.peek()) {
+		l.next()
+	}
+	if l.peek() == '.' {
+		l.next()
+		for unicode.IsDigit(l.peek()) {
+			l.next()
+		}
+	}
+	if c := l.peek(); c == 'e' || c == 'E' {
+		// only consume the exponent if digits follow, so that a name
+		// starting with "e" isn't swallowed
+		offset := l.offset + 1
+		if c := l.peekAt(offset); c == '+' || c == '-' {
+			offset++
+		}
+		if unicode.IsDigit(l.peekAt(offset)) {
+			for l.offset < offset {
+				l.next()
+			}
+			for unicode.IsDigit(l.peek()) {
+				l.next()
+			}
+		}
+	}
+	l.tokens = append(l.tokens, token{kind: tokenNumber, text: l.src[start.Offset:l.offset], pos: start})
+}
+
+// word scans a run of identifier characters or a quoted name, and
+// returns it without quotes.
+func (l *lexer) word() (string, *SyntaxError) {
+	if l.peek() != '"' {
+		start := l.offset
+		for c := l.peek(); c >= 0 && isIdentChar(c); c = l.peek() {
+			l.next()
+		}
+		return l.src[start:l.offset], nil
+	}
+
+	start := l.pos()
+	l.next()
+	var b strings.Builder
+	for {
+		switch c := l.peek(); c {
+		case -1, '\n':
+			return "", &SyntaxError{Pos: start, Msg: "unterminated quoted name"}
+		case '\\':
+			l.next()
+			if l.peek() >= 0 {
+				b.WriteRune(l.next())
+			}
+		case '"':
+			l.next()
+			return b.String(), nil
+		default:
+			b.WriteRune(l.next())
+		}
+	}
+}
+
+// name scans a variable or function name.  Names can be qualified by
+// module ("Module.variable"), and consecutive words that aren't
+// keywords form a single name, as in Vensim's "birth rate".
+func (l *lexer) name(start Pos) *SyntaxError {
+	quoted := l.peek() == '"'
+	word, err := l.word()
+	if err != nil {
+		return err
+	}
+
+	// a keyword followed by ".name" is a module's name, as in "Mod.x"
+	if !quoted && isKeyword(word) && !l.qualified() {
+		l.tokens = append(l.tokens, token{kind: tokenKeyword, text: strings.ToUpper(word), pos: start})
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(word)
+	for {
+		// module qualification
+		if l.qualified() {
+			l.next()
+			part, err := l.word()
+			if err != nil {
+				return err
+			}
+			b.WriteByte('.')
+			b.WriteString(part)
+			continue
+		}
+
+		// another word of a multi-word name
+		offset := l.offset
+		for offset < len(l.src) && (l.src[offset] == ' ' || l.src[offset] == '\t') {
+			offset++
+		}
+		if offset == l.offset || quoted || !isIdentStart(l.peekAt(offset)) {
+			break
+		}
+		end := offset
+		for c := l.peekAt(end); c >= 0 && isIdentChar(c); c = l.peekAt(end) {
+			end += utf8.RuneLen(c)
+		}
+		if isKeyword(l.src[offset:end]) {
+			break
+		}
+		for l.offset < end {
+			l.next()
+		}
+		b.WriteByte(' ')
+		b.WriteString(l.src[offset:end])
+	}
+
+	l.tokens = append(l.tokens, token{kind: tokenIdent, text: b.String(), pos: start})
+	return nil
+}
+
+// qualified reports whether the next characters qualify a name by
+// module: a '.' and the start of another name.
+func (l *lexer) qualified() bool {
+	if l.peek() != '.' {
+		return false
+	}
+	c := l.peekAt(l.offset + 1)
+	return isIdentStart(c) || c == '"'
+}
+
+func (l *lexer) vensimLogical() string {
+	for _, op := range []string{"AND", "OR", "NOT"} {
+		spelled := ":" + op + ":"
+		if end := l.offset + len(spelled); end <= len(l.src) && strings.EqualFold(l.src[l.offset:end], spelled) {
+			for l.offset < end {
+				l.next()
+			}
+			return op
+		}
+	}
+	return ""
+}
+
+var twoCharOps = []string{"<=", ">=", "<>", "==", "!=", "//"}
+
+func (l *lexer) operator(start Pos) *SyntaxError {
+	for _, op := range twoCharOps {
+		if strings.HasPrefix(l.src[l.offset:], op) {
+			l.next()
+			l.next()
+			// normalize the C-style spellings some tools emit
+			switch op {
+			case "==":
+				op = "="
+			case "!=":
+				op = "<>"
+			}
+			l.tokens = append(l.tokens, token{kind: tokenOp, text: op, pos: start})
+			return nil
+		}
+	}
+
+	c := l.next()
+	switch c {
+	case '+', '-', '*', '/', '^', '(', ')', '[', ']', ',', '<', '>', '=':
+		l.tokens = append(l.tokens, token{kind: tokenOp, text: string(c), pos: start})
+		return nil
+	}
+	return &SyntaxError{Pos: start, Msg: "unexpected character " + quoteRune(c)}
+}
+
+func quoteRune(c rune) string {
+	return "'" + string(c) + "'"
+}
@@ -0,0 +1,285 @@
+package equation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes an equation that couldn't be parsed.
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Excerpt returns the line of src containing the error, with a caret
+// underneath the offending position.
+func (e *SyntaxError) Excerpt(src string) string {
+	lines := strings.Split(src, "\n")
+	if e.Pos.Line < 1 || e.Pos.Line > len(lines) {
+		return ""
+	}
+	line := lines[e.Pos.Line-1]
+	col := min(max(e.Pos.Column-1, 0), len(line))
+	// keep tabs so the caret lines up with the source
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return '\t'
+		}
+		return ' '
+	}, line[:col])
+	return line + "\n" + indent + "^"
+}
+
+// Parse parses an equation into a syntax tree.  Errors are returned as
+// a *SyntaxError.
+func Parse(src string) (Node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, &SyntaxError{Pos: p.peek().pos, Msg: "empty equation"}
+	}
+
+	n, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok, "an operator or end of equation")
+	}
+	return n, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) is(kind tokenKind, text string) bool {
+	tok := p.peek()
+	return tok.kind == kind && tok.text == text
+}
+
+func (p *parser) unexpected(tok token, expected string) *SyntaxError {
+	return &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected %s, found %s", expected, tok.describe())}
+}
+
+func (p *parser) expect(kind tokenKind, text string) (token, error) {
+	if !p.is(kind, text) {
+		return token{}, p.unexpected(p.peek(), `"`+text+`"`)
+	}
+	return p.next(), nil
+}
+
+// binaryOp returns the operator if tok is a binary operator.
+func binaryOp(tok token) (string, bool) {
+	switch tok.kind {
+	case tokenOp, tokenKeyword:
+		if precedence(tok.text) > 0 {
+			return tok.text, true
+		}
+	}
+	return "", false
+}
+
+// expr parses a chain of binary operators that bind at least as
+// tightly as minPrec, by precedence climbing.
+func (p *parser) expr(minPrec int) (Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := binaryOp(p.peek())
+		if !ok || precedence(op) < minPrec {
+			return x, nil
+		}
+		p.next()
+
+		nextPrec := precedence(op) + 1
+		if op == "^" {
+			nextPrec = precedence(op)
+		}
+		y, err := p.expr(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Op: op, X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	tok := p.peek()
+	if (tok.kind == tokenOp && (tok.text == "-" || tok.text == "+")) || (tok.kind == tokenKeyword && tok.text == "NOT") {
+		p.next()
+		x, err := p.expr(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Op: tok.text, X: x, Position: tok.pos}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Node, error) {
+	tok := p.peek()
+
+	switch {
+	case tok.kind == tokenNumber:
+		p.next()
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("invalid number %q", tok.text)}
+		}
+		return &Number{Value: value, Text: tok.text, Position: tok.pos}, nil
+
+	case tok.kind == tokenIdent:
+		p.next()
+		ident := &Ident{Name: tok.text, Position: tok.pos}
+		switch {
+		case p.is(tokenOp, "("):
+			args, err := p.list("(", ")", p.argument)
+			if err != nil {
+				return nil, err
+			}
+			return &Call{Func: tok.text, Args: args, Position: tok.pos}, nil
+		case p.is(tokenOp, "["):
+			subscripts, err := p.list("[", "]", p.subscript)
+			if err != nil {
+				return nil, err
+			}
+			return &Index{X: ident, Subscripts: subscripts}, nil
+		}
+		return ident, nil
+
+	case tok.kind == tokenKeyword && tok.text == "IF":
+		return p.ifThenElse()
+
+	case tok.kind == tokenOp && tok.text == "(":
+		p.next()
+		x, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenOp, ")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+
+	return nil, p.unexpected(tok, "a number, name or \"(\"")
+}
+
+func (p *parser) ifThenElse() (Node, error) {
+	tok := p.next()
+
+	// Vensim's function form: IF THEN ELSE(cond, then, else)
+	if p.is(tokenKeyword, "THEN") {
+		p.next()
+		if _, err := p.expect(tokenKeyword, "ELSE"); err != nil {
+			return nil, err
+		}
+		args, err := p.list("(", ")", p.argument)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 3 {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("IF THEN ELSE takes 3 arguments, found %d", len(args))}
+		}
+		return &If{Cond: args[0], Then: args[1], Else: args[2], Position: tok.pos}, nil
+	}
+
+	cond, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenKeyword, "THEN"); err != nil {
+		return nil, err
+	}
+	then, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenKeyword, "ELSE"); err != nil {
+		return nil, err
+	}
+	els, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	return &If{Cond: cond, Then: then, Else: els, Position: tok.pos}, nil
+}
+
+// list parses a comma-separated list between open and close.
+func (p *parser) list(open, close string, item func() (Node, error)) ([]Node, error) {
+	if _, err := p.expect(tokenOp, open); err != nil {
+		return nil, err
+	}
+	var items []Node
+	if p.is(tokenOp, close) {
+		p.next()
+		return items, nil
+	}
+	for {
+		n, err := item()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+
+		if p.is(tokenOp, ",") {
+			p.next()
+			continue
+		}
+		if !p.is(tokenOp, close) {
+			return nil, p.unexpected(p.peek(), `"," or "`+close+`"`)
+		}
+		p.next()
+		return items, nil
+	}
+}
+
+func (p *parser) argument() (Node, error) {
+	return p.expr(0)
+}
+
+func (p *parser) subscript() (Node, error) {
+	if tok := p.peek(); tok.kind == tokenOp && tok.text == "*" {
+		p.next()
+		return &Wildcard{Position: tok.pos}, nil
+	}
+	lo, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(tokenOp, ":") {
+		return lo, nil
+	}
+	p.next()
+	hi, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	return &Range{Lo: lo, Hi: hi}, nil
+}
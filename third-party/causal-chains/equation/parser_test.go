@@ -0,0 +1,234 @@
+package equation
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"1", "1"},
+		{"1.5e-3", "1.5e-3"},
+		{".5", ".5"},
+		{"a + b * c", "a + b*c"},
+		{"(a + b) * c", "(a + b)*c"},
+		{"a - (b - c)", "a - (b - c)"},
+		{"a - b - c", "a - b - c"},
+		{"a / (b * c)", "a/(b*c)"},
+		{"2 ^ 3 ^ 2", "2^3^2"},
+		{"(2 ^ 3) ^ 2", "(2^3)^2"},
+		{"-2^2", "-2^2"},
+		{"(-2)^2", "(-2)^2"},
+		{"-(a + b)", "-(a + b)"},
+		{"a MOD 3", "a MOD 3"},
+		{"a // b", "a//b"},
+		{"a < b AND NOT c OR d >= 1", "a < b AND NOT c OR d >= 1"},
+		{"a = b <> c", "a = b <> c"},
+		{"a == b", "a = b"},
+		{"IF a > 0 THEN b ELSE c + 1", "IF a > 0 THEN b ELSE c + 1"},
+		{"1 + (IF a THEN b ELSE c)", "1 + (IF a THEN b ELSE c)"},
+		{"-(IF a THEN b ELSE c) + 1", "(-(IF a THEN b ELSE c)) + 1"},
+		{"NOT (IF a THEN b ELSE c) AND d", "(NOT (IF a THEN b ELSE c)) AND d"},
+		{"IF THEN ELSE(a > 0, b, c)", "IF a > 0 THEN b ELSE c"},
+		{"if a then b else c", "IF a THEN b ELSE c"},
+		{"STEP(10, 5) + PULSE(1, 2, 3)", "STEP(10, 5) + PULSE(1, 2, 3)"},
+		{"smth1(x, 3)", "smth1(x, 3)"},
+		{"TIME()", "TIME()"},
+		{"x[a, 1, *]", "x[a, 1, *]"},
+		{"SUM(x[region, *])", "SUM(x[region, *])"},
+		{"x[1:3]", "x[1:3]"},
+		{"Hares.Hare_births * 2", "Hares.Hare_births*2"},
+		{"Mod.x + 1", "Mod.x + 1"},
+		{"And.x * Or.y", "And.x*Or.y"},
+		{"a MOD Mod.b", "a MOD Mod.b"},
+		{"NOT Not.ready", "NOT Not.ready"},
+		{`"Birth rate"*Population`, `Birth rate*Population`},
+		{`"a+b" + 1`, `"a+b" + 1`},
+		{"Case fatality rate * Total exiting", "Case fatality rate*Total exiting"},
+		{"DELAY3 ( Incubation + Influx, Presymptomatic period / Days per week )", "DELAY3(Incubation + Influx, Presymptomatic period/Days per week)"},
+		{"a :AND: b", "a AND b"},
+		{"a {a comment} + b", "a + b"},
+		{"a +\n\tb", "a + b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			n, err := Parse(tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, n.String())
+
+			// the formatted equation should parse to the same tree
+			reparsed, err := Parse(n.String())
+			require.NoError(t, err)
+			assert.Equal(t, withoutPositions(n), withoutPositions(reparsed))
+		})
+	}
+}
+
+// withoutPositions clears the positions and literal text in a tree, so
+// trees parsed from differently formatted equations compare equal.
+func withoutPositions(n Node) Node {
+	Walk(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *Number:
+			n.Position, n.Text = Pos{}, ""
+		case *Ident:
+			n.Position = Pos{}
+		case *Unary:
+			n.Position = Pos{}
+		case *Call:
+			n.Position = Pos{}
+		case *If:
+			n.Position = Pos{}
+		case *Wildcard:
+			n.Position = Pos{}
+		}
+		return true
+	})
+	return n
+}
+
+func TestParseTree(t *testing.T) {
+	n, err := Parse("IF x[a] > 0 THEN -Sub.y ELSE MAX(1, z)")
+	require.NoError(t, err)
+
+	expected := &If{
+		Cond: &Binary{
+			Op: ">",
+			X: &Index{
+				X:          &Ident{Name: "x", Position: Pos{Offset: 3, Line: 1, Column: 4}},
+				Subscripts: []Node{&Ident{Name: "a", Position: Pos{Offset: 5, Line: 1, Column: 6}}},
+			},
+			Y: &Number{Value: 0, Text: "0", Position: Pos{Offset: 10, Line: 1, Column: 11}},
+		},
+		Then: &Unary{
+			Op:       "-",
+			X:        &Ident{Name: "Sub.y", Position: Pos{Offset: 18, Line: 1, Column: 19}},
+			Position: Pos{Offset: 17, Line: 1, Column: 18},
+		},
+		Else: &Call{
+			Func: "MAX",
+			Args: []Node{
+				&Number{Value: 1, Text: "1", Position: Pos{Offset: 33, Line: 1, Column: 34}},
+				&Ident{Name: "z", Position: Pos{Offset: 36, Line: 1, Column: 37}},
+			},
+			Position: Pos{Offset: 29, Line: 1, Column: 30},
+		},
+		Position: Pos{Offset: 0, Line: 1, Column: 1},
+	}
+	assert.Equal(t, expected, n)
+
+	module, local := n.(*If).Then.(*Unary).X.(*Ident).Module()
+	assert.Equal(t, "Sub", module)
+	assert.Equal(t, "y", local)
+
+	// a module can be named like a keyword
+	n, err = Parse("Mod.x + 1")
+	require.NoError(t, err)
+	module, local = n.(*Binary).X.(*Ident).Module()
+	assert.Equal(t, "Mod", module)
+	assert.Equal(t, "x", local)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+		excerpt  string
+	}{
+		{"", "1:1: empty equation", "\n^"},
+		{"a +", `1:4: expected a number, name or "(", found end of equation`, "a +\n   ^"},
+		{"(a + b", `1:7: expected ")", found end of equation`, "(a + b\n      ^"},
+		{"a b c )", `1:7: expected an operator or end of equation, found ")"`, "a b c )\n      ^"},
+		{"MAX(a b, )", `1:10: expected a number, name or "(", found ")"`, "MAX(a b, )\n         ^"},
+		{"IF a THEN b", `1:12: expected "ELSE", found end of equation`, "IF a THEN b\n           ^"},
+		{"x[a", `1:4: expected "," or "]", found end of equation`, "x[a\n   ^"},
+		{"a\n\t+ #", "2:4: unexpected character '#'", "\t+ #\n\t  ^"},
+		{`"unterminated`, "1:1: unterminated quoted name", "\"unterminated\n^"},
+		{"IF THEN ELSE(a, b)", "1:1: IF THEN ELSE takes 3 arguments, found 2", "IF THEN ELSE(a, b)\n^"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			require.Error(t, err)
+			assert.Equal(t, tt.expected, err.Error())
+
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.excerpt, syntaxErr.Excerpt(tt.src))
+		})
+	}
+}
+
+func TestIdents(t *testing.T) {
+	n, err := Parse("a * b[region] + SUM(c[*]) - a / DELAY1(d, e)")
+	require.NoError(t, err)
+
+	var names []string
+	for _, ident := range Idents(n) {
+		names = append(names, ident.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+}
+
+// TestParseFixtures checks that every equation in the evals fixtures
+// parses.
+func TestParseFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *struct {
+				Variables []struct {
+					Name           string `json:"name"`
+					Equation       string `json:"equation"`
+					ArrayEquations []struct {
+						Equation string `json:"equation"`
+					} `json:"arrayEquations"`
+				} `json:"variables"`
+			} `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			for _, v := range fixture.Model.Variables {
+				equations := []string{v.Equation}
+				for _, ae := range v.ArrayEquations {
+					equations = append(equations, ae.Equation)
+				}
+				for _, eqn := range equations {
+					if eqn == "" {
+						continue
+					}
+					_, err := Parse(eqn)
+					var syntaxErr *SyntaxError
+					if errors.As(err, &syntaxErr) && syntaxErr.Msg == "empty equation" {
+						// Stella leaves a {comment} as the equation of
+						// unconnected module inputs
+						continue
+					}
+					assert.NoError(t, err, "%s: %s", v.Name, eqn)
+				}
+			}
+		})
+	}
+}
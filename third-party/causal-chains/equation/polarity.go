@@ -0,0 +1,439 @@
+package equation
+
+import (
+	"math"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// effect is the set of directions an expression can move in when one of
+// its inputs increases.  The zero value means the expression doesn't
+// depend on the input at all.
+type effect uint8
+
+const (
+	rises effect = 1 << iota
+	falls
+
+	ambiguous = rises | falls
+)
+
+func (e effect) flip() effect {
+	return (e&rises)<<1 | (e&falls)>>1
+}
+
+// times is the effect of multiplying an expression with effect e by a
+// value with the given signs.
+func (e effect) times(s signs) effect {
+	switch {
+	case e == 0 || s == 0:
+		return 0
+	case s == positive:
+		return e
+	case s == negative:
+		return e.flip()
+	}
+	return ambiguous
+}
+
+// polarity returns "+" or "-" for a consistent effect, and "" otherwise.
+func (e effect) polarity() string {
+	switch e {
+	case rises:
+		return "+"
+	case falls:
+		return "-"
+	}
+	return ""
+}
+
+// signs is the set of signs an expression's value can take; zero is
+// always possible.
+type signs uint8
+
+const (
+	positive signs = 1 << iota
+	negative
+
+	anySign = positive | negative
+)
+
+func signOf(x float64) signs {
+	switch {
+	case x > 0:
+		return positive
+	case x < 0:
+		return negative
+	}
+	return 0
+}
+
+func (s signs) flip() signs {
+	return (s&positive)<<1 | (s&negative)>>1
+}
+
+func (s signs) times(t signs) signs {
+	var product signs
+	if s&positive != 0 {
+		product |= t
+	}
+	if s&negative != 0 {
+		product |= t.flip()
+	}
+	return product
+}
+
+// pointSigns returns the signs of a graphical function's outputs.
+func pointSigns(gf *sdjson.GraphicalFunction) signs {
+	var s signs
+	for _, p := range gf.Points {
+		s |= signOf(p.Y)
+	}
+	return s
+}
+
+// shape returns the effect of a graphical function's input on its
+// output.
+func shape(gf *sdjson.GraphicalFunction) effect {
+	var e effect
+	for i := 1; i < len(gf.Points); i++ {
+		switch dy := gf.Points[i].Y - gf.Points[i-1].Y; {
+		case dy > 0:
+			e |= rises
+		case dy < 0:
+			e |= falls
+		}
+	}
+	return e
+}
+
+// passThrough are builtins whose output follows their first argument:
+// smooths and delays, at least in equilibrium; array reductions; and
+// STEP, PULSE and RAMP, which are either 0 or scaled by it.  Their other
+// arguments (delay times, start times, initial values) have no
+// consistent polarity.
+var passThrough = map[string]bool{
+	"SMTH1": true, "SMTH3": true, "SMTHN": true,
+	"SMOOTH": true, "SMOOTHI": true, "SMOOTH3": true, "SMOOTH3I": true, "SMOOTHN": true,
+	"DELAY": true, "DELAY1": true, "DELAY1I": true, "DELAY3": true, "DELAY3I": true,
+	"DELAYN": true, "DELAYFIXED": true, "DELAYMATERIAL": true, "DELAYINFORMATION": true,
+	"SUM": true, "MEAN": true, "PREVIOUS": true,
+	"STEP": true, "PULSE": true, "RAMP": true,
+}
+
+// increasing are builtins of one argument that never decrease.
+var increasing = map[string]bool{
+	"EXP": true, "LN": true, "LOG10": true, "SQRT": true, "ARCTAN": true,
+	"INT": true, "INTEGER": true,
+}
+
+// analyzer works out how an expression responds to a single input.
+// Variables whose value isn't a known constant are assumed to be
+// non-negative, as stocks and the quantities derived from them usually
+// are.
+type analyzer struct {
+	// isInput reports whether an identifier refers to the input.
+	isInput func(*Ident) bool
+	// constant returns the value of a variable whose equation is a
+	// number.
+	constant func(*Ident) (float64, bool)
+	// lookup returns the graphical function a call refers to, or nil
+	// if the call isn't a lookup.
+	lookup func(*Call) *sdjson.GraphicalFunction
+}
+
+// signs returns the signs n's value can take.
+func (a *analyzer) signs(n Node) signs {
+	switch n := n.(type) {
+	case *Number:
+		return signOf(n.Value)
+	case *Ident:
+		if value, ok := a.constant(n); ok && !a.isInput(n) {
+			return signOf(value)
+		}
+		return positive
+	case *Index:
+		return positive
+	case *Unary:
+		switch n.Op {
+		case "-":
+			return a.signs(n.X).flip()
+		case "NOT":
+			return positive
+		}
+		return a.signs(n.X)
+	case *Binary:
+		x, y := a.signs(n.X), a.signs(n.Y)
+		switch n.Op {
+		case "+":
+			return x | y
+		case "-":
+			return x | y.flip()
+		case "*", "/", "//":
+			return x.times(y)
+		case "^":
+			if x == positive || x == 0 {
+				return x
+			}
+			return anySign
+		case "MOD":
+			return y
+		}
+		// comparisons and logical operators are 0 or 1
+		return positive
+	case *If:
+		return a.signs(n.Then) | a.signs(n.Else)
+	case *Call:
+		if gf := a.lookup(n); gf != nil {
+			return pointSigns(gf)
+		}
+		name := n.Builtin()
+		switch {
+		case name == "EXP", name == "SQRT", name == "ABS":
+			return positive
+		case name == "MIN" || name == "MAX" || name == "INIT" || passThrough[name]:
+			var s signs
+			for i, arg := range n.Args {
+				if i > 0 && passThrough[name] {
+					break
+				}
+				s |= a.signs(arg)
+			}
+			return s
+		}
+	}
+	return anySign
+}
+
+// effect returns how n moves when the input increases.
+func (a *analyzer) effect(n Node) effect {
+	switch n := n.(type) {
+	case *Number, *Wildcard:
+		return 0
+
+	case *Ident:
+		if a.isInput(n) {
+			return rises
+		}
+		return 0
+
+	case *Index:
+		if a.isInput(n.X) {
+			return rises
+		}
+		return 0
+
+	case *Unary:
+		switch n.Op {
+		case "-":
+			return a.effect(n.X).flip()
+		case "NOT":
+			return a.dependsOn(n.X)
+		}
+		return a.effect(n.X)
+
+	case *Binary:
+		return a.binaryEffect(n)
+
+	case *If:
+		if a.effect(n.Cond) != 0 {
+			return ambiguous
+		}
+		return a.effect(n.Then) | a.effect(n.Else)
+
+	case *Call:
+		return a.callEffect(n)
+	}
+
+	return a.dependsOn(n)
+}
+
+// dependsOn returns ambiguous if n depends on the input at all, for
+// expressions whose response has no consistent direction.
+func (a *analyzer) dependsOn(n Node) effect {
+	for _, ident := range Idents(n) {
+		if a.isInput(ident) {
+			return ambiguous
+		}
+	}
+	return 0
+}
+
+func (a *analyzer) binaryEffect(n *Binary) effect {
+	x, y := a.effect(n.X), a.effect(n.Y)
+
+	switch n.Op {
+	case "+":
+		return x | y
+	case "-":
+		return x | y.flip()
+	case "*":
+		// d(uv) = u'v + uv'
+		return x.times(a.signs(n.Y)) | y.times(a.signs(n.X))
+	case "/", "//":
+		// d(u/v) = u'/v - uv'/v^2
+		return x.times(a.signs(n.Y)) | y.flip().times(a.signs(n.X))
+	case "^":
+		var e effect
+		// d(u^c) = c u^(c-1) u', for u >= 0
+		if x != 0 {
+			if base := a.signs(n.X); base == positive || base == 0 {
+				e |= x.times(a.signs(n.Y))
+			} else {
+				e |= ambiguous
+			}
+		}
+		// d(b^v) = b^v ln(b) v', for a constant b > 0
+		if y != 0 {
+			base, ok := n.X.(*Number)
+			switch {
+			case !ok || base.Value <= 0:
+				e |= ambiguous
+			case base.Value > 1:
+				e |= y
+			case base.Value < 1:
+				e |= y.flip()
+			}
+		}
+		return e
+	}
+
+	// MOD, comparisons and logical operators
+	if x|y != 0 {
+		return ambiguous
+	}
+	return 0
+}
+
+func (a *analyzer) callEffect(n *Call) effect {
+	if gf := a.lookup(n); gf != nil {
+		if len(n.Args) != 1 {
+			return a.dependsOn(n)
+		}
+		x := a.effect(n.Args[0])
+		if x == 0 {
+			return 0
+		}
+		switch s := shape(gf); s {
+		case rises:
+			return x
+		case falls:
+			return x.flip()
+		case 0:
+			return 0
+		}
+		return ambiguous
+	}
+
+	name := n.Builtin()
+	switch {
+	case name == "MIN" || name == "MAX":
+		var e effect
+		for _, arg := range n.Args {
+			e |= a.effect(arg)
+		}
+		return e
+
+	case name == "ABS" && len(n.Args) == 1:
+		return a.effect(n.Args[0]).times(a.signs(n.Args[0]))
+
+	case increasing[name] && len(n.Args) == 1:
+		return a.effect(n.Args[0])
+
+	case name == "INIT":
+		// fixed at its initial value, so it doesn't respond to changes
+		return 0
+
+	case passThrough[name] && len(n.Args) > 0:
+		e := a.effect(n.Args[0])
+		for _, arg := range n.Args[1:] {
+			e |= a.dependsOn(arg)
+		}
+		return e
+	}
+
+	return a.dependsOn(n)
+}
+
+// numericPolarity estimates the sign of the partial derivative of n with
+// respect to the input by central differences, at a handful of points
+// where every variable that isn't a known constant is positive.  It
+// returns "" unless the sign is the same, and non-zero, at every point.
+func (a *analyzer) numericPolarity(n Node) string {
+	// fixed sample points keep the result deterministic
+	samples := [][2]float64{
+		// {input, every other variable}
+		{1, 1}, {0.5, 2}, {2, 0.5}, {10, 3}, {0.1, 10}, {5, 5}, {100, 7},
+	}
+
+	var e effect
+	for _, sample := range samples {
+		env := &sampleEnv{a: a, others: sample[1]}
+		h := 1e-4 * sample[0]
+
+		env.input = sample[0] + h
+		hi, err := Eval(n, env)
+		if err != nil {
+			return ""
+		}
+		env.input = sample[0] - h
+		lo, err := Eval(n, env)
+		if err != nil {
+			return ""
+		}
+
+		d := hi - lo
+		if math.IsNaN(d) || math.IsInf(d, 0) {
+			return ""
+		}
+		// ignore differences that are just rounding error
+		if math.Abs(d) <= 1e-9*math.Max(math.Abs(hi), math.Abs(lo)) {
+			continue
+		}
+		if d > 0 {
+			e |= rises
+		} else {
+			e |= falls
+		}
+	}
+	return e.polarity()
+}
+
+// sampleEnv evaluates an expression with the input at one value and
+// every other variable at another, unless it's a known constant.
+// Smooths and delays are evaluated in equilibrium, where they equal
+// their input, STEP, PULSE and RAMP as if they were active, INIT as a
+// constant, and MIN and MAX of a single value as that value.
+type sampleEnv struct {
+	a      *analyzer
+	input  float64
+	others float64
+}
+
+func (e *sampleEnv) Value(ident *Ident, subscripts []Node) (float64, error) {
+	if e.a.isInput(ident) {
+		return e.input, nil
+	}
+	if value, ok := e.a.constant(ident); ok {
+		return value, nil
+	}
+	return e.others, nil
+}
+
+func (e *sampleEnv) Call(call *Call, args []float64) (float64, error) {
+	if gf := e.a.lookup(call); gf != nil && len(args) == 1 {
+		return gf.Lookup(args[0]), nil
+	}
+	name := call.Builtin()
+	switch {
+	case name == "INIT":
+		return e.others, nil
+	case passThrough[name] && len(args) > 0:
+		return args[0], nil
+	case (name == "MIN" || name == "MAX") && len(args) == 1:
+		// Eval leaves the one-argument form, an array reduction, to the
+		// environment; without arrays, it's the argument itself
+		return args[0], nil
+	}
+	return 0, &EvalError{Pos: call.Position, Msg: "unknown function " + call.Func}
+}
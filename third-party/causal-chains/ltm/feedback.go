@@ -0,0 +1,44 @@
+package ltm
+
+import (
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+)
+
+// Feedback is an analysis in the form the ltm-narrative engine takes as
+// its feedbackContent.
+type Feedback struct {
+	FeedbackLoops         []FeedbackLoop `json:"feedbackLoops"`
+	DominantLoopsByPeriod []Period       `json:"dominantLoopsByPeriod"`
+}
+
+// FeedbackLoop is a loop with its relative score over time, in percent.
+type FeedbackLoop struct {
+	causal.Loop
+	Percent []Point `json:"Percent of Model Behavior Explained By Loop"`
+}
+
+// Point is a value at a time.
+type Point struct {
+	Time  float64 `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// Feedback returns the loops and dominant periods of the analysis for
+// the ltm-narrative engine.
+func (a *Analysis) Feedback() Feedback {
+	f := Feedback{
+		FeedbackLoops:         make([]FeedbackLoop, 0, len(a.Loops)),
+		DominantLoopsByPeriod: a.DominantLoopsByPeriod,
+	}
+	if f.DominantLoopsByPeriod == nil {
+		f.DominantLoopsByPeriod = []Period{}
+	}
+	for _, loop := range a.Loops {
+		percent := make([]Point, len(a.Time))
+		for j, time := range a.Time {
+			percent[j] = Point{Time: time, Value: 100 * loop.Relative[j]}
+		}
+		f.FeedbackLoops = append(f.FeedbackLoops, FeedbackLoop{Loop: loop.Loop, Percent: percent})
+	}
+	return f
+}
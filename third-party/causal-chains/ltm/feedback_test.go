@@ -0,0 +1,59 @@
+package ltm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+)
+
+func TestFeedback(t *testing.T) {
+	a := &Analysis{
+		Time: []float64{1, 2},
+		Loops: []LoopScore{{
+			Loop: causal.Loop{
+				Identifier: "R1",
+				Name:       "a -> b -> a",
+				Links:      []causal.Link{{From: "a", To: "b", Polarity: "+"}, {From: "b", To: "a", Polarity: "+"}},
+				Polarity:   "+",
+			},
+			Scores:   []float64{2, 0},
+			Relative: []float64{0.75, 0},
+		}},
+		DominantLoopsByPeriod: []Period{{DominantLoops: []string{"R1"}, StartTime: 1, EndTime: 2}},
+	}
+
+	data, err := json.Marshal(a.Feedback())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "feedbackLoops": [{
+    "identifier": "R1",
+    "name": "a -> b -> a",
+    "links": [{"from": "a", "to": "b", "polarity": "+"}, {"from": "b", "to": "a", "polarity": "+"}],
+    "polarity": "+",
+    "Percent of Model Behavior Explained By Loop": [{"time": 1, "value": 75}, {"time": 2, "value": 0}]
+  }],
+  "dominantLoopsByPeriod": [{"dominantLoops": ["R1"], "startTime": 1, "endTime": 2}]
+}`, string(data))
+
+	data, err = json.Marshal((&Analysis{}).Feedback())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"feedbackLoops": [], "dominantLoopsByPeriod": []}`, string(data))
+}
+
+func TestFeedbackLogistic(t *testing.T) {
+	a, err := Analyze(context.Background(), logistic(), causal.DefaultLoopOptions)
+	require.NoError(t, err)
+
+	f := a.Feedback()
+	require.Len(t, f.FeedbackLoops, 2)
+	first := f.FeedbackLoops[0].Percent[0]
+	assert.Equal(t, 1.0, first.Time)
+	// births outweigh deaths fifty to one at first
+	assert.InDelta(t, 100*0.1/(0.1+0.0002*10), first.Value, 1)
+	assert.Equal(t, a.DominantLoopsByPeriod, f.DominantLoopsByPeriod)
+}
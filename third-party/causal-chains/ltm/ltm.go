@@ -0,0 +1,254 @@
+// Package ltm works out which feedback loops drive a model's behavior,
+// using the Loops That Matter method (Schoenberg, Davidsen and Eberlein,
+// "Understanding model behavior using the Loops that Matter method",
+// System Dynamics Review, 2020).
+//
+// A link's score is the share of its target's change that comes from
+// its source, signed by the direction of the effect.  A loop's score is
+// the product of its links' scores, and its relative score is its share
+// of the sum of the magnitudes of every loop's score.  The loops that
+// dominate are the fewest loops of the same polarity whose relative
+// scores add up to at least half.
+package ltm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/simulate"
+)
+
+// Analysis holds the scores of a model's links and loops over time.
+// Every series has a value for each entry in Time, which follows the
+// model's SaveStep, leaving out the start time, when nothing has changed
+// yet.  Scores are computed every DT, and reported at each save step.
+type Analysis struct {
+	Time  []float64
+	Links []LinkScore
+	Loops []LoopScore
+	// DominantLoopsByPeriod is when each set of loops dominated the
+	// model's behavior, by their identifiers.
+	DominantLoopsByPeriod []Period
+	// DominantLoopsByVariable is the same for each variable in a loop,
+	// considering only the loops through it.
+	DominantLoopsByVariable map[string][]Period
+}
+
+// LinkScore is the score of a link over time.
+type LinkScore struct {
+	causal.Link
+	Scores []float64
+}
+
+// LoopScore is the score of a feedback loop over time.
+type LoopScore struct {
+	causal.Loop
+	Scores []float64
+	// Relative is the loop's score as a fraction of the sum of the
+	// magnitudes of every loop's score, between -1 and 1.  It's 0 when
+	// no loop is active.
+	Relative []float64
+}
+
+// Period is a span of time in which the same loops dominate.
+type Period struct {
+	DominantLoops []string `json:"dominantLoops"`
+	StartTime     float64  `json:"startTime"`
+	EndTime       float64  `json:"endTime"`
+}
+
+// Analyze simulates a model and scores its links and loops.  The loops
+// come from the relationships the model's equations imply, found within
+// the limits opts sets.
+func Analyze(ctx context.Context, m *sdjson.Model, opts causal.LoopOptions) (*Analysis, error) {
+	results, partials, err := simulate.RunPartials(m)
+	if err != nil {
+		return nil, fmt.Errorf("simulate.RunPartials: %w", err)
+	}
+
+	relationships, _ := equation.DeriveRelationships(m)
+	loops, err := causal.NewMap(relationships).LoopsContext(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("LoopsContext: %w", err)
+	}
+
+	s := newScorer(m, results, partials)
+
+	// report at each save step, as Run would
+	dt, saveStep := m.Specs.DT, m.Specs.SaveStep
+	if dt <= 0 {
+		dt = 1
+	}
+	if saveStep <= 0 {
+		saveStep = dt
+	}
+	saveEvery := max(1, int(math.Round(saveStep/dt)))
+	var steps []int
+	for i := 1; i < len(results.Time); i++ {
+		if i%saveEvery == 0 || i == len(results.Time)-1 {
+			steps = append(steps, i)
+		}
+	}
+
+	a := &Analysis{Time: make([]float64, len(steps))}
+	for j, i := range steps {
+		a.Time[j] = results.Time[i]
+	}
+
+	linkScores := make(map[[2]string][]float64)
+	for _, r := range relationships {
+		key := [2]string{causal.Canonicalize(r.From), causal.Canonicalize(r.To)}
+		if _, ok := linkScores[key]; ok {
+			continue
+		}
+		scores := make([]float64, len(steps))
+		for j, i := range steps {
+			scores[j] = s.linkScore(key[0], key[1], i)
+		}
+		linkScores[key] = scores
+		a.Links = append(a.Links, LinkScore{
+			Link:   causal.Link{From: r.From, To: r.To, Polarity: linkPolarity(r.Polarity)},
+			Scores: scores,
+		})
+	}
+
+	for _, loop := range loops {
+		scores := make([]float64, len(steps))
+		for j := range scores {
+			scores[j] = 1
+		}
+		for _, link := range loop.Links {
+			linkScore := linkScores[[2]string{causal.Canonicalize(link.From), causal.Canonicalize(link.To)}]
+			for j := range scores {
+				scores[j] *= linkScore[j]
+			}
+		}
+		for j, score := range scores {
+			if math.IsNaN(score) || math.IsInf(score, 0) {
+				// a score too large to represent; leave the loop out
+				scores[j] = 0
+			}
+		}
+		a.Loops = append(a.Loops, LoopScore{Loop: loop, Scores: scores})
+	}
+
+	all := make([]int, len(a.Loops))
+	for k := range all {
+		all[k] = k
+	}
+	relative := a.relative(all)
+	for k := range a.Loops {
+		a.Loops[k].Relative = relative[k]
+	}
+	a.DominantLoopsByPeriod = a.periods(all, relative)
+
+	a.DominantLoopsByVariable = make(map[string][]Period)
+	through := make(map[string][]int)
+	var order []string
+	for k, loop := range a.Loops {
+		for _, name := range loop.Variables() {
+			if _, ok := through[name]; !ok {
+				order = append(order, name)
+			}
+			through[name] = append(through[name], k)
+		}
+	}
+	for _, name := range order {
+		a.DominantLoopsByVariable[name] = a.periods(through[name], a.relative(through[name]))
+	}
+
+	return a, nil
+}
+
+func linkPolarity(polarity string) string {
+	if polarity != "+" && polarity != "-" {
+		return "?"
+	}
+	return polarity
+}
+
+// relative returns the relative scores of some of the loops, as a share
+// of those loops' scores, in the same order.
+func (a *Analysis) relative(loops []int) [][]float64 {
+	relative := make([][]float64, len(loops))
+	for k := range relative {
+		relative[k] = make([]float64, len(a.Time))
+	}
+	for j := range a.Time {
+		var total float64
+		for _, k := range loops {
+			total += math.Abs(a.Loops[k].Scores[j])
+		}
+		if total == 0 {
+			continue
+		}
+		for n, k := range loops {
+			relative[n][j] = a.Loops[k].Scores[j] / total
+		}
+	}
+	return relative
+}
+
+// dominant returns the fewest of some loops, of the same polarity, whose
+// relative scores at a time add up to at least half, strongest first.
+// It returns nil if no such loops exist.
+func (a *Analysis) dominant(loops []int, relative [][]float64, j int) []string {
+	var positive, negative []int
+	var positiveTotal, negativeTotal float64
+	for n := range loops {
+		switch r := relative[n][j]; {
+		case r > 0:
+			positive = append(positive, n)
+			positiveTotal += r
+		case r < 0:
+			negative = append(negative, n)
+			negativeTotal -= r
+		}
+	}
+	side := positive
+	if negativeTotal > positiveTotal {
+		side = negative
+	}
+	slices.SortStableFunc(side, func(x, y int) int {
+		return cmp.Compare(math.Abs(relative[y][j]), math.Abs(relative[x][j]))
+	})
+
+	var identifiers []string
+	var sum float64
+	for _, n := range side {
+		identifiers = append(identifiers, a.Loops[loops[n]].Identifier)
+		sum += math.Abs(relative[n][j])
+		// allow for rounding, as when two loops balance exactly
+		if sum >= 0.5-1e-9 {
+			return identifiers
+		}
+	}
+	return nil
+}
+
+// periods groups consecutive times with the same dominant loops.  Each
+// period ends when the next starts, or at the last time.  Times when no
+// loops dominate aren't in any period.
+func (a *Analysis) periods(loops []int, relative [][]float64) []Period {
+	periods := []Period{}
+	var current string
+	for j, time := range a.Time {
+		dominant := a.dominant(loops, relative, j)
+		key := strings.Join(dominant, ",")
+		if len(periods) > 0 && current != "" {
+			periods[len(periods)-1].EndTime = time
+		}
+		if key != current && dominant != nil {
+			periods = append(periods, Period{DominantLoops: dominant, StartTime: time, EndTime: time})
+		}
+		current = key
+	}
+	return periods
+}
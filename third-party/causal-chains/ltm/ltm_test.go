@@ -0,0 +1,167 @@
+package ltm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// logistic grows exponentially until crowding takes over, at a
+// population of 500 around time 46.
+func logistic() *sdjson.Model {
+	return &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "Population", Type: sdjson.VariableTypeStock, Equation: "10", Inflows: []string{"births"}, Outflows: []string{"deaths"}},
+			{Name: "births", Type: sdjson.VariableTypeFlow, Equation: "Population * 0.1"},
+			{Name: "deaths", Type: sdjson.VariableTypeFlow, Equation: "Population * Population * 0.0001"},
+		},
+		Specs: sdjson.Specs{StartTime: 0, StopTime: 100, DT: 0.25, SaveStep: 1},
+	}
+}
+
+func TestAnalyzeLogistic(t *testing.T) {
+	a, err := Analyze(context.Background(), logistic(), causal.DefaultLoopOptions)
+	require.NoError(t, err)
+
+	require.Len(t, a.Time, 100)
+	assert.Equal(t, 1.0, a.Time[0])
+	assert.Equal(t, 100.0, a.Time[99])
+
+	require.Len(t, a.Loops, 2)
+	assert.Equal(t, "R1", a.Loops[0].Identifier)
+	assert.Equal(t, "births -> Population -> births", a.Loops[0].Name)
+	assert.Equal(t, "B1", a.Loops[1].Identifier)
+
+	for _, link := range a.Links {
+		for j, score := range link.Scores {
+			switch link.From + " -> " + link.To {
+			case "Population -> births", "Population -> deaths":
+				assert.InDelta(t, 1, score, 1e-9, "%s -> %s at %g", link.From, link.To, a.Time[j])
+			case "births -> Population":
+				assert.Positive(t, score)
+			case "deaths -> Population":
+				assert.Negative(t, score)
+			}
+		}
+	}
+
+	for j := range a.Time {
+		assert.InDelta(t, 1, math.Abs(a.Loops[0].Relative[j])+math.Abs(a.Loops[1].Relative[j]), 1e-9)
+		assert.Positive(t, a.Loops[0].Scores[j])
+		assert.Negative(t, a.Loops[1].Scores[j])
+	}
+
+	periods := a.DominantLoopsByPeriod
+	require.Len(t, periods, 2)
+	assert.Equal(t, []string{"R1"}, periods[0].DominantLoops)
+	assert.Equal(t, 1.0, periods[0].StartTime)
+	assert.Equal(t, []string{"B1"}, periods[1].DominantLoops)
+	assert.InDelta(t, 46, periods[1].StartTime, 1)
+	assert.Equal(t, periods[1].StartTime, periods[0].EndTime)
+	assert.Equal(t, 100.0, periods[1].EndTime)
+
+	// a variable in only one loop is always dominated by it
+	assert.Equal(t, map[string][]Period{
+		"births":     {{DominantLoops: []string{"R1"}, StartTime: 1, EndTime: 100}},
+		"Population": periods,
+		"deaths":     {{DominantLoops: []string{"B1"}, StartTime: 1, EndTime: 100}},
+	}, a.DominantLoopsByVariable)
+}
+
+func TestAnalyzeByVariable(t *testing.T) {
+	m := logistic()
+	m.Variables = append(m.Variables,
+		sdjson.Variable{Name: "Inventory", Type: sdjson.VariableTypeStock, Equation: "100", Outflows: []string{"sales"}},
+		sdjson.Variable{Name: "sales", Type: sdjson.VariableTypeFlow, Equation: "Inventory / 5"},
+	)
+	a, err := Analyze(context.Background(), m, causal.DefaultLoopOptions)
+	require.NoError(t, err)
+	require.Len(t, a.Loops, 3)
+	assert.Equal(t, "B2", a.Loops[2].Identifier)
+
+	// the inventory's loop is always as strong as the population's
+	// loops put together
+	for _, score := range a.Loops[2].Scores {
+		assert.InDelta(t, -1, score, 1e-9)
+	}
+
+	alone, err := Analyze(context.Background(), logistic(), causal.DefaultLoopOptions)
+	require.NoError(t, err)
+	assert.Equal(t, alone.DominantLoopsByPeriod, a.DominantLoopsByVariable["Population"])
+	assert.Equal(t, []Period{{DominantLoops: []string{"B2"}, StartTime: 1, EndTime: 100}}, a.DominantLoopsByVariable["Inventory"])
+}
+
+func TestAnalyzeNoLoops(t *testing.T) {
+	m := &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "stock", Type: sdjson.VariableTypeStock, Equation: "0", Inflows: []string{"inflow"}},
+			{Name: "inflow", Type: sdjson.VariableTypeFlow, Equation: "TIME"},
+		},
+		Specs: sdjson.Specs{StartTime: 0, StopTime: 5},
+	}
+	a, err := Analyze(context.Background(), m, causal.DefaultLoopOptions)
+	require.NoError(t, err)
+	assert.Empty(t, a.Loops)
+	assert.Empty(t, a.DominantLoopsByPeriod)
+	require.Len(t, a.Links, 1)
+	assert.Equal(t, []float64{1, 1, 1, 1, 1}, a.Links[0].Scores)
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	_, err := Analyze(context.Background(), &sdjson.Model{
+		Variables: []sdjson.Variable{{Name: "x", Type: sdjson.VariableTypeAux, Equation: "y"}},
+	}, causal.DefaultLoopOptions)
+	assert.ErrorContains(t, err, "unknown variable")
+
+	_, err = Analyze(context.Background(), logistic(), causal.LoopOptions{MaxLoops: 1})
+	assert.ErrorIs(t, err, causal.ErrTooManyLoops)
+}
+
+func TestAnalyzeFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *sdjson.Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			a, err := Analyze(context.Background(), fixture.Model, causal.DefaultLoopOptions)
+			if errors.Is(err, causal.ErrTooManyLoops) {
+				t.Skip(err)
+			}
+			require.NoError(t, err)
+
+			for j := range a.Time {
+				var total float64
+				for _, loop := range a.Loops {
+					assert.False(t, math.IsNaN(loop.Scores[j]), loop.Name)
+					total += math.Abs(loop.Relative[j])
+				}
+				if total != 0 {
+					assert.InDelta(t, 1, total, 1e-9)
+				}
+			}
+		})
+	}
+}
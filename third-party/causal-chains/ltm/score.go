@@ -0,0 +1,123 @@
+package ltm
+
+import (
+	"math"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/simulate"
+)
+
+// scorer computes link scores from a simulation saved every DT.
+type scorer struct {
+	values   map[string][]float64
+	partials map[[2]string][]float64
+	// flows maps each flow-to-stock link to +1 for an inflow or -1 for
+	// an outflow, and stocks lists each stock's inflows and outflows
+	flows  map[[2]string]float64
+	stocks map[string][]flow
+}
+
+type flow struct {
+	name string
+	sign float64
+}
+
+func newScorer(m *sdjson.Model, results *simulate.Results, partials simulate.Partials) *scorer {
+	s := &scorer{
+		values:   make(map[string][]float64, len(results.Values)),
+		partials: make(map[[2]string][]float64, len(partials)),
+		flows:    make(map[[2]string]float64),
+		stocks:   make(map[string][]flow),
+	}
+	for name, series := range results.Values {
+		s.values[causal.Canonicalize(name)] = series
+	}
+	for link, series := range partials {
+		s.partials[[2]string{causal.Canonicalize(link.From), causal.Canonicalize(link.To)}] = series
+	}
+	for _, v := range m.Variables {
+		if v.Type != sdjson.VariableTypeStock {
+			continue
+		}
+		stock := causal.Canonicalize(v.Name)
+		add := func(names []string, sign float64) {
+			for _, name := range names {
+				if f := m.Resolve(v.Name, name); f != nil {
+					name := causal.Canonicalize(f.Name)
+					s.flows[[2]string{name, stock}] = sign
+					s.stocks[stock] = append(s.stocks[stock], flow{name, sign})
+				}
+			}
+		}
+		add(v.Inflows, 1)
+		add(v.Outflows, -1)
+	}
+	return s
+}
+
+// change returns how much a variable changed in the DT before step i.
+func (s *scorer) change(name string, i int) float64 {
+	series := s.values[name]
+	if i < 1 || i >= len(series) {
+		return 0
+	}
+	return series[i] - series[i-1]
+}
+
+// linkScore returns the score of a link, between variables with
+// canonicalized names, in the DT before step i.
+//
+// For a link into an aux or flow z from x, it's |Δxz / Δz| signed as
+// Δxz / Δx, where Δxz is the change in z due to x alone.  For a flow
+// into or out of a stock, it's |Δflow / Δnet flow|, positive for an
+// inflow and negative for an outflow.  Either is 0 if nothing changed,
+// or if the model's values aren't finite.
+func (s *scorer) linkScore(from, to string, i int) float64 {
+	score := s.rawScore(from, to, i)
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0
+	}
+	return score
+}
+
+func (s *scorer) rawScore(from, to string, i int) float64 {
+	if sign, ok := s.flows[[2]string{from, to}]; ok {
+		var net float64
+		for _, f := range s.stocks[to] {
+			net += f.sign * s.change(f.name, i)
+		}
+		df := s.change(from, i)
+		if negligible(net, s.values[to], i) || df == 0 {
+			return 0
+		}
+		return sign * math.Abs(df/net)
+	}
+
+	partial, ok := s.partials[[2]string{from, to}]
+	if !ok || i >= len(partial) {
+		return 0
+	}
+	dz := s.change(to, i)
+	dx := s.change(from, i)
+	if negligible(dz, s.values[to], i) || dx == 0 || partial[i] == 0 {
+		return 0
+	}
+	score := math.Abs(partial[i] / dz)
+	if partial[i]/dx < 0 {
+		score = -score
+	}
+	return score
+}
+
+// negligible reports whether a change is too small, next to the values
+// of the series around step i, to be told from rounding error.
+func negligible(change float64, series []float64, i int) bool {
+	scale := 1.0
+	for _, j := range []int{i - 1, i} {
+		if j >= 0 && j < len(series) {
+			scale = max(scale, math.Abs(series[j]))
+		}
+	}
+	return math.Abs(change) <= 1e-12*scale
+}
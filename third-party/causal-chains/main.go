@@ -9,6 +9,8 @@ import (
 	"path"
 	"strings"
 
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/archetype"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/boundary"
 	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
 	"github.com/UB-IAD/sd-ai/third-party/causal-chains/llm/provider"
 	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
@@ -25,14 +27,30 @@ type parameters struct {
 }
 
 type input struct {
-	Prompt       string         `json:"prompt"`
-	CurrentModel map[string]any `json:"currentModel"`
-	Parameters   parameters     `json:"parameters"`
+	Prompt string `json:"prompt"`
+	// CurrentModel is decoded on its own, so that a model we can't read
+	// doesn't fail the whole request.
+	CurrentModel json.RawMessage `json:"currentModel"`
+	Parameters   parameters      `json:"parameters"`
 }
 
 type supportingInfo struct {
-	Title       string `json:"title"`
-	Explanation string `json:"explanation"`
+	Title         string        `json:"title"`
+	Explanation   string        `json:"explanation"`
+	FeedbackLoops []causal.Loop `json:"feedbackLoops"`
+	// VariableMerges are the variables merged because they named the
+	// same thing.
+	VariableMerges []causal.Merge `json:"variableMerges"`
+	// Diagnostics are problems with the diagram, such as chains that
+	// disagree about a relationship's polarity.
+	Diagnostics []sdjson.Diagnostic `json:"diagnostics"`
+	// Provenance is each relationship the diagram asserts, with every
+	// chain that asserted it.
+	Provenance []causal.Provenance `json:"provenance"`
+	// Boundary is the model boundary chart of the model we return.
+	Boundary *boundary.Chart `json:"boundary"`
+	// Archetypes are the system archetypes in the model we return.
+	Archetypes []archetype.Match `json:"archetypes"`
 }
 
 type output struct {
@@ -51,10 +69,58 @@ func selectAPIKey(model string, params parameters) string {
 	}
 }
 
+// readModel reads a model from a file that holds either a model or, as
+// our output and the evals' fixtures do, an object with one under
+// "model".
+func readModel(path string) (*sdjson.Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile(%q): %w", path, err)
+	}
+	var wrapped struct {
+		Model *sdjson.Model `json:"model"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(%q): %w", path, err)
+	}
+	if wrapped.Model != nil {
+		return wrapped.Model, nil
+	}
+	m := new(sdjson.Model)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(%q): %w", path, err)
+	}
+	return m, nil
+}
+
+// compare prints how closely a generated diagram matches a reference
+// diagram, for the evals.
+func compare(referencePath, generatedPath string) {
+	reference, err := readModel(referencePath)
+	if err != nil {
+		log.Fatalf("readModel: %s", err)
+	}
+	generated, err := readModel(generatedPath)
+	if err != nil {
+		log.Fatalf("readModel: %s", err)
+	}
+
+	c := causal.NewMap(generated.Relationships).Compare(causal.NewMap(reference.Relationships))
+	outputBytes, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		log.Fatalf("json.MarshalIndent: %s", err)
+	}
+	fmt.Printf("%s\n", string(outputBytes))
+}
+
 func main() {
 	argv := os.Args
+	if len(argv) == 4 && argv[1] == "compare" {
+		compare(argv[2], argv[3])
+		return
+	}
 	if len(argv) < 2 {
-		log.Fatalf("usage: %s input_path", argv[0])
+		log.Fatalf("usage: %s input_path\n       %s compare reference_path generated_path", argv[0], argv[0])
 	}
 	inputPath := argv[1]
 	inputBytes, err := os.ReadFile(inputPath)
@@ -66,6 +132,14 @@ func main() {
 	if err = json.Unmarshal(inputBytes, &input); err != nil {
 		log.Fatalf("json.Unmarshal: %s", err)
 	}
+	var currentModel *sdjson.Model
+	if len(input.CurrentModel) > 0 {
+		if err := json.Unmarshal(input.CurrentModel, &currentModel); err != nil {
+			// generate a diagram from scratch instead
+			log.Printf("json.Unmarshal(currentModel): %s", err)
+			currentModel = nil
+		}
+	}
 
 	if input.Parameters.ApiKey == "" {
 		input.Parameters.ApiKey = os.Getenv("OPENAI_API_KEY")
@@ -93,15 +167,60 @@ func main() {
 
 	ctx := chat.WithDebugDir(context.Background(), debugDir)
 
-	result, err := d.Generate(ctx, input.Prompt, input.Parameters.BackgroundKnowledge)
+	result, err := d.Generate(ctx, input.Prompt, input.Parameters.BackgroundKnowledge,
+		causal.WithCurrentModel(currentModel),
+		causal.WithProblemStatement(input.Parameters.ProblemStatement))
 	if err != nil {
 		log.Fatalf("d.Generate: %s", err)
 	}
 
+	// merge variables the LLM named in different ways, keeping the
+	// current model's names
+	dedupOpts := []causal.DedupOption{causal.WithConfirmation(c, thinkingLevel)}
+	if currentModel != nil {
+		for _, v := range currentModel.Variables {
+			dedupOpts = append(dedupOpts, causal.WithPreferredNames(v.Name))
+		}
+	}
+	merges, err := result.Dedup(ctx, dedupOpts...)
+	if err != nil {
+		// the diagram is still usable with its duplicates
+		log.Printf("result.Dedup: %s", err)
+	}
+
 	output := new(output)
 	output.SupportingInfo.Title = result.Title
 	output.SupportingInfo.Explanation = result.Explanation
-	output.Model = result.Compat()
+	output.Model = result.UpdateModel(currentModel)
+	// find loops in the model we return, so they use its variable names
+	returned := causal.NewMap(output.Model.Relationships)
+	output.SupportingInfo.FeedbackLoops = returned.Loops()
+	// lay out only what's new, leaving the current diagram as it was
+	var view *sdjson.View
+	if currentModel != nil {
+		view = currentModel.View
+	}
+	output.Model.View = returned.View(causal.WithView(view))
+	if output.SupportingInfo.FeedbackLoops == nil {
+		output.SupportingInfo.FeedbackLoops = []causal.Loop{}
+	}
+	output.SupportingInfo.Provenance, output.SupportingInfo.Diagnostics = result.Relationships()
+	if output.SupportingInfo.Provenance == nil {
+		output.SupportingInfo.Provenance = []causal.Provenance{}
+	}
+	if output.SupportingInfo.Diagnostics == nil {
+		output.SupportingInfo.Diagnostics = []sdjson.Diagnostic{}
+	}
+	output.SupportingInfo.VariableMerges = merges
+	output.SupportingInfo.Boundary = boundary.FromModel(&output.Model)
+	output.SupportingInfo.Archetypes, err = archetype.Find(ctx, returned, causal.DefaultLoopOptions)
+	if err != nil {
+		log.Printf("archetype.Find: %s", err)
+		output.SupportingInfo.Archetypes = []archetype.Match{}
+	}
+	if output.SupportingInfo.VariableMerges == nil {
+		output.SupportingInfo.VariableMerges = []causal.Merge{}
+	}
 
 	outputBytes, err := json.MarshalIndent(output, "", "    ")
 	if err != nil {
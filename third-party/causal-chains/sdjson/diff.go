@@ -0,0 +1,358 @@
+package sdjson
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ChangeKind says how a variable or relationship changed between two
+// models.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeRenamed  ChangeKind = "renamed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// FieldChange is a single attribute that differs between two versions of
+// a variable or relationship.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// VariableChange describes a variable that was added, removed, renamed
+// or modified.  Name is the variable's name in the new model, except for
+// removed variables.  A renamed variable may have modified fields too.
+type VariableChange struct {
+	Kind    ChangeKind    `json:"kind"`
+	Name    string        `json:"name"`
+	OldName string        `json:"oldName,omitzero"`
+	Fields  []FieldChange `json:"fields,omitzero"`
+}
+
+// RelationshipChange describes a relationship that was added, removed or
+// modified.  From and To are the names in the new model, except for
+// removed relationships; a relationship between renamed variables is the
+// same relationship.
+type RelationshipChange struct {
+	Kind   ChangeKind    `json:"kind"`
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Fields []FieldChange `json:"fields,omitzero"`
+}
+
+// PolarityFlipped reports whether the change turns a "+" relationship
+// into a "-" one, or the other way around.
+func (c RelationshipChange) PolarityFlipped() bool {
+	for _, f := range c.Fields {
+		if f.Field == "polarity" && f.Old != "" && f.New != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelDiff is the difference between two models, as returned by Diff.
+type ModelDiff struct {
+	Variables     []VariableChange     `json:"variables,omitzero"`
+	Relationships []RelationshipChange `json:"relationships,omitzero"`
+}
+
+// Empty reports whether the models have the same variables and
+// relationships.
+func (d *ModelDiff) Empty() bool {
+	return len(d.Variables) == 0 && len(d.Relationships) == 0
+}
+
+// Diff compares two versions of a model.  Variables are matched by
+// name, ignoring case and runs of whitespace or underscores, like
+// causal.Canonicalize.  A variable that only exists in one of the
+// models is matched as a rename if another of the same type only exists
+// in the other and has the same equation, or the same neighbours in the
+// relationship graph.
+//
+// Variables are compared by type, equation, units, inflows and outflows,
+// and relationships by polarity.  Changes are listed in the old model's
+// order, followed by additions in the new model's order.
+func Diff(old, new *Model) *ModelDiff {
+	d := &differ{old: old, new: new, renames: make(map[string]*Variable)}
+	d.matchVariables()
+	d.diffVariables()
+	d.diffRelationships()
+	return &d.diff
+}
+
+type differ struct {
+	old, new *Model
+	// matches maps each old variable to its version in the new model
+	matches map[*Variable]*Variable
+	// renames maps the identities of renamed old variables to their
+	// new versions
+	renames map[string]*Variable
+	diff    ModelDiff
+}
+
+// byIdentity indexes variables by identity; the first of any duplicates
+// wins, as it does in Model.Variable.
+func byIdentity(m *Model) map[string]*Variable {
+	index := make(map[string]*Variable, len(m.Variables))
+	for i := range m.Variables {
+		id := identity(m.Variables[i].Name)
+		if _, ok := index[id]; !ok {
+			index[id] = &m.Variables[i]
+		}
+	}
+	return index
+}
+
+// neighbours returns the identities of the variables a variable is
+// related to, marked with the direction of the relationship.
+func neighbours(m *Model, v *Variable) []string {
+	id := identity(v.Name)
+	var found []string
+	for _, r := range m.Relationships {
+		switch {
+		case identity(r.From) == id:
+			found = append(found, "->"+identity(r.To))
+		case identity(r.To) == id:
+			found = append(found, "<-"+identity(r.From))
+		}
+	}
+	slices.Sort(found)
+	return slices.Compact(found)
+}
+
+var operatorSpaceRe = regexp.MustCompile(`\s*([^\w\s.'"$])\s*`)
+
+// normalizeEquation returns the form used to decide whether two
+// equations are the same: case, spacing around operators, and the
+// choice of underscores or spaces in names are ignored.
+func normalizeEquation(eqn string) string {
+	eqn = strings.ToLower(identSpaceRe.ReplaceAllString(eqn, " "))
+	return strings.TrimSpace(operatorSpaceRe.ReplaceAllString(eqn, "$1"))
+}
+
+func (d *differ) matchVariables() {
+	d.matches = make(map[*Variable]*Variable)
+	newByID := byIdentity(d.new)
+	matched := make(map[*Variable]bool)
+
+	var removed []*Variable
+	for i := range d.old.Variables {
+		v := &d.old.Variables[i]
+		if n, ok := newByID[identity(v.Name)]; ok && !matched[n] {
+			d.matches[v] = n
+			matched[n] = true
+			continue
+		}
+		removed = append(removed, v)
+	}
+
+	// pair up the remaining variables that look like renames, in order;
+	// constants with the same value aren't likely to be the same thing
+	for _, v := range removed {
+		eqn := normalizeEquation(v.Equation)
+		if _, err := strconv.ParseFloat(eqn, 64); err == nil {
+			eqn = ""
+		}
+		near := neighbours(d.old, v)
+		for i := range d.new.Variables {
+			n := &d.new.Variables[i]
+			if matched[n] || n.Type != v.Type {
+				continue
+			}
+			sameEquation := eqn != "" && eqn == normalizeEquation(n.Equation)
+			sameNeighbours := len(near) > 0 && slices.Equal(near, d.renamed(neighbours(d.new, n)))
+			if sameEquation || sameNeighbours {
+				d.matches[v] = n
+				d.renames[identity(v.Name)] = n
+				matched[n] = true
+				break
+			}
+		}
+	}
+}
+
+// renamed maps identities in the new model back to the old names of
+// any renamed variables, so neighbours can be compared across models.
+func (d *differ) renamed(ids []string) []string {
+	back := make(map[string]string, len(d.renames))
+	for oldID, n := range d.renames {
+		back[identity(n.Name)] = oldID
+	}
+	mapped := make([]string, len(ids))
+	for i, id := range ids {
+		dir, name := id[:2], id[2:]
+		if oldID, ok := back[name]; ok {
+			name = oldID
+		}
+		mapped[i] = dir + name
+	}
+	slices.Sort(mapped)
+	return mapped
+}
+
+// newIdentity returns the identity an old variable name has in the new
+// model.
+func (d *differ) newIdentity(name string) string {
+	id := identity(name)
+	if n, ok := d.renames[id]; ok {
+		return identity(n.Name)
+	}
+	return id
+}
+
+func (d *differ) diffVariables() {
+	for i := range d.old.Variables {
+		v := &d.old.Variables[i]
+		n, ok := d.matches[v]
+		if !ok {
+			d.diff.Variables = append(d.diff.Variables, VariableChange{Kind: ChangeRemoved, Name: v.Name})
+			continue
+		}
+
+		change := VariableChange{Kind: ChangeModified, Name: n.Name, Fields: d.variableFields(v, n)}
+		if v.Name != n.Name {
+			change.Kind = ChangeRenamed
+			change.OldName = v.Name
+		} else if len(change.Fields) == 0 {
+			continue
+		}
+		d.diff.Variables = append(d.diff.Variables, change)
+	}
+
+	matched := make(map[*Variable]bool, len(d.matches))
+	for _, n := range d.matches {
+		matched[n] = true
+	}
+	for i := range d.new.Variables {
+		if n := &d.new.Variables[i]; !matched[n] {
+			d.diff.Variables = append(d.diff.Variables, VariableChange{Kind: ChangeAdded, Name: n.Name})
+		}
+	}
+}
+
+func (d *differ) variableFields(v, n *Variable) []FieldChange {
+	var fields []FieldChange
+	add := func(field, old, new string) {
+		if old != new {
+			fields = append(fields, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+	add("type", v.Type.String(), n.Type.String())
+	if normalizeEquation(v.Equation) != normalizeEquation(n.Equation) {
+		add("equation", v.Equation, n.Equation)
+	}
+	add("units", v.Units, n.Units)
+	add("inflows", d.flows(v.Inflows, n.Inflows), strings.Join(n.Inflows, ", "))
+	add("outflows", d.flows(v.Outflows, n.Outflows), strings.Join(n.Outflows, ", "))
+	return fields
+}
+
+// flows returns the old flows as they should be written if they're the
+// same as the new ones, so reordering or renaming flows isn't a change.
+func (d *differ) flows(old, new []string) string {
+	oldIDs := make([]string, len(old))
+	for i, name := range old {
+		oldIDs[i] = d.newIdentity(name)
+	}
+	newIDs := make([]string, len(new))
+	for i, name := range new {
+		newIDs[i] = identity(name)
+	}
+	slices.Sort(oldIDs)
+	slices.Sort(newIDs)
+	if slices.Equal(oldIDs, newIDs) {
+		return strings.Join(new, ", ")
+	}
+	return strings.Join(old, ", ")
+}
+
+func (d *differ) diffRelationships() {
+	type key struct{ from, to string }
+
+	newRels := make(map[key]*Relationship, len(d.new.Relationships))
+	for i := range d.new.Relationships {
+		r := &d.new.Relationships[i]
+		k := key{identity(r.From), identity(r.To)}
+		if _, ok := newRels[k]; !ok {
+			newRels[k] = r
+		}
+	}
+
+	matched := make(map[*Relationship]bool)
+	for _, r := range d.old.Relationships {
+		n, ok := newRels[key{d.newIdentity(r.From), d.newIdentity(r.To)}]
+		if !ok || matched[n] {
+			d.diff.Relationships = append(d.diff.Relationships, RelationshipChange{Kind: ChangeRemoved, From: r.From, To: r.To})
+			continue
+		}
+		matched[n] = true
+		if r.Polarity != n.Polarity {
+			d.diff.Relationships = append(d.diff.Relationships, RelationshipChange{
+				Kind: ChangeModified, From: n.From, To: n.To,
+				Fields: []FieldChange{{Field: "polarity", Old: r.Polarity, New: n.Polarity}},
+			})
+		}
+	}
+
+	for i := range d.new.Relationships {
+		if n := &d.new.Relationships[i]; !matched[n] && newRels[key{identity(n.From), identity(n.To)}] == n {
+			d.diff.Relationships = append(d.diff.Relationships, RelationshipChange{Kind: ChangeAdded, From: n.From, To: n.To})
+		}
+	}
+}
+
+// Changelog renders the diff as a list of changes in plain English, one
+// per line, suitable for showing to the user.
+func (d *ModelDiff) Changelog() string {
+	if d.Empty() {
+		return "No changes."
+	}
+
+	var b strings.Builder
+	for _, c := range d.Variables {
+		switch c.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "- Added variable %q\n", c.Name)
+		case ChangeRemoved:
+			fmt.Fprintf(&b, "- Removed variable %q\n", c.Name)
+		case ChangeRenamed:
+			fmt.Fprintf(&b, "- Renamed variable %q to %q", c.OldName, c.Name)
+			if len(c.Fields) > 0 {
+				fmt.Fprintf(&b, ", and changed %s", describeFields(c.Fields))
+			}
+			b.WriteString("\n")
+		case ChangeModified:
+			fmt.Fprintf(&b, "- Changed %s of %q\n", describeFields(c.Fields), c.Name)
+		}
+	}
+	for _, c := range d.Relationships {
+		switch {
+		case c.Kind == ChangeAdded:
+			fmt.Fprintf(&b, "- Added relationship %q -> %q\n", c.From, c.To)
+		case c.Kind == ChangeRemoved:
+			fmt.Fprintf(&b, "- Removed relationship %q -> %q\n", c.From, c.To)
+		case c.PolarityFlipped():
+			fmt.Fprintf(&b, "- Flipped the polarity of %q -> %q from %s to %s\n", c.From, c.To, c.Fields[0].Old, c.Fields[0].New)
+		default:
+			fmt.Fprintf(&b, "- Changed %s of %q -> %q\n", describeFields(c.Fields), c.From, c.To)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func describeFields(fields []FieldChange) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = fmt.Sprintf("%s from %q to %q", f.Field, f.Old, f.New)
+	}
+	return strings.Join(parts, "; ")
+}
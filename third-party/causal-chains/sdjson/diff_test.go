@@ -0,0 +1,202 @@
+package sdjson
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(m *Model)
+		variables     []VariableChange
+		relationships []RelationshipChange
+	}{
+		{
+			name:   "unchanged",
+			modify: func(m *Model) {},
+		},
+		{
+			name: "spelling and whitespace",
+			modify: func(m *Model) {
+				m.Variables[1].Equation = "Population*birth_rate"
+				m.Relationships[3].From = "Birth_Rate"
+			},
+		},
+		{
+			name: "added and removed",
+			modify: func(m *Model) {
+				m.Variables = append(m.Variables[:4], Variable{Name: "average lifetime", Type: VariableTypeFlow})
+				m.Relationships = m.Relationships[:5]
+				m.Relationships = append(m.Relationships, Relationship{From: "average lifetime", To: "births", Polarity: "-"})
+			},
+			variables: []VariableChange{
+				{Kind: ChangeRemoved, Name: "lifetime"},
+				{Kind: ChangeAdded, Name: "average lifetime"},
+			},
+			relationships: []RelationshipChange{
+				{Kind: ChangeRemoved, From: "lifetime", To: "deaths"},
+				{Kind: ChangeAdded, From: "average lifetime", To: "births"},
+			},
+		},
+		{
+			name: "modified",
+			modify: func(m *Model) {
+				m.Variables[0].Outflows = nil
+				m.Variables[1].Equation = "Population * birth_rate * 2"
+				m.Variables[2].Type = VariableTypeAux
+				m.Variables[3].Units = "1/year"
+			},
+			variables: []VariableChange{
+				{Kind: ChangeModified, Name: "Population", Fields: []FieldChange{{Field: "outflows", Old: "deaths", New: ""}}},
+				{Kind: ChangeModified, Name: "births", Fields: []FieldChange{{Field: "equation", Old: "Population * birth_rate", New: "Population * birth_rate * 2"}}},
+				{Kind: ChangeModified, Name: "deaths", Fields: []FieldChange{{Field: "type", Old: "flow", New: "variable"}}},
+				{Kind: ChangeModified, Name: "birth rate", Fields: []FieldChange{{Field: "units", Old: "", New: "1/year"}}},
+			},
+		},
+		{
+			name: "renamed by equation",
+			modify: func(m *Model) {
+				m.Variables[2].Name = "mortality"
+				m.Variables[0].Outflows = []string{"mortality"}
+				m.Relationships[1].From = "mortality"
+				m.Relationships[4].To = "mortality"
+				m.Relationships[5].To = "mortality"
+				m.Relationships = append(m.Relationships, Relationship{From: "birth rate", To: "mortality", Polarity: "+"})
+			},
+			variables: []VariableChange{
+				{Kind: ChangeRenamed, Name: "mortality", OldName: "deaths"},
+			},
+			relationships: []RelationshipChange{
+				{Kind: ChangeAdded, From: "birth rate", To: "mortality"},
+			},
+		},
+		{
+			name: "same constant is not a rename",
+			modify: func(m *Model) {
+				m.Variables[4].Name = "tax credit"
+				m.Variables[2].Equation = "Population / 70"
+				m.Relationships = m.Relationships[:5]
+			},
+			variables: []VariableChange{
+				{Kind: ChangeModified, Name: "deaths", Fields: []FieldChange{{Field: "equation", Old: "Population / lifetime", New: "Population / 70"}}},
+				{Kind: ChangeRemoved, Name: "lifetime"},
+				{Kind: ChangeAdded, Name: "tax credit"},
+			},
+			relationships: []RelationshipChange{
+				{Kind: ChangeRemoved, From: "lifetime", To: "deaths"},
+			},
+		},
+		{
+			name: "renamed constant",
+			modify: func(m *Model) {
+				m.Variables[4].Name = "life expectancy"
+				m.Variables[2].Equation = "Population / life_expectancy"
+				m.Relationships[5].From = "life expectancy"
+			},
+			variables: []VariableChange{
+				{Kind: ChangeModified, Name: "deaths", Fields: []FieldChange{{Field: "equation", Old: "Population / lifetime", New: "Population / life_expectancy"}}},
+				{Kind: ChangeRenamed, Name: "life expectancy", OldName: "lifetime"},
+			},
+		},
+		{
+			name: "renamed by neighbours",
+			modify: func(m *Model) {
+				m.Variables[2].Name = "dying"
+				m.Variables[2].Equation = "Population * death_fraction"
+				m.Variables[0].Outflows = []string{"dying"}
+				m.Relationships[1].From = "dying"
+				m.Relationships[4].To = "dying"
+				m.Relationships[5].To = "dying"
+			},
+			variables: []VariableChange{
+				{Kind: ChangeRenamed, Name: "dying", OldName: "deaths", Fields: []FieldChange{{Field: "equation", Old: "Population / lifetime", New: "Population * death_fraction"}}},
+			},
+		},
+		{
+			name: "renamed only in case",
+			modify: func(m *Model) {
+				m.Variables[3].Name = "Birth Rate"
+			},
+			variables: []VariableChange{
+				{Kind: ChangeRenamed, Name: "Birth Rate", OldName: "birth rate"},
+			},
+		},
+		{
+			name: "polarity",
+			modify: func(m *Model) {
+				m.Relationships[3].Polarity = "-"
+				m.Relationships[5].Polarity = ""
+			},
+			relationships: []RelationshipChange{
+				{Kind: ChangeModified, From: "birth rate", To: "births", Fields: []FieldChange{{Field: "polarity", Old: "+", New: "-"}}},
+				{Kind: ChangeModified, From: "lifetime", To: "deaths", Fields: []FieldChange{{Field: "polarity", Old: "-", New: ""}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validModel()
+			tt.modify(m)
+			d := Diff(validModel(), m)
+			assert.Equal(t, tt.variables, d.Variables)
+			assert.Equal(t, tt.relationships, d.Relationships)
+			assert.Equal(t, tt.variables == nil && tt.relationships == nil, d.Empty())
+		})
+	}
+}
+
+func TestDiffChangelog(t *testing.T) {
+	d := &ModelDiff{
+		Variables: []VariableChange{
+			{Kind: ChangeAdded, Name: "a"},
+			{Kind: ChangeRemoved, Name: "b"},
+			{Kind: ChangeRenamed, Name: "c", OldName: "C old"},
+			{Kind: ChangeRenamed, Name: "d", OldName: "D old", Fields: []FieldChange{{Field: "units", Old: "", New: "people"}}},
+			{Kind: ChangeModified, Name: "e", Fields: []FieldChange{{Field: "equation", Old: "1", New: "2"}, {Field: "type", Old: "variable", New: "flow"}}},
+		},
+		Relationships: []RelationshipChange{
+			{Kind: ChangeAdded, From: "a", To: "c"},
+			{Kind: ChangeRemoved, From: "b", To: "c"},
+			{Kind: ChangeModified, From: "c", To: "d", Fields: []FieldChange{{Field: "polarity", Old: "+", New: "-"}}},
+			{Kind: ChangeModified, From: "d", To: "e", Fields: []FieldChange{{Field: "polarity", Old: "", New: "+"}}},
+		},
+	}
+
+	expected := `- Added variable "a"
+- Removed variable "b"
+- Renamed variable "C old" to "c"
+- Renamed variable "D old" to "d", and changed units from "" to "people"
+- Changed equation from "1" to "2"; type from "variable" to "flow" of "e"
+- Added relationship "a" -> "c"
+- Removed relationship "b" -> "c"
+- Flipped the polarity of "c" -> "d" from + to -
+- Changed polarity from "" to "+" of "d" -> "e"`
+	assert.Equal(t, expected, d.Changelog())
+
+	assert.Equal(t, "No changes.", (&ModelDiff{}).Changelog())
+}
+
+func TestDiffJSON(t *testing.T) {
+	m := validModel()
+	m.Variables[4].Units = "years"
+	m.Relationships[5].Polarity = "+"
+
+	data, err := json.Marshal(Diff(validModel(), m))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "variables": [
+    {"kind": "modified", "name": "lifetime", "fields": [{"field": "units", "old": "", "new": "years"}]}
+  ],
+  "relationships": [
+    {"kind": "modified", "from": "lifetime", "to": "deaths", "fields": [{"field": "polarity", "old": "-", "new": "+"}]}
+  ]
+}`, string(data))
+
+	data, err = json.Marshal(Diff(validModel(), validModel()))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(data))
+}
@@ -1,8 +1,11 @@
 package sdjson
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type Polarity int
@@ -107,17 +110,95 @@ type GraphicalFunction struct {
 	Points []Point `json:"points"`
 }
 
+// UnmarshalJSON accepts both the documented {"points": [...]} form and
+// a bare array of points, which older exports produce.
+func (gf *GraphicalFunction) UnmarshalJSON(b []byte) error {
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		return json.Unmarshal(trimmed, &gf.Points)
+	}
+
+	type plainGraphicalFunction GraphicalFunction
+	return json.Unmarshal(b, (*plainGraphicalFunction)(gf))
+}
+
+var _ json.Unmarshaler = (*GraphicalFunction)(nil)
+
+// Lookup returns the graphical function's value at x, interpolating
+// linearly between points and holding the end values outside their
+// range.  Points must be sorted by X.
+func (gf *GraphicalFunction) Lookup(x float64) float64 {
+	points := gf.Points
+	switch {
+	case len(points) == 0:
+		return 0
+	case x <= points[0].X:
+		return points[0].Y
+	case x >= points[len(points)-1].X:
+		return points[len(points)-1].Y
+	}
+
+	i := sort.Search(len(points), func(i int) bool { return points[i].X >= x })
+	lo, hi := points[i-1], points[i]
+	if hi.X == lo.X {
+		return hi.Y
+	}
+	return lo.Y + (hi.Y-lo.Y)*(x-lo.X)/(hi.X-lo.X)
+}
+
+// ArrayEquation is the equation for a specific element of an arrayed
+// variable.  ForElements is ordered to match the variable's Dimensions.
+type ArrayEquation struct {
+	Equation    string   `json:"equation"`
+	ForElements []string `json:"forElements"`
+}
+
 type Variable struct {
 	Name              string             `json:"name"`
 	Type              VariableType       `json:"type"`
 	Equation          string             `json:"equation,omitzero"`
 	Documentation     string             `json:"documentation,omitzero"`
 	Units             string             `json:"units,omitzero"`
+	Uniflow           *bool              `json:"uniflow,omitzero"` // flows only: never negative
 	Inflows           []string           `json:"inflows,omitzero"`
 	Outflows          []string           `json:"outflows,omitzero"`
+	Dimensions        []string           `json:"dimensions,omitzero"`
+	ArrayEquations    []ArrayEquation    `json:"arrayEquations,omitzero"`
+	CrossLevelGhostOf string             `json:"crossLevelGhostOf,omitzero"`
 	GraphicalFunction *GraphicalFunction `json:"graphicalFunction,omitzero"`
+	SubType           SubType            `json:"subType,omitzero"`
+	// AdditionalProperties holds the sub-type specific settings; the
+	// concrete type is determined by SubType (see subtypes.go).
+	AdditionalProperties AdditionalProperties `json:"additionalProperties,omitzero"`
 }
 
+// UnmarshalJSON decodes a variable, picking the concrete
+// AdditionalProperties type based on the variable's type and sub-type.
+func (v *Variable) UnmarshalJSON(b []byte) error {
+	type plainVariable Variable
+	var raw struct {
+		plainVariable
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*v = Variable(raw.plainVariable)
+
+	if len(raw.AdditionalProperties) == 0 || string(raw.AdditionalProperties) == "null" {
+		return nil
+	}
+
+	props := newAdditionalProperties(v.Type, v.SubType)
+	if err := json.Unmarshal(raw.AdditionalProperties, props); err != nil {
+		return fmt.Errorf("variable %q: additionalProperties: %w", v.Name, err)
+	}
+	v.AdditionalProperties = props
+
+	return nil
+}
+
+var _ json.Unmarshaler = (*Variable)(nil)
+
 type Relationship struct {
 	From              string `json:"from"`
 	To                string `json:"to"`
@@ -131,17 +212,179 @@ func (r *Relationship) Key() string {
 	return fmt.Sprintf("%q->%q", r.From, r.To)
 }
 
+type IntegrationMethod string
+
+const (
+	IntegrationEuler IntegrationMethod = "Euler"
+	IntegrationRK4   IntegrationMethod = "RK4"
+)
+
+type DimensionType string
+
+const (
+	// DimensionNumeric dimensions have auto-generated element names
+	// ("1", "2", "3", ...).
+	DimensionNumeric DimensionType = "numeric"
+	// DimensionLabels dimensions have user-defined element names.
+	DimensionLabels DimensionType = "labels"
+)
+
+// ArrayDimension defines a dimension that arrayed variables can
+// reference by name.  All four fields are required by SD-JSON.
+type ArrayDimension struct {
+	Type     DimensionType `json:"type"`
+	Name     string        `json:"name"`
+	Size     int           `json:"size"`
+	Elements []string      `json:"elements"`
+}
+
 type Specs struct {
-	StartTime float64 `json:"startTime"`
-	StopTime  float64 `json:"stopTime"`
-	DT        float64 `json:"dt,omitzero"`
-	SaveStep  float64 `json:"saveStep,omitzero"`
-	TimeUnits string  `json:"timeUnits,omitzero"`
+	StartTime         float64           `json:"startTime"`
+	StopTime          float64           `json:"stopTime"`
+	DT                float64           `json:"dt,omitzero"`
+	SaveStep          float64           `json:"saveStep,omitzero"`
+	TimeUnits         string            `json:"timeUnits,omitzero"`
+	IntegrationMethod IntegrationMethod `json:"integrationMethod,omitzero"`
+	ArrayDimensions   []ArrayDimension  `json:"arrayDimensions,omitzero"`
+}
+
+// Module is a node in the module hierarchy.  Variables inside a module
+// are named with dot notation: "ModuleName.variableName".
+type Module struct {
+	Name         string `json:"name"`
+	ParentModule string `json:"parentModule"` // empty for top-level modules
 }
 
 // Model is the format that sd-ai expects to talk about models.
 type Model struct {
 	Variables     []Variable     `json:"variables,omitzero"`
 	Relationships []Relationship `json:"relationships,omitzero"`
+	Modules       []Module       `json:"modules,omitzero"`
 	Specs         Specs          `json:"specs,omitzero"`
+	// Errors and UnitWarnings are populated by the client.  A nil slice
+	// means the check wasn't reported, while an empty, non-nil slice
+	// means the check ran and found nothing; both survive a roundtrip.
+	Errors       []string      `json:"errors,omitzero"`
+	UnitWarnings []UnitWarning `json:"unitWarnings,omitzero"`
+	// View is where the model's diagram was drawn, if it has been.
+	View *View `json:"view,omitzero"`
 }
+
+// View is the layout of a model's causal loop diagram, so that it can be
+// drawn again the same way.  Coordinates are in pixels, with y growing
+// downward.
+type View struct {
+	Variables     []VariableView     `json:"variables,omitzero"`
+	Relationships []RelationshipView `json:"relationships,omitzero"`
+	Loops         []LoopView         `json:"loops,omitzero"`
+}
+
+// VariableView is the center of a variable's label.
+type VariableView struct {
+	Name string  `json:"name"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// RelationshipView is how far a link bends to its left, as a fraction
+// of its length.
+type RelationshipView struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Curvature float64 `json:"curvature"`
+}
+
+// LoopView is the center of a feedback loop's label.  Loops are matched
+// by name, the variables they go through, since identifiers such as "R1"
+// are renumbered as loops come and go.
+type LoopView struct {
+	Identifier string  `json:"identifier,omitzero"`
+	Name       string  `json:"name"`
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+}
+
+// Variable returns the view of the named variable, or nil.  Names are
+// matched as Model.Variable matches them.
+func (v *View) Variable(name string) *VariableView {
+	id := identity(name)
+	for i := range v.Variables {
+		if identity(v.Variables[i].Name) == id {
+			return &v.Variables[i]
+		}
+	}
+	return nil
+}
+
+// Relationship returns the view of the link between two variables, or
+// nil.
+func (v *View) Relationship(from, to string) *RelationshipView {
+	from, to = identity(from), identity(to)
+	for i := range v.Relationships {
+		if identity(v.Relationships[i].From) == from && identity(v.Relationships[i].To) == to {
+			return &v.Relationships[i]
+		}
+	}
+	return nil
+}
+
+// Variable returns the variable with the given name, or nil.  Names are
+// matched the way equations refer to them: case and runs of whitespace
+// or underscores are ignored, so "birth_rate" finds "Birth Rate".
+func (m *Model) Variable(name string) *Variable {
+	id := identity(name)
+	for i := range m.Variables {
+		if identity(m.Variables[i].Name) == id {
+			return &m.Variables[i]
+		}
+	}
+	return nil
+}
+
+// Resolve returns the variable that name refers to in the equation of
+// the variable named from.  Names are looked up in from's module first,
+// then in each enclosing module, and finally as written, so "births" in
+// the equation of "Population.Population" finds "Population.births".
+func (m *Model) Resolve(from, name string) *Variable {
+	for prefix := from; ; {
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			return m.Variable(name)
+		}
+		prefix = prefix[:i]
+		if found := m.Variable(prefix + "." + name); found != nil {
+			return found
+		}
+	}
+}
+
+// UnitWarning is a single result of a unit-consistency check.  Clients
+// report either a bare message string or an object naming the offending
+// element; a warning without an Element marshals as a bare string.
+type UnitWarning struct {
+	Element string `json:"element,omitzero"`
+	Message string `json:"message"`
+}
+
+func (w UnitWarning) MarshalJSON() ([]byte, error) {
+	if w.Element == "" {
+		return json.Marshal(w.Message)
+	}
+	type plainUnitWarning UnitWarning
+	return json.Marshal(plainUnitWarning(w))
+}
+
+func (w *UnitWarning) UnmarshalJSON(b []byte) error {
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '"' {
+		*w = UnitWarning{}
+		return json.Unmarshal(trimmed, &w.Message)
+	}
+
+	type plainUnitWarning UnitWarning
+	return json.Unmarshal(b, (*plainUnitWarning)(w))
+}
+
+var (
+	_ json.Marshaler   = UnitWarning{}
+	_ json.Unmarshaler = (*UnitWarning)(nil)
+)
@@ -2,6 +2,7 @@ package sdjson
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -243,6 +244,65 @@ func TestGraphicalFunctionRoundtrip(t *testing.T) {
 	}
 }
 
+func TestGraphicalFunctionBareArray(t *testing.T) {
+	var gf GraphicalFunction
+	err := json.Unmarshal([]byte(`[{"x": 0, "y": 1}, {"x": 2, "y": 3}]`), &gf)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{X: 0, Y: 1}, {X: 2, Y: 3}}, gf.Points)
+
+	data, err := json.Marshal(gf)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"points": [{"x": 0, "y": 1}, {"x": 2, "y": 3}]}`, string(data))
+}
+
+func TestGraphicalFunctionLookup(t *testing.T) {
+	gf := GraphicalFunction{Points: []Point{{X: 0, Y: 1}, {X: 2, Y: 3}, {X: 4, Y: 0}}}
+
+	tests := []struct {
+		x        float64
+		expected float64
+	}{
+		{x: -1, expected: 1},
+		{x: 0, expected: 1},
+		{x: 1, expected: 2},
+		{x: 2, expected: 3},
+		{x: 3, expected: 1.5},
+		{x: 4, expected: 0},
+		{x: 10, expected: 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, gf.Lookup(tt.x), "x = %g", tt.x)
+	}
+
+	assert.Equal(t, 0.0, (&GraphicalFunction{}).Lookup(1))
+}
+
+func TestModelVariable(t *testing.T) {
+	m := &Model{Variables: []Variable{{Name: "Birth Rate"}, {Name: "Hares.Hare births"}}}
+
+	assert.Equal(t, &m.Variables[0], m.Variable("birth_rate"))
+	assert.Equal(t, &m.Variables[0], m.Variable(" Birth  Rate "))
+	assert.Equal(t, &m.Variables[1], m.Variable("hares.hare_births"))
+	assert.Nil(t, m.Variable("death rate"))
+}
+
+func TestModelResolve(t *testing.T) {
+	m := &Model{Variables: []Variable{
+		{Name: "births"},
+		{Name: "Population.births"},
+		{Name: "Population.Cohort.births"},
+		{Name: "Population.deaths"},
+	}}
+
+	assert.Equal(t, &m.Variables[0], m.Resolve("Population", "births"))
+	assert.Equal(t, &m.Variables[1], m.Resolve("Population.Population", "births"))
+	assert.Equal(t, &m.Variables[2], m.Resolve("Population.Cohort.Cohort", "births"))
+	assert.Equal(t, &m.Variables[3], m.Resolve("Population.Cohort.Cohort", "deaths"))
+	assert.Equal(t, &m.Variables[3], m.Resolve("births", "Population.deaths"))
+	assert.Nil(t, m.Resolve("Population.Population", "lifetime"))
+}
+
 func TestVariableRoundtrip(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -574,3 +634,228 @@ func TestModelRoundtrip(t *testing.T) {
 		})
 	}
 }
+
+func TestModelFullFidelityRoundtrip(t *testing.T) {
+	serialized := `{
+  "variables": [
+    {
+      "name": "Factory.inventory",
+      "type": "stock",
+      "equation": "100",
+      "units": "widgets",
+      "inflows": ["Factory.production"],
+      "outflows": ["Factory.shipments"],
+      "dimensions": ["region"],
+      "arrayEquations": [
+        {"equation": "60", "forElements": ["North"]},
+        {"equation": "40", "forElements": ["South"]}
+      ]
+    },
+    {
+      "name": "Factory.production",
+      "type": "flow",
+      "equation": "10",
+      "uniflow": true,
+      "additionalProperties": {"spreadFlow": "even"}
+    },
+    {
+      "name": "Factory.shipments",
+      "type": "flow",
+      "equation": "",
+      "subType": "discreteOutflow"
+    },
+    {
+      "name": "Factory.line",
+      "type": "stock",
+      "equation": "0",
+      "subType": "conveyor",
+      "additionalProperties": {"processTime": "4", "capacity": "50", "oneAtATime": true}
+    },
+    {
+      "name": "Factory.kiln",
+      "type": "stock",
+      "equation": "0",
+      "subType": "oven",
+      "additionalProperties": {"processTime": "2", "fillTime": "1", "cleanTime": "0.5"}
+    },
+    {
+      "name": "Factory.backlog",
+      "type": "stock",
+      "equation": "0",
+      "subType": "queue",
+      "additionalProperties": {"fifoEnabled": true, "purgeEq": "12", "overflow": true}
+    },
+    {
+      "name": "Factory.spoilage",
+      "type": "flow",
+      "equation": "",
+      "subType": "conveyorLeakage",
+      "additionalProperties": {"leakFraction": "0.1", "exponential": true, "leakZoneStart": "0", "leakZoneEnd": "50"}
+    },
+    {
+      "name": "Factory.smoothed_demand",
+      "type": "variable",
+      "equation": "SMTH1(Market.demand, 3)",
+      "subType": "delayVariable"
+    },
+    {
+      "name": "Factory.demand",
+      "type": "variable",
+      "equation": "",
+      "crossLevelGhostOf": "Market.demand"
+    },
+    {
+      "name": "Market.demand",
+      "type": "variable",
+      "equation": "5",
+      "subType": "somethingNew",
+      "additionalProperties": {"custom": [1, 2, 3]}
+    }
+  ],
+  "relationships": [
+    {"from": "Factory.production", "to": "Factory.inventory", "polarity": "+"},
+    {"from": "Market.demand", "to": "Factory.demand", "polarity": ""}
+  ],
+  "modules": [
+    {"name": "Factory", "parentModule": ""},
+    {"name": "Market", "parentModule": ""}
+  ],
+  "specs": {
+    "startTime": 0,
+    "stopTime": 52,
+    "dt": 0.25,
+    "timeUnits": "weeks",
+    "integrationMethod": "RK4",
+    "arrayDimensions": [
+      {"type": "labels", "name": "region", "size": 2, "elements": ["North", "South"]}
+    ]
+  },
+  "errors": ["something went wrong"],
+  "unitWarnings": [
+    "stock and flow units disagree",
+    {"element": "Factory.production", "message": "\"Factory.production\" does not have units."}
+  ]
+}`
+
+	var m Model
+	require.NoError(t, json.Unmarshal([]byte(serialized), &m))
+
+	require.Len(t, m.Variables, 10)
+	assert.Equal(t, []string{"region"}, m.Variables[0].Dimensions)
+	assert.Equal(t, ArrayEquation{Equation: "40", ForElements: []string{"South"}}, m.Variables[0].ArrayEquations[1])
+	require.NotNil(t, m.Variables[1].Uniflow)
+	assert.True(t, *m.Variables[1].Uniflow)
+	assert.Equal(t, &InflowProperties{SpreadFlow: SpreadFlowEven}, m.Variables[1].AdditionalProperties)
+	assert.Equal(t, SubTypeDiscreteOutflow, m.Variables[2].SubType)
+	assert.Nil(t, m.Variables[2].AdditionalProperties)
+	assert.Equal(t, &ConveyorProperties{ProcessTime: "4", Capacity: "50", OneAtATime: true}, m.Variables[3].AdditionalProperties)
+	assert.Equal(t, &OvenProperties{ProcessTime: "2", FillTime: "1", CleanTime: "0.5"}, m.Variables[4].AdditionalProperties)
+	assert.Equal(t, &QueueProperties{FifoEnabled: true, PurgeEq: "12", Overflow: true}, m.Variables[5].AdditionalProperties)
+	assert.Equal(t, &LeakageProperties{LeakFraction: "0.1", Exponential: true, LeakZoneStart: "0", LeakZoneEnd: "50"}, m.Variables[6].AdditionalProperties)
+	assert.Equal(t, SubTypeDelayVariable, m.Variables[7].SubType)
+	assert.Equal(t, "Market.demand", m.Variables[8].CrossLevelGhostOf)
+	assert.IsType(t, &RawProperties{}, m.Variables[9].AdditionalProperties)
+
+	assert.Equal(t, []Module{{Name: "Factory"}, {Name: "Market"}}, m.Modules)
+	assert.Equal(t, IntegrationRK4, m.Specs.IntegrationMethod)
+	assert.Equal(t, []ArrayDimension{{Type: DimensionLabels, Name: "region", Size: 2, Elements: []string{"North", "South"}}}, m.Specs.ArrayDimensions)
+	assert.Equal(t, []string{"something went wrong"}, m.Errors)
+	assert.Equal(t, []UnitWarning{
+		{Message: "stock and flow units disagree"},
+		{Element: "Factory.production", Message: `"Factory.production" does not have units.`},
+	}, m.UnitWarnings)
+
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	// empty equations are omitted when marshaling
+	expected := strings.ReplaceAll(serialized, `"equation": "",`, "")
+	assert.JSONEq(t, expected, string(data))
+}
+
+func TestModelDiagnosticsPresence(t *testing.T) {
+	var absent Model
+	require.NoError(t, json.Unmarshal([]byte(`{}`), &absent))
+	assert.Nil(t, absent.Errors)
+	assert.Nil(t, absent.UnitWarnings)
+
+	data, err := json.Marshal(absent)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(data))
+
+	var empty Model
+	require.NoError(t, json.Unmarshal([]byte(`{"errors": [], "unitWarnings": []}`), &empty))
+	assert.NotNil(t, empty.Errors)
+	assert.NotNil(t, empty.UnitWarnings)
+
+	data, err = json.Marshal(empty)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"errors": [], "unitWarnings": []}`, string(data))
+}
+
+func TestViewRoundtrip(t *testing.T) {
+	data := `{
+		"variables": [{"name": "Population", "type": "variable"}],
+		"view": {
+			"variables": [{"name": "Population", "x": 120.5, "y": -40}, {"name": "births", "x": 0, "y": 0}],
+			"relationships": [{"from": "births", "to": "Population", "curvature": -0.2}],
+			"loops": [{"identifier": "R1", "name": "Population -> births -> Population", "x": 60, "y": 10}]
+		}
+	}`
+	var m Model
+	require.NoError(t, json.Unmarshal([]byte(data), &m))
+	require.NotNil(t, m.View)
+	assert.Equal(t, &VariableView{Name: "Population", X: 120.5, Y: -40}, m.View.Variable("population"))
+	assert.Equal(t, &m.View.Variables[1], m.View.Variable("Births"))
+	assert.Nil(t, m.View.Variable("deaths"))
+	assert.Equal(t, -0.2, m.View.Relationship("Births", "population").Curvature)
+	assert.Nil(t, m.View.Relationship("Population", "births"))
+
+	out, err := json.Marshal(m)
+	require.NoError(t, err)
+	assert.JSONEq(t, data, string(out))
+
+	// a model without a view doesn't grow one
+	out, err = json.Marshal(Model{})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(out))
+}
+
+func TestVariableUnknownPropertiesRoundtrip(t *testing.T) {
+	data := `{
+		"name": "Line",
+		"type": "stock",
+		"subType": "queue",
+		"uniflow": false,
+		"additionalProperties": {"fifoEnabled": true, "discrete": false, "batchSize": 5, "label": {"color": "red"}}
+	}`
+	var v Variable
+	require.NoError(t, json.Unmarshal([]byte(data), &v))
+	require.NotNil(t, v.Uniflow)
+	assert.False(t, *v.Uniflow)
+	assert.Equal(t, &QueueProperties{
+		FifoEnabled: true,
+		Extra: Extra{
+			"discrete":  json.RawMessage(`false`),
+			"batchSize": json.RawMessage(`5`),
+			"label":     json.RawMessage(`{"color": "red"}`),
+		},
+	}, v.AdditionalProperties)
+
+	out, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, data, string(out))
+
+	// a field set since takes the place of the value it was read with
+	v.AdditionalProperties.(*QueueProperties).Discrete = true
+	out, err = json.Marshal(v)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"discrete":true`)
+	assert.NotContains(t, string(out), `"discrete":false`)
+}
+
+func TestVariableAdditionalPropertiesError(t *testing.T) {
+	var v Variable
+	err := json.Unmarshal([]byte(`{"name": "q", "type": "stock", "subType": "queue", "additionalProperties": {"fifoEnabled": "yes"}}`), &v)
+	assert.Error(t, err)
+}
@@ -0,0 +1,263 @@
+package sdjson
+
+import (
+	"encoding/json"
+)
+
+// SubType refines a variable's Type for discrete-entity modeling.
+type SubType string
+
+const (
+	// stock sub-types
+	SubTypeQueue       SubType = "queue"
+	SubTypeOven        SubType = "oven"
+	SubTypeConveyor    SubType = "conveyor"
+	SubTypeNonNegative SubType = "nonNegative"
+
+	// flow sub-types; these flows are managed automatically and have
+	// an empty equation.
+	SubTypeDiscreteOutflow SubType = "discreteOutflow"
+	SubTypeConveyorLeakage SubType = "conveyorLeakage"
+	SubTypeQueueOutflow    SubType = "queueOutflow"
+	SubTypeQueueOverflow   SubType = "queueOverflow"
+
+	// variable sub-types
+	SubTypeDelayVariable SubType = "delayVariable"
+)
+
+// AdditionalProperties is implemented by the sub-type specific property
+// sets that can appear in a variable's additionalProperties field.
+type AdditionalProperties interface {
+	isAdditionalProperties()
+}
+
+// ConveyorProperties configures a conveyor stock.
+type ConveyorProperties struct {
+	ProcessTime  string `json:"processTime"`
+	Capacity     string `json:"capacity,omitzero"`
+	InflowLimit  string `json:"inflowLimit,omitzero"`
+	Sample       string `json:"sample,omitzero"`
+	Arrest       string `json:"arrest,omitzero"`
+	OneAtATime   bool   `json:"oneAtATime,omitzero"`
+	SplitBatches bool   `json:"splitBatches,omitzero"`
+	Extra        Extra  `json:"-"`
+}
+
+// OvenProperties configures an oven stock.
+type OvenProperties struct {
+	ProcessTime  string `json:"processTime"`
+	Capacity     string `json:"capacity,omitzero"`
+	InflowLimit  string `json:"inflowLimit,omitzero"`
+	FillTime     string `json:"fillTime,omitzero"`
+	CleanTime    string `json:"cleanTime,omitzero"`
+	Sample       string `json:"sample,omitzero"`
+	Arrest       string `json:"arrest,omitzero"`
+	OneAtATime   bool   `json:"oneAtATime,omitzero"`
+	SplitBatches bool   `json:"splitBatches,omitzero"`
+	Extra        Extra  `json:"-"`
+}
+
+// QueueProperties configures a queue stock.
+type QueueProperties struct {
+	FifoEnabled          bool   `json:"fifoEnabled,omitzero"`
+	Discrete             bool   `json:"discrete,omitzero"`
+	RoundRobin           bool   `json:"roundRobin,omitzero"`
+	QueueOutflowPriority string `json:"queueOutflowPriority,omitzero"`
+	PurgeEq              string `json:"purgeEq,omitzero"`
+	Overflow             bool   `json:"overflow,omitzero"`
+	Extra                Extra  `json:"-"`
+}
+
+type SpreadFlow string
+
+const (
+	SpreadFlowNone         SpreadFlow = "none"
+	SpreadFlowEven         SpreadFlow = "even"
+	SpreadFlowDestination  SpreadFlow = "destination"
+	SpreadFlowDistribution SpreadFlow = "distribution"
+	SpreadFlowSource       SpreadFlow = "source"
+)
+
+// InflowProperties configures a regular flow that feeds a conveyor.
+type InflowProperties struct {
+	SpreadFlow SpreadFlow `json:"spreadFlow,omitzero"`
+	DistribEq  string     `json:"distribEq,omitzero"` // required for SpreadFlowDistribution
+	Extra      Extra      `json:"-"`
+}
+
+// LeakageProperties configures a conveyorLeakage flow.
+type LeakageProperties struct {
+	LeakFraction      string `json:"leakFraction,omitzero"`
+	Exponential       bool   `json:"exponential,omitzero"`
+	LeakZoneStart     string `json:"leakZoneStart,omitzero"`
+	LeakZoneEnd       string `json:"leakZoneEnd,omitzero"`
+	LeakIntegers      bool   `json:"leakIntegers,omitzero"`
+	IgnorePrevZones   bool   `json:"ignorePrevZones,omitzero"`
+	ForceLeakFraction bool   `json:"forceLeakFraction,omitzero"`
+	Extra             Extra  `json:"-"`
+}
+
+// Extra holds the additionalProperties keys a property set wouldn't
+// write back: keys it has no field for, and fields explicitly set to
+// their zero value.  They are written back as they were, unless the
+// field has since been set.
+type Extra map[string]json.RawMessage
+
+// unmarshalExtra decodes b into v, and the keys that v doesn't marshal
+// back to into extra.
+func unmarshalExtra[T any](b []byte, v *T, extra *Extra) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	var fields, kept map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	typed, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(typed, &kept); err != nil {
+		return err
+	}
+
+	*extra = nil
+	for key, value := range fields {
+		if _, ok := kept[key]; !ok {
+			if *extra == nil {
+				*extra = make(Extra)
+			}
+			(*extra)[key] = value
+		}
+	}
+	return nil
+}
+
+// marshalExtra marshals v along with the keys in extra it doesn't set.
+func marshalExtra[T any](v T, extra Extra) ([]byte, error) {
+	typed, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return typed, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(typed, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func (p ConveyorProperties) MarshalJSON() ([]byte, error) {
+	type plainConveyorProperties ConveyorProperties
+	return marshalExtra(plainConveyorProperties(p), p.Extra)
+}
+
+func (p *ConveyorProperties) UnmarshalJSON(b []byte) error {
+	type plainConveyorProperties ConveyorProperties
+	return unmarshalExtra(b, (*plainConveyorProperties)(p), &p.Extra)
+}
+
+func (p OvenProperties) MarshalJSON() ([]byte, error) {
+	type plainOvenProperties OvenProperties
+	return marshalExtra(plainOvenProperties(p), p.Extra)
+}
+
+func (p *OvenProperties) UnmarshalJSON(b []byte) error {
+	type plainOvenProperties OvenProperties
+	return unmarshalExtra(b, (*plainOvenProperties)(p), &p.Extra)
+}
+
+func (p QueueProperties) MarshalJSON() ([]byte, error) {
+	type plainQueueProperties QueueProperties
+	return marshalExtra(plainQueueProperties(p), p.Extra)
+}
+
+func (p *QueueProperties) UnmarshalJSON(b []byte) error {
+	type plainQueueProperties QueueProperties
+	return unmarshalExtra(b, (*plainQueueProperties)(p), &p.Extra)
+}
+
+func (p InflowProperties) MarshalJSON() ([]byte, error) {
+	type plainInflowProperties InflowProperties
+	return marshalExtra(plainInflowProperties(p), p.Extra)
+}
+
+func (p *InflowProperties) UnmarshalJSON(b []byte) error {
+	type plainInflowProperties InflowProperties
+	return unmarshalExtra(b, (*plainInflowProperties)(p), &p.Extra)
+}
+
+func (p LeakageProperties) MarshalJSON() ([]byte, error) {
+	type plainLeakageProperties LeakageProperties
+	return marshalExtra(plainLeakageProperties(p), p.Extra)
+}
+
+func (p *LeakageProperties) UnmarshalJSON(b []byte) error {
+	type plainLeakageProperties LeakageProperties
+	return unmarshalExtra(b, (*plainLeakageProperties)(p), &p.Extra)
+}
+
+var (
+	_ json.Marshaler   = ConveyorProperties{}
+	_ json.Unmarshaler = (*ConveyorProperties)(nil)
+	_ json.Marshaler   = OvenProperties{}
+	_ json.Unmarshaler = (*OvenProperties)(nil)
+	_ json.Marshaler   = QueueProperties{}
+	_ json.Unmarshaler = (*QueueProperties)(nil)
+	_ json.Marshaler   = InflowProperties{}
+	_ json.Unmarshaler = (*InflowProperties)(nil)
+	_ json.Marshaler   = LeakageProperties{}
+	_ json.Unmarshaler = (*LeakageProperties)(nil)
+)
+
+// RawProperties preserves additionalProperties for sub-types that
+// don't have a documented property set, so they aren't silently dropped.
+type RawProperties json.RawMessage
+
+func (r RawProperties) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+func (r *RawProperties) UnmarshalJSON(b []byte) error {
+	*r = append((*r)[:0], b...)
+	return nil
+}
+
+var (
+	_ json.Marshaler   = RawProperties(nil)
+	_ json.Unmarshaler = (*RawProperties)(nil)
+)
+
+func (*ConveyorProperties) isAdditionalProperties() {}
+func (*OvenProperties) isAdditionalProperties()     {}
+func (*QueueProperties) isAdditionalProperties()    {}
+func (*InflowProperties) isAdditionalProperties()   {}
+func (*LeakageProperties) isAdditionalProperties()  {}
+func (*RawProperties) isAdditionalProperties()      {}
+
+// newAdditionalProperties returns a pointer to the property set that
+// corresponds to the given variable type and sub-type.
+func newAdditionalProperties(varType VariableType, subType SubType) AdditionalProperties {
+	switch subType {
+	case SubTypeConveyor:
+		return new(ConveyorProperties)
+	case SubTypeOven:
+		return new(OvenProperties)
+	case SubTypeQueue:
+		return new(QueueProperties)
+	case SubTypeConveyorLeakage:
+		return new(LeakageProperties)
+	case "":
+		if varType == VariableTypeFlow {
+			return new(InflowProperties)
+		}
+	}
+	return new(RawProperties)
+}
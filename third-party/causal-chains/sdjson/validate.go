@@ -0,0 +1,353 @@
+package sdjson
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Severity indicates whether a diagnostic makes a model unusable.
+type Severity string
+
+const (
+	// SeverityError diagnostics describe models that can't be
+	// simulated or drawn as-is.
+	SeverityError Severity = "error"
+	// SeverityWarning diagnostics describe suspicious but usable
+	// models.
+	SeverityWarning Severity = "warning"
+)
+
+// Code identifies the kind of problem a Diagnostic reports.  Codes are
+// stable so that callers can match on them.
+type Code string
+
+const (
+	CodeEmptyName              Code = "empty-name"
+	CodeDuplicateVariable      Code = "duplicate-variable"
+	CodeUnknownVariable        Code = "unknown-variable"
+	CodeSelfRelationship       Code = "self-relationship"
+	CodeDuplicateRelationship  Code = "duplicate-relationship"
+	CodeInvalidPolarity        Code = "invalid-polarity"
+	CodeUnknownFlow            Code = "unknown-flow"
+	CodeNotAFlow               Code = "not-a-flow"
+	CodeFlowsOnNonStock        Code = "flows-on-non-stock"
+	CodeFlowMultipleStocks     Code = "flow-multiple-stocks"
+	CodeMissingEquation        Code = "missing-equation"
+	CodeUnknownDimension       Code = "unknown-dimension"
+	CodeArrayElementsMismatch  Code = "array-elements-mismatch"
+	CodeUnknownGhostSource     Code = "unknown-ghost-source"
+	CodeUnknownModule          Code = "unknown-module"
+	CodeGraphicalFunctionOrder Code = "graphical-function-order"
+	CodeInvalidSpecs           Code = "invalid-specs"
+
+	// Reported by equation.DeriveRelationships, which compares the
+	// declared relationships with the ones the equations imply.
+	CodeInvalidEquation    Code = "invalid-equation"
+	CodePolarityMismatch   Code = "polarity-mismatch"
+	CodeUnusedRelationship Code = "unused-relationship"
+
+	// Reported by causal.Map.Relationships, when the chains of a
+	// causal map disagree about a relationship.
+	CodeConflictingPolarity Code = "conflicting-polarity"
+)
+
+// Diagnostic is a single problem found by Validate.  Variable names the
+// offending variable, and Relationship the offending relationship, when
+// there is one.
+type Diagnostic struct {
+	Code         Code          `json:"code"`
+	Severity     Severity      `json:"severity"`
+	Message      string        `json:"message"`
+	Variable     string        `json:"variable,omitzero"`
+	Relationship *Relationship `json:"relationship,omitzero"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Code, d.Message)
+}
+
+// HasErrors reports whether any of the diagnostics is an error.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+var identSpaceRe = regexp.MustCompile(`(\\n|\\r|\n|\r|\s|\x{00A0}|_)+`)
+
+// identity returns the form used to decide whether two variable names
+// refer to the same variable: like causal.Canonicalize, case and runs of
+// whitespace or underscores are ignored.
+func identity(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		name = name[1 : len(name)-1]
+	}
+	name = identSpaceRe.ReplaceAllString(name, "_")
+	return strings.ToLower(strings.Trim(name, "_"))
+}
+
+// automaticFlows are flow sub-types whose equation is managed by the
+// stock they drain.
+var automaticFlows = map[SubType]bool{
+	SubTypeDiscreteOutflow: true,
+	SubTypeConveyorLeakage: true,
+	SubTypeQueueOutflow:    true,
+	SubTypeQueueOverflow:   true,
+}
+
+type validator struct {
+	m     *Model
+	diags []Diagnostic
+	vars  map[string]*Variable
+}
+
+func (v *validator) report(code Code, severity Severity, variable string, rel *Relationship, format string, args ...any) {
+	d := Diagnostic{
+		Code:     code,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Variable: variable,
+	}
+	if rel != nil {
+		r := *rel
+		d.Relationship = &r
+	}
+	v.diags = append(v.diags, d)
+}
+
+func (v *validator) lookup(name string) *Variable {
+	return v.vars[identity(name)]
+}
+
+// Validate checks that a model is structurally coherent: names are
+// unique, relationships and flows refer to variables that exist, stocks
+// and flows are wired consistently, and arrays, modules and specs are
+// well-formed.  Diagnostics are returned in a stable order: variables,
+// then relationships, then modules and specs.
+func Validate(m *Model) []Diagnostic {
+	v := &validator{
+		m:    m,
+		vars: make(map[string]*Variable, len(m.Variables)),
+	}
+
+	v.indexVariables()
+	v.checkVariables()
+	v.checkFlows()
+	v.checkRelationships()
+	v.checkModules()
+	v.checkSpecs()
+
+	return v.diags
+}
+
+func (v *validator) indexVariables() {
+	for i := range v.m.Variables {
+		variable := &v.m.Variables[i]
+		if strings.TrimSpace(variable.Name) == "" {
+			v.report(CodeEmptyName, SeverityError, "", nil, "variable %d has an empty name", i)
+			continue
+		}
+		id := identity(variable.Name)
+		if other, ok := v.vars[id]; ok {
+			v.report(CodeDuplicateVariable, SeverityError, variable.Name, nil,
+				"variable %q has the same name as %q", variable.Name, other.Name)
+			continue
+		}
+		v.vars[id] = variable
+	}
+}
+
+// quantitative reports whether any variable has an equation; causal
+// loop diagrams legitimately have none.
+func (v *validator) quantitative() bool {
+	for _, variable := range v.m.Variables {
+		if variable.Equation != "" || len(variable.ArrayEquations) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) checkVariables() {
+	dims := make(map[string]ArrayDimension, len(v.m.Specs.ArrayDimensions))
+	for _, d := range v.m.Specs.ArrayDimensions {
+		dims[d.Name] = d
+	}
+	quantitative := v.quantitative()
+
+	for i := range v.m.Variables {
+		variable := &v.m.Variables[i]
+		name := variable.Name
+		hasEquation := variable.Equation != "" || len(variable.ArrayEquations) > 0
+
+		switch {
+		case hasEquation, variable.CrossLevelGhostOf != "":
+		case variable.Type == VariableTypeStock:
+			v.report(CodeMissingEquation, SeverityError, name, nil, "stock %q has no initial value", name)
+		case variable.Type == VariableTypeFlow && automaticFlows[variable.SubType]:
+		case variable.GraphicalFunction != nil:
+		case quantitative:
+			v.report(CodeMissingEquation, SeverityWarning, name, nil, "%s %q has no equation", variable.Type, name)
+		}
+
+		if variable.CrossLevelGhostOf != "" && v.lookup(variable.CrossLevelGhostOf) == nil {
+			v.report(CodeUnknownGhostSource, SeverityError, name, nil,
+				"variable %q is a ghost of unknown variable %q", name, variable.CrossLevelGhostOf)
+		}
+
+		for _, d := range variable.Dimensions {
+			if _, ok := dims[d]; !ok {
+				v.report(CodeUnknownDimension, SeverityError, name, nil, "variable %q uses unknown dimension %q", name, d)
+			}
+		}
+		for _, ae := range variable.ArrayEquations {
+			if len(ae.ForElements) != len(variable.Dimensions) {
+				v.report(CodeArrayElementsMismatch, SeverityError, name, nil,
+					"variable %q has an equation for %d elements but %d dimensions", name, len(ae.ForElements), len(variable.Dimensions))
+				continue
+			}
+			for j, el := range ae.ForElements {
+				d, ok := dims[variable.Dimensions[j]]
+				if ok && !contains(d.Elements, el) {
+					v.report(CodeArrayElementsMismatch, SeverityError, name, nil,
+						"variable %q has an equation for %q, which isn't an element of %q", name, el, d.Name)
+				}
+			}
+		}
+
+		if gf := variable.GraphicalFunction; gf != nil {
+			for j := 1; j < len(gf.Points); j++ {
+				if gf.Points[j].X <= gf.Points[j-1].X {
+					v.report(CodeGraphicalFunctionOrder, SeverityWarning, name, nil,
+						"graphical function for %q has x values that aren't increasing", name)
+					break
+				}
+			}
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) checkFlows() {
+	// the stock each flow fills and drains, for detecting flows wired
+	// to more than one stock on the same side
+	into := make(map[*Variable]string)
+	outOf := make(map[*Variable]string)
+
+	for i := range v.m.Variables {
+		stock := &v.m.Variables[i]
+		if stock.Type != VariableTypeStock {
+			if len(stock.Inflows) > 0 || len(stock.Outflows) > 0 {
+				v.report(CodeFlowsOnNonStock, SeverityWarning, stock.Name, nil,
+					"%s %q lists inflows or outflows, but only stocks have them", stock.Type, stock.Name)
+			}
+			continue
+		}
+
+		check := func(flows []string, direction string, seen map[*Variable]string) {
+			for _, name := range flows {
+				flow := v.lookup(name)
+				switch {
+				case flow == nil:
+					v.report(CodeUnknownFlow, SeverityError, stock.Name, nil,
+						"stock %q has unknown %s %q", stock.Name, direction, name)
+					continue
+				case flow.Type != VariableTypeFlow:
+					v.report(CodeNotAFlow, SeverityError, stock.Name, nil,
+						"stock %q has %s %q, which is a %s", stock.Name, direction, name, flow.Type)
+					continue
+				}
+				if other, ok := seen[flow]; ok && other != stock.Name {
+					v.report(CodeFlowMultipleStocks, SeverityError, flow.Name, nil,
+						"flow %q is an %s of both %q and %q", flow.Name, direction, other, stock.Name)
+					continue
+				}
+				seen[flow] = stock.Name
+			}
+		}
+		check(stock.Inflows, "inflow", into)
+		check(stock.Outflows, "outflow", outOf)
+	}
+}
+
+func (v *validator) checkRelationships() {
+	seen := make(map[string]bool, len(v.m.Relationships))
+
+	for i := range v.m.Relationships {
+		rel := &v.m.Relationships[i]
+		from, to := v.lookup(rel.From), v.lookup(rel.To)
+
+		if from == nil {
+			v.report(CodeUnknownVariable, SeverityError, rel.From, rel,
+				"relationship %s refers to unknown variable %q", rel.Key(), rel.From)
+		}
+		if to == nil {
+			v.report(CodeUnknownVariable, SeverityError, rel.To, rel,
+				"relationship %s refers to unknown variable %q", rel.Key(), rel.To)
+		}
+		if identity(rel.From) == identity(rel.To) {
+			v.report(CodeSelfRelationship, SeverityWarning, rel.From, rel,
+				"relationship %s links a variable to itself", rel.Key())
+		}
+
+		switch rel.Polarity {
+		case "+", "-", "":
+		default:
+			v.report(CodeInvalidPolarity, SeverityError, "", rel,
+				"relationship %s has polarity %q; expected \"+\" or \"-\"", rel.Key(), rel.Polarity)
+		}
+
+		key := identity(rel.From) + "->" + identity(rel.To)
+		if seen[key] {
+			v.report(CodeDuplicateRelationship, SeverityWarning, "", rel,
+				"relationship %s appears more than once", rel.Key())
+		}
+		seen[key] = true
+	}
+}
+
+func (v *validator) checkModules() {
+	modules := make(map[string]bool, len(v.m.Modules))
+	for _, mod := range v.m.Modules {
+		modules[mod.Name] = true
+	}
+
+	for _, mod := range v.m.Modules {
+		if mod.ParentModule != "" && !modules[mod.ParentModule] {
+			v.report(CodeUnknownModule, SeverityError, "", nil,
+				"module %q has unknown parent module %q", mod.Name, mod.ParentModule)
+		}
+	}
+}
+
+func (v *validator) checkSpecs() {
+	specs := v.m.Specs
+
+	if specs.StopTime < specs.StartTime {
+		v.report(CodeInvalidSpecs, SeverityError, "", nil,
+			"stopTime (%g) is before startTime (%g)", specs.StopTime, specs.StartTime)
+	}
+	if specs.DT < 0 {
+		v.report(CodeInvalidSpecs, SeverityError, "", nil, "dt (%g) is negative", specs.DT)
+	}
+	if specs.SaveStep < 0 {
+		v.report(CodeInvalidSpecs, SeverityError, "", nil, "saveStep (%g) is negative", specs.SaveStep)
+	}
+	if specs.SaveStep > 0 && specs.DT > 0 && specs.SaveStep < specs.DT {
+		v.report(CodeInvalidSpecs, SeverityWarning, "", nil,
+			"saveStep (%g) is smaller than dt (%g)", specs.SaveStep, specs.DT)
+	}
+}
@@ -0,0 +1,228 @@
+package sdjson
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validModel() *Model {
+	return &Model{
+		Variables: []Variable{
+			{Name: "Population", Type: VariableTypeStock, Equation: "100", Inflows: []string{"births"}, Outflows: []string{"deaths"}},
+			{Name: "births", Type: VariableTypeFlow, Equation: "Population * birth_rate"},
+			{Name: "deaths", Type: VariableTypeFlow, Equation: "Population / lifetime"},
+			{Name: "birth rate", Type: VariableTypeAux, Equation: "0.03"},
+			{Name: "lifetime", Type: VariableTypeAux, Equation: "70"},
+		},
+		Relationships: []Relationship{
+			{From: "births", To: "Population", Polarity: "+"},
+			{From: "deaths", To: "Population", Polarity: "-"},
+			{From: "Population", To: "births", Polarity: "+"},
+			{From: "birth rate", To: "births", Polarity: "+"},
+			{From: "Population", To: "deaths", Polarity: "+"},
+			{From: "lifetime", To: "deaths", Polarity: "-"},
+		},
+		Specs: Specs{StartTime: 0, StopTime: 100, DT: 0.25},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(m *Model)
+		expected []Diagnostic
+	}{
+		{
+			name:   "valid model",
+			modify: func(m *Model) {},
+		},
+		{
+			name: "causal loop diagram without equations",
+			modify: func(m *Model) {
+				m.Variables = []Variable{{Name: "a"}, {Name: "b"}}
+				m.Relationships = []Relationship{{From: "a", To: "b", Polarity: "+"}}
+			},
+		},
+		{
+			name: "duplicate variable",
+			modify: func(m *Model) {
+				m.Variables = append(m.Variables, Variable{Name: "Birth_Rate", Equation: "0.04"})
+			},
+			expected: []Diagnostic{
+				{Code: CodeDuplicateVariable, Severity: SeverityError, Variable: "Birth_Rate", Message: `variable "Birth_Rate" has the same name as "birth rate"`},
+			},
+		},
+		{
+			name: "empty name",
+			modify: func(m *Model) {
+				m.Variables = append(m.Variables, Variable{Name: " ", Equation: "1"})
+			},
+			expected: []Diagnostic{
+				{Code: CodeEmptyName, Severity: SeverityError, Message: "variable 5 has an empty name"},
+			},
+		},
+		{
+			name: "relationship to unknown variable",
+			modify: func(m *Model) {
+				m.Relationships = append(m.Relationships, Relationship{From: "lifetime", To: "life expectancy", Polarity: "+"})
+			},
+			expected: []Diagnostic{
+				{
+					Code:         CodeUnknownVariable,
+					Severity:     SeverityError,
+					Variable:     "life expectancy",
+					Relationship: &Relationship{From: "lifetime", To: "life expectancy", Polarity: "+"},
+					Message:      `relationship "lifetime"->"life expectancy" refers to unknown variable "life expectancy"`,
+				},
+			},
+		},
+		{
+			name: "self relationship, bad polarity and duplicate",
+			modify: func(m *Model) {
+				m.Relationships = append(m.Relationships,
+					Relationship{From: "lifetime", To: "lifetime", Polarity: "+"},
+					Relationship{From: "Population", To: "Births", Polarity: "positive"},
+				)
+			},
+			expected: []Diagnostic{
+				{
+					Code:         CodeSelfRelationship,
+					Severity:     SeverityWarning,
+					Variable:     "lifetime",
+					Relationship: &Relationship{From: "lifetime", To: "lifetime", Polarity: "+"},
+					Message:      `relationship "lifetime"->"lifetime" links a variable to itself`,
+				},
+				{
+					Code:         CodeInvalidPolarity,
+					Severity:     SeverityError,
+					Relationship: &Relationship{From: "Population", To: "Births", Polarity: "positive"},
+					Message:      `relationship "Population"->"Births" has polarity "positive"; expected "+" or "-"`,
+				},
+				{
+					Code:         CodeDuplicateRelationship,
+					Severity:     SeverityWarning,
+					Relationship: &Relationship{From: "Population", To: "Births", Polarity: "positive"},
+					Message:      `relationship "Population"->"Births" appears more than once`,
+				},
+			},
+		},
+		{
+			name: "inflows that aren't flows",
+			modify: func(m *Model) {
+				m.Variables[0].Inflows = []string{"births", "birth rate", "immigration"}
+			},
+			expected: []Diagnostic{
+				{Code: CodeNotAFlow, Severity: SeverityError, Variable: "Population", Message: `stock "Population" has inflow "birth rate", which is a variable`},
+				{Code: CodeUnknownFlow, Severity: SeverityError, Variable: "Population", Message: `stock "Population" has unknown inflow "immigration"`},
+			},
+		},
+		{
+			name: "flows on a non-stock and a flow into two stocks",
+			modify: func(m *Model) {
+				m.Variables[3].Inflows = []string{"births"}
+				m.Variables = append(m.Variables, Variable{Name: "Adults", Type: VariableTypeStock, Equation: "0", Inflows: []string{"births"}})
+			},
+			expected: []Diagnostic{
+				{Code: CodeFlowsOnNonStock, Severity: SeverityWarning, Variable: "birth rate", Message: `variable "birth rate" lists inflows or outflows, but only stocks have them`},
+				{Code: CodeFlowMultipleStocks, Severity: SeverityError, Variable: "births", Message: `flow "births" is an inflow of both "Population" and "Adults"`},
+			},
+		},
+		{
+			name: "missing equations",
+			modify: func(m *Model) {
+				m.Variables[0].Equation = ""
+				m.Variables[4].Equation = ""
+			},
+			expected: []Diagnostic{
+				{Code: CodeMissingEquation, Severity: SeverityError, Variable: "Population", Message: `stock "Population" has no initial value`},
+				{Code: CodeMissingEquation, Severity: SeverityWarning, Variable: "lifetime", Message: `variable "lifetime" has no equation`},
+			},
+		},
+		{
+			name: "arrays",
+			modify: func(m *Model) {
+				m.Specs.ArrayDimensions = []ArrayDimension{{Type: DimensionLabels, Name: "sex", Size: 2, Elements: []string{"f", "m"}}}
+				m.Variables[4].Dimensions = []string{"sex"}
+				m.Variables[4].ArrayEquations = []ArrayEquation{
+					{Equation: "72", ForElements: []string{"f"}},
+					{Equation: "68", ForElements: []string{"x"}},
+				}
+				m.Variables[3].Dimensions = []string{"age"}
+			},
+			expected: []Diagnostic{
+				{Code: CodeUnknownDimension, Severity: SeverityError, Variable: "birth rate", Message: `variable "birth rate" uses unknown dimension "age"`},
+				{Code: CodeArrayElementsMismatch, Severity: SeverityError, Variable: "lifetime", Message: `variable "lifetime" has an equation for "x", which isn't an element of "sex"`},
+			},
+		},
+		{
+			name: "modules and ghosts",
+			modify: func(m *Model) {
+				m.Modules = []Module{{Name: "Health", ParentModule: "Society"}}
+				m.Variables = append(m.Variables, Variable{Name: "Health.lifetime", CrossLevelGhostOf: "life span"})
+			},
+			expected: []Diagnostic{
+				{Code: CodeUnknownGhostSource, Severity: SeverityError, Variable: "Health.lifetime", Message: `variable "Health.lifetime" is a ghost of unknown variable "life span"`},
+				{Code: CodeUnknownModule, Severity: SeverityError, Message: `module "Health" has unknown parent module "Society"`},
+			},
+		},
+		{
+			name: "graphical function and specs",
+			modify: func(m *Model) {
+				m.Variables[4].GraphicalFunction = &GraphicalFunction{Points: []Point{{X: 0, Y: 1}, {X: 0, Y: 2}}}
+				m.Specs = Specs{StartTime: 10, StopTime: 0, DT: 1, SaveStep: 0.5}
+			},
+			expected: []Diagnostic{
+				{Code: CodeGraphicalFunctionOrder, Severity: SeverityWarning, Variable: "lifetime", Message: `graphical function for "lifetime" has x values that aren't increasing`},
+				{Code: CodeInvalidSpecs, Severity: SeverityError, Message: "stopTime (0) is before startTime (10)"},
+				{Code: CodeInvalidSpecs, Severity: SeverityWarning, Message: "saveStep (0.5) is smaller than dt (1)"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validModel()
+			tt.modify(m)
+
+			diags := Validate(m)
+			assert.Equal(t, tt.expected, diags)
+
+			hasErrors := false
+			for _, d := range tt.expected {
+				hasErrors = hasErrors || d.Severity == SeverityError
+			}
+			assert.Equal(t, hasErrors, HasErrors(diags))
+		})
+	}
+}
+
+func TestValidateFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			for _, d := range Validate(fixture.Model) {
+				assert.NotEqual(t, SeverityError, d.Severity, d.String())
+			}
+		})
+	}
+}
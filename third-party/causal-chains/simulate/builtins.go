@@ -0,0 +1,279 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+)
+
+var builtinReplacer = strings.NewReplacer(" ", "", "_", "")
+
+// builtinName returns a name in the form equation.Call.Builtin uses.
+func builtinName(name string) string {
+	return strings.ToUpper(builtinReplacer.Replace(name))
+}
+
+// timeBuiltins are the names that refer to the simulation clock and
+// specs, in XMILE's and Vensim's spellings.
+var timeBuiltins = map[string]func(s *sim) float64{
+	"TIME":        func(s *sim) float64 { return s.time },
+	"DT":          func(s *sim) float64 { return s.specs.DT },
+	"TIMESTEP":    func(s *sim) float64 { return s.specs.DT },
+	"STARTTIME":   func(s *sim) float64 { return s.specs.StartTime },
+	"INITIALTIME": func(s *sim) float64 { return s.specs.StartTime },
+	"STOPTIME":    func(s *sim) float64 { return s.specs.StopTime },
+	"FINALTIME":   func(s *sim) float64 { return s.specs.StopTime },
+	"SAVEPER":     func(s *sim) float64 { return s.specs.SaveStep },
+	"PI":          func(s *sim) float64 { return math.Pi },
+}
+
+// Value implements equation.Env.
+func (s *sim) Value(ident *equation.Ident, subscripts []equation.Node) (float64, error) {
+	if i, ok := s.idents[ident]; ok {
+		return s.value(i)
+	}
+	if fn, ok := timeBuiltins[builtinName(ident.Name)]; ok {
+		return fn(s), nil
+	}
+	return 0, &Error{Pos: ident.Position, Msg: fmt.Sprintf("unknown variable %q", ident.Name)}
+}
+
+// Call implements equation.Env.
+func (s *sim) Call(call *equation.Call, args []float64) (float64, error) {
+	if gf, ok := s.lookups[call]; ok {
+		return gf.Lookup(args[0]), nil
+	}
+
+	name := call.Builtin()
+	if fn, ok := timeBuiltins[name]; ok && len(args) == 0 {
+		return fn(s), nil
+	}
+
+	arity := func(lo, hi int) error {
+		if len(args) < lo || len(args) > hi {
+			return &Error{Pos: call.Position, Msg: fmt.Sprintf("%s takes %d to %d arguments, found %d", call.Func, lo, hi, len(args))}
+		}
+		return nil
+	}
+
+	switch name {
+	case "STEP":
+		// STEP(height, start)
+		if err := arity(2, 2); err != nil {
+			return 0, err
+		}
+		if s.time >= args[1] {
+			return args[0], nil
+		}
+		return 0, nil
+
+	case "RAMP":
+		// RAMP(slope, start[, end])
+		if err := arity(2, 3); err != nil {
+			return 0, err
+		}
+		t := s.time
+		if len(args) == 3 {
+			t = min(t, args[2])
+		}
+		if t <= args[1] {
+			return 0, nil
+		}
+		return args[0] * (t - args[1]), nil
+
+	case "PULSE":
+		// PULSE(volume, first[, interval]), following XMILE: the volume
+		// is delivered over a single DT
+		if err := arity(1, 3); err != nil {
+			return 0, err
+		}
+		first := s.specs.StartTime
+		if len(args) > 1 {
+			first = args[1]
+		}
+		interval := 0.0
+		if len(args) > 2 {
+			interval = args[2]
+		}
+		if s.pulsing(first, interval) {
+			return args[0] / s.specs.DT, nil
+		}
+		return 0, nil
+	}
+
+	return 0, &Error{Pos: call.Position, Msg: fmt.Sprintf("unsupported function %s", call.Func)}
+}
+
+// pulsing reports whether a pulse starting at first and repeating every
+// interval (or never, if it's 0) is active in the current DT.
+func (s *sim) pulsing(first, interval float64) bool {
+	dt := s.specs.DT
+	since := s.time - first
+	if since < -dt/2 {
+		return false
+	}
+	if interval <= 0 {
+		return math.Abs(since) < dt/2
+	}
+	phase := math.Mod(since+dt/2, interval)
+	return phase < dt
+}
+
+// rewriteBuiltin replaces calls to stateful builtins with placeholders.
+// Other builtins are evaluated by Call.
+func (s *sim) rewriteBuiltin(scope string, call *equation.Call) (equation.Node, error) {
+	name := call.Builtin()
+	args := call.Args
+
+	if name == "INIT" {
+		if len(args) != 1 {
+			return nil, &Error{Variable: scope, Pos: call.Position, Msg: fmt.Sprintf("INIT takes 1 argument, found %d", len(args))}
+		}
+		return s.placeholder(call, &slot{kind: slotInit, name: call.String(), eqn: args[0]}), nil
+	}
+
+	form, ok := delayForms[name]
+	if !ok {
+		return call, nil
+	}
+	if len(args) < form.minArgs || len(args) > form.maxArgs {
+		return nil, &Error{Variable: scope, Pos: call.Position, Msg: fmt.Sprintf("%s takes %d to %d arguments, found %d", call.Func, form.minArgs, form.maxArgs, len(args))}
+	}
+
+	d := &delay{material: form.material, order: form.order, input: args[0], time: args[1]}
+	rest := args[2:]
+	if form.order == 0 {
+		d.orderArg, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 0 {
+		d.init = rest[0]
+	}
+	return s.placeholder(call, &slot{kind: slotDelay, name: call.String(), delay: d}), nil
+}
+
+type delayForm struct {
+	material         bool
+	order            int // 0 if given as an argument
+	minArgs, maxArgs int
+}
+
+// delayForms are the smooths and material delays, as
+// name(input, time[, order][, initial]).
+var delayForms = map[string]delayForm{
+	"SMTH1":    {order: 1, minArgs: 2, maxArgs: 3},
+	"SMOOTH":   {order: 1, minArgs: 2, maxArgs: 2},
+	"SMOOTHI":  {order: 1, minArgs: 3, maxArgs: 3},
+	"SMTH3":    {order: 3, minArgs: 2, maxArgs: 3},
+	"SMOOTH3":  {order: 3, minArgs: 2, maxArgs: 2},
+	"SMOOTH3I": {order: 3, minArgs: 3, maxArgs: 3},
+	"SMTHN":    {order: 0, minArgs: 3, maxArgs: 4},
+	"DELAY1":   {material: true, order: 1, minArgs: 2, maxArgs: 3},
+	"DELAY1I":  {material: true, order: 1, minArgs: 3, maxArgs: 3},
+	"DELAY3":   {material: true, order: 3, minArgs: 2, maxArgs: 3},
+	"DELAY3I":  {material: true, order: 3, minArgs: 3, maxArgs: 3},
+	"DELAYN":   {material: true, order: 0, minArgs: 3, maxArgs: 4},
+}
+
+// delay is an instance of a smooth or material delay: a chain of
+// first-order stages, each with an equal share of the delay time.  A
+// smooth's stages hold the smoothed value; a material delay's stages
+// hold the material in transit, and its output is the outflow of the
+// last stage.
+type delay struct {
+	material bool
+	order    int
+	input    equation.Node
+	time     equation.Node
+	orderArg equation.Node
+	init     equation.Node // defaults to the input
+	// state is the index of the first stage in the state vector
+	state int
+}
+
+func (d *delay) initialOutput(s *sim) (float64, error) {
+	if d.init != nil {
+		return s.eval(d.init)
+	}
+	return s.eval(d.input)
+}
+
+// initialize returns the initial values of the stages, and fixes the
+// order if it's given as an argument.
+func (d *delay) initialize(s *sim) ([]float64, error) {
+	if d.orderArg != nil {
+		order, err := s.eval(d.orderArg)
+		if err != nil {
+			return nil, err
+		}
+		if order < 1 {
+			return nil, fmt.Errorf("delay order %g is less than 1", order)
+		}
+		d.order = int(math.Round(order))
+	}
+
+	out, err := d.initialOutput(s)
+	if err != nil {
+		return nil, err
+	}
+	if d.material {
+		// each stage holds its outflow times its share of the delay
+		t, err := s.eval(d.time)
+		if err != nil {
+			return nil, err
+		}
+		out *= t / float64(d.order)
+	}
+
+	stages := make([]float64, d.order)
+	for i := range stages {
+		stages[i] = out
+	}
+	return stages, nil
+}
+
+func (d *delay) stageTime(s *sim) (float64, error) {
+	t, err := s.eval(d.time)
+	if err != nil {
+		return 0, err
+	}
+	// a delay shorter than DT would overshoot
+	return max(t/float64(d.order), s.specs.DT), nil
+}
+
+func (d *delay) output(s *sim) (float64, error) {
+	last := s.state[d.state+d.order-1]
+	if !d.material {
+		return last, nil
+	}
+	t, err := d.stageTime(s)
+	if err != nil {
+		return 0, err
+	}
+	return last / t, nil
+}
+
+func (d *delay) derivatives(s *sim, deriv []float64) error {
+	in, err := s.eval(d.input)
+	if err != nil {
+		return err
+	}
+	t, err := d.stageTime(s)
+	if err != nil {
+		return err
+	}
+
+	stages := s.state[d.state : d.state+d.order]
+	for i, level := range stages {
+		if d.material {
+			outflow := level / t
+			deriv[d.state+i] = in - outflow
+			in = outflow
+		} else {
+			deriv[d.state+i] = (in - level) / t
+			in = level
+		}
+	}
+	return nil
+}
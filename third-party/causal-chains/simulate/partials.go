@@ -0,0 +1,194 @@
+package simulate
+
+import (
+	"slices"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// Link is a causal link from one variable to another, named as in the
+// model.
+type Link struct {
+	From, To string
+}
+
+// Partials holds how much of each aux's and flow's change comes from
+// each of its inputs.  Partials[link][i] is how much link.To would have
+// changed from Time[i-1] to Time[i] if link.From had changed as it did,
+// but everything else in its equation, including TIME, had kept its
+// value at Time[i-1].  The first value of each series is 0.
+//
+// The inputs of a smooth or delay in an equation count as inputs of the
+// equation, changing the smooth's or delay's output with them.  Stocks
+// have no partials, since their change is the sum of their flows.
+type Partials map[Link][]float64
+
+// RunPartials is like Run, but it saves results every DT, and also
+// returns the partial changes of every aux and flow.
+func RunPartials(m *sdjson.Model) (*Results, Partials, error) {
+	s, err := compile(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.specs.SaveStep = s.specs.DT
+	s.partials = &partialRecorder{partials: make(Partials)}
+	results, err := s.run()
+	if err != nil {
+		return nil, nil, err
+	}
+	return results, s.partials.partials, nil
+}
+
+type partialRecorder struct {
+	partials Partials
+	// inputs are the variables' inputs, found when the first frame is
+	// recorded
+	inputs []partialInput
+	// the previous frame's values of every slot
+	prev     []float64
+	prevTime float64
+}
+
+// partialInput is an input of a variable's equation, and the slots that
+// change with it: the input itself, and any smooths or delays of it.
+type partialInput struct {
+	to, from int
+	changed  []int
+}
+
+// findInputs lists the inputs of every aux and flow.
+func (p *partialRecorder) findInputs(s *sim) {
+	for to := range s.m.Variables {
+		sl := s.slots[to]
+		if sl.kind != slotAux || sl.eqn == nil {
+			continue
+		}
+		var inputs []partialInput
+		add := func(from, changed int) {
+			i := slices.IndexFunc(inputs, func(in partialInput) bool { return in.from == from })
+			if i < 0 {
+				i = len(inputs)
+				inputs = append(inputs, partialInput{to: to, from: from})
+			}
+			if !slices.Contains(inputs[i].changed, changed) {
+				inputs[i].changed = append(inputs[i].changed, changed)
+			}
+		}
+		for _, ident := range equation.Idents(sl.eqn) {
+			k, ok := s.idents[ident]
+			if !ok {
+				continue
+			}
+			if k < len(s.m.Variables) {
+				add(k, k)
+				continue
+			}
+			for _, from := range s.sources(k) {
+				add(from, k)
+			}
+		}
+		p.inputs = append(p.inputs, inputs...)
+	}
+
+	for _, in := range p.inputs {
+		link := Link{From: s.slots[in.from].name, To: s.slots[in.to].name}
+		p.partials[link] = []float64{0}
+	}
+}
+
+// sources returns the variables the arguments of a smooth or delay
+// refer to, directly or through other smooths and delays.  INIT's
+// output never changes, so it has none.
+func (s *sim) sources(k int) []int {
+	sl := s.slots[k]
+	if sl.kind != slotDelay {
+		return nil
+	}
+	var sources []int
+	for _, arg := range []equation.Node{sl.delay.input, sl.delay.time, sl.delay.orderArg, sl.delay.init} {
+		if arg == nil {
+			continue
+		}
+		for _, ident := range equation.Idents(arg) {
+			j, ok := s.idents[ident]
+			if !ok {
+				continue
+			}
+			found := []int{j}
+			if j >= len(s.m.Variables) {
+				found = s.sources(j)
+			}
+			for _, f := range found {
+				if !slices.Contains(sources, f) {
+					sources = append(sources, f)
+				}
+			}
+		}
+	}
+	return sources
+}
+
+// record evaluates the partial changes since the previous frame, and
+// saves the current frame for the next.
+func (p *partialRecorder) record(s *sim) error {
+	values := make([]float64, len(s.slots))
+	for i, sl := range s.slots {
+		if sl.kind == slotAux && sl.eqn == nil {
+			continue
+		}
+		v, err := s.value(i)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+
+	if p.prev == nil {
+		p.findInputs(s)
+	} else {
+		now := s.time
+		s.time = p.prevTime
+		for _, in := range p.inputs {
+			sl := s.slots[in.to]
+			env := &partialEnv{sim: s, prev: p.prev, values: values, changed: in.changed}
+			v, err := equation.Eval(sl.eqn, env)
+			if err != nil {
+				s.time = now
+				return err
+			}
+			if sl.gf != nil {
+				v = sl.gf.Lookup(v)
+			}
+			if sl.uniflow {
+				v = max(v, 0)
+			}
+			link := Link{From: s.slots[in.from].name, To: sl.name}
+			p.partials[link] = append(p.partials[link], v-p.prev[in.to])
+		}
+		s.time = now
+	}
+
+	p.prev, p.prevTime = values, s.time
+	return nil
+}
+
+// partialEnv evaluates an equation with the changed slots at their
+// current values, and everything else at its previous values.
+type partialEnv struct {
+	*sim
+	prev, values []float64
+	changed      []int
+}
+
+// Value implements equation.Env.
+func (e *partialEnv) Value(ident *equation.Ident, subscripts []equation.Node) (float64, error) {
+	i, ok := e.idents[ident]
+	if !ok {
+		return e.sim.Value(ident, subscripts)
+	}
+	if slices.Contains(e.changed, i) {
+		return e.values[i], nil
+	}
+	return e.prev[i], nil
+}
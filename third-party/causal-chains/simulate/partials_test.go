@@ -0,0 +1,64 @@
+package simulate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestRunPartials(t *testing.T) {
+	t.Run("product", func(t *testing.T) {
+		m := &sdjson.Model{
+			Variables: []sdjson.Variable{aux("z", "x * y"), aux("x", "TIME"), aux("y", "TIME + 1")},
+			Specs:     sdjson.Specs{StartTime: 0, StopTime: 3},
+		}
+		r, partials, err := RunPartials(m)
+		require.NoError(t, err)
+		assert.Equal(t, []float64{0, 1, 2, 3}, r.Time)
+		assert.Equal(t, Partials{
+			{From: "x", To: "z"}: {0, 1, 2, 3},
+			{From: "y", To: "z"}: {0, 0, 1, 2},
+		}, partials)
+	})
+
+	t.Run("time is held", func(t *testing.T) {
+		m := &sdjson.Model{
+			Variables: []sdjson.Variable{aux("z", "x + STEP(10, 2)"), aux("x", "TIME")},
+			Specs:     sdjson.Specs{StartTime: 0, StopTime: 3},
+		}
+		_, partials, err := RunPartials(m)
+		require.NoError(t, err)
+		assert.Equal(t, Partials{{From: "x", To: "z"}: {0, 1, 1, 1}}, partials)
+	})
+
+	t.Run("saves every DT", func(t *testing.T) {
+		m := growth(sdjson.IntegrationEuler)
+		m.Specs.SaveStep = 2
+		r, partials, err := RunPartials(m)
+		require.NoError(t, err)
+		assert.Len(t, r.Time, 21)
+
+		// births only depend on the population, so its partial is the
+		// whole change
+		births := r.Values["births"]
+		require.Len(t, partials[Link{From: "population", To: "births"}], len(births))
+		for i := 1; i < len(births); i++ {
+			assert.InDelta(t, births[i]-births[i-1], partials[Link{From: "population", To: "births"}][i], 1e-9)
+			assert.Zero(t, partials[Link{From: "rate", To: "births"}][i])
+		}
+	})
+
+	t.Run("smooths change with their input", func(t *testing.T) {
+		m := &sdjson.Model{
+			Variables: []sdjson.Variable{aux("x", "2 * SMTH1(input, 4)"), aux("input", "STEP(10, 1)")},
+			Specs:     sdjson.Specs{StartTime: 0, StopTime: 3},
+		}
+		r, partials, err := RunPartials(m)
+		require.NoError(t, err)
+		assert.InDeltaSlice(t, []float64{0, 0, 5, 3.75}, partials[Link{From: "input", To: "x"}], 1e-9)
+		assert.InDeltaSlice(t, []float64{0, 0, 5, 8.75}, r.Values["x"], 1e-9)
+	})
+}
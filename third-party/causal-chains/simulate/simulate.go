@@ -0,0 +1,522 @@
+// Package simulate runs sdjson models without any external tools.
+//
+// Models are integrated over their Specs with Euler's method or RK4.
+// Equations can use the operators and math functions the equation
+// package evaluates, graphical function lookups, TIME, DT, STEP, PULSE
+// and RAMP, and the smooths and material delays (SMTH1, SMTH3, SMTHN,
+// DELAY1, DELAY3, DELAYN and their Vensim spellings), as well as INIT.
+// Arrays, conveyors and queues aren't supported.
+//
+// RunPartials also reports how much of each variable's change comes from
+// each of its inputs, for loop dominance analysis.
+package simulate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// Results holds the time series from a simulation: Values[name][i] is
+// the value of the variable with that name at Time[i].  Every variable
+// has a series except lookup tables, which only have a value when
+// called.
+type Results struct {
+	Time   []float64
+	Values map[string][]float64
+}
+
+// Error is a problem with a variable's equation found while compiling
+// or running the model, such as a name that refers to no variable or a
+// function it doesn't support, as opposed to an
+// equation.SyntaxError in the equation's text.
+type Error struct {
+	// Variable is the name of the variable whose equation it's in.
+	Variable string
+	Pos      equation.Pos
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Run simulates a model from Specs.StartTime to Specs.StopTime, saving
+// results every Specs.SaveStep.  DT defaults to 1, and SaveStep to DT.
+func Run(m *sdjson.Model) (*Results, error) {
+	s, err := compile(m)
+	if err != nil {
+		return nil, err
+	}
+	return s.run()
+}
+
+type slotKind int
+
+const (
+	slotAux slotKind = iota
+	slotStock
+	slotGhost
+	slotDelay // the output of a smooth or delay
+	slotInit  // the value of an INIT call
+)
+
+// slot is a value computed in every frame: a model variable, or the
+// output of a stateful builtin call.
+type slot struct {
+	kind slotKind
+	name string
+	// eqn is the equation of an aux or flow, the initial value of a
+	// stock, or the argument of INIT
+	eqn equation.Node
+	gf  *sdjson.GraphicalFunction
+	// uniflow flows are never negative
+	uniflow bool
+	// source is the slot a ghost copies
+	source int
+	// stock is the index of a stock's value in the state vector, and
+	// inflows and outflows are its flows' slots
+	stock             int
+	inflows, outflows []int
+	delay             *delay
+	// initial is the value an INIT call captured
+	initial float64
+}
+
+const (
+	statusPending = iota
+	statusBusy
+	statusDone
+)
+
+type sim struct {
+	m      *sdjson.Model
+	specs  sdjson.Specs
+	slots  []*slot
+	stocks []int
+	index  map[*sdjson.Variable]int
+
+	// idents and lookups resolve the names in equations
+	idents  map[*equation.Ident]int
+	lookups map[*equation.Call]*sdjson.GraphicalFunction
+
+	// the frame being evaluated
+	time         float64
+	state        []float64
+	values       []float64
+	status       []uint8
+	initializing bool
+
+	// partials, if not nil, collects the partial changes RunPartials
+	// returns
+	partials *partialRecorder
+}
+
+func compile(m *sdjson.Model) (*sim, error) {
+	s := &sim{
+		m:       m,
+		specs:   m.Specs,
+		idents:  make(map[*equation.Ident]int),
+		lookups: make(map[*equation.Call]*sdjson.GraphicalFunction),
+	}
+	if s.specs.DT <= 0 {
+		s.specs.DT = 1
+	}
+	if s.specs.SaveStep <= 0 {
+		s.specs.SaveStep = s.specs.DT
+	}
+	switch s.specs.IntegrationMethod {
+	case "", sdjson.IntegrationEuler, sdjson.IntegrationRK4:
+	default:
+		return nil, fmt.Errorf("unsupported integration method %q", s.specs.IntegrationMethod)
+	}
+	if s.specs.StopTime < s.specs.StartTime {
+		return nil, fmt.Errorf("stopTime (%g) is before startTime (%g)", s.specs.StopTime, s.specs.StartTime)
+	}
+
+	// every variable gets a slot up front, so equations can refer to
+	// variables defined later
+	s.index = make(map[*sdjson.Variable]int, len(m.Variables))
+	for i := range m.Variables {
+		s.index[&m.Variables[i]] = len(s.slots)
+		s.slots = append(s.slots, &slot{name: m.Variables[i].Name})
+	}
+
+	for i := range m.Variables {
+		v := &m.Variables[i]
+		if err := s.compileVariable(v, s.slots[i]); err != nil {
+			return nil, fmt.Errorf("variable %q: %w", v.Name, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *sim) compileVariable(v *sdjson.Variable, sl *slot) error {
+	if len(v.Dimensions) > 0 {
+		return errors.New("arrayed variables aren't supported")
+	}
+
+	if v.CrossLevelGhostOf != "" {
+		source := s.m.Variable(v.CrossLevelGhostOf)
+		if source == nil {
+			return fmt.Errorf("ghost of unknown variable %q", v.CrossLevelGhostOf)
+		}
+		sl.kind = slotGhost
+		sl.source = s.index[source]
+		return nil
+	}
+
+	switch v.SubType {
+	case sdjson.SubTypeConveyor, sdjson.SubTypeQueue:
+		return fmt.Errorf("%s stocks aren't supported", v.SubType)
+	}
+
+	if v.Type == sdjson.VariableTypeStock {
+		sl.kind = slotStock
+		sl.stock = len(s.stocks)
+		s.stocks = append(s.stocks, s.index[v])
+		for _, name := range v.Inflows {
+			flow := s.m.Resolve(v.Name, name)
+			if flow == nil {
+				return fmt.Errorf("unknown inflow %q", name)
+			}
+			sl.inflows = append(sl.inflows, s.index[flow])
+		}
+		for _, name := range v.Outflows {
+			flow := s.m.Resolve(v.Name, name)
+			if flow == nil {
+				return fmt.Errorf("unknown outflow %q", name)
+			}
+			sl.outflows = append(sl.outflows, s.index[flow])
+		}
+	}
+
+	sl.gf = v.GraphicalFunction
+	sl.uniflow = v.Type == sdjson.VariableTypeFlow && v.Uniflow != nil && *v.Uniflow
+	if v.Equation == "" {
+		if v.GraphicalFunction != nil {
+			// a lookup table, only evaluated when called
+			sl.kind = slotAux
+			return nil
+		}
+		return errors.New("no equation")
+	}
+
+	n, err := equation.Parse(v.Equation)
+	if err != nil {
+		return err
+	}
+	sl.eqn, err = s.rewrite(v.Name, n)
+	return err
+}
+
+// rewrite resolves the names in an equation, and replaces each call to a
+// stateful builtin with a reference to a slot holding its output, so
+// that the call's arguments are only evaluated when its state needs
+// them.
+func (s *sim) rewrite(scope string, n equation.Node) (equation.Node, error) {
+	var err error
+	rewriteAll := func(nodes []equation.Node) {
+		for i := range nodes {
+			if err == nil {
+				nodes[i], err = s.rewrite(scope, nodes[i])
+			}
+		}
+	}
+
+	switch n := n.(type) {
+	case *equation.Ident:
+		if v := s.m.Resolve(scope, n.Name); v != nil {
+			s.idents[n] = s.index[v]
+			return n, nil
+		}
+		if _, ok := timeBuiltins[builtinName(n.Name)]; ok {
+			return n, nil
+		}
+		return nil, &Error{Variable: scope, Pos: n.Position, Msg: fmt.Sprintf("unknown variable %q", n.Name)}
+
+	case *equation.Index:
+		return nil, &Error{Variable: scope, Pos: n.Pos(), Msg: "arrays aren't supported"}
+
+	case *equation.Unary:
+		n.X, err = s.rewrite(scope, n.X)
+	case *equation.Binary:
+		n.X, err = s.rewrite(scope, n.X)
+		if err == nil {
+			n.Y, err = s.rewrite(scope, n.Y)
+		}
+	case *equation.If:
+		nodes := []equation.Node{n.Cond, n.Then, n.Else}
+		rewriteAll(nodes)
+		n.Cond, n.Then, n.Else = nodes[0], nodes[1], nodes[2]
+
+	case *equation.Call:
+		rewriteAll(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		if v := s.m.Resolve(scope, n.Func); v != nil && v.GraphicalFunction != nil {
+			if len(n.Args) != 1 {
+				return nil, &Error{Variable: scope, Pos: n.Position, Msg: fmt.Sprintf("lookup %s takes 1 argument, found %d", n.Func, len(n.Args))}
+			}
+			s.lookups[n] = v.GraphicalFunction
+			return n, nil
+		}
+		return s.rewriteBuiltin(scope, n)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// placeholder returns an identifier referring to a new slot.
+func (s *sim) placeholder(call *equation.Call, sl *slot) equation.Node {
+	ident := &equation.Ident{Name: call.String(), Position: call.Position}
+	s.idents[ident] = len(s.slots)
+	s.slots = append(s.slots, sl)
+	return ident
+}
+
+func (s *sim) run() (*Results, error) {
+	if err := s.initialize(); err != nil {
+		return nil, err
+	}
+
+	results := &Results{Values: make(map[string][]float64)}
+	record := func() error {
+		results.Time = append(results.Time, s.time)
+		for i := range s.m.Variables {
+			sl := s.slots[i]
+			if sl.kind == slotAux && sl.eqn == nil {
+				continue
+			}
+			v, err := s.value(i)
+			if err != nil {
+				return err
+			}
+			results.Values[sl.name] = append(results.Values[sl.name], v)
+		}
+		if s.partials != nil {
+			return s.partials.record(s)
+		}
+		return nil
+	}
+
+	dt := s.specs.DT
+	steps := int(math.Round((s.specs.StopTime - s.specs.StartTime) / dt))
+	saveEvery := max(1, int(math.Round(s.specs.SaveStep/dt)))
+
+	d := make([]float64, len(s.state))
+	for step := 0; ; step++ {
+		t := s.specs.StartTime + float64(step)*dt
+		s.frame(t, s.state)
+		if step%saveEvery == 0 || step == steps {
+			if err := record(); err != nil {
+				return nil, err
+			}
+		}
+		if step == steps {
+			break
+		}
+
+		var err error
+		if s.specs.IntegrationMethod == sdjson.IntegrationRK4 {
+			err = s.rk4(t, d)
+		} else {
+			err = s.derivatives(d)
+			for i := range s.state {
+				s.state[i] += dt * d[i]
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// initialize evaluates the initial values of stocks and stateful
+// builtins, and sets up the state vector.
+func (s *sim) initialize() error {
+	s.frame(s.specs.StartTime, nil)
+	s.initializing = true
+	defer func() { s.initializing = false }()
+
+	var state []float64
+	for _, i := range s.stocks {
+		v, err := s.value(i)
+		if err != nil {
+			return err
+		}
+		state = append(state, v)
+	}
+	for i, sl := range s.slots {
+		switch sl.kind {
+		case slotInit:
+			v, err := s.value(i)
+			if err != nil {
+				return err
+			}
+			sl.initial = v
+		case slotDelay:
+			stages, err := sl.delay.initialize(s)
+			if err != nil {
+				return err
+			}
+			sl.delay.state = len(state)
+			state = append(state, stages...)
+		}
+	}
+	s.state = state
+	return nil
+}
+
+// frame starts evaluating the model at time t, with the given state.
+func (s *sim) frame(t float64, state []float64) {
+	s.time = t
+	s.state = state
+	if len(s.values) != len(s.slots) {
+		s.values = make([]float64, len(s.slots))
+		s.status = make([]uint8, len(s.slots))
+	}
+	clear(s.status)
+}
+
+// derivatives computes the rate of change of the state vector in the
+// current frame.
+func (s *sim) derivatives(d []float64) error {
+	for _, i := range s.stocks {
+		sl := s.slots[i]
+		var net float64
+		for _, flow := range sl.inflows {
+			v, err := s.value(flow)
+			if err != nil {
+				return err
+			}
+			net += v
+		}
+		for _, flow := range sl.outflows {
+			v, err := s.value(flow)
+			if err != nil {
+				return err
+			}
+			net -= v
+		}
+		d[sl.stock] = net
+	}
+	for _, sl := range s.slots {
+		if sl.kind == slotDelay {
+			if err := sl.delay.derivatives(s, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sim) rk4(t float64, d []float64) error {
+	dt := s.specs.DT
+	state := s.state
+	n := len(state)
+	k := [4][]float64{d, make([]float64, n), make([]float64, n), make([]float64, n)}
+	tmp := make([]float64, n)
+
+	if err := s.derivatives(k[0]); err != nil {
+		return err
+	}
+	for stage, h := range []float64{dt / 2, dt / 2, dt} {
+		for i := range tmp {
+			tmp[i] = state[i] + h*k[stage][i]
+		}
+		s.frame(t+h, tmp)
+		if err := s.derivatives(k[stage+1]); err != nil {
+			return err
+		}
+	}
+
+	for i := range state {
+		state[i] += dt / 6 * (k[0][i] + 2*k[1][i] + 2*k[2][i] + k[3][i])
+	}
+	s.state = state
+	return nil
+}
+
+// value returns the value of a slot in the current frame, computing it
+// if needed.
+func (s *sim) value(i int) (float64, error) {
+	switch s.status[i] {
+	case statusDone:
+		return s.values[i], nil
+	case statusBusy:
+		return 0, fmt.Errorf("%q depends on itself (simultaneous equations)", s.slots[i].name)
+	}
+
+	s.status[i] = statusBusy
+	v, err := s.compute(s.slots[i])
+	if err != nil {
+		s.status[i] = statusPending
+		return 0, err
+	}
+	s.values[i] = v
+	s.status[i] = statusDone
+	return v, nil
+}
+
+func (s *sim) compute(sl *slot) (float64, error) {
+	switch sl.kind {
+	case slotGhost:
+		return s.value(sl.source)
+
+	case slotStock:
+		if !s.initializing {
+			return s.state[sl.stock], nil
+		}
+
+	case slotDelay:
+		if s.initializing {
+			return sl.delay.initialOutput(s)
+		}
+		return sl.delay.output(s)
+
+	case slotInit:
+		if !s.initializing {
+			return sl.initial, nil
+		}
+	}
+
+	if sl.eqn == nil {
+		return 0, fmt.Errorf("lookup %q needs an input", sl.name)
+	}
+	v, err := equation.Eval(sl.eqn, s)
+	if err != nil {
+		// the environment doesn't know whose equation it's evaluating
+		var e *Error
+		var evalErr *equation.EvalError
+		switch {
+		case errors.As(err, &e) && e.Variable == "":
+			e.Variable = sl.name
+		case errors.As(err, &evalErr):
+			err = &Error{Variable: sl.name, Pos: evalErr.Pos, Msg: evalErr.Msg}
+		}
+		return 0, fmt.Errorf("%q: %w", sl.name, err)
+	}
+	if sl.gf != nil {
+		v = sl.gf.Lookup(v)
+	}
+	if sl.uniflow {
+		v = max(v, 0)
+	}
+	return v, nil
+}
+
+// eval evaluates an argument of a stateful builtin in the current frame.
+func (s *sim) eval(n equation.Node) (float64, error) {
+	return equation.Eval(n, s)
+}
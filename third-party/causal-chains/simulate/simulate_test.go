@@ -0,0 +1,221 @@
+package simulate
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func aux(name, eqn string) sdjson.Variable {
+	return sdjson.Variable{Name: name, Type: sdjson.VariableTypeAux, Equation: eqn}
+}
+
+func growth(method sdjson.IntegrationMethod) *sdjson.Model {
+	return &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "population", Type: sdjson.VariableTypeStock, Equation: "100", Inflows: []string{"births"}},
+			{Name: "births", Type: sdjson.VariableTypeFlow, Equation: "population * rate"},
+			aux("rate", "0.1"),
+		},
+		Specs: sdjson.Specs{StartTime: 0, StopTime: 10, DT: 0.5, IntegrationMethod: method},
+	}
+}
+
+func TestRunGrowth(t *testing.T) {
+	t.Run("Euler", func(t *testing.T) {
+		r, err := Run(growth(sdjson.IntegrationEuler))
+		require.NoError(t, err)
+		require.Len(t, r.Time, 21)
+		for i, p := range r.Values["population"] {
+			assert.InDelta(t, 100*math.Pow(1.05, float64(i)), p, 1e-9)
+		}
+		assert.Equal(t, []float64{100, 10}, []float64{r.Values["population"][0], r.Values["births"][0]})
+	})
+
+	t.Run("RK4", func(t *testing.T) {
+		r, err := Run(growth(sdjson.IntegrationRK4))
+		require.NoError(t, err)
+		for i, p := range r.Values["population"] {
+			assert.InEpsilon(t, 100*math.Exp(0.1*r.Time[i]), p, 1e-5)
+		}
+	})
+}
+
+func TestRunBuiltins(t *testing.T) {
+	uniflow := true
+	tests := []struct {
+		name string
+		vars []sdjson.Variable
+		want []float64
+	}{
+		{"time", []sdjson.Variable{aux("x", "TIME")}, []float64{0, 1, 2, 3, 4}},
+		{"dt", []sdjson.Variable{aux("x", "DT + STOPTIME")}, []float64{5, 5, 5, 5, 5}},
+		{"step", []sdjson.Variable{aux("x", "STEP(5, 2)")}, []float64{0, 0, 5, 5, 5}},
+		{"ramp", []sdjson.Variable{aux("x", "RAMP(2, 1)")}, []float64{0, 0, 2, 4, 6}},
+		{"ramp end", []sdjson.Variable{aux("x", "RAMP(2, 1, 2)")}, []float64{0, 0, 2, 2, 2}},
+		{"pulse", []sdjson.Variable{aux("x", "PULSE(3, 1)")}, []float64{0, 3, 0, 0, 0}},
+		{"pulse train", []sdjson.Variable{aux("x", "PULSE(3, 1, 2)")}, []float64{0, 3, 0, 3, 0}},
+		{"if", []sdjson.Variable{aux("x", "IF TIME > 2 THEN 1 ELSE -1")}, []float64{-1, -1, -1, 1, 1}},
+		{"min max", []sdjson.Variable{aux("x", "MIN(MAX(TIME, 1), 3)")}, []float64{1, 1, 2, 3, 3}},
+		{"init", []sdjson.Variable{aux("x", "INIT(y)"), aux("y", "TIME + 2")}, []float64{2, 2, 2, 2, 2}},
+		{
+			"lookup",
+			[]sdjson.Variable{
+				aux("x", "effect(TIME)"),
+				{Name: "effect", GraphicalFunction: &sdjson.GraphicalFunction{Points: []sdjson.Point{{X: 0, Y: 0}, {X: 2, Y: 1}}}},
+			},
+			[]float64{0, 0.5, 1, 1, 1},
+		},
+		{
+			"graphical function",
+			[]sdjson.Variable{{
+				Name: "x", Equation: "TIME",
+				GraphicalFunction: &sdjson.GraphicalFunction{Points: []sdjson.Point{{X: 0, Y: 10}, {X: 4, Y: 0}}},
+			}},
+			[]float64{10, 7.5, 5, 2.5, 0},
+		},
+		{
+			"uniflow",
+			[]sdjson.Variable{
+				{Name: "s", Type: sdjson.VariableTypeStock, Equation: "0", Inflows: []string{"x"}},
+				{Name: "x", Type: sdjson.VariableTypeFlow, Equation: "TIME - 2", Uniflow: &uniflow},
+			},
+			[]float64{0, 0, 0, 1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &sdjson.Model{Variables: tt.vars, Specs: sdjson.Specs{StartTime: 0, StopTime: 4}}
+			r, err := Run(m)
+			require.NoError(t, err)
+			assert.Equal(t, []float64{0, 1, 2, 3, 4}, r.Time)
+			assert.InDeltaSlice(t, tt.want, r.Values["x"], 1e-9)
+		})
+	}
+}
+
+func TestRunSaveStep(t *testing.T) {
+	m := growth(sdjson.IntegrationEuler)
+	m.Specs.SaveStep = 2
+	r, err := Run(m)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0, 2, 4, 6, 8, 10}, r.Time)
+	assert.InDelta(t, 100*math.Pow(1.05, 4), r.Values["population"][1], 1e-9)
+}
+
+func TestRunDelays(t *testing.T) {
+	t.Run("smooth", func(t *testing.T) {
+		m := &sdjson.Model{
+			Variables: []sdjson.Variable{aux("x", "SMTH1(input, 4)"), aux("input", "STEP(10, 1)")},
+			Specs:     sdjson.Specs{StartTime: 0, StopTime: 3},
+		}
+		r, err := Run(m)
+		require.NoError(t, err)
+		assert.InDeltaSlice(t, []float64{0, 0, 2.5, 4.375}, r.Values["x"], 1e-9)
+	})
+
+	t.Run("material delay conserves its input", func(t *testing.T) {
+		m := &sdjson.Model{
+			Variables: []sdjson.Variable{
+				{Name: "in transit", Type: sdjson.VariableTypeStock, Equation: "0", Inflows: []string{"shipments"}, Outflows: []string{"arrivals"}},
+				{Name: "shipments", Type: sdjson.VariableTypeFlow, Equation: "5"},
+				{Name: "arrivals", Type: sdjson.VariableTypeFlow, Equation: "DELAY3(shipments, 6)"},
+			},
+			Specs: sdjson.Specs{StartTime: 0, StopTime: 20, DT: 0.25},
+		}
+		r, err := Run(m)
+		require.NoError(t, err)
+		for _, a := range r.Values["arrivals"] {
+			assert.InDelta(t, 5, a, 1e-9)
+		}
+	})
+
+	t.Run("initial value", func(t *testing.T) {
+		m := &sdjson.Model{
+			Variables: []sdjson.Variable{aux("x", "SMTH3(0, 3, 9)")},
+			Specs:     sdjson.Specs{StartTime: 0, StopTime: 3, DT: 0.5},
+		}
+		r, err := Run(m)
+		require.NoError(t, err)
+		assert.InDelta(t, 9, r.Values["x"][0], 1e-9)
+		assert.Less(t, r.Values["x"][6], 9.0)
+	})
+}
+
+func TestRunErrors(t *testing.T) {
+	// variable is the variable an Error names, if it's one
+	tests := []struct {
+		name     string
+		vars     []sdjson.Variable
+		want     string
+		variable string
+	}{
+		{"simultaneous", []sdjson.Variable{aux("a", "b"), aux("b", "a")}, "depends on itself", ""},
+		{"unknown variable", []sdjson.Variable{aux("a", "b + 1")}, `unknown variable "b"`, "a"},
+		{"unsupported function", []sdjson.Variable{aux("a", "RANDOM(1)")}, "unsupported function RANDOM", "a"},
+		{"arguments", []sdjson.Variable{aux("a", "1"), aux("b", "a + STEP(1)")}, "STEP takes 2 to 2 arguments", "b"},
+		{"delay arguments", []sdjson.Variable{aux("a", "SMTH1(1)")}, "SMTH1 takes", "a"},
+		{"math arguments", []sdjson.Variable{aux("a", "EXP(1, 2)")}, "EXP takes 1 arguments", "a"},
+		{"no equation", []sdjson.Variable{aux("a", "")}, `variable "a"`, ""},
+		{"arrays", []sdjson.Variable{aux("a", "b[1]"), aux("b", "1")}, "arrays aren't supported", "a"},
+		{"syntax", []sdjson.Variable{aux("a", "1 +")}, `variable "a"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &sdjson.Model{Variables: tt.vars, Specs: sdjson.Specs{StartTime: 0, StopTime: 1}}
+			_, err := Run(m)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+
+			var e *Error
+			if tt.variable == "" {
+				assert.False(t, errors.As(err, &e), "not a simulate.Error")
+				return
+			}
+			require.True(t, errors.As(err, &e), "a simulate.Error")
+			assert.Equal(t, tt.variable, e.Variable)
+			assert.Positive(t, e.Pos.Column)
+			var syntax *equation.SyntaxError
+			assert.False(t, errors.As(err, &syntax), "not a syntax error")
+		})
+	}
+
+	_, err := Run(&sdjson.Model{Variables: []sdjson.Variable{aux("a", "1 +")}, Specs: sdjson.Specs{StopTime: 1}})
+	var syntax *equation.SyntaxError
+	assert.True(t, errors.As(err, &syntax))
+}
+
+func TestRunFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *sdjson.Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			r, err := Run(fixture.Model)
+			require.NoError(t, err)
+			assert.NotEmpty(t, r.Time)
+		})
+	}
+}
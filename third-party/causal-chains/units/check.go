@@ -0,0 +1,158 @@
+package units
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// Check runs a unit-consistency check on a model and returns its
+// warnings in the form clients report them in Model.UnitWarnings.  The
+// result is never nil, so a model that passes the check gets an empty
+// list rather than none at all.
+//
+// Check reports units that don't parse; variables without units, if any
+// variable in the model has them; flows whose units aren't their stock's
+// units per Specs.TimeUnits; equations whose units don't match their
+// variable's; and equations that add, compare or otherwise mix
+// quantities with different units.  Numbers take on whatever units they
+// are used with, and variables without units are ignored.
+func Check(m *sdjson.Model) []sdjson.UnitWarning {
+	c := &checker{m: m, units: make(map[*sdjson.Variable]Unit), warnings: []sdjson.UnitWarning{}}
+
+	if m.Specs.TimeUnits != "" {
+		time, err := Parse(m.Specs.TimeUnits)
+		if err != nil {
+			c.warn("", "time units %q don't parse: %s", m.Specs.TimeUnits, err)
+		} else {
+			c.time = time
+		}
+	}
+
+	c.parseUnits()
+
+	for i := range m.Variables {
+		v := &m.Variables[i]
+		if v.CrossLevelGhostOf != "" {
+			continue
+		}
+		if v.Type == sdjson.VariableTypeStock {
+			c.checkFlows(v)
+		}
+		c.checkEquations(v)
+	}
+
+	return c.warnings
+}
+
+type checker struct {
+	m *sdjson.Model
+	// units holds the units of the variables whose units parse
+	units    map[*sdjson.Variable]Unit
+	time     Unit // nil if the model has no time units
+	warnings []sdjson.UnitWarning
+}
+
+func (c *checker) warn(element, format string, args ...any) {
+	c.warnings = append(c.warnings, sdjson.UnitWarning{Element: element, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) parseUnits() {
+	anyUnits := false
+	for _, v := range c.m.Variables {
+		if v.Units != "" {
+			anyUnits = true
+			break
+		}
+	}
+
+	for i := range c.m.Variables {
+		v := &c.m.Variables[i]
+		switch {
+		case v.Units != "":
+			u, err := Parse(v.Units)
+			if err != nil {
+				c.warn(v.Name, "units %q of %q don't parse: %s", v.Units, v.Name, err)
+				continue
+			}
+			c.units[v] = u
+		case v.CrossLevelGhostOf != "":
+			// a ghost takes its source's units
+		case anyUnits:
+			c.warn(v.Name, "%q does not have units.", v.Name)
+		}
+	}
+
+	for i := range c.m.Variables {
+		v := &c.m.Variables[i]
+		if _, ok := c.units[v]; ok || v.CrossLevelGhostOf == "" {
+			continue
+		}
+		if source := c.m.Variable(v.CrossLevelGhostOf); source != nil {
+			if u, ok := c.units[source]; ok {
+				c.units[v] = u
+			}
+		}
+	}
+}
+
+// checkFlows checks that a stock's flows are in its units per time unit.
+func (c *checker) checkFlows(stock *sdjson.Variable) {
+	u, ok := c.units[stock]
+	if !ok || c.time == nil {
+		return
+	}
+	want := u.Div(c.time)
+
+	check := func(names []string, direction string) {
+		for _, name := range names {
+			flow := c.m.Variable(name)
+			if flow == nil {
+				continue
+			}
+			got, ok := c.units[flow]
+			if ok && !got.Equal(want) {
+				c.warn(flow.Name, "%q flows %s %q, so its units should be %s, not %s", flow.Name, direction, stock.Name, want, got)
+			}
+		}
+	}
+	check(stock.Inflows, "into")
+	check(stock.Outflows, "out of")
+}
+
+// checkEquations works out the units of a variable's equations, checking
+// them against its declared units.  A stock's equation is its initial
+// value, so it's in the stock's units too.
+func (c *checker) checkEquations(v *sdjson.Variable) {
+	equations := []string{v.Equation}
+	for _, ae := range v.ArrayEquations {
+		equations = append(equations, ae.Equation)
+	}
+
+	for _, eqn := range equations {
+		if strings.TrimSpace(eqn) == "" {
+			continue
+		}
+		// sdjson.Validate and equation.DeriveRelationships report
+		// equations that don't parse
+		n, err := equation.Parse(eqn)
+		if err != nil {
+			return
+		}
+
+		e := &inferrer{c: c, v: v}
+		got := e.infer(n)
+
+		// a graphical function maps the equation's result into the
+		// variable's units
+		if v.GraphicalFunction != nil {
+			continue
+		}
+		want, ok := c.units[v]
+		if ok && got.kind == known && !got.unit.Equal(want) {
+			c.warn(v.Name, "equation for %q gives %s, but its units are %s", v.Name, got.unit, want)
+		}
+	}
+}
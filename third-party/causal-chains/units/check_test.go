@@ -0,0 +1,185 @@
+package units
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func populationModel() *sdjson.Model {
+	return &sdjson.Model{
+		Variables: []sdjson.Variable{
+			{Name: "Population", Type: sdjson.VariableTypeStock, Equation: "100", Units: "people", Inflows: []string{"births"}, Outflows: []string{"deaths"}},
+			{Name: "births", Type: sdjson.VariableTypeFlow, Equation: "Population * birth_rate", Units: "people/year"},
+			{Name: "deaths", Type: sdjson.VariableTypeFlow, Equation: "Population / lifetime", Units: "person/years"},
+			{Name: "birth rate", Type: sdjson.VariableTypeAux, Equation: "0.03", Units: "1/year"},
+			{Name: "lifetime", Type: sdjson.VariableTypeAux, Equation: "70", Units: "years"},
+		},
+		Specs: sdjson.Specs{StartTime: 0, StopTime: 100, TimeUnits: "Years"},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(m *sdjson.Model)
+		expected []sdjson.UnitWarning
+	}{
+		{
+			name:   "consistent",
+			modify: func(m *sdjson.Model) {},
+		},
+		{
+			name: "no units at all",
+			modify: func(m *sdjson.Model) {
+				for i := range m.Variables {
+					m.Variables[i].Units = ""
+				}
+			},
+		},
+		{
+			name: "missing units",
+			modify: func(m *sdjson.Model) {
+				m.Variables[4].Units = ""
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "lifetime", Message: `"lifetime" does not have units.`},
+			},
+		},
+		{
+			name: "units that don't parse",
+			modify: func(m *sdjson.Model) {
+				m.Variables[3].Units = "1/(year"
+				m.Specs.TimeUnits = "/"
+			},
+			expected: []sdjson.UnitWarning{
+				{Message: `time units "/" don't parse: unexpected "/"`},
+				{Element: "birth rate", Message: `units "1/(year" of "birth rate" don't parse: missing )`},
+			},
+		},
+		{
+			name: "flow in the wrong time units",
+			modify: func(m *sdjson.Model) {
+				m.Specs.TimeUnits = "months"
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "births", Message: `"births" flows into "Population", so its units should be person/month, not person/year`},
+				{Element: "deaths", Message: `"deaths" flows out of "Population", so its units should be person/month, not person/year`},
+			},
+		},
+		{
+			name: "equation in the wrong units",
+			modify: func(m *sdjson.Model) {
+				m.Variables[2].Equation = "Population * lifetime"
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "deaths", Message: `equation for "deaths" gives person*year, but its units are person/year`},
+			},
+		},
+		{
+			name: "adding different units",
+			modify: func(m *sdjson.Model) {
+				m.Variables[1].Equation = "Population * birth_rate + Population"
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "births", Message: `equation for "births" adds person/year to person`},
+			},
+		},
+		{
+			name: "numbers take on any units",
+			modify: func(m *sdjson.Model) {
+				m.Variables[1].Equation = "MAX(Population * birth_rate - 1, 0)"
+				m.Variables[2].Equation = "IF Population > 10 THEN Population / lifetime ELSE 0"
+			},
+		},
+		{
+			name: "comparing different units",
+			modify: func(m *sdjson.Model) {
+				m.Variables[2].Equation = "IF Population > lifetime THEN Population / lifetime ELSE 0"
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "deaths", Message: `equation for "deaths" compares person with year`},
+			},
+		},
+		{
+			name: "dimensionless function arguments",
+			modify: func(m *sdjson.Model) {
+				m.Variables[1].Equation = "Population * birth_rate * EXP(lifetime)"
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "births", Message: `equation for "births" takes EXP of year, which isn't dimensionless`},
+			},
+		},
+		{
+			name: "builtins",
+			modify: func(m *sdjson.Model) {
+				m.Variables[1].Equation = "SMTH1(Population, lifetime) * birth_rate + STEP(Population, 10) / DT + RAMP(Population, 5) / TIME^2"
+				m.Variables[3].Equation = "SQRT(birth_rate^2) * INIT(Population) / Population"
+			},
+		},
+		{
+			name: "lookups",
+			modify: func(m *sdjson.Model) {
+				m.Variables = append(m.Variables,
+					sdjson.Variable{Name: "effect", Units: "dmnl", GraphicalFunction: &sdjson.GraphicalFunction{}},
+					sdjson.Variable{Name: "scaled", Equation: "Population", Units: "dmnl", GraphicalFunction: &sdjson.GraphicalFunction{}},
+				)
+				m.Variables[3].Equation = "0.03 * effect(Population) * scaled"
+			},
+			expected: []sdjson.UnitWarning{
+				{Element: "birth rate", Message: `equation for "birth rate" gives dimensionless, but its units are 1/year`},
+			},
+		},
+		{
+			name: "ghosts take their source's units",
+			modify: func(m *sdjson.Model) {
+				m.Variables = append(m.Variables, sdjson.Variable{Name: "Population ghost", Type: sdjson.VariableTypeStock, CrossLevelGhostOf: "Population"})
+				m.Variables[2].Equation = "Population_ghost / lifetime"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := populationModel()
+			tt.modify(m)
+			warnings := Check(m)
+			require.NotNil(t, warnings)
+			if tt.expected == nil {
+				tt.expected = []sdjson.UnitWarning{}
+			}
+			assert.Equal(t, tt.expected, warnings)
+		})
+	}
+}
+
+func TestCheckFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../../../evals/categories/*/*.json")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Model *sdjson.Model `json:"model"`
+		}
+		if json.Unmarshal(data, &fixture) != nil || fixture.Model == nil {
+			continue
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			for _, w := range Check(fixture.Model) {
+				assert.NotEmpty(t, w.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package units
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/equation"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+type kind uint8
+
+const (
+	// unknown is an expression whose units can't be worked out, such as
+	// a variable without units
+	unknown kind = iota
+	// number is a number, or something that acts like one, such as a
+	// comparison: it takes on whatever units it's used with
+	number
+	known
+)
+
+// dim is the units of an expression.
+type dim struct {
+	kind kind
+	unit Unit
+}
+
+func knownDim(u Unit) dim {
+	return dim{kind: known, unit: u}
+}
+
+// inferrer works out the units of the expressions in a variable's
+// equation, reporting the places it mixes different units.
+type inferrer struct {
+	c *checker
+	v *sdjson.Variable
+}
+
+func (e *inferrer) mismatch(format string, a, b Unit) {
+	e.c.warn(e.v.Name, "equation for %q %s", e.v.Name, fmt.Sprintf(format, a, b))
+}
+
+// same combines two expressions that must have the same units, reporting
+// them with format if they don't.
+func (e *inferrer) same(x, y dim, format string) dim {
+	switch {
+	case x.kind == known && y.kind == known:
+		if !x.unit.Equal(y.unit) {
+			e.mismatch(format, x.unit, y.unit)
+		}
+		return x
+	case x.kind == known:
+		return x
+	case y.kind == known:
+		return y
+	case x.kind == number && y.kind == number:
+		return x
+	}
+	return dim{}
+}
+
+func product(x, y dim, div bool) dim {
+	switch {
+	case x.kind == unknown || y.kind == unknown:
+		return dim{}
+	case x.kind == number && y.kind == number:
+		return dim{kind: number}
+	}
+	// numbers are dimensionless factors
+	if div {
+		return knownDim(x.unit.Div(y.unit))
+	}
+	return knownDim(x.unit.Mul(y.unit))
+}
+
+// time is the units of the simulation clock.
+func (e *inferrer) time() dim {
+	if e.c.time == nil {
+		return dim{}
+	}
+	return knownDim(e.c.time)
+}
+
+// timeBuiltins are the names that refer to the simulation clock, in
+// XMILE's and Vensim's spellings.
+var timeBuiltins = map[string]bool{
+	"TIME": true, "DT": true, "TIMESTEP": true, "SAVEPER": true,
+	"STARTTIME": true, "INITIALTIME": true, "STOPTIME": true, "FINALTIME": true,
+}
+
+var builtinReplacer = strings.NewReplacer(" ", "", "_", "")
+
+// builtinName returns a name in the form equation.Call.Builtin uses.
+func builtinName(name string) string {
+	return strings.ToUpper(builtinReplacer.Replace(name))
+}
+
+func (e *inferrer) infer(n equation.Node) dim {
+	switch n := n.(type) {
+	case *equation.Number:
+		return dim{kind: number}
+
+	case *equation.Ident:
+		if found := e.c.m.Resolve(e.v.Name, n.Name); found != nil {
+			if u, ok := e.c.units[found]; ok {
+				return knownDim(u)
+			}
+			return dim{}
+		}
+		switch name := builtinName(n.Name); {
+		case timeBuiltins[name]:
+			return e.time()
+		case name == "PI":
+			return dim{kind: number}
+		}
+		return dim{}
+
+	case *equation.Index:
+		return e.infer(n.X)
+
+	case *equation.Unary:
+		x := e.infer(n.X)
+		if n.Op == "NOT" {
+			return dim{kind: number}
+		}
+		return x
+
+	case *equation.Binary:
+		return e.binary(n)
+
+	case *equation.If:
+		e.infer(n.Cond)
+		return e.same(e.infer(n.Then), e.infer(n.Else), "has IF branches in %s and %s")
+
+	case *equation.Call:
+		return e.call(n)
+	}
+	return dim{}
+}
+
+func (e *inferrer) binary(n *equation.Binary) dim {
+	x, y := e.infer(n.X), e.infer(n.Y)
+
+	switch n.Op {
+	case "+":
+		return e.same(x, y, "adds %s to %s")
+	case "-":
+		return e.same(x, y, "subtracts %[2]s from %[1]s")
+	case "*":
+		return product(x, y, false)
+	case "/", "//":
+		return product(x, y, true)
+	case "MOD":
+		return x
+	case "^":
+		return e.power(n, x)
+	case "AND", "OR":
+		return dim{kind: number}
+	}
+
+	// comparisons
+	e.same(x, y, "compares %s with %s")
+	return dim{kind: number}
+}
+
+// power works out the units of x^y, which needs a dimensionless base or
+// an integer exponent.
+func (e *inferrer) power(n *equation.Binary, x dim) dim {
+	if x.kind != known || x.unit.Dimensionless() {
+		return x
+	}
+	exp, ok := n.Y.(*equation.Number)
+	if neg, isNeg := n.Y.(*equation.Unary); isNeg && neg.Op == "-" {
+		if exp, ok = neg.X.(*equation.Number); ok {
+			exp = &equation.Number{Value: -exp.Value}
+		}
+	}
+	if !ok || exp.Value != float64(int(exp.Value)) {
+		return dim{}
+	}
+	return knownDim(x.unit.Pow(int(exp.Value)))
+}
+
+// dimensionless are builtins that need a dimensionless argument and
+// return a dimensionless result.
+var dimensionless = map[string]bool{
+	"EXP": true, "LN": true, "LOG10": true,
+	"SIN": true, "COS": true, "TAN": true, "ARCSIN": true, "ARCCOS": true, "ARCTAN": true,
+}
+
+// sameAsFirst are builtins whose result is in the units of their first
+// argument: rounding, smooths and delays, and array reductions.
+var sameAsFirst = map[string]bool{
+	"ABS": true, "INT": true, "INTEGER": true, "INIT": true, "PREVIOUS": true,
+	"SMTH1": true, "SMTH3": true, "SMTHN": true,
+	"SMOOTH": true, "SMOOTHI": true, "SMOOTH3": true, "SMOOTH3I": true, "SMOOTHN": true,
+	"DELAY": true, "DELAY1": true, "DELAY1I": true, "DELAY3": true, "DELAY3I": true,
+	"DELAYN": true, "DELAYFIXED": true, "DELAYMATERIAL": true, "DELAYINFORMATION": true,
+	"SUM": true, "MEAN": true, "STEP": true,
+}
+
+func (e *inferrer) call(n *equation.Call) dim {
+	args := make([]dim, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = e.infer(arg)
+	}
+
+	// a lookup called by name gives its graphical function's output
+	if found := e.c.m.Resolve(e.v.Name, n.Func); found != nil && found.GraphicalFunction != nil {
+		if u, ok := e.c.units[found]; ok {
+			return knownDim(u)
+		}
+		return dim{}
+	}
+
+	name := n.Builtin()
+	switch {
+	case timeBuiltins[name] && len(args) == 0:
+		return e.time()
+
+	case name == "PI":
+		return dim{kind: number}
+
+	case dimensionless[name] && len(args) == 1:
+		if x := args[0]; x.kind == known && !x.unit.Dimensionless() {
+			e.c.warn(e.v.Name, "equation for %q takes %s of %s, which isn't dimensionless", e.v.Name, n.Func, x.unit)
+		}
+		return knownDim(Unit{})
+
+	case name == "SQRT" && len(args) == 1:
+		x := args[0]
+		if x.kind != known {
+			return x
+		}
+		root := make(Unit, len(x.unit))
+		for u, exp := range x.unit {
+			if exp%2 != 0 {
+				return dim{}
+			}
+			root[u] = exp / 2
+		}
+		return knownDim(root)
+
+	case (name == "MIN" || name == "MAX") && len(args) > 0:
+		d := args[0]
+		for _, arg := range args[1:] {
+			d = e.same(d, arg, fmt.Sprintf("takes the %s of %%s and %%s", name))
+		}
+		return d
+
+	case sameAsFirst[name] && len(args) > 0:
+		return args[0]
+
+	case (name == "PULSE" || name == "RAMP") && len(args) > 0 && args[0].kind != known:
+		return args[0]
+
+	case name == "PULSE" && len(args) > 0:
+		// the volume is delivered over a single DT
+		return product(args[0], e.time(), true)
+
+	case name == "RAMP" && len(args) > 0:
+		return product(args[0], e.time(), false)
+
+	case (name == "SAFEDIV" || name == "ZIDZ" || name == "XIDZ") && len(args) >= 2:
+		return product(args[0], args[1], true)
+
+	case name == "MODULO" && len(args) > 0:
+		return args[0]
+	}
+
+	return dim{}
+}
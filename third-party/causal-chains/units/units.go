@@ -0,0 +1,314 @@
+// Package units checks the dimensional consistency of sdjson models.
+//
+// Units strings are products and quotients of named units, such as
+// "people/year", "widgets per person per month" or "Resource/(Person*Year)".
+// Names are case-insensitive, common plurals and abbreviations of time
+// units are folded together ("Years", "yr" and "year" are the same unit),
+// and "dimensionless", "dmnl", "unitless", "fraction", "percent" and "1"
+// all mean a dimensionless quantity.  Scale factors are ignored, so
+// "1000 people" is the same unit as "people".
+package units
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Unit is a product of named units raised to integer powers, such as
+// {"person": 1, "year": -1} for people/year.  A nil or empty Unit is
+// dimensionless.  Units are never modified in place.
+type Unit map[string]int
+
+// Mul returns u*o.
+func (u Unit) Mul(o Unit) Unit {
+	return u.combine(o, 1)
+}
+
+// Div returns u/o.
+func (u Unit) Div(o Unit) Unit {
+	return u.combine(o, -1)
+}
+
+func (u Unit) combine(o Unit, sign int) Unit {
+	product := make(Unit, len(u)+len(o))
+	for name, exp := range u {
+		product[name] = exp
+	}
+	for name, exp := range o {
+		product[name] += sign * exp
+		if product[name] == 0 {
+			delete(product, name)
+		}
+	}
+	return product
+}
+
+// Pow returns u raised to the nth power.
+func (u Unit) Pow(n int) Unit {
+	power := make(Unit, len(u))
+	if n == 0 {
+		return power
+	}
+	for name, exp := range u {
+		power[name] = exp * n
+	}
+	return power
+}
+
+// Dimensionless reports whether u has no dimensions.
+func (u Unit) Dimensionless() bool {
+	return len(u) == 0
+}
+
+// Equal reports whether u and o are the same unit.
+func (u Unit) Equal(o Unit) bool {
+	if len(u) != len(o) {
+		return false
+	}
+	for name, exp := range u {
+		if o[name] != exp {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns u in a canonical form, such as "person/year" or
+// "widget/(month*person)".
+func (u Unit) String() string {
+	if u.Dimensionless() {
+		return "dimensionless"
+	}
+
+	var num, den []string
+	for _, name := range slices.Sorted(maps.Keys(u)) {
+		exp := u[name]
+		term := name
+		if exp < 0 {
+			exp = -exp
+		}
+		if exp != 1 {
+			term += "^" + strconv.Itoa(exp)
+		}
+		if u[name] > 0 {
+			num = append(num, term)
+		} else {
+			den = append(den, term)
+		}
+	}
+
+	s := strings.Join(num, "*")
+	if s == "" {
+		s = "1"
+	}
+	switch len(den) {
+	case 0:
+		return s
+	case 1:
+		return s + "/" + den[0]
+	}
+	return s + "/(" + strings.Join(den, "*") + ")"
+}
+
+// aliases maps normalized unit names to their canonical names.  An empty
+// canonical name means the unit is dimensionless.
+var aliases = map[string]string{
+	"dimensionless": "", "dmnl": "", "unitless": "", "fraction": "",
+	"percent": "", "%": "",
+
+	"people": "person",
+
+	"sec": "second", "secs": "second",
+	"min": "minute", "mins": "minute",
+	"hr": "hour", "hrs": "hour",
+	"wk": "week", "wks": "week",
+	"mo": "month", "mos": "month",
+	"yr": "year", "yrs": "year",
+}
+
+// canonical returns the canonical name of a unit written as words.
+func canonical(words []string) string {
+	name := strings.ToLower(strings.Join(words, " "))
+	if alias, ok := aliases[name]; ok {
+		return alias
+	}
+	// fold a simple plural into the singular
+	if len(name) > 3 && strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") {
+		singular := name[:len(name)-1]
+		if alias, ok := aliases[singular]; ok {
+			return alias
+		}
+		return singular
+	}
+	return name
+}
+
+// Parse parses a units string.  The empty string is an error, since it
+// means the units are unknown rather than dimensionless.
+func Parse(s string) (Unit, error) {
+	p := &parser{tokens: tokenize(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("no units")
+	}
+	u, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return u, nil
+}
+
+// tokenize splits a units string into words, numbers and the operators
+// * / ^ ( and ).
+func tokenize(s string) []string {
+	var tokens []string
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, s[start:end])
+			start = -1
+		}
+	}
+	for i, c := range s {
+		switch {
+		case strings.ContainsRune("*/^()", c):
+			flush(i)
+			tokens = append(tokens, string(c))
+		case unicode.IsSpace(c):
+			flush(i)
+		case start < 0:
+			start = i
+		}
+	}
+	flush(len(s))
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func isOperator(tok string) bool {
+	return len(tok) == 1 && strings.Contains("*/^()", tok)
+}
+
+func isPer(tok string) bool {
+	return strings.EqualFold(tok, "per")
+}
+
+func isNumber(tok string) bool {
+	_, err := strconv.ParseFloat(tok, 64)
+	return err == nil
+}
+
+// expr parses terms separated by *, / and "per"; a leading "per" means
+// 1/..., as in "per year".
+func (p *parser) expr() (Unit, error) {
+	u := Unit{}
+	op := "*"
+	if isPer(p.peek()) {
+		p.pos++
+		op = "/"
+	}
+	for {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if op == "*" {
+			u = u.Mul(term)
+		} else {
+			u = u.Div(term)
+		}
+
+		switch tok := p.peek(); {
+		case tok == "*" || tok == "/":
+			op = tok
+		case isPer(tok):
+			op = "/"
+		case tok != "" && tok != ")" && tok != "^":
+			// a scale factor followed by a unit, as in "1000 people"
+			if !isNumber(p.tokens[p.pos-1]) {
+				return nil, fmt.Errorf("unexpected %q", tok)
+			}
+			op = "*"
+			continue
+		default:
+			return u, nil
+		}
+		p.pos++
+	}
+}
+
+// term parses a factor with an optional integer exponent.
+func (p *parser) term() (Unit, error) {
+	u, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "^" {
+		return u, nil
+	}
+	p.pos++
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("missing exponent")
+	}
+	n, err := strconv.Atoi(tok)
+	if err != nil {
+		return nil, fmt.Errorf("exponent %q isn't an integer", tok)
+	}
+	p.pos++
+	return u.Pow(n), nil
+}
+
+// factor parses a parenthesized expression, a number or a unit name,
+// which may be several words long.
+func (p *parser) factor() (Unit, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("missing unit at end")
+	case tok == "(":
+		p.pos++
+		u, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return u, nil
+	case isOperator(tok) || isPer(tok):
+		return nil, fmt.Errorf("unexpected %q", tok)
+	case isNumber(tok):
+		p.pos++
+		return Unit{}, nil
+	}
+
+	var words []string
+	for tok := p.peek(); tok != "" && !isOperator(tok) && !isPer(tok) && !isNumber(tok); tok = p.peek() {
+		words = append(words, tok)
+		p.pos++
+	}
+	name := canonical(words)
+	if name == "" {
+		return Unit{}, nil
+	}
+	return Unit{name: 1}, nil
+}
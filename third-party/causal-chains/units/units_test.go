@@ -0,0 +1,78 @@
+package units
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		units    string
+		expected string
+	}{
+		{"Person", "person"},
+		{"people", "person"},
+		{"Person/Week", "person/week"},
+		{"people/years", "person/year"},
+		{"customer/yr", "customer/year"},
+		{"widget/mo", "widget/month"},
+		{"1/Week", "1/week"},
+		{"per year", "1/year"},
+		{"Dmnl", "dimensionless"},
+		{"Dimensionless", "dimensionless"},
+		{"1", "dimensionless"},
+		{"Day/Week", "day/week"},
+		{"hares/lynx/year", "hare/(lynx*year)"},
+		{"Resource/(Person*Year)", "resource/(person*year)"},
+		{"widgets per person per month", "widget/(month*person)"},
+		{"square meters", "square meter"},
+		{"meter^2/second", "meter^2/second"},
+		{"year^-1", "1/year"},
+		{"1000 people", "person"},
+		{"$/year", "$/year"},
+		{"class", "class"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.units, func(t *testing.T) {
+			u, err := Parse(tt.units)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, u.String())
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		units    string
+		expected string
+	}{
+		{"", "no units"},
+		{"person/", "missing unit at end"},
+		{"(person", "missing )"},
+		{"person)", `unexpected ")"`},
+		{"person^x", `exponent "x" isn't an integer`},
+		{"person 2", `unexpected "2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.units, func(t *testing.T) {
+			_, err := Parse(tt.units)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestUnitArithmetic(t *testing.T) {
+	person, year := Unit{"person": 1}, Unit{"year": 1}
+
+	rate := person.Div(year)
+	assert.Equal(t, "person/year", rate.String())
+	assert.True(t, rate.Mul(year).Equal(person))
+	assert.True(t, person.Div(person).Dimensionless())
+	assert.Equal(t, "1/year^2", year.Pow(-2).String())
+	assert.False(t, rate.Equal(person))
+
+	// the operands are unchanged
+	assert.Equal(t, Unit{"person": 1}, person)
+}
@@ -0,0 +1,557 @@
+package vensim
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+var (
+	continuationRe = regexp.MustCompile(`\\\r?\n\t*`)
+	encodingRe     = regexp.MustCompile(`^\s*\{[^}]*\}`)
+	lookupPointRe  = regexp.MustCompile(`\(\s*([^,()]+?)\s*,\s*([^,()]+?)\s*\)`)
+	elementRangeRe = regexp.MustCompile(`^\(\s*(\D*?)(\d+)\s*-\s*(\D*?)(\d+)\s*\)$`)
+	delayBuiltinRe = regexp.MustCompile(`(?i)\b(DELAY[0-9N]*|DELAY FIXED|SMOOTH[0-9N]*I?)\s*\(`)
+	functionOfRe   = regexp.MustCompile(`(?i)^A\s+FUNCTION\s+OF\s*\(`)
+)
+
+// entryKind identifies what an entry in the equations section defines.
+type entryKind int
+
+const (
+	entryEquation entryKind = iota
+	entryLookup             // name( [(x,y)-(x,y)], (x,y), ... )
+	entryRange              // subscript range: name: a, b, c
+)
+
+// entry is one "equation ~ units ~ comment |" block in the equations
+// section of a model.
+type entry struct {
+	kind       entryKind
+	name       string
+	subscripts []string
+	rhs        string
+	units      string
+	comment    string
+}
+
+// Read parses a Vensim .mdl model into an sdjson model.  Arrows in the
+// sketch become relationships, with their polarity when one is drawn,
+// as do the inflow (+) and outflow (-) links between flows and stocks.
+func Read(r io.Reader) (*sdjson.Model, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	text := string(data)
+	var sketch string
+	if i := strings.Index(text, sketchStart); i >= 0 {
+		text, sketch = text[:i], text[i+len(sketchStart):]
+		if j := strings.Index(sketch, sketchEnd); j >= 0 {
+			sketch = sketch[:j]
+		}
+	}
+
+	rd := &reader{
+		entries: parseEntries(text),
+		mdl:     new(sdjson.Model),
+		index:   make(map[string]int),
+		flowOf:  make(map[string]string),
+	}
+	if err := rd.convert(); err != nil {
+		return nil, err
+	}
+	rd.convertRelationships(parseSketch(sketch))
+
+	return rd.mdl, nil
+}
+
+// parseEntries splits the equations section into entries.
+func parseEntries(text string) []entry {
+	text = encodingRe.ReplaceAllString(text, "")
+	text = continuationRe.ReplaceAllString(text, "")
+
+	var entries []entry
+	inMacro := false
+	for _, block := range strings.Split(text, "|") {
+		block = strings.TrimSpace(block)
+		upper := strings.ToUpper(block)
+		switch {
+		case strings.Contains(upper, ":MACRO:"):
+			inMacro = true
+			continue
+		case strings.Contains(upper, ":END OF MACRO:"):
+			inMacro = false
+			continue
+		case inMacro, block == "", strings.HasPrefix(block, "*"):
+			continue
+		}
+
+		parts := strings.SplitN(block, "~", 3)
+		for len(parts) < 3 {
+			parts = append(parts, "")
+		}
+		e, ok := parseEquation(strings.Join(strings.Fields(parts[0]), " "))
+		if !ok {
+			continue
+		}
+		e.units = strings.TrimSpace(parts[1])
+		// units may be followed by a [min,max,increment] range
+		if i := strings.IndexByte(e.units, '['); i >= 0 {
+			e.units = strings.TrimSpace(e.units[:i])
+		}
+		e.comment = strings.TrimSpace(parts[2])
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func parseEquation(eq string) (entry, bool) {
+	var e entry
+	var lhs string
+
+	if i := indexTopLevel(eq, '='); i >= 0 {
+		lhs, e.rhs = eq[:i], strings.TrimSpace(eq[i+1:])
+		// data equations (:=) are treated like regular equations
+		lhs = strings.TrimSuffix(lhs, ":")
+		e.kind = entryEquation
+	} else if i := indexTopLevel(eq, ':'); i >= 0 {
+		lhs, e.rhs = eq[:i], strings.TrimSpace(eq[i+1:])
+		e.kind = entryRange
+	} else if i := strings.IndexByte(eq, '('); i > 0 && strings.HasSuffix(eq, ")") {
+		lhs, e.rhs = eq[:i], strings.TrimSpace(eq[i+1:len(eq)-1])
+		e.kind = entryLookup
+	} else {
+		return e, false
+	}
+
+	lhs = strings.TrimSpace(lhs)
+	if i := strings.IndexByte(lhs, '['); i >= 0 && strings.HasSuffix(lhs, "]") {
+		for _, s := range strings.Split(lhs[i+1:len(lhs)-1], ",") {
+			e.subscripts = append(e.subscripts, strings.TrimSpace(s))
+		}
+		lhs = lhs[:i]
+	}
+	e.name = displayName(lhs)
+
+	return e, e.name != ""
+}
+
+type reader struct {
+	entries []entry
+	mdl     *sdjson.Model
+	// index maps canonical names to their position in mdl.Variables.
+	index map[string]int
+	// rates holds each stock's rate expression, in the order the stocks
+	// are defined.
+	rates []stockRate
+	// flowOf maps stocks whose rate isn't a sum of flows to the net
+	// flow created for them.
+	flowOf map[string]string
+}
+
+type stockRate struct {
+	stock string
+	rate  string
+}
+
+func (r *reader) convert() error {
+	dims := make(map[string]string) // canonical element -> dimension
+
+	for _, e := range r.entries {
+		if e.kind != entryRange {
+			continue
+		}
+		dim := sdjson.ArrayDimension{Type: sdjson.DimensionLabels, Name: e.name}
+		rhs, _, _ := strings.Cut(e.rhs, "->")
+		for _, el := range splitTopLevel(rhs, ',') {
+			dim.Elements = append(dim.Elements, expandElements(strings.TrimSpace(el))...)
+		}
+		dim.Size = len(dim.Elements)
+		for _, el := range dim.Elements {
+			dims[canonicalName(el)] = dim.Name
+		}
+		r.mdl.Specs.ArrayDimensions = append(r.mdl.Specs.ArrayDimensions, dim)
+	}
+
+	for _, e := range r.entries {
+		if e.kind == entryRange {
+			continue
+		}
+		if r.convertControl(e) {
+			continue
+		}
+		if err := r.convertEntry(e, dims); err != nil {
+			return err
+		}
+	}
+
+	r.classifyFlows()
+
+	for i := range r.mdl.Variables {
+		v := &r.mdl.Variables[i]
+		if v.Type == sdjson.VariableTypeAux && delayBuiltinRe.MatchString(v.Equation) {
+			v.SubType = sdjson.SubTypeDelayVariable
+		}
+	}
+
+	return nil
+}
+
+// expandElements expands a numbered element range like "(a1-a3)".
+func expandElements(el string) []string {
+	m := elementRangeRe.FindStringSubmatch(el)
+	if m == nil {
+		return []string{displayName(el)}
+	}
+	first, _ := strconv.Atoi(m[2])
+	last, _ := strconv.Atoi(m[4])
+	var elements []string
+	for i := first; i <= last; i++ {
+		elements = append(elements, m[1]+strconv.Itoa(i))
+	}
+	return elements
+}
+
+func (r *reader) convertControl(e entry) bool {
+	specs := &r.mdl.Specs
+	value, err := strconv.ParseFloat(e.rhs, 64)
+
+	switch canonicalName(e.name) {
+	case initialTime:
+		specs.StartTime = value
+	case finalTime:
+		specs.StopTime = value
+		if specs.TimeUnits == "" {
+			specs.TimeUnits = e.units
+		}
+	case timeStep:
+		specs.DT = value
+		specs.TimeUnits = e.units
+	case savePer:
+		if err != nil && canonicalName(e.rhs) == timeStep {
+			value = specs.DT
+		}
+		specs.SaveStep = value
+	default:
+		return false
+	}
+	return true
+}
+
+func (r *reader) convertEntry(e entry, dims map[string]string) error {
+	key := canonicalName(e.name)
+	i, ok := r.index[key]
+	if !ok {
+		i = len(r.mdl.Variables)
+		r.index[key] = i
+		r.mdl.Variables = append(r.mdl.Variables, sdjson.Variable{
+			Name: e.name,
+			Type: sdjson.VariableTypeAux,
+		})
+	}
+	v := &r.mdl.Variables[i]
+
+	// an arrayed variable defined element by element only has units and
+	// a comment on its last equation.
+	if e.units != "" {
+		v.Units = e.units
+	}
+	if e.comment != "" {
+		v.Documentation = e.comment
+	}
+
+	eqn := e.rhs
+	switch e.kind {
+	case entryLookup:
+		gf, err := parseLookup(e.rhs)
+		if err != nil {
+			return fmt.Errorf("variable %q: %w", v.Name, err)
+		}
+		v.GraphicalFunction = gf
+		eqn = ""
+	case entryEquation:
+		if args, ok := callArgs(eqn, "INTEG"); ok && len(args) == 2 {
+			v.Type = sdjson.VariableTypeStock
+			eqn = args[1]
+			// arrayed stocks repeat INTEG for each element; the flows
+			// are taken from the first.
+			if len(r.rates) == 0 || r.rates[len(r.rates)-1].stock != key {
+				r.rates = append(r.rates, stockRate{stock: key, rate: args[0]})
+			}
+		} else if args, ok := callArgs(eqn, "WITH LOOKUP"); ok && len(args) == 2 {
+			table := strings.TrimSuffix(strings.TrimPrefix(args[1], "("), ")")
+			gf, err := parseLookup(table)
+			if err != nil {
+				return fmt.Errorf("variable %q: %w", v.Name, err)
+			}
+			v.GraphicalFunction = gf
+			eqn = args[0]
+		} else if functionOfRe.MatchString(eqn) {
+			// Vensim's placeholder for variables drawn in a causal
+			// loop diagram that don't have an equation yet
+			eqn = ""
+		}
+	}
+
+	if len(e.subscripts) == 0 {
+		v.Equation = eqn
+		return nil
+	}
+
+	// subscripts naming whole dimensions apply the equation to every
+	// element; anything else is an equation for specific elements.
+	var dimensions []string
+	allDims := true
+	for _, s := range e.subscripts {
+		if dim, ok := dims[canonicalName(s)]; ok {
+			allDims = false
+			dimensions = append(dimensions, dim)
+		} else {
+			dimensions = append(dimensions, s)
+		}
+	}
+	if v.Dimensions == nil {
+		v.Dimensions = dimensions
+	}
+	if allDims {
+		v.Equation = eqn
+	} else {
+		v.ArrayEquations = append(v.ArrayEquations, sdjson.ArrayEquation{
+			Equation:    eqn,
+			ForElements: e.subscripts,
+		})
+	}
+	return nil
+}
+
+// parseLookup parses a lookup table, "[(xmin,ymin)-(xmax,ymax)],(x,y),..."
+// or the older "[(xmin,ymin)-(xmax,ymax)],x1,x2,...,y1,y2,...".
+func parseLookup(table string) (*sdjson.GraphicalFunction, error) {
+	table = strings.TrimSpace(table)
+	if strings.HasPrefix(table, "[") {
+		depth := 0
+		for i, c := range table {
+			switch c {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+			if depth == 0 {
+				table = strings.TrimPrefix(strings.TrimSpace(table[i+1:]), ",")
+				break
+			}
+		}
+	}
+
+	gf := new(sdjson.GraphicalFunction)
+	if strings.Contains(table, "(") {
+		for _, m := range lookupPointRe.FindAllStringSubmatch(table, -1) {
+			x, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("strconv.ParseFloat: %w", err)
+			}
+			y, err := strconv.ParseFloat(m[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("strconv.ParseFloat: %w", err)
+			}
+			gf.Points = append(gf.Points, sdjson.Point{X: x, Y: y})
+		}
+		return gf, nil
+	}
+
+	var values []float64
+	for _, field := range strings.Split(table, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("strconv.ParseFloat: %w", err)
+		}
+		values = append(values, f)
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("lookup has %d values, expected x and y pairs", len(values))
+	}
+	n := len(values) / 2
+	for i := range n {
+		gf.Points = append(gf.Points, sdjson.Point{X: values[i], Y: values[n+i]})
+	}
+	return gf, nil
+}
+
+type rateTerm struct {
+	sign int
+	expr string
+}
+
+// splitSum splits an expression into the terms of a top-level sum.
+func splitSum(expr string) []rateTerm {
+	var terms []rateTerm
+	depth := 0
+	inQuote := false
+	sign := 1
+	start := 0
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case (c == '+' || c == '-') && depth == 0:
+			if term := strings.TrimSpace(expr[start:i]); term != "" {
+				terms = append(terms, rateTerm{sign: sign, expr: term})
+			} else if len(terms) > 0 || start > 0 {
+				// "a + - b" or "a * -b" isn't a simple sum
+				return nil
+			}
+			sign = 1
+			if c == '-' {
+				sign = -1
+			}
+			start = i + 1
+		}
+	}
+	return append(terms, rateTerm{sign: sign, expr: strings.TrimSpace(expr[start:])})
+}
+
+// classifyFlows turns each stock's rate into inflows and outflows.  A
+// rate that is a sum of non-stock variables makes those variables
+// flows; any other rate gets its own net flow variable.
+func (r *reader) classifyFlows() {
+	for _, sr := range r.rates {
+		stock := &r.mdl.Variables[r.index[sr.stock]]
+		inflows, outflows := make([]string, 0), make([]string, 0)
+
+		if value, err := strconv.ParseFloat(sr.rate, 64); err == nil && value == 0 {
+			stock.Inflows, stock.Outflows = inflows, outflows
+			continue
+		}
+
+		terms := splitSum(sr.rate)
+		ok := len(terms) > 0
+		var flows []int
+		for _, term := range terms {
+			name, _, _ := strings.Cut(term.expr, "[")
+			i, found := r.index[canonicalName(name)]
+			if !found || r.mdl.Variables[i].Type == sdjson.VariableTypeStock {
+				ok = false
+				break
+			}
+			flows = append(flows, i)
+			if term.sign > 0 {
+				inflows = append(inflows, r.mdl.Variables[i].Name)
+			} else {
+				outflows = append(outflows, r.mdl.Variables[i].Name)
+			}
+		}
+
+		if ok {
+			stock.Inflows, stock.Outflows = inflows, outflows
+			for _, i := range flows {
+				r.mdl.Variables[i].Type = sdjson.VariableTypeFlow
+			}
+			continue
+		}
+
+		flow := sdjson.Variable{
+			Name:     stock.Name + " net flow",
+			Type:     sdjson.VariableTypeFlow,
+			Equation: sr.rate,
+		}
+		if stock.Units != "" && r.mdl.Specs.TimeUnits != "" {
+			flow.Units = stock.Units + "/" + r.mdl.Specs.TimeUnits
+		}
+		stock.Inflows, stock.Outflows = []string{flow.Name}, make([]string, 0)
+		r.flowOf[sr.stock] = flow.Name
+		r.index[canonicalName(flow.Name)] = len(r.mdl.Variables)
+		r.mdl.Variables = append(r.mdl.Variables, flow)
+	}
+}
+
+// convertRelationships adds a relationship for each arrow drawn
+// between two variables, followed by the links from flows to stocks.
+func (r *reader) convertRelationships(views []*sketchView) {
+	seen := make(map[string]bool)
+	add := func(rel sdjson.Relationship) {
+		if rel.From == rel.To || seen[rel.Key()] {
+			return
+		}
+		seen[rel.Key()] = true
+		r.mdl.Relationships = append(r.mdl.Relationships, rel)
+	}
+
+	isStock := func(name string) bool {
+		i, ok := r.index[canonicalName(name)]
+		return ok && r.mdl.Variables[i].Type == sdjson.VariableTypeStock
+	}
+
+	for _, view := range views {
+		variable := func(id int) (string, bool) {
+			obj, ok := view.objects[id]
+			if !ok {
+				return "", false
+			}
+			if obj.kind == objectValve {
+				if obj, ok = view.objects[obj.flow]; !ok {
+					return "", false
+				}
+			}
+			if obj.kind != objectVariable {
+				return "", false
+			}
+			i, ok := r.index[canonicalName(obj.name)]
+			if !ok {
+				return "", false
+			}
+			return r.mdl.Variables[i].Name, true
+		}
+
+		for _, a := range view.arrows {
+			// pipes run from a valve to the stocks (or clouds) on
+			// either side of it; they are covered by inflows and
+			// outflows below.
+			if obj, ok := view.objects[a.from]; ok && obj.kind == objectValve {
+				if to, ok := view.objects[a.to]; ok && (to.kind == objectComment || isStock(to.name)) {
+					continue
+				}
+			}
+
+			from, okFrom := variable(a.from)
+			to, okTo := variable(a.to)
+			if !okFrom || !okTo {
+				continue
+			}
+			// other arrows into a stock only feed its initial value,
+			// unless the stock's rate was moved into a net flow.
+			if flow, ok := r.flowOf[canonicalName(to)]; ok {
+				to = flow
+			} else if isStock(to) {
+				continue
+			}
+			add(sdjson.Relationship{From: from, To: to, Polarity: a.polarity})
+		}
+	}
+
+	for _, v := range r.mdl.Variables {
+		if v.Type != sdjson.VariableTypeStock {
+			continue
+		}
+		for _, flow := range v.Inflows {
+			add(sdjson.Relationship{From: flow, To: v.Name, Polarity: "+"})
+		}
+		for _, flow := range v.Outflows {
+			add(sdjson.Relationship{From: flow, To: v.Name, Polarity: "-"})
+		}
+	}
+}
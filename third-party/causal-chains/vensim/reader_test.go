@@ -0,0 +1,216 @@
+package vensim
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// evalsDir holds the paired .mdl/.json fixtures used by the JS evals.
+const evalsDir = "../../../evals/categories"
+
+type fixture struct {
+	Model sdjson.Model `json:"model"`
+}
+
+func readFixture(t *testing.T, mdlPath string) (actual, expected *sdjson.Model) {
+	t.Helper()
+
+	f, err := os.Open(mdlPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	actual, err = Read(f)
+	require.NoError(t, err)
+
+	jsonBytes, err := os.ReadFile(strings.TrimSuffix(mdlPath, ".mdl") + ".json")
+	require.NoError(t, err)
+
+	var fix fixture
+	require.NoError(t, json.Unmarshal(jsonBytes, &fix))
+
+	return actual, &fix.Model
+}
+
+func relationshipSet(rels []sdjson.Relationship) []string {
+	set := make([]string, 0, len(rels))
+	for _, r := range rels {
+		set = append(set, r.Key()+" "+r.Polarity)
+	}
+	sort.Strings(set)
+	return set
+}
+
+// normalizeEquation strips the differences between an equation as
+// written in Vensim and the same equation in the fixtures, which were
+// translated to XMILE conventions.
+func normalizeEquation(eqn string) string {
+	eqn = strings.ToLower(eqn)
+	eqn = strings.NewReplacer(" ", "", "\t", "", "_", "").Replace(eqn)
+	return strings.ReplaceAll(eqn, "timestep", "dt")
+}
+
+func TestReadConformance(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(evalsDir, "*", "*.mdl"))
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			actual, expected := readFixture(t, path)
+
+			assert.Equal(t, expected.Specs.StartTime, actual.Specs.StartTime)
+			assert.Equal(t, expected.Specs.StopTime, actual.Specs.StopTime)
+			assert.Equal(t, expected.Specs.DT, actual.Specs.DT)
+			assert.Equal(t, expected.Specs.TimeUnits, actual.Specs.TimeUnits)
+
+			actualVars := make(map[string]sdjson.Variable, len(actual.Variables))
+			for _, v := range actual.Variables {
+				actualVars[v.Name] = v
+			}
+			require.Len(t, actual.Variables, len(expected.Variables))
+
+			for _, ev := range expected.Variables {
+				av, ok := actualVars[ev.Name]
+				if !assert.True(t, ok, "missing variable %q", ev.Name) {
+					continue
+				}
+				assert.Equal(t, ev.Type, av.Type, ev.Name)
+				assert.Equal(t, normalizeEquation(ev.Equation), normalizeEquation(av.Equation), ev.Name)
+				assert.Equal(t, ev.Units, av.Units, ev.Name)
+				assert.Equal(t, strings.TrimSpace(ev.Documentation), av.Documentation, ev.Name)
+				assert.ElementsMatch(t, ev.Inflows, av.Inflows, ev.Name)
+				assert.ElementsMatch(t, ev.Outflows, av.Outflows, ev.Name)
+				assert.Equal(t, ev.GraphicalFunction, av.GraphicalFunction, ev.Name)
+			}
+
+			assert.Equal(t, relationshipSet(expected.Relationships), relationshipSet(actual.Relationships))
+		})
+	}
+}
+
+const cldMDL = `{UTF-8}
+births = A FUNCTION OF( population, "fertility (per capita)" )
+	~
+	~		|
+
+population = A FUNCTION OF( births, deaths )
+	~	people
+	~	Everyone living in the region.
+	|
+
+deaths = A FUNCTION OF( population )
+	~
+	~		|
+
+"fertility (per capita)" = 0.03
+	~	1/year [0,0.1]
+	~		|
+
+region: (r1-r3)
+	~
+	~		|
+
+demand[region] = 10, 20, 30
+	~	widgets
+	~		|
+
+capacity[r1] = 5 ~~|
+capacity[r2] = 7
+	~	widgets
+	~	Per-region capacity.
+	|
+
+effect of crowding(
+	[(0,0)-(2,1)],(0,1),(1,0.8),(2,0.2))
+	~	Dmnl
+	~		|
+
+\\\---/// Sketch information - do not modify anything except names
+V300  Do not put anything below this section - it will be ignored
+*View 1
+$192-192-192,0,Times New Roman|12||0-0-0|0-0-0|0-0-255|-1--1--1|-1--1--1|96,96,100,0
+10,1,births,100,100,40,20,8,3,0,0,0,0,0,0
+10,2,population,200,100,40,20,8,3,0,0,0,0,0,0
+10,3,deaths,300,100,40,20,8,3,0,0,0,0,0,0
+10,4,"fertility (per capita)",100,200,40,20,8,3,0,0,0,0,0,0
+1,5,1,2,1,0,43,0,0,64,0,-1--1--1,,1|(150,80)|
+1,6,2,1,1,0,43,0,0,64,0,-1--1--1,,1|(150,120)|
+1,7,3,2,1,0,45,0,0,64,0,-1--1--1,,1|(250,80)|
+1,8,2,3,1,0,0,0,0,64,0,-1--1--1,,1|(250,120)|
+1,9,4,1,0,0,83,0,0,64,0,-1--1--1,,1|(0,0)|
+///---\\\
+`
+
+func TestReadCausalLoopDiagram(t *testing.T) {
+	m, err := Read(strings.NewReader(cldMDL))
+	require.NoError(t, err)
+
+	assert.Equal(t, []sdjson.ArrayDimension{
+		{Type: sdjson.DimensionLabels, Name: "region", Size: 3, Elements: []string{"r1", "r2", "r3"}},
+	}, m.Specs.ArrayDimensions)
+
+	require.Len(t, m.Variables, 7)
+
+	population := m.Variables[1]
+	assert.Equal(t, "population", population.Name)
+	assert.Equal(t, sdjson.VariableTypeAux, population.Type)
+	assert.Equal(t, "", population.Equation)
+	assert.Equal(t, "people", population.Units)
+	assert.Equal(t, "Everyone living in the region.", population.Documentation)
+
+	fertility := m.Variables[3]
+	assert.Equal(t, "fertility (per capita)", fertility.Name)
+	assert.Equal(t, "0.03", fertility.Equation)
+	assert.Equal(t, "1/year", fertility.Units)
+
+	demand := m.Variables[4]
+	assert.Equal(t, []string{"region"}, demand.Dimensions)
+	assert.Equal(t, "10, 20, 30", demand.Equation)
+
+	capacity := m.Variables[5]
+	assert.Equal(t, []string{"region"}, capacity.Dimensions)
+	assert.Equal(t, []sdjson.ArrayEquation{
+		{Equation: "5", ForElements: []string{"r1"}},
+		{Equation: "7", ForElements: []string{"r2"}},
+	}, capacity.ArrayEquations)
+	assert.Equal(t, "widgets", capacity.Units)
+	assert.Equal(t, "Per-region capacity.", capacity.Documentation)
+
+	crowding := m.Variables[6]
+	assert.Equal(t, "", crowding.Equation)
+	assert.Equal(t, &sdjson.GraphicalFunction{Points: []sdjson.Point{{X: 0, Y: 1}, {X: 1, Y: 0.8}, {X: 2, Y: 0.2}}}, crowding.GraphicalFunction)
+
+	assert.Equal(t, []sdjson.Relationship{
+		{From: "births", To: "population", Polarity: "+"},
+		{From: "population", To: "births", Polarity: "+"},
+		{From: "deaths", To: "population", Polarity: "-"},
+		{From: "population", To: "deaths"},
+		{From: "fertility (per capita)", To: "births", Polarity: "+"},
+	}, m.Relationships)
+}
+
+func TestSplitSum(t *testing.T) {
+	for _, tt := range []struct {
+		expr  string
+		terms []rateTerm
+	}{
+		{"a", []rateTerm{{1, "a"}}},
+		{"a + b - c", []rateTerm{{1, "a"}, {1, "b"}, {-1, "c"}}},
+		{"-a + MAX(b - c, 0)", []rateTerm{{-1, "a"}, {1, "MAX(b - c, 0)"}}},
+		{`"x-y" - z[r1]`, []rateTerm{{1, `"x-y"`}, {-1, "z[r1]"}}},
+		{"a + - b", nil},
+	} {
+		assert.Equal(t, tt.terms, splitSum(tt.expr), tt.expr)
+	}
+}
@@ -0,0 +1,149 @@
+package vensim
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Sketch object types, from the first field of each sketch line.
+const (
+	objectArrow    = 1
+	objectVariable = 10
+	objectValve    = 11
+	objectComment  = 12 // also used for clouds
+)
+
+// Arrow shapes used for the pipes between a valve and its stocks.
+const (
+	pipeHead   = 4
+	pipeNoHead = 100
+)
+
+type sketchObject struct {
+	kind int
+	id   int
+	name string
+	x, y float64
+	// flow is the id of the variable attached to a valve.
+	flow int
+}
+
+type sketchArrow struct {
+	id       int
+	from, to int
+	shape    int
+	polarity string
+}
+
+type sketchView struct {
+	name    string
+	objects map[int]*sketchObject
+	arrows  []sketchArrow
+}
+
+// splitSketchFields splits a sketch line on commas, keeping quoted
+// names intact.
+func splitSketchFields(line string) []string {
+	var fields []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				fields = append(fields, line[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, line[start:])
+}
+
+// arrowPolarity decodes an arrow's polarity field, which holds the
+// character code of the symbol drawn at its head.
+func arrowPolarity(field string) string {
+	code, err := strconv.Atoi(field)
+	if err != nil {
+		return ""
+	}
+	switch rune(code) {
+	case '+', 'S', 's':
+		return "+"
+	case '-', 'O', 'o':
+		return "-"
+	}
+	return ""
+}
+
+// parseSketch parses the views in a model's sketch section.  Lines it
+// doesn't understand are ignored, as they only affect appearance.
+func parseSketch(text string) []*sketchView {
+	var views []*sketchView
+	var view *sketchView
+	var lastValve *sketchObject
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "*") {
+			view = &sketchView{
+				name:    strings.TrimPrefix(line, "*"),
+				objects: make(map[int]*sketchObject),
+			}
+			views = append(views, view)
+			lastValve = nil
+			continue
+		}
+		if view == nil {
+			continue
+		}
+
+		fields := splitSketchFields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		kind, err1 := strconv.Atoi(fields[0])
+		id, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		switch kind {
+		case objectArrow:
+			if len(fields) < 7 {
+				continue
+			}
+			from, _ := strconv.Atoi(fields[2])
+			to, _ := strconv.Atoi(fields[3])
+			shape, _ := strconv.Atoi(fields[4])
+			view.arrows = append(view.arrows, sketchArrow{
+				id:       id,
+				from:     from,
+				to:       to,
+				shape:    shape,
+				polarity: arrowPolarity(fields[6]),
+			})
+		case objectVariable, objectValve, objectComment:
+			if len(fields) < 5 {
+				continue
+			}
+			obj := &sketchObject{kind: kind, id: id, name: displayName(fields[2])}
+			obj.x, _ = strconv.ParseFloat(fields[3], 64)
+			obj.y, _ = strconv.ParseFloat(fields[4], 64)
+			view.objects[id] = obj
+
+			// a valve is immediately followed by the variable holding
+			// its flow's equation.
+			if kind == objectVariable && lastValve != nil {
+				lastValve.flow = id
+			}
+			lastValve = nil
+			if kind == objectValve {
+				lastValve = obj
+			}
+		}
+	}
+
+	return views
+}
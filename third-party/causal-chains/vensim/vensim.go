@@ -0,0 +1,136 @@
+// Package vensim converts between Vensim text models (.mdl) and sdjson
+// models.
+//
+// Equations are copied verbatim in both directions; Vensim treats
+// spaces and underscores in names as equivalent, so equations written
+// for XMILE generally read correctly, but builtins are not translated
+// between the two dialects.
+package vensim
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// sketchStart and sketchEnd delimit the sketch (diagram) section
+	// that follows the equations.
+	sketchStart = `\\\---///`
+	sketchEnd   = `///---\\\`
+)
+
+// Control variables hold the simulation specs rather than model
+// structure.
+const (
+	initialTime = "initial time"
+	finalTime   = "final time"
+	timeStep    = "time step"
+	savePer     = "saveper"
+)
+
+var (
+	nameSpaceRe = regexp.MustCompile(`[\s_]+`)
+	plainNameRe = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_ $']*$`)
+)
+
+// canonicalName returns the form used to compare Vensim names: case,
+// surrounding quotes, and runs of spaces and underscores are ignored.
+func canonicalName(name string) string {
+	name = unquote(strings.TrimSpace(name))
+	name = nameSpaceRe.ReplaceAllString(name, " ")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func unquote(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `\"`, `"`)
+	}
+	return name
+}
+
+// displayName returns a Vensim name in the form sdjson uses, with
+// quotes removed and whitespace collapsed.
+func displayName(name string) string {
+	return strings.Join(strings.Fields(unquote(strings.TrimSpace(name))), " ")
+}
+
+// quoteName quotes a name if Vensim wouldn't otherwise parse it as a
+// single identifier.
+func quoteName(name string) string {
+	if plainNameRe.MatchString(name) {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+}
+
+// splitTopLevel splits s on sep, ignoring separators nested inside
+// parentheses, brackets or quoted names.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// indexTopLevel returns the index of the first sep in s that isn't
+// nested inside parentheses, brackets or quotes, or -1.
+func indexTopLevel(s string, sep byte) int {
+	parts := splitTopLevel(s, sep)
+	if len(parts) == 1 {
+		return -1
+	}
+	return len(parts[0])
+}
+
+// callArgs returns the arguments of expr if it is a single call to the
+// named function, such as "INTEG(a - b, 0)".  Function names match
+// case-insensitively, with any spacing between words.
+func callArgs(expr, function string) ([]string, bool) {
+	expr = strings.TrimSpace(expr)
+	words := strings.Fields(function)
+	for i := range words {
+		words[i] = regexp.QuoteMeta(words[i])
+	}
+	re := regexp.MustCompile(`(?i)^` + strings.Join(words, `\s+`) + `\s*\(`)
+	loc := re.FindStringIndex(expr)
+	if loc == nil || !strings.HasSuffix(expr, ")") {
+		return nil, false
+	}
+
+	inner := expr[loc[1] : len(expr)-1]
+	// make sure the closing paren belongs to this call, and not to a
+	// later one as in "MIN(a, b) + MAX(c, d)"
+	depth := 0
+	for _, c := range inner {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth < 0 {
+			return nil, false
+		}
+	}
+
+	args := splitTopLevel(inner, ',')
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args, true
+}
@@ -0,0 +1,328 @@
+package vensim
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+const (
+	// flowLength is the length of an auto-generated flow pipe that
+	// starts or ends in a cloud.
+	flowLength = 150
+	// minNodeSpacing is the minimum distance along the circumference
+	// between two auto-placed variables.
+	minNodeSpacing = 110
+)
+
+const sketchHeader = `V300  Do not put anything below this section - it will be ignored
+*View 1
+$192-192-192,0,Times New Roman|12||0-0-0|0-0-0|0-0-255|-1--1--1|-1--1--1|96,96,100,0
+`
+
+// Write serializes an sdjson model as a Vensim .mdl model, including a
+// sketch laid out automatically so that every variable and
+// relationship is visible when the model is opened in Vensim.
+func Write(w io.Writer, m *sdjson.Model) error {
+	var b strings.Builder
+
+	b.WriteString("{UTF-8}\n")
+	for _, d := range m.Specs.ArrayDimensions {
+		fmt.Fprintf(&b, "%s: %s\n\t~\t\n\t~\t\t|\n\n", quoteName(d.Name), strings.Join(quoteNames(d.Elements), ", "))
+	}
+	for i := range m.Variables {
+		writeVariable(&b, m, &m.Variables[i])
+	}
+	writeControl(&b, m.Specs)
+	writeSketch(&b, m)
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("io.WriteString: %w", err)
+	}
+	return nil
+}
+
+func quoteNames(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteName(name)
+	}
+	return quoted
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// formatLookup writes a graphical function as a Vensim lookup table,
+// preceded by its range.
+func formatLookup(gf *sdjson.GraphicalFunction) string {
+	xMin, xMax := math.Inf(1), math.Inf(-1)
+	yMin, yMax := xMin, xMax
+	points := make([]string, len(gf.Points))
+	for i, p := range gf.Points {
+		xMin, xMax = min(xMin, p.X), max(xMax, p.X)
+		yMin, yMax = min(yMin, p.Y), max(yMax, p.Y)
+		points[i] = "(" + formatFloat(p.X) + "," + formatFloat(p.Y) + ")"
+	}
+	return fmt.Sprintf("[(%s,%s)-(%s,%s)],%s",
+		formatFloat(xMin), formatFloat(yMin), formatFloat(xMax), formatFloat(yMax),
+		strings.Join(points, ","))
+}
+
+// causes lists the variables with a relationship into name; Vensim
+// uses them for the placeholder equation of a variable that doesn't
+// have one.
+func causes(m *sdjson.Model, name string) []string {
+	var from []string
+	for _, r := range m.Relationships {
+		if r.To == name && r.From != name {
+			from = append(from, quoteName(r.From))
+		}
+	}
+	return from
+}
+
+// equation returns the right-hand side for a variable, or for one
+// element of it when subscripts is set.
+func equation(m *sdjson.Model, v *sdjson.Variable, eqn, subscripts string) string {
+	if v.Type == sdjson.VariableTypeStock {
+		var rate strings.Builder
+		for _, flow := range v.Inflows {
+			if rate.Len() > 0 {
+				rate.WriteString(" + ")
+			}
+			rate.WriteString(quoteName(flow) + subscripts)
+		}
+		for _, flow := range v.Outflows {
+			rate.WriteString(" - " + quoteName(flow) + subscripts)
+		}
+		if rate.Len() == 0 {
+			rate.WriteString("0")
+		}
+		if eqn == "" {
+			eqn = "0"
+		}
+		return fmt.Sprintf("INTEG(%s, %s)", strings.TrimSpace(rate.String()), eqn)
+	}
+
+	if gf := v.GraphicalFunction; gf != nil && len(gf.Points) > 0 && eqn != "" {
+		return fmt.Sprintf("WITH LOOKUP(%s, (%s))", eqn, formatLookup(gf))
+	}
+	if eqn == "" {
+		return "A FUNCTION OF(" + strings.Join(causes(m, v.Name), ", ") + ")"
+	}
+	return eqn
+}
+
+func writeEntry(b *strings.Builder, lhs, rhs, units, comment string) {
+	fmt.Fprintf(b, "%s = %s\n\t~\t%s\n\t~\t%s\n\t|\n\n", lhs, rhs, units, comment)
+}
+
+func writeVariable(b *strings.Builder, m *sdjson.Model, v *sdjson.Variable) {
+	name := quoteName(v.Name)
+	// "~" and "|" delimit the parts of an entry
+	comment := strings.NewReplacer("~", "-", "|", "/").Replace(v.Documentation)
+
+	// a graphical function without an input is a standalone lookup,
+	// called like a function elsewhere in the model.
+	if gf := v.GraphicalFunction; gf != nil && len(gf.Points) > 0 && v.Equation == "" && len(v.ArrayEquations) == 0 {
+		fmt.Fprintf(b, "%s(\n\t%s)\n\t~\t%s\n\t~\t%s\n\t|\n\n", name, formatLookup(gf), v.Units, comment)
+		return
+	}
+
+	if len(v.ArrayEquations) == 0 {
+		lhs, subscripts := name, ""
+		if len(v.Dimensions) > 0 {
+			subscripts = "[" + strings.Join(quoteNames(v.Dimensions), ",") + "]"
+			lhs += subscripts
+		}
+		writeEntry(b, lhs, equation(m, v, v.Equation, subscripts), v.Units, comment)
+		return
+	}
+
+	// element equations share the units and comment written with the
+	// last of them
+	for i, ae := range v.ArrayEquations {
+		subscripts := "[" + strings.Join(quoteNames(ae.ForElements), ",") + "]"
+		rhs := equation(m, v, ae.Equation, subscripts)
+		if i < len(v.ArrayEquations)-1 {
+			fmt.Fprintf(b, "%s%s = %s ~~|\n", name, subscripts, rhs)
+			continue
+		}
+		writeEntry(b, name+subscripts, rhs, v.Units, comment)
+	}
+}
+
+func writeControl(b *strings.Builder, specs sdjson.Specs) {
+	dt := specs.DT
+	if dt == 0 {
+		dt = 1
+	}
+	savePer := "TIME STEP"
+	if specs.SaveStep != 0 {
+		savePer = formatFloat(specs.SaveStep)
+	}
+	units := specs.TimeUnits
+
+	b.WriteString("********************************************************\n\t.Control\n********************************************************~\n\t\tSimulation Control Parameters\n\t|\n\n")
+	writeEntry(b, "FINAL TIME", formatFloat(specs.StopTime), units, "The final time for the simulation.")
+	writeEntry(b, "INITIAL TIME", formatFloat(specs.StartTime), units, "The initial time for the simulation.")
+	writeEntry(b, "SAVEPER", savePer, strings.TrimSpace(units+" [0,?]"), "The frequency with which output is stored.")
+	writeEntry(b, "TIME STEP", formatFloat(dt), strings.TrimSpace(units+" [0,?]"), "The time step for the simulation.")
+}
+
+type position struct {
+	x, y float64
+}
+
+type sketchWriter struct {
+	b      *strings.Builder
+	nextID int
+	// ids maps variable names to the id of their sketch object.
+	ids map[string]int
+}
+
+func (s *sketchWriter) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *sketchWriter) variable(name string, p position, shape int) int {
+	id := s.id()
+	s.ids[name] = id
+	fmt.Fprintf(s.b, "10,%d,%s,%d,%d,40,20,%d,3,0,0,-1,0,0,0\n", id, quoteName(name), int(p.x), int(p.y), shape)
+	return id
+}
+
+func (s *sketchWriter) cloud(p position) int {
+	id := s.id()
+	fmt.Fprintf(s.b, "12,%d,48,%d,%d,10,8,0,3,0,0,-1,0,0,0\n", id, int(p.x), int(p.y))
+	return id
+}
+
+func (s *sketchWriter) arrow(from, to, shape int, polarity string, thickness, dtype int, via position) {
+	pol := 0
+	switch polarity {
+	case "+":
+		pol = '+'
+	case "-":
+		pol = '-'
+	}
+	fmt.Fprintf(s.b, "1,%d,%d,%d,%d,0,%d,%d,0,%d,0,-1--1--1,,1|(%d,%d)|\n",
+		s.id(), from, to, shape, pol, thickness, dtype, int(via.x), int(via.y))
+}
+
+// Shapes for sketch variables.
+const (
+	shapeStock = 3
+	shapeAux   = 8
+	shapeFlow  = 40
+)
+
+// writeSketch lays out the model like the XMILE writer: stocks and
+// auxiliaries evenly around a circle, flows between the stocks they
+// connect (with a cloud at a missing end), and an arrow for each
+// relationship other than the pipes between flows and stocks.
+func writeSketch(b *strings.Builder, m *sdjson.Model) {
+	b.WriteString(sketchStart + " Sketch information - do not modify anything except names\n")
+	b.WriteString(sketchHeader)
+
+	flowEnds := make(map[string][2]string) // flow -> [source stock, dest stock]
+	for _, v := range m.Variables {
+		if v.Type != sdjson.VariableTypeStock {
+			continue
+		}
+		for _, out := range v.Outflows {
+			ends := flowEnds[out]
+			ends[0] = v.Name
+			flowEnds[out] = ends
+		}
+		for _, in := range v.Inflows {
+			ends := flowEnds[in]
+			ends[1] = v.Name
+			flowEnds[in] = ends
+		}
+	}
+
+	var placed []*sdjson.Variable
+	for i := range m.Variables {
+		v := &m.Variables[i]
+		if _, ok := flowEnds[v.Name]; ok && v.Type == sdjson.VariableTypeFlow {
+			continue
+		}
+		placed = append(placed, v)
+	}
+
+	radius := max(150, float64(len(placed))*minNodeSpacing/(2*math.Pi))
+	center := position{x: radius + 100, y: radius + 100}
+
+	s := &sketchWriter{b: b, ids: make(map[string]int)}
+	positions := make(map[string]position)
+	for i, v := range placed {
+		theta := 2 * math.Pi * float64(i) / float64(len(placed))
+		p := position{
+			x: math.Round(center.x + radius*math.Cos(theta)),
+			y: math.Round(center.y + radius*math.Sin(theta)),
+		}
+		positions[v.Name] = p
+		shape := shapeAux
+		if v.Type == sdjson.VariableTypeStock {
+			shape = shapeStock
+		}
+		s.variable(v.Name, p, shape)
+	}
+
+	for _, v := range m.Variables {
+		ends, ok := flowEnds[v.Name]
+		if !ok || v.Type != sdjson.VariableTypeFlow {
+			continue
+		}
+		from, hasFrom := positions[ends[0]]
+		to, hasTo := positions[ends[1]]
+		switch {
+		case hasFrom && !hasTo:
+			to = position{x: from.x + flowLength, y: from.y}
+		case !hasFrom && hasTo:
+			from = position{x: to.x - flowLength, y: to.y}
+		}
+		valve := position{x: math.Round((from.x + to.x) / 2), y: math.Round((from.y + to.y) / 2)}
+
+		fromID, toID := s.ids[ends[0]], s.ids[ends[1]]
+		if !hasFrom {
+			fromID = s.cloud(from)
+		}
+		if !hasTo {
+			toID = s.cloud(to)
+		}
+
+		valveID := s.id()
+		fmt.Fprintf(b, "11,%d,0,%d,%d,6,8,34,3,0,0,1,0,0,0\n", valveID, int(valve.x), int(valve.y))
+		s.variable(v.Name, position{x: valve.x, y: valve.y + 18}, shapeFlow)
+		positions[v.Name] = valve
+
+		s.arrow(valveID, toID, pipeHead, "", 22, 0, to)
+		s.arrow(valveID, fromID, pipeNoHead, "", 22, 0, from)
+	}
+
+	seen := make(map[string]bool)
+	for _, r := range m.Relationships {
+		fromID, okFrom := s.ids[r.From]
+		toID, okTo := s.ids[r.To]
+		if !okFrom || !okTo || r.From == r.To || seen[r.Key()] {
+			continue
+		}
+		// flows and stocks are already connected by the flow's pipe
+		if ends, ok := flowEnds[r.From]; ok && (ends[0] == r.To || ends[1] == r.To) {
+			continue
+		}
+		seen[r.Key()] = true
+		s.arrow(fromID, toID, 0, r.Polarity, 0, 64, position{})
+	}
+
+	b.WriteString(sketchEnd + "\n")
+}
@@ -0,0 +1,95 @@
+package vensim
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestWriteRoundtrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(evalsDir, "*", "*.mdl"))
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			expected, _ := readFixture(t, path)
+
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, expected))
+
+			actual, err := Read(&buf)
+			require.NoError(t, err)
+
+			assert.Equal(t, expected.Specs, actual.Specs)
+			assert.Equal(t, expected.Variables, actual.Variables)
+			assert.Equal(t, relationshipSet(expected.Relationships), relationshipSet(actual.Relationships))
+		})
+	}
+}
+
+func TestWriteArrays(t *testing.T) {
+	expected, err := Read(strings.NewReader(cldMDL))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, expected))
+
+	actual, err := Read(&buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected.Specs.ArrayDimensions, actual.Specs.ArrayDimensions)
+	assert.Equal(t, expected.Variables, actual.Variables)
+	assert.ElementsMatch(t, expected.Relationships, actual.Relationships)
+}
+
+func TestWriteCausalLoopDiagram(t *testing.T) {
+	m := &causal.Map{
+		Title: "Adoption",
+		CausalChains: []causal.Chain{
+			{
+				InitialVariable: "adopters",
+				Relationships: []causal.RelationshipEntry{
+					{Variable: "word of mouth", Polarity: "+"},
+					{Variable: "adoption rate", Polarity: "+"},
+					{Variable: "adopters", Polarity: "+"},
+				},
+			},
+			{
+				InitialVariable: "adopters",
+				Relationships: []causal.RelationshipEntry{
+					{Variable: "potential adopters", Polarity: "-"},
+					{Variable: "adoption rate", Polarity: "+"},
+				},
+			},
+		},
+	}
+	model := m.Compat()
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, &model))
+	assert.Contains(t, buf.String(), "adoption rate = A FUNCTION OF(word of mouth, potential adopters)")
+
+	actual, err := Read(&buf)
+	require.NoError(t, err)
+
+	var relationships []sdjson.Relationship
+	for _, r := range model.Relationships {
+		relationships = append(relationships, sdjson.Relationship{From: r.From, To: r.To, Polarity: r.Polarity})
+	}
+	assert.ElementsMatch(t, relationships, actual.Relationships)
+
+	for _, v := range actual.Variables {
+		assert.Equal(t, sdjson.VariableTypeAux, v.Type, v.Name)
+		assert.Equal(t, "", v.Equation, v.Name)
+	}
+}
@@ -0,0 +1,481 @@
+package xmile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+var delayBuiltinRe = regexp.MustCompile(`(?i)\b(DELAY[0-9N]*|SMTH[0-9N]*)\s*\(`)
+
+// Read parses an XMILE document into an sdjson model.
+func Read(r io.Reader) (*sdjson.Model, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	// Stella occasionally writes mis-encoded font names into view
+	// styles; don't let that make the whole model unreadable.
+	data = bytes.ToValidUTF8(data, []byte("�"))
+
+	var f File
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("xml.Unmarshal: %w", err)
+	}
+
+	return f.SDJSON()
+}
+
+// flowSource records the stock a flow drains, for flows whose
+// sub-type is determined by the kind of stock they leave.
+type flowSource struct {
+	conveyor *Conveyor
+	queue    bool
+}
+
+type reader struct {
+	f *File
+	// names maps canonical model name ("" for the root model) to a map
+	// from canonical local identifier to the sdjson variable name.
+	names   map[string]map[string]string
+	ghostOf map[string]string
+	sources map[string]flowSource
+	// overflows are the flows that take what doesn't fit in a queue
+	overflows map[string]bool
+	mdl       *sdjson.Model
+}
+
+// SDJSON converts a parsed XMILE document into an sdjson model.
+// Connectors in each model's views become relationships, as do the
+// inflow (+) and outflow (-) links between flows and stocks.
+func (f *File) SDJSON() (*sdjson.Model, error) {
+	r := &reader{
+		f:         f,
+		names:     make(map[string]map[string]string),
+		ghostOf:   make(map[string]string),
+		sources:   make(map[string]flowSource),
+		overflows: make(map[string]bool),
+		mdl:       new(sdjson.Model),
+	}
+
+	r.indexNames()
+	r.indexConnections()
+
+	r.mdl.Specs = r.specs()
+
+	for i := range f.Models {
+		if err := r.convertModel(&f.Models[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range f.Models {
+		r.convertRelationships(&f.Models[i])
+	}
+
+	return r.mdl, nil
+}
+
+func modelPrefix(m *Model) string {
+	if m.Name == "" {
+		return ""
+	}
+	return displayName(m.Name) + "."
+}
+
+func (r *reader) indexNames() {
+	for i := range r.f.Models {
+		m := &r.f.Models[i]
+		prefix := modelPrefix(m)
+		names := make(map[string]string)
+		for _, v := range m.Variables.Items {
+			switch v.XMLName.Local {
+			case "stock", "flow", "aux":
+				names[canonicalIdent(v.Name)] = prefix + displayName(v.Name)
+			}
+		}
+		r.names[canonicalIdent(m.Name)] = names
+	}
+}
+
+// resolve looks up an identifier as referenced from within the given
+// model, following a single level of module qualification ("Mod.var").
+func (r *reader) resolve(model, ident string) (string, bool) {
+	ident = strings.TrimSpace(ident)
+	if name, ok := r.names[canonicalIdent(model)][canonicalIdent(ident)]; ok {
+		return name, true
+	}
+	if module, local, ok := strings.Cut(ident, "."); ok {
+		if name, ok := r.names[canonicalIdent(module)][canonicalIdent(local)]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// indexConnections records where each module input is wired from, and
+// which flows drain conveyors and queues or overflow queues.
+func (r *reader) indexConnections() {
+	for i := range r.f.Models {
+		m := &r.f.Models[i]
+		for _, v := range m.Variables.Items {
+			switch v.XMLName.Local {
+			case "module":
+				for _, c := range v.Connects {
+					to, okTo := r.resolve(m.Name, c.To)
+					from, okFrom := r.resolve(m.Name, c.From)
+					if okTo && okFrom && to != from {
+						r.ghostOf[to] = from
+					}
+				}
+			case "flow":
+				if name, ok := r.resolve(m.Name, v.Name); ok && v.Overflow != nil {
+					r.overflows[name] = true
+				}
+			case "stock":
+				if v.Conveyor == nil && v.Queue == nil {
+					continue
+				}
+				for _, out := range v.Outflows {
+					if name, ok := r.resolve(m.Name, out); ok {
+						r.sources[name] = flowSource{conveyor: v.Conveyor, queue: v.Queue != nil}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (r *reader) specs() sdjson.Specs {
+	ss := r.f.SimSpecs
+	specs := sdjson.Specs{
+		StartTime: ss.Start,
+		StopTime:  ss.Stop,
+		SaveStep:  ss.SaveStep,
+		TimeUnits: ss.TimeUnits,
+	}
+
+	if ss.DT != nil {
+		specs.DT = ss.DT.Value
+		if ss.DT.Reciprocal && ss.DT.Value != 0 {
+			specs.DT = 1 / ss.DT.Value
+		}
+	}
+
+	switch strings.ToLower(ss.Method) {
+	case "":
+	case "euler":
+		specs.IntegrationMethod = sdjson.IntegrationEuler
+	case "rk4":
+		specs.IntegrationMethod = sdjson.IntegrationRK4
+	default:
+		specs.IntegrationMethod = sdjson.IntegrationMethod(ss.Method)
+	}
+
+	for _, d := range r.f.Dimensions {
+		dim := sdjson.ArrayDimension{
+			Name: d.Name,
+		}
+		if len(d.Elements) > 0 {
+			dim.Type = sdjson.DimensionLabels
+			for _, e := range d.Elements {
+				dim.Elements = append(dim.Elements, e.Name)
+			}
+		} else {
+			dim.Type = sdjson.DimensionNumeric
+			for i := 1; i <= d.Size; i++ {
+				dim.Elements = append(dim.Elements, strconv.Itoa(i))
+			}
+		}
+		dim.Size = len(dim.Elements)
+		specs.ArrayDimensions = append(specs.ArrayDimensions, dim)
+	}
+
+	return specs
+}
+
+func (r *reader) resolveAll(model string, idents []string) []string {
+	names := make([]string, 0, len(idents))
+	for _, ident := range idents {
+		name, ok := r.resolve(model, ident)
+		if !ok {
+			name = modelPrefix(&Model{Name: model}) + displayName(ident)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (r *reader) convertModel(m *Model) error {
+	prefix := modelPrefix(m)
+
+	for _, xv := range m.Variables.Items {
+		var v sdjson.Variable
+		switch xv.XMLName.Local {
+		case "stock":
+			v.Type = sdjson.VariableTypeStock
+		case "flow":
+			v.Type = sdjson.VariableTypeFlow
+		case "aux":
+			v.Type = sdjson.VariableTypeAux
+		case "module":
+			r.mdl.Modules = append(r.mdl.Modules, sdjson.Module{
+				Name:         displayName(xv.Name),
+				ParentModule: displayName(m.Name),
+			})
+			continue
+		default:
+			continue
+		}
+
+		v.Name = prefix + displayName(xv.Name)
+		v.Documentation = strings.TrimSpace(xv.Doc)
+		v.Units = strings.TrimSpace(xv.Units)
+		if xv.Eqn != nil {
+			v.Equation = strings.TrimSpace(*xv.Eqn)
+		}
+
+		for _, d := range xv.Dimensions {
+			v.Dimensions = append(v.Dimensions, d.Name)
+		}
+		for _, e := range xv.Elements {
+			elements := strings.Split(e.Subscript, ",")
+			for i := range elements {
+				elements[i] = strings.TrimSpace(elements[i])
+			}
+			v.ArrayEquations = append(v.ArrayEquations, sdjson.ArrayEquation{
+				Equation:    strings.TrimSpace(e.Eqn),
+				ForElements: elements,
+			})
+		}
+
+		if xv.GF != nil {
+			gf, err := convertGF(xv.GF)
+			if err != nil {
+				return fmt.Errorf("variable %q: %w", v.Name, err)
+			}
+			v.GraphicalFunction = gf
+		}
+
+		switch v.Type {
+		case sdjson.VariableTypeStock:
+			r.convertStock(m, &xv, &v)
+		case sdjson.VariableTypeFlow:
+			r.convertFlow(&xv, &v)
+		case sdjson.VariableTypeAux:
+			if xv.DelayAux != nil || delayBuiltinRe.MatchString(v.Equation) {
+				v.SubType = sdjson.SubTypeDelayVariable
+			}
+		}
+
+		// module inputs are ghosts of the variable wired into them.  Their
+		// own equation is only used when the module is unconnected, so we
+		// keep it, but they no longer behave like a stock.
+		if source, ok := r.ghostOf[v.Name]; ok {
+			v.Type = sdjson.VariableTypeAux
+			v.CrossLevelGhostOf = source
+			v.Inflows = nil
+			v.Outflows = nil
+			v.SubType = ""
+		}
+
+		r.mdl.Variables = append(r.mdl.Variables, v)
+	}
+
+	return nil
+}
+
+func (r *reader) convertStock(m *Model, xv *Variable, v *sdjson.Variable) {
+	v.Inflows = r.resolveAll(m.Name, xv.Inflows)
+	v.Outflows = r.resolveAll(m.Name, xv.Outflows)
+
+	switch {
+	case xv.Conveyor != nil:
+		c := xv.Conveyor
+		v.SubType = sdjson.SubTypeConveyor
+		v.AdditionalProperties = &sdjson.ConveyorProperties{
+			ProcessTime: strings.TrimSpace(c.Len),
+			Capacity:    strings.TrimSpace(c.Capacity),
+			InflowLimit: strings.TrimSpace(c.InLimit),
+			Sample:      strings.TrimSpace(c.Sample),
+			Arrest:      strings.TrimSpace(c.Arrest),
+			OneAtATime:  c.OneAtATime,
+		}
+	case xv.Queue != nil:
+		v.SubType = sdjson.SubTypeQueue
+		props := &sdjson.QueueProperties{}
+		for _, out := range v.Outflows {
+			props.Overflow = props.Overflow || r.overflows[out]
+		}
+		v.AdditionalProperties = props
+	case xv.NonNegative != nil:
+		v.SubType = sdjson.SubTypeNonNegative
+	}
+}
+
+func (r *reader) convertFlow(xv *Variable, v *sdjson.Variable) {
+	if xv.NonNegative != nil {
+		uniflow := true
+		v.Uniflow = &uniflow
+	}
+
+	source, fromSpecialStock := r.sources[v.Name]
+
+	switch {
+	case xv.Leak != nil:
+		props := &sdjson.LeakageProperties{
+			LeakFraction: v.Equation,
+			LeakIntegers: xv.LeakIntegers != nil,
+		}
+		if c := source.conveyor; c != nil {
+			props.Exponential = c.Exponential
+			props.LeakZoneStart = strings.TrimSpace(c.LeakZoneStart)
+			props.LeakZoneEnd = strings.TrimSpace(c.LeakZoneEnd)
+		}
+		v.SubType = sdjson.SubTypeConveyorLeakage
+		v.AdditionalProperties = props
+		v.Equation = ""
+	case fromSpecialStock && source.conveyor != nil:
+		v.SubType = sdjson.SubTypeDiscreteOutflow
+		v.Equation = ""
+	case fromSpecialStock && source.queue:
+		v.SubType = sdjson.SubTypeQueueOutflow
+		if xv.Overflow != nil {
+			v.SubType = sdjson.SubTypeQueueOverflow
+		}
+		v.Equation = ""
+	}
+}
+
+func parsePts(pts *Pts) ([]float64, error) {
+	sep := pts.Sep
+	if sep == "" {
+		sep = ","
+	}
+
+	var values []float64
+	for _, s := range strings.Split(pts.Values, sep) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("strconv.ParseFloat(%q): %w", s, err)
+		}
+		values = append(values, f)
+	}
+	return values, nil
+}
+
+func convertGF(gf *GF) (*sdjson.GraphicalFunction, error) {
+	if gf.YPts == nil {
+		return nil, fmt.Errorf("graphical function has no ypts")
+	}
+	ys, err := parsePts(gf.YPts)
+	if err != nil {
+		return nil, fmt.Errorf("ypts: %w", err)
+	}
+
+	var xs []float64
+	if gf.XPts != nil {
+		if xs, err = parsePts(gf.XPts); err != nil {
+			return nil, fmt.Errorf("xpts: %w", err)
+		}
+		if len(xs) != len(ys) {
+			return nil, fmt.Errorf("graphical function has %d xpts but %d ypts", len(xs), len(ys))
+		}
+	} else {
+		if gf.XScale == nil {
+			return nil, fmt.Errorf("graphical function has neither xpts nor xscale")
+		}
+		xs = make([]float64, len(ys))
+		for i := range ys {
+			if len(ys) == 1 {
+				xs[i] = gf.XScale.Min
+				continue
+			}
+			xs[i] = gf.XScale.Min + float64(i)*(gf.XScale.Max-gf.XScale.Min)/float64(len(ys)-1)
+		}
+	}
+
+	points := make([]sdjson.Point, len(ys))
+	for i := range ys {
+		points[i] = sdjson.Point{X: xs[i], Y: ys[i]}
+	}
+
+	return &sdjson.GraphicalFunction{Points: points}, nil
+}
+
+func (r *reader) endpointName(m *Model, aliases map[int]string, e Endpoint) (string, bool) {
+	ident := e.Name
+	if e.Alias != nil {
+		ident = aliases[e.Alias.UID]
+	}
+	return r.resolve(m.Name, ident)
+}
+
+func (r *reader) convertRelationships(m *Model) {
+	seen := make(map[string]bool)
+	for _, rel := range r.mdl.Relationships {
+		seen[rel.Key()] = true
+	}
+	add := func(rel sdjson.Relationship) {
+		if rel.From == rel.To || seen[rel.Key()] {
+			return
+		}
+		seen[rel.Key()] = true
+		r.mdl.Relationships = append(r.mdl.Relationships, rel)
+	}
+
+	for _, view := range m.Views {
+		aliases := make(map[int]string, len(view.Aliases))
+		for _, a := range view.Aliases {
+			aliases[a.UID] = a.Of
+		}
+
+		for _, c := range view.Connectors {
+			from, okFrom := r.endpointName(m, aliases, c.From)
+			to, okTo := r.endpointName(m, aliases, c.To)
+			// connectors between modules don't name variables
+			if !okFrom || !okTo {
+				continue
+			}
+			polarity := ""
+			switch strings.TrimSpace(c.Polarity) {
+			case "+":
+				polarity = "+"
+			case "-":
+				polarity = "-"
+			}
+			add(sdjson.Relationship{
+				From:              from,
+				To:                to,
+				Polarity:          polarity,
+				Reasoning:         strings.TrimSpace(c.Reasoning),
+				PolarityReasoning: strings.TrimSpace(c.PolarityReasoning),
+			})
+		}
+	}
+
+	prefix := modelPrefix(m)
+	for _, xv := range m.Variables.Items {
+		if xv.XMLName.Local != "stock" {
+			continue
+		}
+		stock := prefix + displayName(xv.Name)
+		for _, flow := range r.resolveAll(m.Name, xv.Inflows) {
+			add(sdjson.Relationship{From: flow, To: stock, Polarity: "+"})
+		}
+		for _, flow := range r.resolveAll(m.Name, xv.Outflows) {
+			add(sdjson.Relationship{From: flow, To: stock, Polarity: "-"})
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package xmile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+// evalsDir holds the paired .stmx/.json fixtures used by the JS evals.
+const evalsDir = "../../../evals/categories"
+
+// staleEquations lists fixture variables whose .stmx equation was edited
+// after the paired .json was exported.
+var staleEquations = map[string]bool{
+	"maibab.stmx/Food": true,
+}
+
+type fixture struct {
+	Model sdjson.Model `json:"model"`
+}
+
+func readFixture(t *testing.T, stmxPath string) (actual, expected *sdjson.Model) {
+	t.Helper()
+
+	f, err := os.Open(stmxPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	actual, err = Read(f)
+	require.NoError(t, err)
+
+	jsonBytes, err := os.ReadFile(strings.TrimSuffix(stmxPath, ".stmx") + ".json")
+	require.NoError(t, err)
+
+	var fix fixture
+	require.NoError(t, json.Unmarshal(jsonBytes, &fix))
+
+	return actual, &fix.Model
+}
+
+func relationshipSet(rels []sdjson.Relationship) []string {
+	set := make([]string, 0, len(rels))
+	for _, r := range rels {
+		// the fixtures record a self-link for connectors drawn between
+		// modules; those don't name real relationships.
+		if r.From == r.To {
+			continue
+		}
+		set = append(set, r.Key()+" "+r.Polarity)
+	}
+	sort.Strings(set)
+	return set
+}
+
+func TestReadConformance(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(evalsDir, "*", "*.stmx"))
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(filepath.Dir(path))+"/"+filepath.Base(path), func(t *testing.T) {
+			actual, expected := readFixture(t, path)
+
+			assert.Equal(t, expected.Specs.StartTime, actual.Specs.StartTime)
+			assert.Equal(t, expected.Specs.StopTime, actual.Specs.StopTime)
+			assert.Equal(t, expected.Specs.DT, actual.Specs.DT)
+			assert.Equal(t, expected.Specs.TimeUnits, actual.Specs.TimeUnits)
+
+			actualVars := make(map[string]sdjson.Variable, len(actual.Variables))
+			for _, v := range actual.Variables {
+				actualVars[v.Name] = v
+			}
+			require.Len(t, actual.Variables, len(expected.Variables))
+
+			for _, ev := range expected.Variables {
+				av, ok := actualVars[ev.Name]
+				if !assert.True(t, ok, "missing variable %q", ev.Name) {
+					continue
+				}
+				assert.Equal(t, ev.Type, av.Type, ev.Name)
+				if !staleEquations[filepath.Base(path)+"/"+ev.Name] {
+					assert.Equal(t, ev.Equation, av.Equation, ev.Name)
+				}
+				assert.Equal(t, ev.Units, av.Units, ev.Name)
+				assert.Equal(t, ev.Documentation, av.Documentation, ev.Name)
+				assert.Equal(t, ev.CrossLevelGhostOf, av.CrossLevelGhostOf, ev.Name)
+				assert.ElementsMatch(t, ev.Inflows, av.Inflows, ev.Name)
+				assert.ElementsMatch(t, ev.Outflows, av.Outflows, ev.Name)
+				if ev.GraphicalFunction == nil {
+					assert.Nil(t, av.GraphicalFunction, ev.Name)
+				} else if assert.NotNil(t, av.GraphicalFunction, ev.Name) {
+					require.Len(t, av.GraphicalFunction.Points, len(ev.GraphicalFunction.Points), ev.Name)
+					for i, p := range ev.GraphicalFunction.Points {
+						assert.InDelta(t, p.X, av.GraphicalFunction.Points[i].X, 1e-9, ev.Name)
+						assert.InDelta(t, p.Y, av.GraphicalFunction.Points[i].Y, 1e-9, ev.Name)
+					}
+				}
+			}
+
+			assert.Equal(t, relationshipSet(expected.Relationships), relationshipSet(actual.Relationships))
+		})
+	}
+}
+
+func TestReadModules(t *testing.T) {
+	path := filepath.Join(evalsDir, "modularModificationData", "predatorPreyModular.stmx")
+	if _, err := os.Stat(path); err != nil {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	actual, _ := readFixture(t, path)
+	assert.Equal(t, []sdjson.Module{{Name: "Hares"}, {Name: "Lynx"}}, actual.Modules)
+}
+
+const arrayedXMILE = `<?xml version="1.0" encoding="utf-8"?>
+<xmile version="1.0" xmlns="http://docs.oasis-open.org/xmile/ns/XMILE/v1.0" xmlns:isee="http://iseesystems.com/XMILE">
+	<header><name>arrays</name></header>
+	<sim_specs method="RK4" time_units="Weeks">
+		<start>1</start>
+		<stop>10</stop>
+		<dt>0.5</dt>
+	</sim_specs>
+	<dimensions>
+		<dim name="region">
+			<elem name="North"/>
+			<elem name="South"/>
+		</dim>
+		<dim name="cohort" size="3"/>
+	</dimensions>
+	<model>
+		<variables>
+			<stock name="Inventory">
+				<dimensions><dim name="region"/></dimensions>
+				<element subscript="North"><eqn>60</eqn></element>
+				<element subscript="South"><eqn>40</eqn></element>
+				<inflow>production</inflow>
+				<outflow>shipping</outflow>
+			</stock>
+			<flow name="production">
+				<dimensions><dim name="region"/></dimensions>
+				<eqn>10</eqn>
+				<non_negative/>
+			</flow>
+			<stock name="Shipping\nLine">
+				<eqn>0</eqn>
+				<inflow>shipping</inflow>
+				<outflow>arrivals</outflow>
+				<outflow>spoilage</outflow>
+				<conveyor>
+					<len>4</len>
+					<capacity>100</capacity>
+					<leak_zone_start>0.5</leak_zone_start>
+				</conveyor>
+			</stock>
+			<flow name="shipping"><eqn>Inventory[North]/2</eqn></flow>
+			<flow name="arrivals"/>
+			<flow name="spoilage"><eqn>0.1</eqn><leak/></flow>
+			<aux name="smoothed">
+				<eqn>SMTH1(shipping, 3)</eqn>
+			</aux>
+		</variables>
+		<views>
+			<view>
+				<aux name="smoothed" x="10" y="10"/>
+				<alias uid="7"><of>shipping</of></alias>
+				<connector uid="8" polarity="+">
+					<from><alias uid="7"/></from>
+					<to>smoothed</to>
+				</connector>
+				<connector uid="9">
+					<from>Inventory</from>
+					<to>shipping</to>
+				</connector>
+			</view>
+		</views>
+	</model>
+</xmile>`
+
+func TestReadArraysAndConveyors(t *testing.T) {
+	m, err := Read(strings.NewReader(arrayedXMILE))
+	require.NoError(t, err)
+
+	assert.Equal(t, sdjson.Specs{
+		StartTime:         1,
+		StopTime:          10,
+		DT:                0.5,
+		TimeUnits:         "Weeks",
+		IntegrationMethod: sdjson.IntegrationRK4,
+		ArrayDimensions: []sdjson.ArrayDimension{
+			{Type: sdjson.DimensionLabels, Name: "region", Size: 2, Elements: []string{"North", "South"}},
+			{Type: sdjson.DimensionNumeric, Name: "cohort", Size: 3, Elements: []string{"1", "2", "3"}},
+		},
+	}, m.Specs)
+
+	require.Len(t, m.Variables, 7)
+
+	inventory := m.Variables[0]
+	assert.Equal(t, []string{"region"}, inventory.Dimensions)
+	assert.Equal(t, []sdjson.ArrayEquation{
+		{Equation: "60", ForElements: []string{"North"}},
+		{Equation: "40", ForElements: []string{"South"}},
+	}, inventory.ArrayEquations)
+
+	require.NotNil(t, m.Variables[1].Uniflow)
+	assert.True(t, *m.Variables[1].Uniflow)
+
+	line := m.Variables[2]
+	assert.Equal(t, "Shipping Line", line.Name)
+	assert.Equal(t, sdjson.SubTypeConveyor, line.SubType)
+	assert.Equal(t, &sdjson.ConveyorProperties{ProcessTime: "4", Capacity: "100"}, line.AdditionalProperties)
+
+	assert.Equal(t, sdjson.SubTypeDiscreteOutflow, m.Variables[4].SubType)
+
+	spoilage := m.Variables[5]
+	assert.Equal(t, sdjson.SubTypeConveyorLeakage, spoilage.SubType)
+	assert.Equal(t, "", spoilage.Equation)
+	assert.Equal(t, &sdjson.LeakageProperties{LeakFraction: "0.1", LeakZoneStart: "0.5"}, spoilage.AdditionalProperties)
+
+	assert.Equal(t, sdjson.SubTypeDelayVariable, m.Variables[6].SubType)
+
+	assert.Equal(t, []sdjson.Relationship{
+		{From: "shipping", To: "smoothed", Polarity: "+"},
+		{From: "Inventory", To: "shipping"},
+		{From: "production", To: "Inventory", Polarity: "+"},
+		{From: "shipping", To: "Inventory", Polarity: "-"},
+		{From: "shipping", To: "Shipping Line", Polarity: "+"},
+		{From: "arrivals", To: "Shipping Line", Polarity: "-"},
+		{From: "spoilage", To: "Shipping Line", Polarity: "-"},
+	}, m.Relationships)
+}
+
+const queueXMILE = `<?xml version="1.0" encoding="utf-8"?>
+<xmile version="1.0" xmlns="http://docs.oasis-open.org/xmile/ns/XMILE/v1.0">
+	<sim_specs><start>0</start><stop>10</stop></sim_specs>
+	<model>
+		<variables>
+			<stock name="Waiting">
+				<eqn>0</eqn>
+				<inflow>arriving</inflow>
+				<outflow>served</outflow>
+				<outflow>turned_away</outflow>
+				<queue/>
+			</stock>
+			<flow name="arriving"><eqn>3</eqn></flow>
+			<flow name="served"><eqn>2</eqn></flow>
+			<flow name="turned away"><eqn>0</eqn><overflow/></flow>
+		</variables>
+	</model>
+</xmile>`
+
+func TestReadQueue(t *testing.T) {
+	m, err := Read(strings.NewReader(queueXMILE))
+	require.NoError(t, err)
+	require.Len(t, m.Variables, 4)
+
+	waiting := m.Variables[0]
+	assert.Equal(t, sdjson.SubTypeQueue, waiting.SubType)
+	assert.Equal(t, &sdjson.QueueProperties{Overflow: true}, waiting.AdditionalProperties)
+	assert.Equal(t, sdjson.SubType(""), m.Variables[1].SubType)
+	assert.Equal(t, sdjson.SubTypeQueueOutflow, m.Variables[2].SubType)
+	assert.Equal(t, sdjson.SubTypeQueueOverflow, m.Variables[3].SubType)
+}
@@ -0,0 +1,489 @@
+package xmile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+const (
+	// flowLength is the length of an auto-generated flow pipe that
+	// starts or ends in a cloud.
+	flowLength = 150
+	// minNodeSpacing is the minimum distance along the circumference
+	// between two auto-placed variables.
+	minNodeSpacing = 110
+)
+
+// Write serializes an sdjson model as an XMILE document, including an
+// automatically laid out view so that every variable and relationship
+// is visible when the file is opened in Stella or Simlin.
+func Write(w io.Writer, m *sdjson.Model) error {
+	f := NewFile(m)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("io.WriteString: %w", err)
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(f); err != nil {
+		return fmt.Errorf("enc.Encode: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("enc.Close: %w", err)
+	}
+
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("io.WriteString: %w", err)
+	}
+
+	return nil
+}
+
+type writer struct {
+	m       *sdjson.Model
+	modules map[string]sdjson.Module
+	models  map[string]*Model
+	order   []string
+	access  map[string]string
+	// connects holds the connections for each module, keyed by the
+	// module name.
+	connects map[string][]Connect
+}
+
+// NewFile converts an sdjson model into an XMILE document.  Variables
+// named "Module.variable" are placed in the model for that module, and
+// cross-level ghosts become module inputs wired to their source.
+func NewFile(m *sdjson.Model) *File {
+	w := &writer{
+		m:        m,
+		modules:  make(map[string]sdjson.Module, len(m.Modules)),
+		models:   make(map[string]*Model),
+		access:   make(map[string]string),
+		connects: make(map[string][]Connect),
+	}
+	for _, mod := range m.Modules {
+		w.modules[mod.Name] = mod
+	}
+
+	f := &File{
+		Version:   "1.0",
+		Xmlns:     Namespace,
+		XmlnsIsee: IseeNamespace,
+		Header: Header{
+			Smile:   &Smile{Version: "1.0", Namespace: "std, isee"},
+			Vendor:  "sd-ai",
+			Product: &Product{Name: "sd-ai"},
+		},
+		SimSpecs:   convertSpecs(m.Specs),
+		Dimensions: convertDimensions(m.Specs.ArrayDimensions),
+	}
+
+	w.model("")
+	w.indexGhosts()
+
+	for _, mod := range m.Modules {
+		parent := w.model(mod.ParentModule)
+		w.model(mod.Name)
+		parent.Variables.Items = append(parent.Variables.Items, Variable{
+			XMLName:  xml.Name{Local: "module"},
+			Name:     mod.Name,
+			Connects: w.connects[mod.Name],
+		})
+	}
+
+	for i := range m.Variables {
+		v := &m.Variables[i]
+		module, _ := w.splitName(v.Name)
+		model := w.model(module)
+		model.Variables.Items = append(model.Variables.Items, w.convertVariable(module, v))
+	}
+
+	for _, name := range w.order {
+		model := w.models[name]
+		model.Views = []View{w.layout(name, model)}
+		f.Models = append(f.Models, *model)
+	}
+
+	return f
+}
+
+// model returns the XMILE model for the named module ("" for the root
+// model), creating it if necessary.
+func (w *writer) model(module string) *Model {
+	if model, ok := w.models[module]; ok {
+		return model
+	}
+	model := &Model{Name: module}
+	w.models[module] = model
+	w.order = append(w.order, module)
+	return model
+}
+
+// splitName separates a variable's module from its local name; only
+// prefixes that name a declared module are treated as qualifiers.
+func (w *writer) splitName(name string) (module, local string) {
+	if module, local, ok := strings.Cut(name, "."); ok {
+		if _, ok := w.modules[module]; ok {
+			return module, local
+		}
+	}
+	return "", name
+}
+
+func ident(name string) string {
+	return strings.ReplaceAll(name, " ", "_")
+}
+
+// ref returns how the named variable is referenced from inside the
+// model for the given module.
+func (w *writer) ref(fromModule, name string) string {
+	module, local := w.splitName(name)
+	if module == fromModule || module == "" {
+		return ident(local)
+	}
+	return module + "." + ident(local)
+}
+
+func (w *writer) indexGhosts() {
+	for _, v := range w.m.Variables {
+		if v.CrossLevelGhostOf == "" {
+			continue
+		}
+		module, _ := w.splitName(v.Name)
+		parent := w.modules[module].ParentModule
+		w.connects[module] = append(w.connects[module], Connect{
+			To:   w.ref(parent, v.Name),
+			From: w.ref(parent, v.CrossLevelGhostOf),
+		})
+		w.access[v.Name] = "input"
+		if w.access[v.CrossLevelGhostOf] == "" {
+			w.access[v.CrossLevelGhostOf] = "output"
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func convertSpecs(s sdjson.Specs) SimSpecs {
+	ss := SimSpecs{
+		Method:    string(s.IntegrationMethod),
+		TimeUnits: s.TimeUnits,
+		Start:     s.StartTime,
+		Stop:      s.StopTime,
+		SaveStep:  s.SaveStep,
+	}
+	if ss.Method == "" {
+		ss.Method = string(sdjson.IntegrationEuler)
+	}
+	if s.DT > 0 {
+		ss.DT = &DT{Value: s.DT}
+	}
+	return ss
+}
+
+func convertDimensions(dims []sdjson.ArrayDimension) Dims {
+	var result Dims
+	for _, d := range dims {
+		dim := Dim{Name: d.Name}
+		if d.Type == sdjson.DimensionLabels {
+			for _, e := range d.Elements {
+				dim.Elements = append(dim.Elements, Elem{Name: e})
+			}
+		} else {
+			dim.Size = d.Size
+			if dim.Size == 0 {
+				dim.Size = len(d.Elements)
+			}
+		}
+		result = append(result, dim)
+	}
+	return result
+}
+
+func convertGraphicalFunction(gf *sdjson.GraphicalFunction) *GF {
+	if gf == nil || len(gf.Points) == 0 {
+		return nil
+	}
+
+	xs := make([]string, len(gf.Points))
+	ys := make([]string, len(gf.Points))
+	xScale := Scale{Min: math.Inf(1), Max: math.Inf(-1)}
+	yScale := xScale
+	for i, p := range gf.Points {
+		xs[i] = formatFloat(p.X)
+		ys[i] = formatFloat(p.Y)
+		xScale.Min, xScale.Max = min(xScale.Min, p.X), max(xScale.Max, p.X)
+		yScale.Min, yScale.Max = min(yScale.Min, p.Y), max(yScale.Max, p.Y)
+	}
+
+	return &GF{
+		XScale: &xScale,
+		YScale: &yScale,
+		XPts:   &Pts{Values: strings.Join(xs, ",")},
+		YPts:   &Pts{Values: strings.Join(ys, ",")},
+	}
+}
+
+func (w *writer) refs(module string, names []string) []string {
+	refs := make([]string, len(names))
+	for i, name := range names {
+		refs[i] = w.ref(module, name)
+	}
+	return refs
+}
+
+func (w *writer) convertVariable(module string, v *sdjson.Variable) Variable {
+	_, local := w.splitName(v.Name)
+	xv := Variable{
+		Name:   local,
+		Access: w.access[v.Name],
+		Doc:    v.Documentation,
+		Units:  v.Units,
+		GF:     convertGraphicalFunction(v.GraphicalFunction),
+	}
+
+	switch v.Type {
+	case sdjson.VariableTypeStock:
+		xv.XMLName.Local = "stock"
+	case sdjson.VariableTypeFlow:
+		xv.XMLName.Local = "flow"
+	default:
+		xv.XMLName.Local = "aux"
+	}
+
+	for _, d := range v.Dimensions {
+		xv.Dimensions = append(xv.Dimensions, Dim{Name: d})
+	}
+	for _, ae := range v.ArrayEquations {
+		xv.Elements = append(xv.Elements, ElementEqn{
+			Subscript: strings.Join(ae.ForElements, ", "),
+			Eqn:       ae.Equation,
+		})
+	}
+	if v.Equation != "" {
+		eqn := v.Equation
+		xv.Eqn = &eqn
+	}
+
+	switch v.Type {
+	case sdjson.VariableTypeStock:
+		xv.Inflows = w.refs(module, v.Inflows)
+		xv.Outflows = w.refs(module, v.Outflows)
+		w.convertStockSubType(v, &xv)
+	case sdjson.VariableTypeFlow:
+		if v.Uniflow != nil && *v.Uniflow {
+			xv.NonNegative = &struct{}{}
+		}
+		if v.SubType == sdjson.SubTypeQueueOverflow {
+			xv.Overflow = &struct{}{}
+		}
+		if props, ok := v.AdditionalProperties.(*sdjson.LeakageProperties); ok && v.SubType == sdjson.SubTypeConveyorLeakage {
+			xv.Leak = &struct{}{}
+			if props.LeakIntegers {
+				xv.LeakIntegers = &struct{}{}
+			}
+			if props.LeakFraction != "" {
+				eqn := props.LeakFraction
+				xv.Eqn = &eqn
+			}
+		}
+	default:
+		if v.SubType == sdjson.SubTypeDelayVariable {
+			xv.DelayAux = &struct{}{}
+		}
+	}
+
+	return xv
+}
+
+func (w *writer) convertStockSubType(v *sdjson.Variable, xv *Variable) {
+	switch v.SubType {
+	case sdjson.SubTypeNonNegative:
+		xv.NonNegative = &struct{}{}
+	case sdjson.SubTypeQueue:
+		xv.Queue = &struct{}{}
+	case sdjson.SubTypeConveyor:
+		c := &Conveyor{}
+		if props, ok := v.AdditionalProperties.(*sdjson.ConveyorProperties); ok {
+			c.Len = props.ProcessTime
+			c.Capacity = props.Capacity
+			c.InLimit = props.InflowLimit
+			c.Sample = props.Sample
+			c.Arrest = props.Arrest
+			c.OneAtATime = props.OneAtATime
+		}
+		// leak zones live on the conveyor in XMILE, but on the leakage
+		// flow in sdjson.
+		for _, out := range v.Outflows {
+			flow := w.variable(out)
+			if flow == nil || flow.SubType != sdjson.SubTypeConveyorLeakage {
+				continue
+			}
+			if props, ok := flow.AdditionalProperties.(*sdjson.LeakageProperties); ok {
+				c.LeakZoneStart = props.LeakZoneStart
+				c.LeakZoneEnd = props.LeakZoneEnd
+				c.Exponential = props.Exponential
+			}
+		}
+		xv.Conveyor = c
+	}
+}
+
+func (w *writer) variable(name string) *sdjson.Variable {
+	for i := range w.m.Variables {
+		if w.m.Variables[i].Name == name {
+			return &w.m.Variables[i]
+		}
+	}
+	return nil
+}
+
+type position struct {
+	x, y float64
+}
+
+// layout places every variable in a model's view: stocks and auxes
+// evenly around a circle, and flows between the stocks they connect
+// (or next to them, with a cloud at the other end).  Each relationship
+// between two variables in the model becomes a connector.
+func (w *writer) layout(module string, model *Model) View {
+	view := View{Type: "stock_flow"}
+
+	// flows attached to a stock are drawn as pipes between their
+	// stocks; everything else is placed on the circle.
+	flowEnds := make(map[string][2]string) // flow -> [source stock, dest stock]
+	for _, xv := range model.Variables.Items {
+		if xv.XMLName.Local != "stock" {
+			continue
+		}
+		for _, out := range xv.Outflows {
+			ends := flowEnds[canonicalIdent(out)]
+			ends[0] = xv.Name
+			flowEnds[canonicalIdent(out)] = ends
+		}
+		for _, in := range xv.Inflows {
+			ends := flowEnds[canonicalIdent(in)]
+			ends[1] = xv.Name
+			flowEnds[canonicalIdent(in)] = ends
+		}
+	}
+
+	var placed []*Variable
+	for i := range model.Variables.Items {
+		xv := &model.Variables.Items[i]
+		if _, ok := flowEnds[canonicalIdent(xv.Name)]; ok && xv.XMLName.Local == "flow" {
+			continue
+		}
+		placed = append(placed, xv)
+	}
+
+	radius := max(150, float64(len(placed))*minNodeSpacing/(2*math.Pi))
+	center := position{x: radius + 100, y: radius + 100}
+
+	positions := make(map[string]position)
+	for i, xv := range placed {
+		theta := 2 * math.Pi * float64(i) / float64(len(placed))
+		p := position{
+			x: math.Round(center.x + radius*math.Cos(theta)),
+			y: math.Round(center.y + radius*math.Sin(theta)),
+		}
+		positions[canonicalIdent(xv.Name)] = p
+
+		entry := ViewEntry{Name: xv.Name, X: p.x, Y: p.y}
+		switch xv.XMLName.Local {
+		case "stock":
+			view.Stocks = append(view.Stocks, entry)
+		case "module":
+			view.Modules = append(view.Modules, entry)
+		case "flow":
+			entry.Pts = Points{{X: p.x - flowLength/2, Y: p.y}, {X: p.x + flowLength/2, Y: p.y}}
+			view.Flows = append(view.Flows, entry)
+		default:
+			view.Auxes = append(view.Auxes, entry)
+		}
+	}
+
+	for _, xv := range model.Variables.Items {
+		if xv.XMLName.Local != "flow" {
+			continue
+		}
+		ends, ok := flowEnds[canonicalIdent(xv.Name)]
+		if !ok {
+			continue
+		}
+		from, hasFrom := positions[canonicalIdent(ends[0])]
+		to, hasTo := positions[canonicalIdent(ends[1])]
+		switch {
+		case hasFrom && !hasTo:
+			to = position{x: from.x + flowLength, y: from.y}
+		case !hasFrom && hasTo:
+			from = position{x: to.x - flowLength, y: to.y}
+		}
+		p := position{x: math.Round((from.x + to.x) / 2), y: math.Round((from.y + to.y) / 2)}
+		positions[canonicalIdent(xv.Name)] = p
+		view.Flows = append(view.Flows, ViewEntry{
+			Name: xv.Name,
+			X:    p.x,
+			Y:    p.y,
+			Pts:  Points{{X: from.x, Y: from.y}, {X: to.x, Y: to.y}},
+		})
+	}
+
+	uid := 1
+	seen := make(map[string]bool)
+	for _, r := range w.m.Relationships {
+		fromModule, from := w.splitName(r.From)
+		toModule, to := w.splitName(r.To)
+		if fromModule != module || toModule != module || from == to || seen[r.Key()] {
+			continue
+		}
+		fromPos, okFrom := positions[canonicalIdent(from)]
+		toPos, okTo := positions[canonicalIdent(to)]
+		if !okFrom || !okTo {
+			continue
+		}
+		// flows and stocks are already connected by the flow's pipe
+		if ends, ok := flowEnds[canonicalIdent(from)]; ok && (ends[0] == to || ends[1] == to) {
+			continue
+		}
+		seen[r.Key()] = true
+
+		c := Connector{
+			UID:               uid,
+			Angle:             connectorAngle(fromPos, toPos),
+			Reasoning:         r.Reasoning,
+			PolarityReasoning: r.PolarityReasoning,
+			From:              Endpoint{Name: ident(from)},
+			To:                Endpoint{Name: ident(to)},
+		}
+		if r.Polarity == "+" || r.Polarity == "-" {
+			c.Polarity = r.Polarity
+		}
+		view.Connectors = append(view.Connectors, c)
+		uid++
+	}
+
+	return view
+}
+
+// connectorAngle returns the angle, in degrees counter-clockwise from
+// the positive x axis, at which a straight connector leaves from.
+// XMILE's y axis points down, so it is flipped here.
+func connectorAngle(from, to position) float64 {
+	angle := math.Atan2(-(to.y-from.y), to.x-from.x) * 180 / math.Pi
+	angle = math.Round(angle*1000) / 1000
+	if angle < 0 {
+		angle += 360
+	}
+	// avoid writing "-0"
+	return angle + 0
+}
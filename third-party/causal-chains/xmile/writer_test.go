@@ -0,0 +1,133 @@
+package xmile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/causal"
+	"github.com/UB-IAD/sd-ai/third-party/causal-chains/sdjson"
+)
+
+func TestWriteRoundtrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(evalsDir, "*", "*.stmx"))
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("Skipping test because the evals fixtures aren't available.")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(filepath.Dir(path))+"/"+filepath.Base(path), func(t *testing.T) {
+			expected, _ := readFixture(t, path)
+
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, expected))
+
+			actual, err := Read(&buf)
+			require.NoError(t, err)
+
+			assert.Equal(t, expected.Specs, actual.Specs)
+			assert.Equal(t, expected.Modules, actual.Modules)
+			assert.ElementsMatch(t, expected.Variables, actual.Variables)
+			assert.Equal(t, relationshipSet(expected.Relationships), relationshipSet(actual.Relationships))
+		})
+	}
+}
+
+func TestWriteArraysAndConveyors(t *testing.T) {
+	expected, err := Read(bytes.NewBufferString(arrayedXMILE))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, expected))
+
+	actual, err := Read(&buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected.Specs, actual.Specs)
+	assert.Equal(t, expected.Variables, actual.Variables)
+	assert.ElementsMatch(t, expected.Relationships, actual.Relationships)
+}
+
+func TestWriteQueue(t *testing.T) {
+	expected, err := Read(bytes.NewBufferString(queueXMILE))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, expected))
+
+	actual, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Variables, actual.Variables)
+}
+
+func TestWriteCausalLoopDiagram(t *testing.T) {
+	m := &causal.Map{
+		Title: "Adoption",
+		CausalChains: []causal.Chain{
+			{
+				InitialVariable: "adopters",
+				Relationships: []causal.RelationshipEntry{
+					{Variable: "word of mouth", Polarity: "+"},
+					{Variable: "adoption rate", Polarity: "+"},
+					{Variable: "adopters", Polarity: "+"},
+				},
+			},
+			{
+				InitialVariable: "adopters",
+				Relationships: []causal.RelationshipEntry{
+					{Variable: "potential adopters", Polarity: "-"},
+					{Variable: "adoption rate", Polarity: "+"},
+				},
+			},
+		},
+	}
+	model := m.Compat()
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, &model))
+
+	var f File
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &f))
+	require.Len(t, f.Models, 1)
+	require.Len(t, f.Models[0].Views, 1)
+
+	view := f.Models[0].Views[0]
+	assert.Len(t, view.Connectors, len(model.Relationships))
+	assert.Len(t, view.Auxes, len(model.Variables))
+
+	positions := make(map[Pt]bool)
+	for _, aux := range view.Auxes {
+		positions[Pt{X: aux.X, Y: aux.Y}] = true
+	}
+	assert.Len(t, positions, len(view.Auxes), "variables should not overlap")
+
+	actual, err := Read(&buf)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, model.Relationships, actual.Relationships)
+
+	names := make([]string, len(actual.Variables))
+	for i, v := range actual.Variables {
+		names[i] = v.Name
+		assert.Equal(t, sdjson.VariableTypeAux, v.Type)
+	}
+	assert.ElementsMatch(t, []string{"adopters", "word of mouth", "adoption rate", "potential adopters"}, names)
+}
+
+func TestConnectorAngle(t *testing.T) {
+	for _, tt := range []struct {
+		to    position
+		angle float64
+	}{
+		{position{x: 10, y: 0}, 0},
+		{position{x: 0, y: -10}, 90},
+		{position{x: -10, y: 0}, 180},
+		{position{x: 0, y: 10}, 270},
+	} {
+		assert.Equal(t, tt.angle, connectorAngle(position{}, tt.to))
+	}
+}
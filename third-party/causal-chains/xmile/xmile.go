@@ -0,0 +1,265 @@
+// Package xmile converts between XMILE (.stmx, .xmile) documents and
+// sdjson models.
+package xmile
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+)
+
+const (
+	Namespace     = "http://docs.oasis-open.org/xmile/ns/XMILE/v1.0"
+	IseeNamespace = "http://iseesystems.com/XMILE"
+)
+
+type File struct {
+	XMLName    xml.Name `xml:"xmile"`
+	Version    string   `xml:"version,attr"`
+	Xmlns      string   `xml:"xmlns,attr,omitempty"`
+	XmlnsIsee  string   `xml:"xmlns:isee,attr,omitempty"`
+	Header     Header   `xml:"header"`
+	SimSpecs   SimSpecs `xml:"sim_specs"`
+	Dimensions Dims     `xml:"dimensions,omitempty"`
+	Models     []Model  `xml:"model"`
+}
+
+type Header struct {
+	Smile   *Smile   `xml:"smile"`
+	Name    string   `xml:"name,omitempty"`
+	Vendor  string   `xml:"vendor,omitempty"`
+	Product *Product `xml:"product"`
+}
+
+type Smile struct {
+	Version   string `xml:"version,attr"`
+	Namespace string `xml:"namespace,attr,omitempty"`
+}
+
+type Product struct {
+	Version string `xml:"version,attr,omitempty"`
+	Name    string `xml:",chardata"`
+}
+
+type SimSpecs struct {
+	Method    string  `xml:"method,attr,omitempty"`
+	TimeUnits string  `xml:"time_units,attr,omitempty"`
+	Start     float64 `xml:"start"`
+	Stop      float64 `xml:"stop"`
+	DT        *DT     `xml:"dt"`
+	SaveStep  float64 `xml:"save_step,omitempty"`
+}
+
+type DT struct {
+	Value      float64 `xml:",chardata"`
+	Reciprocal bool    `xml:"reciprocal,attr,omitempty"`
+}
+
+// Dim is either a dimension definition (in the file's dimensions list)
+// or a reference to one (in a variable's dimensions list).
+type Dim struct {
+	Name     string `xml:"name,attr"`
+	Size     int    `xml:"size,attr,omitempty"`
+	Elements []Elem `xml:"elem"`
+}
+
+type Elem struct {
+	Name string `xml:"name,attr"`
+}
+
+// Dims is a list of dimensions wrapped in a <dimensions> element.
+// encoding/xml always writes the wrapper for "a>b" paths, so the
+// element is marshaled by hand to omit it when the list is empty.
+type Dims []Dim
+
+func (d Dims) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Dims []Dim `xml:"dim"`
+	}{d}, start)
+}
+
+func (d *Dims) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var list struct {
+		Dims []Dim `xml:"dim"`
+	}
+	if err := dec.DecodeElement(&list, &start); err != nil {
+		return err
+	}
+	*d = append(*d, list.Dims...)
+	return nil
+}
+
+type Model struct {
+	Name      string    `xml:"name,attr,omitempty"`
+	Variables Variables `xml:"variables"`
+	Views     []View    `xml:"views>view"`
+}
+
+type Variables struct {
+	Items []Variable `xml:",any"`
+}
+
+// Variable is any entry in a model's variables list; XMLName.Local
+// identifies its kind (stock, flow, aux, module, ...).
+type Variable struct {
+	XMLName      xml.Name
+	Name         string       `xml:"name,attr"`
+	Access       string       `xml:"access,attr,omitempty"`
+	Doc          string       `xml:"doc,omitempty"`
+	Dimensions   Dims         `xml:"dimensions,omitempty"`
+	Elements     []ElementEqn `xml:"element"`
+	Eqn          *string      `xml:"eqn"`
+	GF           *GF          `xml:"gf"`
+	Inflows      []string     `xml:"inflow"`
+	Outflows     []string     `xml:"outflow"`
+	Conveyor     *Conveyor    `xml:"conveyor"`
+	Queue        *struct{}    `xml:"queue"`
+	Overflow     *struct{}    `xml:"overflow"`
+	Leak         *struct{}    `xml:"leak"`
+	LeakIntegers *struct{}    `xml:"leak_integers"`
+	NonNegative  *struct{}    `xml:"non_negative"`
+	Units        string       `xml:"units,omitempty"`
+	Connects     []Connect    `xml:"connect"`
+	DelayAux     *struct{}    `xml:"http://iseesystems.com/XMILE delay_aux"`
+}
+
+type Conveyor struct {
+	Len           string `xml:"len,omitempty"`
+	Capacity      string `xml:"capacity,omitempty"`
+	InLimit       string `xml:"in_limit,omitempty"`
+	Sample        string `xml:"sample,omitempty"`
+	Arrest        string `xml:"arrest,omitempty"`
+	LeakZoneStart string `xml:"leak_zone_start,omitempty"`
+	LeakZoneEnd   string `xml:"leak_zone_end,omitempty"`
+	OneAtATime    bool   `xml:"one_at_a_time,attr,omitempty"`
+	Exponential   bool   `xml:"exponential_leak,attr,omitempty"`
+}
+
+// ElementEqn is the equation for a single element of an arrayed
+// variable; Subscript is a comma-separated list of element names.
+type ElementEqn struct {
+	Subscript string `xml:"subscript,attr"`
+	Eqn       string `xml:"eqn"`
+}
+
+type Connect struct {
+	To   string `xml:"to,attr"`
+	From string `xml:"from,attr"`
+}
+
+type GF struct {
+	XScale *Scale `xml:"xscale"`
+	YScale *Scale `xml:"yscale"`
+	XPts   *Pts   `xml:"xpts"`
+	YPts   *Pts   `xml:"ypts"`
+}
+
+type Scale struct {
+	Min float64 `xml:"min,attr"`
+	Max float64 `xml:"max,attr"`
+}
+
+type Pts struct {
+	Sep    string `xml:"sep,attr,omitempty"`
+	Values string `xml:",chardata"`
+}
+
+type View struct {
+	Type       string      `xml:"type,attr,omitempty"`
+	Stocks     []ViewEntry `xml:"stock"`
+	Flows      []ViewEntry `xml:"flow"`
+	Auxes      []ViewEntry `xml:"aux"`
+	Modules    []ViewEntry `xml:"module"`
+	Aliases    []Alias     `xml:"alias"`
+	Connectors []Connector `xml:"connector"`
+}
+
+type ViewEntry struct {
+	Name string  `xml:"name,attr"`
+	X    float64 `xml:"x,attr"`
+	Y    float64 `xml:"y,attr"`
+	Pts  Points  `xml:"pts,omitempty"` // flow pipes only
+}
+
+type Pt struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+}
+
+// Points is a list of points wrapped in a <pts> element; like Dims, it
+// is omitted entirely when empty.
+type Points []Pt
+
+func (p Points) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Pts []Pt `xml:"pt"`
+	}{p}, start)
+}
+
+func (p *Points) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var list struct {
+		Pts []Pt `xml:"pt"`
+	}
+	if err := dec.DecodeElement(&list, &start); err != nil {
+		return err
+	}
+	*p = append(*p, list.Pts...)
+	return nil
+}
+
+type Alias struct {
+	UID int    `xml:"uid,attr"`
+	Of  string `xml:"of"`
+}
+
+type Connector struct {
+	UID               int      `xml:"uid,attr"`
+	Angle             float64  `xml:"angle,attr"`
+	Polarity          string   `xml:"polarity,attr,omitempty"`
+	Reasoning         string   `xml:"http://iseesystems.com/XMILE reasoning,omitempty"`
+	PolarityReasoning string   `xml:"http://iseesystems.com/XMILE polarity_reasoning,omitempty"`
+	From              Endpoint `xml:"from"`
+	To                Endpoint `xml:"to"`
+}
+
+// Endpoint names a connector's variable, either directly or through
+// an alias that lives elsewhere in the view.
+type Endpoint struct {
+	Name  string    `xml:",chardata"`
+	Alias *AliasRef `xml:"alias"`
+}
+
+type AliasRef struct {
+	UID int `xml:"uid,attr"`
+}
+
+var (
+	escapedNewlineRe = regexp.MustCompile(`(\\n|\\r|\r\n|\n|\r)`)
+	identSpaceRe     = regexp.MustCompile(`[\s\x{00A0}_]+`)
+)
+
+// displayName turns an XMILE name like "Potential\nAdopters" into the
+// human-readable form sdjson uses ("Potential Adopters").
+func displayName(name string) string {
+	name = escapedNewlineRe.ReplaceAllString(name, " ")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// canonicalIdent returns the form used to compare XMILE identifiers:
+// spaces, newlines and underscores are equivalent, and case is ignored.
+func canonicalIdent(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		name = name[1 : len(name)-1]
+	}
+	name = escapedNewlineRe.ReplaceAllString(name, " ")
+	name = identSpaceRe.ReplaceAllString(name, "_")
+	return strings.ToLower(strings.Trim(name, "_"))
+}
+
+var (
+	_ xml.Marshaler   = Dims(nil)
+	_ xml.Unmarshaler = (*Dims)(nil)
+	_ xml.Marshaler   = Points(nil)
+	_ xml.Unmarshaler = (*Points)(nil)
+)